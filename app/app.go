@@ -7,106 +7,1112 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sashayakovtseva/bookshelf"
+	"google.golang.org/grpc"
 )
 
-var DB bookshelf.BookDatabase
+var (
+	DB     bookshelf.BookDatabase
+	Covers bookshelf.CoverStore
+
+	// Log receives the app's log messages, at the level set by LOG_LEVEL.
+	Log = bookshelf.NewLogger(os.Stderr, bookshelf.LevelInfo)
+
+	// Version identifies the build, set with
+	// -ldflags "-X main.Version=...".
+	Version = "dev"
+
+	// Idempotency remembers the books created for the Idempotency-Key
+	// header of POST /books.
+	Idempotency bookshelf.IdempotencyStore
+
+	// AllowedOrigins lists the origins browsers may call the API from.
+	AllowedOrigins []string
+
+	// RequestTimeout bounds how long a request may take, as described by
+	// TimeoutMiddleware, except for the streaming routes. Zero means no
+	// limit.
+	RequestTimeout = 30 * time.Second
+
+	// RateLimitRPS and RateLimitBurst limit the requests of each client IP,
+	// as described by RateLimitMiddleware. A zero RateLimitRPS disables the
+	// limit.
+	RateLimitRPS   float64
+	RateLimitBurst = 20
+
+	// AdminToken is the bearer token the admin routes require, as described
+	// by AdminMiddleware. They are disabled if it is empty.
+	AdminToken string
+
+	// AllowReset enables POST /admin/reset, which deletes every book, for
+	// demo and test deployments. It is set by ALLOW_RESET.
+	AllowReset bool
+
+	// WriteUsername and WritePassword are the credentials the routes that
+	// change books require, as described by BasicAuthMiddleware. Writes are
+	// open if WriteUsername is empty.
+	WriteUsername, WritePassword string
+)
 
 func main() {
-	mongoURL := os.Getenv("MONGO_URL")
-	if mongoURL == "" {
-		mongoURL = "localhost"
+	level, err := bookshelf.ParseLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		log.Fatalf("Bad LOG_LEVEL: %v", err)
+	}
+	Log = bookshelf.NewLogger(os.Stderr, level)
+
+	config := defaultConfig()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		Log.Infof("Reading config from %s", path)
+		if config, err = LoadConfig(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := config.overrideFromEnv(); err != nil {
+		log.Fatal(err)
 	}
 
-	var err error
-	log.Printf("Connecting to mongo at %q", mongoURL)
-	DB, err = bookshelf.NewMongoDB(mongoURL)
+	Log.Infof("Connecting to mongo at %q", config.MongoURL)
+	DB, err = bookshelf.NewMongoDBWithOptions(config.MongoURL, bookshelf.MongoOptions{
+		PoolLimit:           config.PoolSize,
+		WriteConcern:        os.Getenv("MONGO_WRITE_CONCERN"),
+		RejectDuplicateISBN: config.RejectDuplicateISBN,
+		Logger:              Log,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := DB.Migrate(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	if config.ReadOnly {
+		Log.Infof("Serving in read-only mode")
+		DB = bookshelf.NewReadOnlyDB(DB)
+	}
+	DB = bookshelf.NewInstrumentedDB(bookshelf.NewRetryingDB(DB, retryAttempts, retryBackoff))
+
+	if s := os.Getenv("MAX_BODY_BYTES"); s != "" {
+		if MaxBodyBytes, err = strconv.ParseInt(s, 10, 64); err != nil {
+			log.Fatalf("Bad MAX_BODY_BYTES: %v", err)
+		}
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if s := os.Getenv("REQUEST_TIMEOUT"); s != "" {
+		if RequestTimeout, err = time.ParseDuration(s); err != nil {
+			log.Fatalf("Bad REQUEST_TIMEOUT: %v", err)
+		}
+	}
+	if s := os.Getenv("RATE_LIMIT_RPS"); s != "" {
+		if RateLimitRPS, err = strconv.ParseFloat(s, 64); err != nil {
+			log.Fatalf("Bad RATE_LIMIT_RPS: %v", err)
+		}
 	}
-	log.Printf("Listening on %s", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), handler()))
+	if s := os.Getenv("RATE_LIMIT_BURST"); s != "" {
+		if RateLimitBurst, err = strconv.Atoi(s); err != nil {
+			log.Fatalf("Bad RATE_LIMIT_BURST: %v", err)
+		}
+	}
+
+	idempotencyTTL := defaultIdempotencyTTL
+	if s := os.Getenv("IDEMPOTENCY_TTL"); s != "" {
+		if idempotencyTTL, err = time.ParseDuration(s); err != nil {
+			log.Fatalf("Bad IDEMPOTENCY_TTL: %v", err)
+		}
+	}
+	Idempotency = bookshelf.NewMemoryIdempotencyStore(idempotencyTTL)
+
+	AdminToken = os.Getenv("ADMIN_TOKEN")
+	WriteUsername, WritePassword = os.Getenv("WRITE_USERNAME"), os.Getenv("WRITE_PASSWORD")
+	AllowReset, _ = strconv.ParseBool(os.Getenv("ALLOW_RESET"))
+
+	if origins := os.Getenv("CORS_ORIGINS"); origins != "" {
+		AllowedOrigins = strings.Split(origins, ",")
+	}
+
+	if dir := os.Getenv("COVER_DIR"); dir != "" {
+		coverDir = dir
+	}
+	Covers, err = bookshelf.NewFileCoverStore(coverDir, "/covers")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	port := config.Port
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%s", port),
+		Handler: handler(config.pageSizes()),
+	}
+
+	// The gRPC BookService is only served if a port is given for it.
+	var grpcServer *grpc.Server
+	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%s", grpcPort))
+		if err != nil {
+			log.Fatal(err)
+		}
+		grpcServer = bookshelf.NewGRPCServer(DB)
+		Log.Infof("Serving gRPC on %s", grpcPort)
+		go grpcServer.Serve(lis)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		sig := <-stop
+		Log.Infof("Received %v, shutting down", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			Log.Errorf("Could not shut down gracefully: %v", err)
+		}
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+	}()
+
+	Log.Infof("Listening on %s", port)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	// ListenAndServe returns as soon as Shutdown starts; wait for in-flight
+	// requests before pulling the database from under them.
+	<-stopped
+
+	Log.Infof("Closing database")
+	if err := DB.Close(); err != nil {
+		Log.Errorf("Could not close database: %v", err)
+	}
+	Log.Infof("Shut down")
 }
 
-func handler() http.Handler {
+// pageSizeFromEnv returns the positive page size set by the environment
+// variable key, or fallback if it is unset or invalid.
+func pageSizeFromEnv(key string, fallback int) int {
+	s := os.Getenv(key)
+	if s == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		Log.Errorf("Ignoring bad %s %q, using %d", key, s, fallback)
+		return fallback
+	}
+	return n
+}
+
+// retryAttempts and retryBackoff control how reads failing with a transient
+// database error are retried.
+const (
+	retryAttempts = 3
+	retryBackoff  = 100 * time.Millisecond
+)
+
+// defaultIdempotencyTTL is how long idempotency keys are remembered unless
+// IDEMPOTENCY_TTL says otherwise.
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// shutdownTimeout bounds how long in-flight requests may take to finish once
+// the server is asked to stop.
+const shutdownTimeout = 15 * time.Second
+
+func handler(pages pageSizes) http.Handler {
 	r := mux.NewRouter()
 	r.Handle("/", http.RedirectHandler("/books", http.StatusFound))
 
+	// paged passes the page sizes on to the handlers that paginate.
+	paged := func(fn func(http.ResponseWriter, *http.Request, pageSizes) *appError) appHandler {
+		return func(w http.ResponseWriter, r *http.Request) *appError {
+			return fn(w, r, pages)
+		}
+	}
+
+	// Routes that change books need credentials if they are set. Reads made
+	// with POST, such as batchGet and graphql, stay public, and the admin
+	// routes have a token of their own.
+	write := BasicAuthMiddleware(WriteUsername, WritePassword)
+
 	r.Methods("POST").Path("/books").
-		Handler(appHandler(createHandler))
+		Handler(write(appHandler(createHandler)))
 	r.Methods("GET").Path("/books").
-		Handler(appHandler(listHandler))
+		Handler(paged(listHandler))
+	r.Methods("POST").Path("/books:batchDelete").
+		Handler(write(appHandler(batchDeleteHandler)))
+	r.Methods("POST").Path("/books:batchGet").
+		Handler(appHandler(batchGetHandler))
+	r.Methods("POST").Path("/books:import").
+		Handler(write(appHandler(importHandler)))
+	r.Methods("GET").Path("/books.csv").
+		Handler(appHandler(exportHandler))
+	r.Methods("GET").Path("/books.jsonl").
+		Handler(appHandler(exportJSONLinesHandler))
+	r.Methods("GET").Path("/books/count").
+		Handler(appHandler(countHandler))
+	r.Methods("GET").Path("/books/stats/ratings").
+		Handler(appHandler(ratingsHandler))
+	r.Methods("GET").Path("/books/index").
+		Handler(appHandler(titleIndexHandler))
+	r.Methods("GET").Path("/books/search").
+		Handler(appHandler(searchHandler))
+	r.Methods("GET").Path("/books/suggest").
+		Handler(paged(suggestHandler))
+	r.Methods("GET").Path("/books/recent").
+		Handler(paged(recentHandler))
+	r.Methods("GET").Path("/books/random").
+		Handler(appHandler(randomHandler))
+	r.Methods("GET").Path("/books/stream").
+		Handler(appHandler(streamHandler))
 	r.Methods("POST", "PUT").Path("/books/{id:[0-9]+}").
-		Handler(appHandler(updateHandler))
+		Handler(write(appHandler(updateHandler)))
+	r.Methods("PATCH").Path("/books/{id:[0-9]+}").
+		Handler(write(appHandler(patchHandler)))
 	r.Methods("GET").Path("/books/{id:[0-9]+}").
 		Handler(appHandler(detailHandler))
+	r.Methods("HEAD").Path("/books/{id:[0-9]+}").
+		Handler(appHandler(existsHandler))
 	r.Methods("POST").Path("/books/{id:[0-9]+}:delete").
-		Handler(appHandler(deleteHandler)).Name("delete")
+		Handler(write(appHandler(deleteHandler))).Name("delete")
+	r.Methods("POST").Path("/books/{id:[0-9]+}:clone").
+		Handler(write(appHandler(cloneHandler)))
+
+	r.Methods("PUT").Path("/books/{id:[0-9]+}/tags").
+		Handler(write(appHandler(setTagsHandler)))
+	r.Methods("DELETE").Path("/books/{id:[0-9]+}/tags/{tag}").
+		Handler(write(appHandler(removeTagHandler)))
+	r.Methods("POST").Path("/books/{id:[0-9]+}/cover").
+		Handler(write(appHandler(uploadCoverHandler)))
+	r.Methods("POST").Path("/books/{id:[0-9]+}/reviews").
+		Handler(write(appHandler(addReviewHandler)))
+	r.Methods("GET").Path("/books/{id:[0-9]+}/reviews").
+		Handler(paged(listReviewsHandler))
+	r.Methods("GET").PathPrefix("/covers/").
+		Handler(http.StripPrefix("/covers/", http.FileServer(http.Dir(coverDir))))
+
+	r.Methods("POST").Path("/graphql").
+		Handler(graphqlHandler(newGraphQLSchema(pages)))
+
+	r.Methods("GET").Path("/authors").
+		Handler(appHandler(authorsHandler))
+	r.Methods("GET").Path("/authors/top").
+		Handler(appHandler(topAuthorsHandler))
+	r.Methods("POST").Path("/authors:rename").
+		Handler(write(appHandler(renameAuthorHandler)))
+
+	admin := AdminMiddleware(AdminToken)
+	r.Methods("POST").Path("/admin/books:updateWhere").
+		Handler(admin(appHandler(updateWhereHandler)))
+	r.Methods("POST").Path("/admin/reindex").
+		Handler(admin(appHandler(reindexHandler)))
+	if AllowReset {
+		r.Methods("POST").Path("/admin/reset").
+			Handler(admin(appHandler(resetHandler)))
+	}
 
 	r.Methods("GET").Path("/healthz").
-		HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Write([]byte("ok"))
+		HandlerFunc(healthzHandler)
+	r.Methods("GET").Path("/status").
+		HandlerFunc(statusHandler)
+	r.Methods("GET").Path("/metrics").
+		Handler(bookshelf.MetricsHandler())
+
+	var h http.Handler = GzipMiddleware(r)
+	if RequestTimeout > 0 {
+		// The exports and the event stream last as long as the client
+		// keeps reading, so they are left out of the timeout.
+		timed, untimed := TimeoutMiddleware(RequestTimeout)(h), h
+		h = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if streamingPaths[req.URL.Path] {
+				untimed.ServeHTTP(w, req)
+				return
+			}
+			timed.ServeHTTP(w, req)
 		})
-	return r
+	}
+	if RateLimitRPS > 0 {
+		h = RateLimitMiddleware(RateLimitRPS, RateLimitBurst)(h)
+	}
+	return livez(RequestIDMiddleware(LoggingMiddleware(Log)(CORSMiddleware(AllowedOrigins)(h))))
+}
+
+// streamingPaths are the paths of the routes that stream their responses,
+// which RequestTimeout does not apply to.
+var streamingPaths = map[string]bool{
+	"/books.csv":    true,
+	"/books.jsonl":  true,
+	"/books/stream": true,
+}
+
+// livez answers liveness probes, GET /livez, with an empty 200 ahead of next,
+// so that neither rate limiting nor logging applies to them, and passes every
+// other request on to next. Unlike /healthz, it never touches the database:
+// it only reports that the app is up and serving.
+func livez(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/livez" && (r.Method == "GET" || r.Method == "HEAD") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// healthzHandler reports whether the database can be reached, for readiness
+// probes.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if err := DB.Ping(r.Context()); err != nil {
+		Log.Errorf("Health check failed: %v", err)
+		http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// started is when the app started, for reporting its uptime.
+var started = time.Now()
+
+// statusHandler reports the version and uptime of the app, which database
+// backend it uses and whether that can be reached, as JSON.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	status := struct {
+		Version  string `json:"version"`
+		Uptime   string `json:"uptime"`
+		Database string `json:"database"`
+		PingOK   bool   `json:"ping_ok"`
+	}{
+		Version:  Version,
+		Uptime:   time.Since(started).Round(time.Second).String(),
+		Database: DB.Name(),
+	}
+	if err := DB.Ping(r.Context()); err != nil {
+		Log.Errorf("Status ping failed: %v", err)
+	} else {
+		status.PingOK = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// MaxBodyBytes caps the size of the JSON request bodies read by decodeJSON.
+var MaxBodyBytes int64 = 1 << 20
+
+// decodeJSON decodes the JSON request body into v, naming it what in error
+// messages. Bodies larger than MaxBodyBytes are rejected with 413 Request
+// Entity Too Large, and bodies with fields v does not have with 400 Bad
+// Request.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}, what string) *appError {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBodyBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			e := appErrorf(err, "request body is larger than %d bytes", tooLarge.Limit)
+			e.Code = http.StatusRequestEntityTooLarge
+			return e
+		}
+		return badRequestf(err, "could not decode json %s: %v", what, err)
+	}
+	return nil
 }
 
 // createHandler adds a book to the database.
 func createHandler(w http.ResponseWriter, r *http.Request) *appError {
 	var book bookshelf.Book
-	err := json.NewDecoder(r.Body).Decode(&book)
+	if aerr := decodeJSON(w, r, &book, "book"); aerr != nil {
+		return aerr
+	}
+	creditUser(r.Context(), &book)
+	if err := book.Validate(); err != nil {
+		return appErrorf(err, "invalid book")
+	}
+
+	// A request retried with the same Idempotency-Key gets the book created
+	// the first time, unless it has since been deleted. Keys are scoped to
+	// the user, and reusing one for a different book is refused. Concurrent
+	// requests with the same key are not guarded against.
+	key := r.Header.Get("Idempotency-Key")
+	var digest string
+	if key != "" && Idempotency != nil {
+		// Usernames cannot contain colons, so scoped keys do not collide.
+		key = UserFromContext(r.Context()) + ":" + key
+		digest = bookDigest(&book)
+		prev, ok, err := Idempotency.Get(r.Context(), key)
+		if err != nil {
+			return appErrorf(err, "could not look up idempotency key: %v", err)
+		}
+		if ok {
+			if prev.Digest != digest {
+				return &appError{
+					Message: "Idempotency-Key was already used for a different book",
+					Code:    http.StatusUnprocessableEntity,
+				}
+			}
+			created, err := DB.GetBook(r.Context(), prev.BookID)
+			if err == nil {
+				return writeCreated(w, r, created)
+			}
+			if !errors.Is(err, bookshelf.ErrBookNotFound) {
+				return appErrorf(err, "could not get book: %v", err)
+			}
+		}
+	}
+
+	if _, err := DB.AddBook(r.Context(), &book); err != nil {
+		if errors.Is(err, bookshelf.ErrDuplicateISBN) {
+			return appErrorf(err, "a book with ISBN %s already exists", book.ISBN)
+		}
+		return appErrorf(err, "could not save book: %v", err)
+	}
+	if key != "" && Idempotency != nil {
+		req := bookshelf.IdempotentRequest{BookID: book.ID, Digest: digest}
+		if err := Idempotency.Put(r.Context(), key, req); err != nil {
+			Log.Errorf("Could not store idempotency key: %v", err)
+		}
+	}
+	return writeCreated(w, r, &book)
+}
+
+// creditUser sets the owner of books to the user the request with context ctx
+// was authenticated as, when writes need credentials, so that clients cannot
+// credit books to someone else. Otherwise the owner fields are left as sent.
+func creditUser(ctx context.Context, books ...*bookshelf.Book) {
+	if WriteUsername == "" {
+		return
+	}
+	user := UserFromContext(ctx)
+	for _, b := range books {
+		b.CreatedByID, b.CreatedBy = user, user
+	}
+}
+
+// bookDigest returns a digest of the book a request asks to create, which
+// tells whether two requests are for the same book.
+func bookDigest(b *bookshelf.Book) string {
+	body, _ := json.Marshal(b)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// cloneHandler adds a copy of a given book, such as the start of a new
+// edition, and responds like createHandler. With suffix=true, " (copy)" is
+// appended to the title of the copy.
+func cloneHandler(w http.ResponseWriter, r *http.Request) *appError {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
-		return appErrorf(err, "could not decode json book: %v", err)
+		return appErrorf(err, "bad book id: %v", err)
 	}
-	id, err := DB.AddBook(&book)
+	var titleSuffix string
+	if suffix, _ := strconv.ParseBool(r.URL.Query().Get("suffix")); suffix {
+		titleSuffix = copySuffix
+	}
+	book, err := cloneBook(r.Context(), id, titleSuffix)
 	if err != nil {
-		return appErrorf(err, "could not save book: %v", err)
+		return appErrorf(err, "could not clone book: %v", err)
+	}
+	return writeCreated(w, r, book)
+}
+
+// copySuffix is appended to the titles of the books cloned with
+// suffix=true.
+const copySuffix = " (copy)"
+
+// cloneBook adds a copy of the book with the given ID, with titleSuffix
+// appended to its title, and returns it. The copy gets its own ID, version and
+// timestamps, and is credited to the user the request with context ctx was
+// authenticated as; its ISBN is left empty, since an ISBN identifies a single
+// edition.
+func cloneBook(ctx context.Context, id int64, titleSuffix string) (*bookshelf.Book, error) {
+	book, err := DB.GetBook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	book.ID = 0
+	book.ISBN = ""
+	book.Title += titleSuffix
+	user := UserFromContext(ctx)
+	book.CreatedByID, book.CreatedBy = user, user
+	if _, err := DB.AddBook(ctx, book); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// writeCreated responds to the creation of book, redirecting to it for
+// browsers and describing it in JSON otherwise.
+func writeCreated(w http.ResponseWriter, r *http.Request, book *bookshelf.Book) *appError {
+	location := fmt.Sprintf("/books/%d", book.ID)
+	if prefersHTML(r) {
+		http.Redirect(w, r, location, http.StatusFound)
+		return nil
+	}
+	w.Header().Set("Location", location)
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := encodeNamed(w, r, book); err != nil {
+		return appErrorf(err, "could not encode book: %v", err)
+	}
+	return nil
+}
+
+// prefersHTML reports whether the request's Accept header ranks text/html
+// above application/json.
+func prefersHTML(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return acceptQuality(accept, "text/html") > acceptQuality(accept, "application/json")
+}
+
+// acceptQuality returns the quality the Accept header value accept assigns
+// to mediaType, taken from the most specific range that matches it.
+func acceptQuality(accept, mediaType string) float64 {
+	var (
+		q           float64
+		specificity = -1
+	)
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		rng := strings.ToLower(strings.TrimSpace(params[0]))
+
+		var s int
+		switch {
+		case rng == mediaType:
+			s = 2
+		case strings.HasSuffix(rng, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(rng, "*")):
+			s = 1
+		case rng == "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+
+		specificity, q = s, 1
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+	}
+	return q
+}
+
+// listHandler displays a list with summaries of books in the database,
+// paginated by the limit and offset query parameters, or by the limit and
+// after ones for cursor-based pagination. The list can be narrowed down or
+// reordered by the parameters understood by filteredBooks. With envelope=true,
+// offset-paginated lists are wrapped in a bookPage rather than sent bare.
+// With fields, only the listed fields of each book are sent.
+func listHandler(w http.ResponseWriter, r *http.Request, pages pageSizes) *appError {
+	limit, offset, err := pageFromRequest(r, pages)
+	if err != nil {
+		return badRequestf(err, "%v", err)
+	}
+	if r.URL.Query().Get("after") != "" {
+		return listAfter(w, r, limit)
+	}
+
+	books, filtered, appErr := filteredBooks(r)
+	if appErr != nil {
+		return appErr
+	}
+	var total int
+	if filtered {
+		total = len(books)
+		books = pageOf(books, limit, offset)
+	} else {
+		books, total, err = DB.ListBooksPaged(r.Context(), limit, offset)
+		if err != nil {
+			return appErrorf(err, "could not list books: %v", err)
+		}
+	}
+
+	if acceptsByName(r, jsonAPI) {
+		return writeJSONAPIBooks(w, r, books, total)
+	}
+
+	envelope, _ := strconv.ParseBool(r.URL.Query().Get("envelope"))
+	if envelope && books == nil {
+		books = []*bookshelf.Book{}
+	}
+	var body interface{} = books
+	if fields := fieldsFromRequest(r); fields != nil {
+		if body, err = selectFields(books, fields); err != nil {
+			return appErrorf(err, "could not encode books: %v", err)
+		}
+	}
+	if envelope {
+		body = bookPage{Items: body, Total: total, Limit: limit, Offset: offset}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	err = encodeNamed(w, r, body)
+	if err != nil {
+		return appErrorf(err, "could not encode books: %v", err)
 	}
-	http.Redirect(w, r, fmt.Sprintf("/books/%d", id), http.StatusFound)
 	return nil
 }
 
-// listHandler displays a list with summaries of books in the database.
-func listHandler(w http.ResponseWriter, r *http.Request) *appError {
-	books, err := DB.ListBooks()
+// bookPage is the response of listHandler when asked for an envelope: a page
+// of books along with what is needed to fetch the others.
+type bookPage struct {
+	Items  interface{} `json:"items"` // the books, or only some of their fields.
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+// listAfter displays at most limit books with an ID greater than the one in
+// the after query parameter. Unless this is the last page, a Link header
+// points at the next one.
+func listAfter(w http.ResponseWriter, r *http.Request, limit int) *appError {
+	after, err := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+	if err != nil {
+		return badRequestf(err, "bad cursor: %v", err)
+	}
+	books, err := DB.ListBooksAfter(r.Context(), after, limit)
 	if err != nil {
 		return appErrorf(err, "could not list books: %v", err)
 	}
 
+	if len(books) == limit {
+		nextCursor := books[len(books)-1].ID
+		w.Header().Set("Link", fmt.Sprintf(`</books?after=%d&limit=%d>; rel="next"`, nextCursor, limit))
+	}
+	w.Header().Add("Content-Type", "application/json")
+	err = encodeNamed(w, r, books)
+	if err != nil {
+		return appErrorf(err, "could not encode books: %v", err)
+	}
+	return nil
+}
+
+// filteredBooks lists every book selected by the request's query parameters:
+//
+//	author=A            books written by A
+//	tag=T               books tagged T
+//	tags=T,U&match=M    books tagged with all of T, U... if M is all, or any if it is any or unset
+//	lang=L              books written in the language with ISO 639-1 code L
+//	genre=G             books of genre G
+//	status=S            books with status S, draft or published
+//	minPages=N          books with at least N pages, and at most maxPages if set
+//	maxPages=N          books with at most N pages, and at least minPages if set
+//	from=D              books published on YYYY-MM-DD date D or later, and no later than to if set
+//	to=D                books published on YYYY-MM-DD date D or earlier, and no earlier than from if set
+//	sort=F&order=O      all books ordered by field F, ascending unless O is desc
+//
+// It reports false if none of them are present.
+func filteredBooks(r *http.Request) ([]*bookshelf.Book, bool, *appError) {
+	var (
+		books []*bookshelf.Book
+		err   error
+	)
+	q := r.URL.Query()
+	switch {
+	case q.Get("author") != "":
+		books, err = DB.ListBooksByAuthor(r.Context(), q.Get("author"))
+	case q.Get("tag") != "":
+		books, err = DB.ListBooksByTag(r.Context(), q.Get("tag"))
+	case q.Get("tags") != "":
+		var matchAll bool
+		switch match := q.Get("match"); match {
+		case "", "any":
+		case "all":
+			matchAll = true
+		default:
+			return nil, false, badRequestf(nil, "bad match %q: must be all or any", match)
+		}
+		var tags []string
+		for _, t := range strings.Split(q.Get("tags"), ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tags = append(tags, t)
+			}
+		}
+		books, err = DB.ListBooksByTags(r.Context(), tags, matchAll)
+	case q.Get("lang") != "":
+		books, err = DB.ListBooksByLanguage(r.Context(), q.Get("lang"))
+	case q.Get("genre") != "":
+		books, err = DB.ListBooksByGenre(r.Context(), q.Get("genre"))
+	case q.Get("status") != "":
+		books, err = DB.ListBooksByStatus(r.Context(), q.Get("status"))
+	case q.Get("minPages") != "" || q.Get("maxPages") != "":
+		var min, max int
+		if v := q.Get("minPages"); v != "" {
+			if min, err = strconv.Atoi(v); err != nil {
+				return nil, false, badRequestf(err, "bad minPages: %v", err)
+			}
+		}
+		if v := q.Get("maxPages"); v != "" {
+			if max, err = strconv.Atoi(v); err != nil {
+				return nil, false, badRequestf(err, "bad maxPages: %v", err)
+			}
+		}
+		books, err = DB.ListBooksByPageRange(r.Context(), min, max)
+	case q.Get("from") != "" || q.Get("to") != "":
+		var from, to time.Time
+		if v := q.Get("from"); v != "" {
+			if from, err = time.Parse("2006-01-02", v); err != nil {
+				return nil, false, badRequestf(err, "bad from: %v", err)
+			}
+		}
+		if v := q.Get("to"); v != "" {
+			if to, err = time.Parse("2006-01-02", v); err != nil {
+				return nil, false, badRequestf(err, "bad to: %v", err)
+			}
+		}
+		books, err = DB.ListBooksBetweenDates(r.Context(), from, to)
+	case q.Get("sort") != "":
+		var descending bool
+		switch order := q.Get("order"); order {
+		case "", "asc":
+		case "desc":
+			descending = true
+		default:
+			return nil, false, badRequestf(nil, "bad order %q: must be asc or desc", order)
+		}
+		books, err = DB.ListBooksSorted(r.Context(), q.Get("sort"), descending)
+	default:
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, appErrorf(err, "could not list books: %v", err)
+	}
+	return books, true, nil
+}
+
+// pageSizes are the number of items in a page when the limit parameter is
+// missing, and the most a page may hold.
+type pageSizes struct {
+	Default, Max int
+}
+
+// The page sizes used unless the config sets others.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// clamp returns limit within [1, s.Max].
+func (s pageSizes) clamp(limit int) int {
+	if limit < 1 {
+		return 1
+	}
+	if limit > s.Max {
+		return s.Max
+	}
+	return limit
+}
+
+// pageFromRequest reads the limit and offset query parameters, clamping the
+// limit to [1, pages.Max] and the offset to be non-negative.
+func pageFromRequest(r *http.Request, pages pageSizes) (limit, offset int, err error) {
+	limit, offset = pages.Default, 0
+	q := r.URL.Query()
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil {
+			return 0, 0, fmt.Errorf("bad limit: %v", err)
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil {
+			return 0, 0, fmt.Errorf("bad offset: %v", err)
+		}
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return pages.clamp(limit), offset, nil
+}
+
+// pageOf returns the window of books described by limit and offset.
+func pageOf(books []*bookshelf.Book, limit, offset int) []*bookshelf.Book {
+	if offset >= len(books) {
+		return []*bookshelf.Book{}
+	}
+	books = books[offset:]
+	if limit < len(books) {
+		books = books[:limit]
+	}
+	return books
+}
+
+// searchHandler displays the books matching the q query parameter. If tag
+// parameters are given, only the books carrying one of them are displayed.
+func searchHandler(w http.ResponseWriter, r *http.Request) *appError {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		return badRequestf(nil, "missing search query")
+	}
+	var tags []string
+	for _, t := range r.URL.Query()["tag"] {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	books, err := DB.SearchBooksFiltered(r.Context(), q, tags)
+	if err != nil {
+		return appErrorf(err, "could not search books: %v", err)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = encodeNamed(w, r, books)
+	if err != nil {
+		return appErrorf(err, "could not encode books: %v", err)
+	}
+	return nil
+}
+
+// suggestHandler displays up to limit titles starting with the q query
+// parameter, for autocompletion.
+func suggestHandler(w http.ResponseWriter, r *http.Request, pages pageSizes) *appError {
+	limit, _, err := pageFromRequest(r, pages)
+	if err != nil {
+		return badRequestf(err, "%v", err)
+	}
+	titles, err := DB.SuggestTitles(r.Context(), r.URL.Query().Get("q"), limit)
+	if err != nil {
+		return appErrorf(err, "could not suggest titles: %v", err)
+	}
+
 	w.Header().Add("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(books)
+	err = json.NewEncoder(w).Encode(titles)
+	if err != nil {
+		return appErrorf(err, "could not encode titles: %v", err)
+	}
+	return nil
+}
+
+// recentHandler displays up to limit of the most recently added books, newest
+// first.
+func recentHandler(w http.ResponseWriter, r *http.Request, pages pageSizes) *appError {
+	limit, _, err := pageFromRequest(r, pages)
+	if err != nil {
+		return badRequestf(err, "%v", err)
+	}
+	books, err := DB.ListRecentBooks(r.Context(), limit)
+	if err != nil {
+		return appErrorf(err, "could not list recent books: %v", err)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = encodeNamed(w, r, books)
 	if err != nil {
 		return appErrorf(err, "could not encode books: %v", err)
 	}
 	return nil
 }
 
+// randomHandler displays a book picked at random.
+func randomHandler(w http.ResponseWriter, r *http.Request) *appError {
+	book, err := DB.RandomBook(r.Context())
+	if err != nil {
+		return appErrorf(err, "could not pick a book: %v", err)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Content-Type", "application/json")
+	err = encodeNamed(w, r, book)
+	if err != nil {
+		return appErrorf(err, "could not encode book: %v", err)
+	}
+	return nil
+}
+
+// streamHandler sends every published book added from now on as a server-sent
+// event named "book", with the book as JSON for data, until the client goes
+// away. Browsers' EventSource reconnects by itself.
+func streamHandler(w http.ResponseWriter, r *http.Request) *appError {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return appErrorf(nil, "streaming is not supported")
+	}
+	books, unsubscribe := DB.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case b, ok := <-books:
+			if !ok {
+				return nil
+			}
+			if b.Status == bookshelf.StatusDraft {
+				continue
+			}
+			data, err := marshalNamed(r, b)
+			if err != nil {
+				Log.Errorf("Could not encode book %d for stream: %v", b.ID, err)
+				continue
+			}
+			fmt.Fprintf(w, "event: book\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
+// countHandler displays the number of books.
+func countHandler(w http.ResponseWriter, r *http.Request) *appError {
+	n, err := DB.CountBooks(r.Context())
+	if err != nil {
+		return appErrorf(err, "could not count books: %v", err)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(struct {
+		Count int64 `json:"count"`
+	}{n})
+	if err != nil {
+		return appErrorf(err, "could not encode count: %v", err)
+	}
+	return nil
+}
+
+// authorsHandler displays the distinct authors of the books.
+func authorsHandler(w http.ResponseWriter, r *http.Request) *appError {
+	authors, err := DB.ListAuthors(r.Context())
+	if err != nil {
+		return appErrorf(err, "could not list authors: %v", err)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(authors)
+	if err != nil {
+		return appErrorf(err, "could not encode authors: %v", err)
+	}
+	return nil
+}
+
+// topAuthorsHandler displays the authors with the most books, along with
+// how many each has written. The limit query parameter caps how many are
+// listed, 10 by default.
+func topAuthorsHandler(w http.ResponseWriter, r *http.Request) *appError {
+	limit := defaultTopAuthors
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return badRequestf(err, "bad limit %q", v)
+		}
+		limit = n
+	}
+	top, err := DB.TopAuthors(r.Context(), limit)
+	if err != nil {
+		return appErrorf(err, "could not count books by author: %v", err)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(top)
+	if err != nil {
+		return appErrorf(err, "could not encode authors: %v", err)
+	}
+	return nil
+}
+
+// defaultTopAuthors is how many authors topAuthorsHandler lists unless asked
+// otherwise.
+const defaultTopAuthors = 10
+
+// ratingsHandler displays the average rating of each author's books.
+func ratingsHandler(w http.ResponseWriter, r *http.Request) *appError {
+	ratings, err := DB.AverageRatingByAuthor(r.Context())
+	if err != nil {
+		return appErrorf(err, "could not average ratings: %v", err)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(ratings)
+	if err != nil {
+		return appErrorf(err, "could not encode ratings: %v", err)
+	}
+	return nil
+}
+
+// titleIndexHandler displays how many books have a title starting with each
+// letter, for an A-Z index.
+func titleIndexHandler(w http.ResponseWriter, r *http.Request) *appError {
+	index, err := DB.ListTitleIndex(r.Context())
+	if err != nil {
+		return appErrorf(err, "could not index titles: %v", err)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(index)
+	if err != nil {
+		return appErrorf(err, "could not encode index: %v", err)
+	}
+	return nil
+}
+
 // updateHandler updates the details of a given book.
+//
+// The request must carry an If-Match header listing the book's current ETag,
+// as sent by detailHandler, so that it cannot overwrite changes it has not
+// seen: without one it gets 428 Precondition Required, and with a stale one
+// 412 Precondition Failed. The ETag names the version the update is based on,
+// so any version in the request body is ignored.
 func updateHandler(w http.ResponseWriter, r *http.Request) *appError {
 	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
 		return appErrorf(err, "bad book id: %v", err)
 	}
 	var book bookshelf.Book
-	err = json.NewDecoder(r.Body).Decode(&book)
-	if err != nil {
-		return appErrorf(err, "could not decode json book: %v", err)
+	if aerr := decodeJSON(w, r, &book, "book"); aerr != nil {
+		return aerr
 	}
 	book.ID = id
+	if err := book.Validate(); err != nil {
+		return appErrorf(err, "invalid book")
+	}
 
-	err = DB.UpdateBook(&book)
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return &appError{Message: "If-Match header required", Code: http.StatusPreconditionRequired}
+	}
+	current, err := DB.GetBook(r.Context(), id)
+	if err != nil {
+		return appErrorf(err, "could not find book: %v", err)
+	}
+	body, err := json.Marshal(current)
+	if err != nil {
+		return appErrorf(err, "could not encode book: %v", err)
+	}
+	if !etagMatchesStrong(ifMatch, bookETag(body)) {
+		return &appError{Message: "book was changed by someone else", Code: http.StatusPreconditionFailed}
+	}
+	// A write made since GetBook still fails the update with a conflict.
+	book.Version = current.Version
+
+	err = DB.UpdateBook(r.Context(), &book)
 	if err != nil {
 		return appErrorf(err, "could not save book: %v", err)
 	}
@@ -114,21 +1120,333 @@ func updateHandler(w http.ResponseWriter, r *http.Request) *appError {
 	return nil
 }
 
-// detailHandler displays the details of a given book.
-func detailHandler(w http.ResponseWriter, r *http.Request) *appError {
+// patchHandler changes only the fields of a given book present in the
+// request body, and displays the updated book.
+func patchHandler(w http.ResponseWriter, r *http.Request) *appError {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return appErrorf(err, "bad book id: %v", err)
+	}
+	var fields map[string]interface{}
+	if aerr := decodeJSON(w, r, &fields, "fields"); aerr != nil {
+		return aerr
+	}
+
+	err = DB.UpdateBookFields(r.Context(), id, fields)
+	if err != nil {
+		return appErrorf(err, "could not save book: %v", err)
+	}
+	book, err := DB.GetBook(r.Context(), id)
+	if err != nil {
+		return appErrorf(err, "could not find book: %v", err)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = encodeNamed(w, r, book)
+	if err != nil {
+		return appErrorf(err, "could not encode book: %v", err)
+	}
+	return nil
+}
+
+// setTagsHandler replaces the tags of a given book with those in the request
+// body, given as {"tags": [...]}, and displays the updated book.
+func setTagsHandler(w http.ResponseWriter, r *http.Request) *appError {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return appErrorf(err, "bad book id: %v", err)
+	}
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if aerr := decodeJSON(w, r, &req, "tags"); aerr != nil {
+		return aerr
+	}
+
+	if err := DB.SetBookTags(r.Context(), id, req.Tags); err != nil {
+		return appErrorf(err, "could not save tags: %v", err)
+	}
+	return writeBook(w, r, id)
+}
+
+// removeTagHandler removes a tag from a given book, if it carries it, and
+// displays the updated book.
+func removeTagHandler(w http.ResponseWriter, r *http.Request) *appError {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return appErrorf(err, "bad book id: %v", err)
+	}
+	book, err := DB.GetBook(r.Context(), id)
+	if err != nil {
+		return appErrorf(err, "could not find book: %v", err)
+	}
+
+	tag := mux.Vars(r)["tag"]
+	tags := make([]string, 0, len(book.Tags))
+	for _, t := range book.Tags {
+		if t != tag {
+			tags = append(tags, t)
+		}
+	}
+	if len(tags) < len(book.Tags) {
+		if err := DB.SetBookTags(r.Context(), id, tags); err != nil {
+			return appErrorf(err, "could not save tags: %v", err)
+		}
+	}
+	return writeBook(w, r, id)
+}
+
+// writeBook displays the current version of a given book.
+func writeBook(w http.ResponseWriter, r *http.Request, id int64) *appError {
+	book, err := DB.GetBook(r.Context(), id)
+	if err != nil {
+		return appErrorf(err, "could not find book: %v", err)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = encodeNamed(w, r, book)
+	if err != nil {
+		return appErrorf(err, "could not encode book: %v", err)
+	}
+	return nil
+}
+
+// reindexHandler rebuilds the database's indexes.
+func reindexHandler(w http.ResponseWriter, r *http.Request) *appError {
+	if err := DB.Reindex(r.Context()); err != nil {
+		return appErrorf(err, "could not reindex: %v", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// renameAuthorHandler changes the author of every book written by one author,
+// both given in the request body as {"from": "...", "to": "..."}, and reports
+// how many books were changed.
+func renameAuthorHandler(w http.ResponseWriter, r *http.Request) *appError {
+	var req struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if aerr := decodeJSON(w, r, &req, "request"); aerr != nil {
+		return aerr
+	}
+	if req.From == "" {
+		return badRequestf(nil, "missing author to rename")
+	}
+	updated, err := DB.RenameAuthor(r.Context(), req.From, req.To)
+	if err != nil {
+		return appErrorf(err, "could not rename author: %v", err)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(struct {
+		Updated int `json:"updated"`
+	}{updated})
+	if err != nil {
+		return appErrorf(err, "could not encode result: %v", err)
+	}
+	return nil
+}
+
+// resetHandler permanently deletes every book and reports how many there
+// were.
+func resetHandler(w http.ResponseWriter, r *http.Request) *appError {
+	deleted, err := DB.DeleteAllBooks(r.Context())
+	if err != nil {
+		return appErrorf(err, "could not delete books: %v", err)
+	}
+	Log.Infof("Deleted all %d books", deleted)
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(struct {
+		Deleted int `json:"deleted"`
+	}{deleted})
+	if err != nil {
+		return appErrorf(err, "could not encode result: %v", err)
+	}
+	return nil
+}
+
+// updateWhereHandler sets fields on every book matching a filter, both given
+// in the request body as {"filter": {...}, "set": {...}}, and reports how
+// many books matched.
+func updateWhereHandler(w http.ResponseWriter, r *http.Request) *appError {
+	var req struct {
+		Filter map[string]interface{} `json:"filter"`
+		Set    map[string]interface{} `json:"set"`
+	}
+	if aerr := decodeJSON(w, r, &req, "request"); aerr != nil {
+		return aerr
+	}
+	matched, err := DB.UpdateBooksWhere(r.Context(), req.Filter, req.Set)
+	if err != nil {
+		return appErrorf(err, "could not update books: %v", err)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(struct {
+		Matched int `json:"matched"`
+	}{matched})
+	if err != nil {
+		return appErrorf(err, "could not encode result: %v", err)
+	}
+	return nil
+}
+
+// maxCoverBytes caps the size of an uploaded cover image.
+const maxCoverBytes = 10 << 20
+
+// coverDir is the directory cover images are stored in and served from.
+var coverDir = "covers"
+
+// uploadCoverHandler stores the cover image uploaded in the "image" form
+// field for a given book, and displays the book with its new cover URL.
+func uploadCoverHandler(w http.ResponseWriter, r *http.Request) *appError {
 	book, err := bookFromRequest(r)
 	if err != nil {
 		return appErrorf(err, "%v", err)
 	}
 
+	r.Body = http.MaxBytesReader(w, r.Body, maxCoverBytes)
+	f, header, err := r.FormFile("image")
+	if err != nil {
+		return badRequestf(err, "could not read uploaded image: %v", err)
+	}
+	defer f.Close()
+	contentType := header.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		return badRequestf(nil, "uploaded file is not an image: %q", contentType)
+	}
+
+	url, err := Covers.Save(r.Context(), book.ID, f, contentType)
+	if err != nil {
+		return appErrorf(err, "could not save cover: %v", err)
+	}
+	err = DB.UpdateBookFields(r.Context(), book.ID, map[string]interface{}{"cover_url": url})
+	if err != nil {
+		return appErrorf(err, "could not save book: %v", err)
+	}
+	book, err = DB.GetBook(r.Context(), book.ID)
+	if err != nil {
+		return appErrorf(err, "could not find book: %v", err)
+	}
+
 	w.Header().Add("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(book)
+	err = encodeNamed(w, r, book)
 	if err != nil {
 		return appErrorf(err, "could not encode book: %v", err)
 	}
 	return nil
 }
 
+// detailHandler displays the details of a given book, or only those listed
+// by the fields parameter.
+//
+// The response carries a strong ETag, and a request whose If-None-Match header
+// lists it gets an empty 304 Not Modified response instead. The ETag is
+// computed from the full snake_case encoding of the book, whatever the
+// naming and fields parameters, so that it can be sent back in the If-Match
+// of an update.
+func detailHandler(w http.ResponseWriter, r *http.Request) *appError {
+	book, err := bookFromRequest(r)
+	if err != nil {
+		return appErrorf(err, "%v", err)
+	}
+
+	body, err := json.Marshal(book)
+	if err != nil {
+		return appErrorf(err, "could not encode book: %v", err)
+	}
+	etag := bookETag(body)
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	if acceptsByName(r, jsonAPI) {
+		return writeJSONAPIBook(w, r, book)
+	}
+
+	var v interface{} = book
+	if fields := fieldsFromRequest(r); fields != nil {
+		if v, err = selectFields(book, fields); err != nil {
+			return appErrorf(err, "could not encode book: %v", err)
+		}
+	}
+	w.Header().Add("Content-Type", "application/json")
+	if err := encodeNamed(w, r, v); err != nil {
+		return appErrorf(err, "could not encode book: %v", err)
+	}
+	return nil
+}
+
+// existsHandler answers 200 OK if a given book exists and 404 Not Found
+// otherwise, with no body either way.
+func existsHandler(w http.ResponseWriter, r *http.Request) *appError {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return appErrorf(err, "bad book id: %v", err)
+	}
+	exists, err := DB.BookExists(r.Context(), id)
+	if err != nil {
+		return appErrorf(err, "could not look up book: %v", err)
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// addReviewHandler adds a review to a given book and displays it.
+func addReviewHandler(w http.ResponseWriter, r *http.Request) *appError {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return appErrorf(err, "bad book id: %v", err)
+	}
+	var review bookshelf.Review
+	if aerr := decodeJSON(w, r, &review, "review"); aerr != nil {
+		return aerr
+	}
+	if err := DB.AddReview(r.Context(), id, &review); err != nil {
+		return appErrorf(err, "could not save review: %v", err)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := encodeNamed(w, r, review); err != nil {
+		return appErrorf(err, "could not encode review: %v", err)
+	}
+	return nil
+}
+
+// listReviewsHandler displays a page of the reviews of a given book, oldest
+// first, taking the same limit and offset parameters as listHandler. The
+// total number of reviews is sent in the X-Total-Count header.
+func listReviewsHandler(w http.ResponseWriter, r *http.Request, pages pageSizes) *appError {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return appErrorf(err, "bad book id: %v", err)
+	}
+	limit, offset, err := pageFromRequest(r, pages)
+	if err != nil {
+		return badRequestf(err, "%v", err)
+	}
+	reviews, total, err := DB.ListReviewsPaged(r.Context(), id, limit, offset)
+	if err != nil {
+		return appErrorf(err, "could not list reviews: %v", err)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if err := encodeNamed(w, r, reviews); err != nil {
+		return appErrorf(err, "could not encode reviews: %v", err)
+	}
+	return nil
+}
+
 // bookFromRequest retrieves a book from the database given a book ID in the
 // URL's path.
 func bookFromRequest(r *http.Request) (*bookshelf.Book, error) {
@@ -136,20 +1454,33 @@ func bookFromRequest(r *http.Request) (*bookshelf.Book, error) {
 	if err != nil {
 		return nil, fmt.Errorf("bad book id: %v", err)
 	}
-	book, err := DB.GetBook(id)
+	book, err := DB.GetBook(r.Context(), id)
 	if err != nil {
-		return nil, fmt.Errorf("could not find book: %v", err)
+		return nil, fmt.Errorf("could not find book: %w", err)
 	}
 	return book, nil
 }
 
-// deleteHandler deletes a given book.
+// deleteHandler deletes a given book. With dryRun=true, it displays the book
+// that would be deleted instead.
 func deleteHandler(w http.ResponseWriter, r *http.Request) *appError {
 	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
 		return appErrorf(err, "bad book id: %v", err)
 	}
-	err = DB.DeleteBook(id)
+	if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun")); dryRun {
+		book, err := DB.DeleteBookDryRun(r.Context(), id)
+		if err != nil {
+			return appErrorf(err, "could not delete book: %v", err)
+		}
+		w.Header().Add("Content-Type", "application/json")
+		if err := encodeNamed(w, r, book); err != nil {
+			return appErrorf(err, "could not encode book: %v", err)
+		}
+		return nil
+	}
+
+	err = DB.DeleteBook(r.Context(), id)
 	if err != nil {
 		return appErrorf(err, "could not delete book: %v", err)
 	}
@@ -157,21 +1488,114 @@ func deleteHandler(w http.ResponseWriter, r *http.Request) *appError {
 	return nil
 }
 
+// batchGetHandler displays the books whose IDs are listed in the request
+// body, as {"ids": [...]}, in the same order. Missing books are left out.
+func batchGetHandler(w http.ResponseWriter, r *http.Request) *appError {
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	if aerr := decodeJSON(w, r, &req, "ids"); aerr != nil {
+		return aerr
+	}
+
+	books, err := DB.GetBooks(r.Context(), req.IDs)
+	if err != nil {
+		return appErrorf(err, "could not get books: %v", err)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = encodeNamed(w, r, books)
+	if err != nil {
+		return appErrorf(err, "could not encode books: %v", err)
+	}
+	return nil
+}
+
+// batchDeleteHandler deletes the books whose IDs are listed in the request
+// body, as {"ids": [...]}, and reports how many were deleted.
+func batchDeleteHandler(w http.ResponseWriter, r *http.Request) *appError {
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	if aerr := decodeJSON(w, r, &req, "ids"); aerr != nil {
+		return aerr
+	}
+
+	n, err := DB.DeleteBooks(r.Context(), req.IDs)
+	if err != nil {
+		return appErrorf(err, "could not delete books: %v", err)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(struct {
+		Deleted int `json:"deleted"`
+	}{n})
+	if err != nil {
+		return appErrorf(err, "could not encode result: %v", err)
+	}
+	return nil
+}
+
 // http://blog.golang.org/error-handling-and-go
 type appHandler func(http.ResponseWriter, *http.Request) *appError
 
 type appError struct {
-	Error   error
-	Message string
-	Code    int
+	Error     error
+	Message   string
+	Code      int
+	RequestID string
+
+	// Fields maps the JSON names of the invalid fields of a book to what is
+	// wrong with them.
+	Fields map[string]string
+}
+
+// errorBody is the JSON shape of an appError sent to clients.
+type errorBody struct {
+	Error     string            `json:"error"`
+	Code      int               `json:"code"`
+	RequestID string            `json:"request_id,omitempty"`
+	Errors    map[string]string `json:"errors,omitempty"`
 }
 
 func (fn appHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if e := fn(w, r); e != nil { // e is *appError, not os.Error.
-		log.Printf("Handler error: status code: %d, message: %s, underlying err: %#v",
-			e.Code, e.Message, e.Error)
+	rw := newResponseWriter(w)
+	if e := fn(rw, r); e != nil { // e is *appError, not os.Error.
+		// Not every database error wraps the context's, so check it directly.
+		if r.Context().Err() == context.DeadlineExceeded {
+			e.Message = "request timed out"
+			e.Code = http.StatusServiceUnavailable
+		}
+		logf := Log.Infof
+		if e.Code >= http.StatusInternalServerError {
+			logf = Log.Errorf
+		}
+		if e.RequestID == "" {
+			e.RequestID = RequestIDFromContext(r.Context())
+		}
+		logf("Handler error: request id: %s, status code: %d, message: %s, underlying err: %#v",
+			e.RequestID, e.Code, e.Message, e.Error)
+		if rw.started {
+			// The response is under way, as for a failed export, so the
+			// error can only cut it short.
+			return
+		}
 
-		http.Error(w, e.Message, e.Code)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if acceptsByName(r, problemJSON) {
+			w.Header().Set("Content-Type", problemJSON)
+			w.WriteHeader(e.Code)
+			json.NewEncoder(w).Encode(newProblem(e))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(e.Code)
+		json.NewEncoder(w).Encode(errorBody{
+			Error:     e.Message,
+			Code:      e.Code,
+			RequestID: e.RequestID,
+			Errors:    e.Fields,
+		})
 	}
 }
 
@@ -179,6 +1603,59 @@ func appErrorf(err error, format string, v ...interface{}) *appError {
 	return &appError{
 		Error:   err,
 		Message: fmt.Sprintf(format, v...),
-		Code:    500,
+		Code:    errorCode(err),
+		Fields:  fieldErrors(err),
+	}
+}
+
+// fieldErrors returns the problems listed by a ValidationError in err, keyed
+// by field, or nil if there is none.
+func fieldErrors(err error) map[string]string {
+	var verr bookshelf.ValidationError
+	if !errors.As(err, &verr) {
+		return nil
+	}
+	fields := make(map[string]string, len(verr))
+	for _, fe := range verr {
+		if msg, ok := fields[fe.Field]; ok {
+			fields[fe.Field] = msg + "; " + fe.Err.Error()
+		} else {
+			fields[fe.Field] = fe.Err.Error()
+		}
+	}
+	return fields
+}
+
+// badRequestf is like appErrorf, but blames the client for the error.
+func badRequestf(err error, format string, v ...interface{}) *appError {
+	e := appErrorf(err, format, v...)
+	e.Code = http.StatusBadRequest
+	return e
+}
+
+// errorCode maps well-known database errors to the HTTP status code that
+// best describes them, defaulting to 500.
+func errorCode(err error) int {
+	var verr bookshelf.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		return http.StatusBadRequest
+	case errors.Is(err, bookshelf.ErrBookNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, bookshelf.ErrDatabaseUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, bookshelf.ErrReadOnly):
+		return http.StatusForbidden
+	case errors.Is(err, bookshelf.ErrVersionConflict),
+		errors.Is(err, bookshelf.ErrDuplicateISBN):
+		return http.StatusConflict
+	case errors.Is(err, bookshelf.ErrInvalidSortField),
+		errors.Is(err, bookshelf.ErrInvalidISBN),
+		errors.Is(err, bookshelf.ErrInvalidGenre),
+		errors.Is(err, bookshelf.ErrInvalidStatus),
+		errors.Is(err, bookshelf.ErrInvalidRating):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
 	}
 }
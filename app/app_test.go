@@ -0,0 +1,1835 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sashayakovtseva/bookshelf"
+)
+
+// defaultPages are the page sizes the app uses unless configured otherwise.
+var defaultPages = pageSizes{Default: defaultPageSize, Max: maxPageSize}
+
+// serve sends req to the app's handler, set up with the default page sizes,
+// and returns the recorded response.
+func serve(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(defaultPages).ServeHTTP(w, req)
+	return w
+}
+
+// addBooks adds books with the given titles to DB.
+func addBooks(t *testing.T, titles ...string) {
+	t.Helper()
+	for _, title := range titles {
+		if _, err := DB.AddBook(context.Background(), &bookshelf.Book{Title: title}); err != nil {
+			t.Fatalf("AddBook: %v", err)
+		}
+	}
+}
+
+// decodeTitles decodes the list of books in the body of a 200 response and
+// returns their titles.
+func decodeTitles(t *testing.T, w *httptest.ResponseRecorder) []string {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", w.Code, w.Body)
+	}
+	var books []*bookshelf.Book
+	if err := json.NewDecoder(w.Body).Decode(&books); err != nil {
+		t.Fatalf("decoding books: %v", err)
+	}
+	titles := []string{}
+	for _, b := range books {
+		titles = append(titles, b.Title)
+	}
+	return titles
+}
+
+// metadataKeyError returns the error of listing books by a bad metadata key.
+func metadataKeyError(t *testing.T) error {
+	t.Helper()
+	_, err := bookshelf.NewMemoryDB().ListBooksByMetadata(context.Background(), "$where", "1")
+	if err == nil {
+		t.Fatal("ListBooksByMetadata($where): got no error")
+	}
+	return err
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{bookshelf.ErrBookNotFound, http.StatusNotFound},
+		{fmt.Errorf("could not find book: %w", bookshelf.ErrBookNotFound), http.StatusNotFound},
+		{fmt.Errorf("could not list books: %w: no reachable servers", bookshelf.ErrDatabaseUnavailable), http.StatusServiceUnavailable},
+		{fmt.Errorf("could not add book: %w", bookshelf.ErrReadOnly), http.StatusForbidden},
+		{bookshelf.ErrInvalidGenre, http.StatusBadRequest},
+		{bookshelf.ErrInvalidStatus, http.StatusBadRequest},
+		{metadataKeyError(t), http.StatusBadRequest},
+		{errors.New("boom"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := errorCode(tt.err); got != tt.want {
+			t.Errorf("errorCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDetailNotFound(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	w := serve(httptest.NewRequest("GET", "/books/7", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /books/7: got status %d, want 404", w.Code)
+	}
+}
+
+func TestPageFromRequest(t *testing.T) {
+	tests := []struct {
+		query         string
+		limit, offset int
+		wantErr       bool
+	}{
+		{query: "", limit: 20, offset: 0},
+		{query: "limit=7&offset=3", limit: 7, offset: 3},
+		{query: "limit=101", limit: 100},
+		{query: "limit=0", limit: 1},
+		{query: "limit=-3&offset=-1", limit: 1, offset: 0},
+		{query: "limit=ten", wantErr: true},
+		{query: "offset=x", wantErr: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/books?"+tt.query, nil)
+		limit, offset, err := pageFromRequest(r, defaultPages)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pageFromRequest(%q): got error %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (limit != tt.limit || offset != tt.offset) {
+			t.Errorf("pageFromRequest(%q) = %d, %d; want %d, %d", tt.query, limit, offset, tt.limit, tt.offset)
+		}
+	}
+}
+
+func TestPageSizes(t *testing.T) {
+	pages := pageSizes{Default: 2, Max: 5}
+	for _, tt := range []struct {
+		query string
+		limit int
+	}{
+		{"", 2},
+		{"limit=4", 4},
+		{"limit=50", 5},
+	} {
+		limit, _, err := pageFromRequest(httptest.NewRequest("GET", "/books?"+tt.query, nil), pages)
+		if err != nil || limit != tt.limit {
+			t.Errorf("pageFromRequest(%q) with sizes 2 and 5 = %d, %v; want %d", tt.query, limit, err, tt.limit)
+		}
+	}
+}
+
+func TestListClampsLimit(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	addBooks(t, "Dune", "Emma", "Middlemarch", "Persuasion", "Ulysses")
+	h := handler(pageSizes{Default: 2, Max: 3})
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/books", 2},
+		{"/books?limit=3", 3},
+		{"/books?limit=50", 3},
+		{"/books/recent?limit=50", 3},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if got := decodeTitles(t, w); len(got) != tt.want {
+			t.Errorf("GET %s with sizes 2 and 3: got %d books, want %d", tt.path, len(got), tt.want)
+		}
+	}
+}
+
+func TestConfigPageSizes(t *testing.T) {
+	c := &Config{DefaultPageSize: 50, MaxPageSize: 30}
+	if got, want := c.pageSizes(), (pageSizes{Default: 30, Max: 30}); got != want {
+		t.Errorf("pageSizes() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPageSizeFromEnv(t *testing.T) {
+	defer func(l bookshelf.Logger) { Log = l }(Log)
+	Log = bookshelf.NewLogger(io.Discard, bookshelf.LevelInfo)
+
+	for _, tt := range []struct {
+		value string
+		want  int
+	}{
+		{"", 20},
+		{"50", 50},
+		{"0", 20},
+		{"-5", 20},
+		{"many", 20},
+	} {
+		t.Setenv("TEST_PAGE_SIZE", tt.value)
+		if got := pageSizeFromEnv("TEST_PAGE_SIZE", 20); got != tt.want {
+			t.Errorf("pageSizeFromEnv with %q = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestListBadPage(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	if w := serve(httptest.NewRequest("GET", "/books?limit=ten", nil)); w.Code != http.StatusBadRequest {
+		t.Errorf("GET /books?limit=ten: got status %d, want 400", w.Code)
+	}
+	w := serve(httptest.NewRequest("GET", "/books", nil))
+	if w.Code != http.StatusOK || w.Header().Get("X-Total-Count") != "0" {
+		t.Errorf("GET /books: got status %d and X-Total-Count %q, want 200 and 0", w.Code, w.Header().Get("X-Total-Count"))
+	}
+}
+
+func TestSearch(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	addBooks(t, "Dune", "Emma")
+
+	if w := serve(httptest.NewRequest("GET", "/books/search?q=+", nil)); w.Code != http.StatusBadRequest {
+		t.Errorf("GET /books/search without a query: got status %d, want 400", w.Code)
+	}
+	w := serve(httptest.NewRequest("GET", "/books/search?q=dune", nil))
+	if got := decodeTitles(t, w); !reflect.DeepEqual(got, []string{"Dune"}) {
+		t.Errorf("GET /books/search?q=dune = %q, want [Dune]", got)
+	}
+
+	for _, b := range []*bookshelf.Book{
+		{Title: "Dune Messiah", Tags: []string{"scifi"}},
+		{Title: "Dune Road", Tags: []string{"travel"}},
+	} {
+		if _, err := DB.AddBook(context.Background(), b); err != nil {
+			t.Fatalf("AddBook: %v", err)
+		}
+	}
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"q=dune&tag=scifi", []string{"Dune Messiah"}},
+		{"q=dune&tag=scifi&tag=travel", []string{"Dune Messiah", "Dune Road"}},
+		{"q=dune&tag=", []string{"Dune", "Dune Messiah", "Dune Road"}},
+	} {
+		w := serve(httptest.NewRequest("GET", "/books/search?"+tt.query, nil))
+		if got := decodeTitles(t, w); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GET /books/search?%s = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestListSorted(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	addBooks(t, "Emma", "Dune", "Persuasion")
+
+	w := serve(httptest.NewRequest("GET", "/books?sort=title&order=desc&limit=2", nil))
+	if got, want := decodeTitles(t, w), []string{"Persuasion", "Emma"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GET /books sorted by descending title = %q, want %q", got, want)
+	}
+	if got := w.Header().Get("X-Total-Count"); got != "3" {
+		t.Errorf("got X-Total-Count %q, want 3", got)
+	}
+	for _, query := range []string{"sort=title&order=up", "sort=isbn"} {
+		if w := serve(httptest.NewRequest("GET", "/books?"+query, nil)); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /books?%s: got status %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	w := serve(httptest.NewRequest("GET", "/books/7", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("GET /books/7: got status %d, want 404", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("got Content-Type %q, want application/json", got)
+	}
+	var body errorBody
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding error body: %v", err)
+	}
+	if body.Code != http.StatusNotFound || !strings.Contains(body.Error, "not found") {
+		t.Errorf("got error body %+v, want code 404 and a not found message", body)
+	}
+}
+
+// csvUpload returns a request posting data to path as the "file" field of a
+// multipart form.
+func csvUpload(t *testing.T, path, data string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "books.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(fw, data)
+	mw.Close()
+	req := httptest.NewRequest("POST", path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestImportCSV(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	w := serve(csvUpload(t, "/books:import", `title,author,published_date,description
+Dune,Frank Herbert,1965,A desert planet.
+Emma,Jane Austen
+,Nobody,2000,No title.
+Persuasion,Jane Austen,1817,"A second chance."
+`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /books:import: got status %d, want 200: %s", w.Code, w.Body)
+	}
+	var res importResult
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("decoding import result: %v", err)
+	}
+	if res.Imported != 2 || len(res.Errors) != 2 || res.Errors[0].Row != 3 || res.Errors[1].Row != 4 {
+		t.Errorf("got import result %+v, want 2 imported and errors on rows 3 and 4", res)
+	}
+	books, err := DB.ListBooks(context.Background())
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	if len(books) != 2 || books[0].Title != "Dune" || books[1].Author != "Jane Austen" {
+		t.Errorf("got books %+v after the import, want Dune and Persuasion", books)
+	}
+
+	if w := serve(httptest.NewRequest("POST", "/books:import", nil)); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /books:import without a file: got status %d, want 400", w.Code)
+	}
+}
+
+func TestCreateCreditsUser(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	defer func(user, pass string) { WriteUsername, WritePassword = user, pass }(WriteUsername, WritePassword)
+	const body = `{"title":"Dune","created_by_id":"mallory","created_by":"Mallory"}`
+
+	// Without credentials, the owner is taken as sent.
+	w := serve(httptest.NewRequest("POST", "/books", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /books: got status %d, want 201: %s", w.Code, w.Body)
+	}
+
+	WriteUsername, WritePassword = "editor", "s3cret"
+	r := httptest.NewRequest("POST", "/books", strings.NewReader(body))
+	r.SetBasicAuth("editor", "s3cret")
+	if w := serve(r); w.Code != http.StatusCreated {
+		t.Fatalf("POST /books: got status %d, want 201: %s", w.Code, w.Body)
+	}
+	r = csvUpload(t, "/books:import", "Emma,Jane Austen,1815,\n")
+	r.SetBasicAuth("editor", "s3cret")
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Fatalf("POST /books:import: got status %d, want 200: %s", w.Code, w.Body)
+	}
+
+	books, err := DB.ListBooks(context.Background())
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	var got []string
+	for _, b := range books {
+		got = append(got, b.Title+" by "+b.CreatedByID+"/"+b.CreatedBy)
+	}
+	want := []string{"Dune by mallory/Mallory", "Dune by editor/editor", "Emma by editor/editor"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("created %q, want %q", got, want)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	for _, b := range []*bookshelf.Book{
+		{Title: "Emma", Author: "Jane Austen", PublishedDate: "1815"},
+		{Title: "Dune", Author: "Frank Herbert", PublishedDate: "1965", Description: "Spice, sand, \"worms\"."},
+	} {
+		if _, err := DB.AddBook(context.Background(), b); err != nil {
+			t.Fatalf("AddBook: %v", err)
+		}
+	}
+
+	w := serve(httptest.NewRequest("GET", "/books.csv", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /books.csv: got status %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/csv" {
+		t.Errorf("got Content-Type %q, want text/csv", got)
+	}
+	want := "title,author,published_date,description\n" +
+		"Dune,Frank Herbert,1965,\"Spice, sand, \"\"worms\"\".\"\n" +
+		"Emma,Jane Austen,1815,\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("GET /books.csv =\n%s\nwant\n%s", got, want)
+	}
+
+	// The export can be imported back.
+	books, rowErrs, err := parseBooksCSV(strings.NewReader(want))
+	if err != nil || len(rowErrs) != 0 || len(books) != 2 || books[0].Description != "Spice, sand, \"worms\"." {
+		t.Errorf("parseBooksCSV of the export = %+v, %v, %v; want both books", books, rowErrs, err)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	db := bookshelf.NewMemoryDB()
+	DB = db
+
+	if w := serve(httptest.NewRequest("GET", "/healthz", nil)); w.Code != http.StatusOK {
+		t.Errorf("health check with open database: got status %d, want 200", w.Code)
+	}
+	db.Close()
+	if w := serve(httptest.NewRequest("GET", "/healthz", nil)); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("health check with closed database: got status %d, want 503", w.Code)
+	}
+	for _, method := range []string{"GET", "HEAD"} {
+		if w := serve(httptest.NewRequest(method, "/livez", nil)); w.Code != http.StatusOK || w.Body.Len() != 0 {
+			t.Errorf("%s /livez with closed database: got status %d and %q, want an empty 200", method, w.Code, w.Body)
+		}
+	}
+	if w := serve(httptest.NewRequest("POST", "/livez", nil)); w.Code == http.StatusOK {
+		t.Errorf("POST /livez: got status 200, want it not to be answered as a probe")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+
+	req := httptest.NewRequest("POST", "/books", strings.NewReader(`{"title":"Dune","author":"Frank Herbert"}`))
+	w := serve(req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /books: got status %d, want 201", w.Code)
+	}
+	var got bookshelf.Book
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding created book: %v", err)
+	}
+	if got.ID == 0 || got.Title != "Dune" {
+		t.Errorf("POST /books returned %+v, want Dune with an ID", got)
+	}
+	if want := fmt.Sprintf("/books/%d", got.ID); w.Header().Get("Location") != want {
+		t.Errorf("got Location %q, want %q", w.Header().Get("Location"), want)
+	}
+
+	req = httptest.NewRequest("POST", "/books", strings.NewReader(`{"title":"Emma"}`))
+	req.Header.Set("Accept", "text/html,application/json;q=0.9")
+	if w := serve(req); w.Code != http.StatusFound {
+		t.Errorf("POST /books from a browser: got status %d, want 302", w.Code)
+	}
+}
+
+func TestPrefersHTML(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"text/html", true},
+		{"text/html;q=0.5, application/json", false},
+		{"text/*, application/json;q=0.8", true},
+		{"text/html;q=0.1, */*", false},
+		{"TEXT/HTML", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/books", nil)
+		r.Header.Set("Accept", tt.accept)
+		if got := prefersHTML(r); got != tt.want {
+			t.Errorf("prefersHTML(Accept: %q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestRatings(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	for _, b := range []*bookshelf.Book{
+		{Title: "Emma", Author: "Jane Austen", Rating: 4},
+		{Title: "Persuasion", Author: "Jane Austen", Rating: 5},
+	} {
+		if _, err := DB.AddBook(context.Background(), b); err != nil {
+			t.Fatalf("AddBook: %v", err)
+		}
+	}
+
+	w := serve(httptest.NewRequest("GET", "/books/stats/ratings", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /books/stats/ratings: got status %d, want 200", w.Code)
+	}
+	var got map[string]float64
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding ratings: %v", err)
+	}
+	if want := map[string]float64{"Jane Austen": 4.5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GET /books/stats/ratings = %v, want %v", got, want)
+	}
+
+	req := httptest.NewRequest("POST", "/books", strings.NewReader(`{"title":"Dune","rating":6}`))
+	if w := serve(req); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /books with rating 6: got status %d, want 400", w.Code)
+	}
+}
+
+func TestListByTag(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	for _, b := range []*bookshelf.Book{
+		{Title: "Neuromancer", Tags: []string{"scifi"}},
+		{Title: "Emma", Tags: []string{"classics"}},
+		{Title: "Dune", Tags: []string{"scifi", "classics"}},
+	} {
+		if _, err := DB.AddBook(context.Background(), b); err != nil {
+			t.Fatalf("AddBook: %v", err)
+		}
+	}
+
+	w := serve(httptest.NewRequest("GET", "/books?tag=scifi", nil))
+	if got, want := decodeTitles(t, w), []string{"Dune", "Neuromancer"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GET /books?tag=scifi = %q, want %q", got, want)
+	}
+	if got := w.Header().Get("X-Total-Count"); got != "2" {
+		t.Errorf("got X-Total-Count %q, want 2", got)
+	}
+
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"tags=scifi,classics", []string{"Dune", "Emma", "Neuromancer"}},
+		{"tags=scifi,classics&match=any", []string{"Dune", "Emma", "Neuromancer"}},
+		{"tags=scifi,%20classics&match=all", []string{"Dune"}},
+	} {
+		w := serve(httptest.NewRequest("GET", "/books?"+tt.query, nil))
+		if got := decodeTitles(t, w); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GET /books?%s = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+	if w := serve(httptest.NewRequest("GET", "/books?tags=scifi&match=most", nil)); w.Code != http.StatusBadRequest {
+		t.Errorf("GET /books?tags=scifi&match=most: got status %d, want 400", w.Code)
+	}
+}
+
+// TestShutdown runs main in a child process and checks that SIGTERM stops it
+// cleanly. It needs a Mongo server, found through MONGO_URL.
+func TestShutdown(t *testing.T) {
+	if os.Getenv("BOOKSHELF_RUN_MAIN") == "1" {
+		main()
+		return
+	}
+	if os.Getenv("MONGO_URL") == "" {
+		t.Skip("MONGO_URL not set")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	var out bytes.Buffer
+	cmd := exec.Command(os.Args[0], "-test.run=^TestShutdown$")
+	cmd.Env = append(os.Environ(), "BOOKSHELF_RUN_MAIN=1", "PORT="+port)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("starting server: %v", err)
+	}
+
+	up := false
+	for i := 0; i < 50 && !up; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if resp, err := http.Get("http://127.0.0.1:" + port + "/healthz"); err == nil {
+			resp.Body.Close()
+			up = resp.StatusCode == http.StatusOK
+		}
+	}
+	if !up {
+		cmd.Process.Kill()
+		cmd.Wait()
+		t.Fatalf("server did not become healthy:\n%s", out.String())
+	}
+
+	cmd.Process.Signal(syscall.SIGTERM)
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("server exited with %v:\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "Shut down") {
+		t.Errorf("server output lacks the shutdown message:\n%s", out.String())
+	}
+}
+
+func TestCreateInvalid(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+
+	req := httptest.NewRequest("POST", "/books", strings.NewReader(`{"author":"Frank Herbert","isbn":"12345"}`))
+	w := serve(req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("POST /books without a title: got status %d, want 400", w.Code)
+	}
+	var body errorBody
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding error body: %v", err)
+	}
+	if body.Errors["title"] != "required" || body.Errors["isbn"] == "" || len(body.Errors) != 2 {
+		t.Errorf("POST /books without a title: got field errors %q, want title and isbn", body.Errors)
+	}
+	if books, _ := DB.ListBooks(context.Background()); len(books) != 0 {
+		t.Errorf("invalid book was saved")
+	}
+
+	id, err := DB.AddBook(context.Background(), &bookshelf.Book{Title: "Dune"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	path := fmt.Sprintf("/books/%d", id)
+	w = serve(httptest.NewRequest("PUT", path, strings.NewReader(`{"title":"Dune","rating":7,"version":1}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("PUT %s with a rating of 7: got status %d, want 400", path, w.Code)
+	}
+	body = errorBody{}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding error body: %v", err)
+	}
+	if _, ok := body.Errors["rating"]; !ok || len(body.Errors) != 1 {
+		t.Errorf("PUT %s with a rating of 7: got field errors %q, want rating", path, body.Errors)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	orig := &bookshelf.Book{Title: "Dune", Author: "Frank Herbert", PublishedDate: "1965", Tags: []string{"scifi"}}
+	id, err := DB.AddBook(context.Background(), orig)
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	path := fmt.Sprintf("/books/%d", id)
+
+	w := serve(httptest.NewRequest("PATCH", path, strings.NewReader(`{"title":"Dune Messiah"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH %s: got status %d, want 200: %s", path, w.Code, w.Body)
+	}
+	var got bookshelf.Book
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding patched book: %v", err)
+	}
+	want := bookshelf.Book{ID: id, Title: "Dune Messiah", Author: "Frank Herbert", PublishedDate: "1965", Tags: []string{"scifi"},
+		Status: bookshelf.StatusPublished, Version: 2, CreatedAt: got.CreatedAt, UpdatedAt: got.UpdatedAt}
+	if got.UpdatedAt.Before(got.CreatedAt) {
+		t.Errorf("PATCH %s: UpdatedAt %v is before CreatedAt %v", path, got.UpdatedAt, got.CreatedAt)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PATCH %s returned %+v, want %+v", path, got, want)
+	}
+
+	tests := []struct {
+		path, body string
+		want       int
+	}{
+		{path, `{"created_by":"mallory"}`, http.StatusBadRequest},
+		{path, `{"id":99}`, http.StatusBadRequest},
+		{path, `{"rating":"high"}`, http.StatusBadRequest},
+		{path, `{"title":`, http.StatusBadRequest},
+		{"/books/999999", `{"title":"Emma"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := serve(httptest.NewRequest("PATCH", tt.path, strings.NewReader(tt.body))); w.Code != tt.want {
+			t.Errorf("PATCH %s %s: got status %d, want %d", tt.path, tt.body, w.Code, tt.want)
+		}
+	}
+	if b, err := DB.GetBook(context.Background(), id); err != nil || b.Title != "Dune Messiah" || b.CreatedBy != "" {
+		t.Errorf("after rejected patches, GetBook = %+v, %v", b, err)
+	}
+}
+
+func TestListAfter(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	addBooks(t, "Dune", "Emma", "Persuasion")
+
+	var got []string
+	path := "/books?after=0&limit=2"
+	for i := 0; path != ""; i++ {
+		if i == 3 {
+			t.Fatalf("Link headers did not run out")
+		}
+		w := serve(httptest.NewRequest("GET", path, nil))
+		got = append(got, decodeTitles(t, w)...)
+		path = ""
+		if link := w.Header().Get("Link"); link != "" {
+			if !strings.HasSuffix(link, `>; rel="next"`) {
+				t.Fatalf("malformed Link header %q", link)
+			}
+			path = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+		}
+	}
+	if want := []string{"Dune", "Emma", "Persuasion"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("following Link headers listed %q, want %q", got, want)
+	}
+
+	if w := serve(httptest.NewRequest("GET", "/books?after=abc", nil)); w.Code != http.StatusBadRequest {
+		t.Errorf("GET /books?after=abc: got status %d, want 400", w.Code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	w := serve(httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics: got status %d, want 200", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "go_goroutines") {
+		t.Errorf("GET /metrics does not expose the Go runtime metrics")
+	}
+}
+
+// coverUpload builds a request uploading data as a cover image of the given
+// content type.
+func coverUpload(t *testing.T, path, contentType, data string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreatePart(map[string][]string{
+		"Content-Disposition": {`form-data; name="image"; filename="cover"`},
+		"Content-Type":        {contentType},
+	})
+	if err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+	io.WriteString(part, data)
+	mw.Close()
+	req := httptest.NewRequest("POST", path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestUploadCover(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	coverDir = t.TempDir()
+	var err error
+	Covers, err = bookshelf.NewFileCoverStore(coverDir, "/covers")
+	if err != nil {
+		t.Fatalf("NewFileCoverStore: %v", err)
+	}
+	id, err := DB.AddBook(context.Background(), &bookshelf.Book{Title: "Dune"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	path := fmt.Sprintf("/books/%d/cover", id)
+
+	w := serve(coverUpload(t, path, "image/png", "PNG"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST %s: got status %d, want 200: %s", path, w.Code, w.Body)
+	}
+	want := fmt.Sprintf("/covers/%d.png", id)
+	if b, err := DB.GetBook(context.Background(), id); err != nil || b.CoverURL != want {
+		t.Errorf("after upload, GetBook = %+v, %v; want cover URL %q", b, err, want)
+	}
+	if w := serve(httptest.NewRequest("GET", want, nil)); w.Code != http.StatusOK || w.Body.String() != "PNG" {
+		t.Errorf("GET %s = %d %q, want 200 \"PNG\"", want, w.Code, w.Body)
+	}
+
+	if w := serve(coverUpload(t, path, "text/plain", "hi")); w.Code != http.StatusBadRequest {
+		t.Errorf("POST %s with a text file: got status %d, want 400", path, w.Code)
+	}
+	if w := serve(coverUpload(t, "/books/999999/cover", "image/png", "PNG")); w.Code != http.StatusNotFound {
+		t.Errorf("POST cover of a missing book: got status %d, want 404", w.Code)
+	}
+}
+
+func TestDetailConditionalGet(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	id, err := DB.AddBook(context.Background(), &bookshelf.Book{Title: "Dune"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	path := fmt.Sprintf("/books/%d", id)
+
+	w := serve(httptest.NewRequest("GET", path, nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("GET %s: got status %d and ETag %q, want 200 and a strong ETag", path, w.Code, etag)
+	}
+
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("If-None-Match", etag)
+	if w := serve(req); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("GET %s with a matching If-None-Match: got status %d and %d bytes, want an empty 304", path, w.Code, w.Body.Len())
+	}
+
+	if err := DB.UpdateBook(context.Background(), &bookshelf.Book{ID: id, Title: "Dune Messiah", Version: 1}); err != nil {
+		t.Fatalf("UpdateBook: %v", err)
+	}
+	w = serve(req)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("GET %s after an update: got status %d and ETag %q, want 200 and a new ETag", path, w.Code, w.Header().Get("ETag"))
+	}
+}
+
+// currentETag returns the ETag GET sends for path.
+func currentETag(t *testing.T, path string) string {
+	t.Helper()
+	w := serve(httptest.NewRequest("GET", path, nil))
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == "" {
+		t.Fatalf("GET %s: got status %d and ETag %q, want 200 and an ETag", path, w.Code, w.Header().Get("ETag"))
+	}
+	return w.Header().Get("ETag")
+}
+
+func TestUpdateConflict(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	id, err := DB.AddBook(context.Background(), &bookshelf.Book{Title: "Dune"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	path := fmt.Sprintf("/books/%d", id)
+	put := func(body, ifMatch string) int {
+		req := httptest.NewRequest("PUT", path, strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		return serve(req).Code
+	}
+
+	stale := currentETag(t, path)
+	if code := put(`{"title":"Dune Messiah","version":1}`, ""); code != http.StatusPreconditionRequired {
+		t.Errorf("PUT %s without If-Match: got status %d, want 428", path, code)
+	}
+	if code := put(`{"title":"Dune Messiah","version":1}`, "W/"+stale); code != http.StatusPreconditionFailed {
+		t.Errorf("PUT %s with a weak If-Match: got status %d, want 412", path, code)
+	}
+	if code := put(`{"title":"Dune Messiah","version":1}`, stale); code != http.StatusFound {
+		t.Fatalf("PUT %s with a matching If-Match: got status %d, want 302", path, code)
+	}
+	if code := put(`{"title":"Children of Dune","version":2}`, stale); code != http.StatusPreconditionFailed {
+		t.Errorf("PUT %s with a stale If-Match: got status %d, want 412", path, code)
+	}
+	if b, err := DB.GetBook(context.Background(), id); err != nil || b.Title != "Dune Messiah" {
+		t.Errorf("after the rejected PUTs, GetBook = %+v, %v; want Dune Messiah", b, err)
+	}
+
+	// The version in the body does not matter once If-Match does.
+	for _, body := range []string{
+		`{"title":"Children of Dune","version":1}`,
+		`{"title":"God Emperor of Dune"}`,
+	} {
+		if code := put(body, currentETag(t, path)); code != http.StatusFound {
+			t.Errorf("PUT %s %s with a matching If-Match: got status %d, want 302", path, body, code)
+		}
+	}
+	if b, err := DB.GetBook(context.Background(), id); err != nil || b.Title != "God Emperor of Dune" || b.Version != 4 {
+		t.Errorf("after the PUTs, GetBook = %+v, %v; want God Emperor of Dune at version 4", b, err)
+	}
+}
+
+func TestErrorCodeDuplicateISBN(t *testing.T) {
+	err := fmt.Errorf("could not save book: %w", bookshelf.ErrDuplicateISBN)
+	if got := errorCode(err); got != http.StatusConflict {
+		t.Errorf("errorCode(%v) = %d, want 409", err, got)
+	}
+}
+
+// duplicateISBNDB is a database whose AddBook fails the way Mongo's does when
+// the unique isbn index is broken.
+type duplicateISBNDB struct {
+	bookshelf.BookDatabase
+}
+
+func (db duplicateISBNDB) AddBook(ctx context.Context, b *bookshelf.Book) (int64, error) {
+	return 0, fmt.Errorf("mongodb: could not add book: %w", bookshelf.ErrDuplicateISBN)
+}
+
+func TestCreateDuplicateISBN(t *testing.T) {
+	DB = duplicateISBNDB{bookshelf.NewMemoryDB()}
+	r := httptest.NewRequest("POST", "/books", strings.NewReader(`{"title":"Dune","isbn":"978-0-441-17271-9"}`))
+	w := serve(r)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("POST /books with a duplicate ISBN: got status %d, want 409", w.Code)
+	}
+	var body errorBody
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding error body: %v", err)
+	}
+	if want := "a book with ISBN 978-0-441-17271-9 already exists"; body.Error != want {
+		t.Errorf("got error %q, want %q", body.Error, want)
+	}
+}
+
+func TestCount(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	addBooks(t, "Dune", "Emma")
+
+	w := serve(httptest.NewRequest("GET", "/books/count", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /books/count: got status %d, want 200", w.Code)
+	}
+	if got, want := w.Body.String(), "{\"count\":2}\n"; got != want {
+		t.Errorf("GET /books/count = %q, want %q", got, want)
+	}
+}
+
+func TestExportJSONLines(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	addBooks(t, "Emma", "Dune")
+
+	w := serve(httptest.NewRequest("GET", "/books.jsonl", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /books.jsonl: got status %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("got Content-Type %q, want application/x-ndjson", got)
+	}
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	var got []string
+	for _, line := range lines {
+		var b bookshelf.Book
+		if err := json.Unmarshal([]byte(line), &b); err != nil {
+			t.Fatalf("decoding line %q: %v", line, err)
+		}
+		got = append(got, b.Title)
+	}
+	if want := []string{"Dune", "Emma"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GET /books.jsonl listed %q, want %q", got, want)
+	}
+}
+
+func TestExportJSONLinesFlushes(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	for i := 0; i < jsonLinesFlushEvery; i++ {
+		addBooks(t, fmt.Sprintf("Book %03d", i))
+	}
+
+	if w := serve(httptest.NewRequest("GET", "/books.jsonl", nil)); !w.Flushed {
+		t.Errorf("GET /books.jsonl of %d books was never flushed", jsonLinesFlushEvery)
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	id, err := DB.AddBook(context.Background(), &bookshelf.Book{Title: "Dune", Author: "Frank Herbert"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	addBooks(t, "Emma")
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`{ books { title } }`, `{"data":{"books":[{"title":"Dune"},{"title":"Emma"}]}}`},
+		{`{ books(limit: 1, offset: 1) { title } }`, `{"data":{"books":[{"title":"Emma"}]}}`},
+		{fmt.Sprintf(`{ book(id: "%d") { id title author } }`, id), fmt.Sprintf(`{"data":{"book":{"author":"Frank Herbert","id":"%d","title":"Dune"}}}`, id)},
+		{`{ book(id: "999999") { title } }`, `{"data":{"book":null}}`},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(map[string]string{"query": tt.query})
+		w := serve(httptest.NewRequest("POST", "/graphql", bytes.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Errorf("POST /graphql %s: got status %d, want 200", tt.query, w.Code)
+			continue
+		}
+		if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+			t.Errorf("POST /graphql %s = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+
+	body, _ := json.Marshal(map[string]string{"query": `{ books { nope } }`})
+	w := serve(httptest.NewRequest("POST", "/graphql", bytes.NewReader(body)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"errors"`) {
+		t.Errorf("POST /graphql with an unknown field = %d %s, want 200 with errors", w.Code, w.Body)
+	}
+	if w := serve(httptest.NewRequest("POST", "/graphql", strings.NewReader("{"))); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /graphql with malformed JSON: got status %d, want 400", w.Code)
+	}
+}
+
+func TestBatchDelete(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	addBooks(t, "Dune", "Emma", "Persuasion")
+	books, err := DB.ListBooks(context.Background())
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+
+	body := fmt.Sprintf(`{"ids":[%d,%d,999999]}`, books[0].ID, books[1].ID)
+	w := serve(httptest.NewRequest("POST", "/books:batchDelete", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /books:batchDelete: got status %d, want 200", w.Code)
+	}
+	if got, want := w.Body.String(), "{\"deleted\":2}\n"; got != want {
+		t.Errorf("POST /books:batchDelete = %q, want %q", got, want)
+	}
+	if got, want := decodeTitles(t, serve(httptest.NewRequest("GET", "/books", nil))), []string{"Persuasion"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GET /books after the batch delete = %q, want %q", got, want)
+	}
+
+	if w := serve(httptest.NewRequest("POST", "/books:batchDelete", strings.NewReader(`{"ids":"all"}`))); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /books:batchDelete with bad ids: got status %d, want 400", w.Code)
+	}
+}
+
+func TestDecodeJSONLimits(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	defer func(n int64) { MaxBodyBytes = n }(MaxBodyBytes)
+	MaxBodyBytes = 64
+
+	tests := []struct {
+		name, body string
+		want       int
+	}{
+		{"small", `{"title":"Dune"}`, http.StatusCreated},
+		{"too large", `{"title":"` + strings.Repeat("x", 64) + `"}`, http.StatusRequestEntityTooLarge},
+		{"unknown field", `{"title":"Dune","subtitle":"A Novel"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := serve(httptest.NewRequest("POST", "/books", strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("POST /books with a %s body: got status %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if tt.want != http.StatusCreated && !strings.Contains(w.Header().Get("Content-Type"), "application/json") {
+			t.Errorf("POST /books with a %s body: error is not JSON", tt.name)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	prev := AllowedOrigins
+	AllowedOrigins = []string{"https://example.com"}
+	t.Cleanup(func() { AllowedOrigins = prev })
+
+	req := httptest.NewRequest("OPTIONS", "/books/1", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	w := serve(req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("preflight OPTIONS /books/1: got status %d, want 204", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, "PUT") {
+		t.Errorf("Access-Control-Allow-Methods = %q, want it to include PUT", got)
+	}
+
+	req = httptest.NewRequest("GET", "/books", nil)
+	req.Header.Set("Origin", "https://example.com")
+	w = serve(req)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://example.com" {
+		t.Errorf("GET /books from an allowed origin: Access-Control-Allow-Origin = %q", got)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	prevRPS, prevBurst := RateLimitRPS, RateLimitBurst
+	RateLimitRPS, RateLimitBurst = 0.5, 2
+	t.Cleanup(func() { RateLimitRPS, RateLimitBurst = prevRPS, prevBurst })
+
+	h := handler(defaultPages)
+	get := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/books", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		if w := get("192.0.2.1"); w.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst: got status %d, want 200", i+1, w.Code)
+		}
+	}
+	w := get("192.0.2.1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the burst: got status %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+	if w := get("192.0.2.2"); w.Code != http.StatusOK {
+		t.Errorf("request from another client: got status %d, want 200", w.Code)
+	}
+}
+
+func TestCreateIdempotent(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	prev := Idempotency
+	Idempotency = bookshelf.NewMemoryIdempotencyStore(time.Hour)
+	t.Cleanup(func() { Idempotency = prev })
+
+	post := func(key string) bookshelf.Book {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/books", strings.NewReader(`{"title":"Dune"}`))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := serve(req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("POST /books with key %q: got status %d, want 201: %s", key, w.Code, w.Body)
+		}
+		var b bookshelf.Book
+		if err := json.NewDecoder(w.Body).Decode(&b); err != nil {
+			t.Fatalf("decoding created book: %v", err)
+		}
+		return b
+	}
+
+	first := post("k1")
+	if again := post("k1"); again.ID != first.ID {
+		t.Errorf("retried POST /books created book %d, want the first book %d", again.ID, first.ID)
+	}
+	if other := post("k2"); other.ID == first.ID {
+		t.Errorf("POST /books with another key returned the first book %d", first.ID)
+	}
+	post("")
+	if n, _ := DB.CountBooks(context.Background()); n != 3 {
+		t.Errorf("after four POSTs with two repeated keys, %d books, want 3", n)
+	}
+}
+
+func TestCreateIdempotentReuse(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	prev := Idempotency
+	Idempotency = bookshelf.NewMemoryIdempotencyStore(time.Hour)
+	t.Cleanup(func() { Idempotency = prev })
+	defer func(user, pass string) { WriteUsername, WritePassword = user, pass }(WriteUsername, WritePassword)
+
+	post := func(body string, auth bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/books", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", "k1")
+		if auth {
+			req.SetBasicAuth("editor", "s3cret")
+		}
+		return serve(req)
+	}
+	createdID := func(w *httptest.ResponseRecorder) int64 {
+		t.Helper()
+		if w.Code != http.StatusCreated {
+			t.Fatalf("POST /books: got status %d, want 201: %s", w.Code, w.Body)
+		}
+		var b bookshelf.Book
+		if err := json.NewDecoder(w.Body).Decode(&b); err != nil {
+			t.Fatalf("decoding created book: %v", err)
+		}
+		return b.ID
+	}
+
+	first := createdID(post(`{"title":"Dune"}`, false))
+	if w := post(`{"title":"Emma"}`, false); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("POST /books reusing a key for another book: got status %d, want 422", w.Code)
+	}
+
+	// A retry after the book was deleted creates it again.
+	if err := DB.DeleteBook(context.Background(), first); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+	if id := createdID(post(`{"title":"Dune"}`, false)); id == first {
+		t.Errorf("retried POST /books after the book was deleted returned the deleted book %d", id)
+	}
+
+	// Another user's key of the same name is their own.
+	WriteUsername, WritePassword = "editor", "s3cret"
+	createdID(post(`{"title":"Emma"}`, true))
+}
+
+func TestListEnvelope(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	addBooks(t, "Dune", "Emma", "Middlemarch")
+
+	w := serve(httptest.NewRequest("GET", "/books?envelope=true&limit=2&offset=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /books?envelope=true: got status %d, want 200", w.Code)
+	}
+	var page struct {
+		Items                []*bookshelf.Book
+		Total, Limit, Offset int
+	}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("decoding page: %v", err)
+	}
+	var got []string
+	for _, b := range page.Items {
+		got = append(got, b.Title)
+	}
+	if want := []string{"Emma", "Middlemarch"}; !reflect.DeepEqual(got, want) || page.Total != 3 || page.Limit != 2 || page.Offset != 1 {
+		t.Errorf("GET /books?envelope=true&limit=2&offset=1 = %q, total %d, limit %d, offset %d; want %q, 3, 2, 1",
+			got, page.Total, page.Limit, page.Offset, want)
+	}
+
+	w = serve(httptest.NewRequest("GET", "/books?envelope=true&offset=5", nil))
+	if body := w.Body.String(); !strings.Contains(body, `"items":[]`) {
+		t.Errorf("GET /books?envelope=true past the end = %s, want an empty items list", body)
+	}
+	if got := decodeTitles(t, serve(httptest.NewRequest("GET", "/books?limit=1", nil))); len(got) != 1 {
+		t.Errorf("GET /books?limit=1 without an envelope = %q, want a bare list of one book", got)
+	}
+}
+
+func TestListGzip(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	addBooks(t, "Dune", "Emma")
+
+	req := httptest.NewRequest("GET", "/books", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := serve(req)
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("GET /books accepting gzip: Content-Encoding = %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	var books []*bookshelf.Book
+	if err := json.NewDecoder(zr).Decode(&books); err != nil {
+		t.Fatalf("decoding gzipped books: %v", err)
+	}
+	if len(books) != 2 {
+		t.Errorf("GET /books accepting gzip returned %d books, want 2", len(books))
+	}
+}
+
+func TestDeleteDryRun(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	id, err := DB.AddBook(context.Background(), &bookshelf.Book{Title: "Dune"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	path := fmt.Sprintf("/books/%d:delete?dryRun=true", id)
+
+	w := serve(httptest.NewRequest("POST", path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST %s: got status %d, want 200", path, w.Code)
+	}
+	var got bookshelf.Book
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding book: %v", err)
+	}
+	if got.ID != id || got.Title != "Dune" {
+		t.Errorf("POST %s = %+v, want Dune", path, got)
+	}
+	if _, err := DB.GetBook(context.Background(), id); err != nil {
+		t.Errorf("GetBook after a dry run: %v", err)
+	}
+
+	if w := serve(httptest.NewRequest("POST", "/books/999:delete?dryRun=true", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("dry run delete of a missing book: got status %d, want 404", w.Code)
+	}
+}
+
+// slowDB is a database whose GetBook waits for its context to be done.
+type slowDB struct {
+	bookshelf.BookDatabase
+}
+
+func (db slowDB) GetBook(ctx context.Context, id int64) (*bookshelf.Book, error) {
+	<-ctx.Done()
+	return nil, errors.New("no reply from the server")
+}
+
+func TestRequestTimeout(t *testing.T) {
+	DB = slowDB{bookshelf.NewMemoryDB()}
+	defer func(d time.Duration) { RequestTimeout = d }(RequestTimeout)
+	RequestTimeout = 20 * time.Millisecond
+
+	w := serve(httptest.NewRequest("GET", "/books/1", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("GET /books/1 from a slow database: got status %d, want 503", w.Code)
+	}
+	var body errorBody
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding error body: %v", err)
+	}
+	if body.Error != "request timed out" {
+		t.Errorf("got error %q, want request timed out", body.Error)
+	}
+}
+
+func TestRecent(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	addBooks(t, "Dune", "Emma", "Middlemarch")
+	books, err := DB.ListBooks(context.Background())
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	if err := DB.DeleteBook(context.Background(), books[2].ID); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/books/recent", []string{"Emma", "Dune"}},
+		{"/books/recent?limit=1", []string{"Emma"}},
+	}
+	for _, tt := range tests {
+		if got := decodeTitles(t, serve(httptest.NewRequest("GET", tt.path, nil))); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GET %s = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+	if w := serve(httptest.NewRequest("GET", "/books/recent?limit=x", nil)); w.Code != http.StatusBadRequest {
+		t.Errorf("GET /books/recent?limit=x: got status %d, want 400", w.Code)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	w := serve(httptest.NewRequest("GET", "/status", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /status: got status %d, want 200", w.Code)
+	}
+	var status struct {
+		Version  string `json:"version"`
+		Uptime   string `json:"uptime"`
+		Database string `json:"database"`
+		PingOK   bool   `json:"ping_ok"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatalf("decoding status: %v", err)
+	}
+	if status.Version != Version || status.Database != "memory" || !status.PingOK {
+		t.Errorf("GET /status = %+v, want version %q, database memory and ping_ok", status, Version)
+	}
+	if _, err := time.ParseDuration(status.Uptime); err != nil {
+		t.Errorf("GET /status: bad uptime %q: %v", status.Uptime, err)
+	}
+}
+
+func TestCamelNaming(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	id, err := DB.AddBook(context.Background(), &bookshelf.Book{Title: "Dune", PublishedDate: "1965", PageCount: 412})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	path := fmt.Sprintf("/books/%d", id)
+
+	w := serve(httptest.NewRequest("GET", path+"?naming=camel", nil))
+	var got map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding book: %v", err)
+	}
+	if got["publishedDate"] != "1965" || got["pageCount"] != 412.0 || got["published_date"] != nil {
+		t.Errorf("GET %s?naming=camel = %v, want camelCase keys", path, got)
+	}
+	if etag := w.Header().Get("ETag"); etag != currentETag(t, path) {
+		t.Errorf("GET %s?naming=camel: ETag %q differs from the snake_case one", path, etag)
+	}
+
+	w = serve(httptest.NewRequest("GET", "/books?naming=camel", nil))
+	if body := w.Body.String(); !strings.Contains(body, `"publishedDate":"1965"`) {
+		t.Errorf("GET /books?naming=camel = %s, want camelCase keys", body)
+	}
+	w = serve(httptest.NewRequest("GET", path, nil))
+	if body := w.Body.String(); !strings.Contains(body, `"published_date":"1965"`) {
+		t.Errorf("GET %s = %s, want snake_case keys", path, body)
+	}
+}
+
+func TestStream(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	// The stream outlives the request timeout.
+	defer func(d time.Duration) { RequestTimeout = d }(RequestTimeout)
+	RequestTimeout = 20 * time.Millisecond
+	srv := httptest.NewServer(handler(defaultPages))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/books/stream", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /books/stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("GET /books/stream: got Content-Type %q, want text/event-stream", ct)
+	}
+
+	time.Sleep(2 * RequestTimeout)
+	addBooks(t, "Dune")
+	r := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 2 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	if lines[0] != "event: book" || !strings.HasPrefix(lines[1], "data: {") || !strings.Contains(lines[1], `"title":"Dune"`) {
+		t.Errorf("GET /books/stream sent %q, want a book event for Dune", lines)
+	}
+}
+
+func TestFieldSelection(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	id, err := DB.AddBook(context.Background(), &bookshelf.Book{Title: "Dune", Author: "Frank Herbert", PublishedDate: "1965"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	path := fmt.Sprintf("/books/%d", id)
+
+	tests := []struct {
+		path, want string
+	}{
+		{path + "?fields=title,author", `{"author":"Frank Herbert","title":"Dune"}`},
+		{path + "?fields=publishedDate&naming=camel", `{"publishedDate":"1965"}`},
+		{"/books?fields=title", `[{"title":"Dune"}]`},
+		{"/books?fields=title&envelope=true", `{"items":[{"title":"Dune"}],"total":1,"limit":20,"offset":0}`},
+	}
+	for _, tt := range tests {
+		w := serve(httptest.NewRequest("GET", tt.path, nil))
+		if got := strings.TrimSpace(w.Body.String()); w.Code != http.StatusOK || got != tt.want {
+			t.Errorf("GET %s = %d %s, want 200 %s", tt.path, w.Code, got, tt.want)
+		}
+	}
+
+	w := serve(httptest.NewRequest("GET", path+"?fields=title", nil))
+	if etag := w.Header().Get("ETag"); etag != currentETag(t, path) {
+		t.Errorf("GET %s?fields=title: ETag %q differs from the full book's", path, etag)
+	}
+}
+
+func TestTopAuthors(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	for _, b := range []*bookshelf.Book{
+		{Title: "Emma", Author: "Jane Austen"},
+		{Title: "Persuasion", Author: "Jane Austen"},
+		{Title: "Dune", Author: "Frank Herbert"},
+	} {
+		if _, err := DB.AddBook(context.Background(), b); err != nil {
+			t.Fatalf("AddBook: %v", err)
+		}
+	}
+
+	w := serve(httptest.NewRequest("GET", "/authors/top?limit=1", nil))
+	if got, want := strings.TrimSpace(w.Body.String()), `[{"author":"Jane Austen","count":2}]`; w.Code != http.StatusOK || got != want {
+		t.Errorf("GET /authors/top?limit=1 = %d %s, want 200 %s", w.Code, got, want)
+	}
+	for _, limit := range []string{"0", "x"} {
+		if w := serve(httptest.NewRequest("GET", "/authors/top?limit="+limit, nil)); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /authors/top?limit=%s: got status %d, want 400", limit, w.Code)
+		}
+	}
+}
+
+func TestErrorRequestID(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	r := httptest.NewRequest("GET", "/books/7", nil)
+	r.Header.Set("X-Request-ID", "req-7")
+	w := serve(r)
+
+	if got := w.Header().Get("X-Request-ID"); got != "req-7" {
+		t.Errorf("GET /books/7: got X-Request-ID %q, want req-7", got)
+	}
+	var body errorBody
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding error body: %v", err)
+	}
+	if body.RequestID != "req-7" {
+		t.Errorf("GET /books/7: got error body %+v, want request ID req-7", body)
+	}
+}
+
+func TestClone(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	id, err := DB.AddBook(context.Background(), &bookshelf.Book{Title: "Dune", Author: "Frank Herbert", ISBN: "978-0-441-17271-9"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+
+	tests := []struct {
+		query, title string
+	}{
+		{"", "Dune"},
+		{"?suffix=true", "Dune (copy)"},
+	}
+	for _, tt := range tests {
+		path := fmt.Sprintf("/books/%d:clone%s", id, tt.query)
+		w := serve(httptest.NewRequest("POST", path, nil))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("POST %s: got status %d, want 201: %s", path, w.Code, w.Body)
+		}
+		var got bookshelf.Book
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatalf("decoding book: %v", err)
+		}
+		if got.ID == id || got.Title != tt.title || got.Author != "Frank Herbert" || got.ISBN != "" {
+			t.Errorf("POST %s = %+v, want a new %q by Frank Herbert without an ISBN", path, got, tt.title)
+		}
+		if loc := w.Header().Get("Location"); loc != fmt.Sprintf("/books/%d", got.ID) {
+			t.Errorf("POST %s: got Location %q, want the copy's", path, loc)
+		}
+	}
+
+	if w := serve(httptest.NewRequest("POST", "/books/999:clone", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("POST /books/999:clone: got status %d, want 404", w.Code)
+	}
+	if n, _ := DB.CountBooks(context.Background()); n != 3 {
+		t.Errorf("after cloning twice, %d books, want 3", n)
+	}
+}
+
+func TestCloneCreatedBy(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	defer func(user, pass string) { WriteUsername, WritePassword = user, pass }(WriteUsername, WritePassword)
+	WriteUsername, WritePassword = "editor", "s3cret"
+	id, err := DB.AddBook(context.Background(), &bookshelf.Book{Title: "Dune", CreatedByID: "frank", CreatedBy: "Frank"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+
+	path := fmt.Sprintf("/books/%d:clone", id)
+	r := httptest.NewRequest("POST", path, nil)
+	r.SetBasicAuth("editor", "s3cret")
+	w := serve(r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST %s: got status %d, want 201: %s", path, w.Code, w.Body)
+	}
+	var got bookshelf.Book
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding book: %v", err)
+	}
+	if got.CreatedByID != "editor" || got.CreatedBy != "editor" {
+		t.Errorf("POST %s: copy created by %q (%q), want editor", path, got.CreatedByID, got.CreatedBy)
+	}
+}
+
+func TestListByStatus(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	for _, b := range []*bookshelf.Book{
+		{Title: "Dune"},
+		{Title: "Emma", Status: bookshelf.StatusDraft},
+	} {
+		if _, err := DB.AddBook(context.Background(), b); err != nil {
+			t.Fatalf("AddBook: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Dune"}},
+		{"?status=draft", []string{"Emma"}},
+		{"?status=published", []string{"Dune"}},
+	}
+	for _, tt := range tests {
+		if got := decodeTitles(t, serve(httptest.NewRequest("GET", "/books"+tt.query, nil))); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GET /books%s = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+	if w := serve(httptest.NewRequest("GET", "/books?status=archived", nil)); w.Code != http.StatusBadRequest {
+		t.Errorf("GET /books?status=archived: got status %d, want 400", w.Code)
+	}
+}
+
+func TestListBetweenDates(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	for _, b := range []*bookshelf.Book{
+		{Title: "Dune", PublishedDate: "1965-08-01"},
+		{Title: "Dune Messiah", PublishedDate: "1969"},
+		{Title: "Emma", PublishedDate: "1815-12-23"},
+	} {
+		if _, err := DB.AddBook(context.Background(), b); err != nil {
+			t.Fatalf("AddBook: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"?from=1900-01-01", []string{"Dune", "Dune Messiah"}},
+		{"?to=1965-08-01", []string{"Emma", "Dune"}},
+		{"?from=1965-08-02&to=1969-01-01", []string{"Dune Messiah"}},
+	}
+	for _, tt := range tests {
+		if got := decodeTitles(t, serve(httptest.NewRequest("GET", "/books"+tt.query, nil))); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GET /books%s = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+	for _, query := range []string{"?from=1965", "?to=yesterday"} {
+		if w := serve(httptest.NewRequest("GET", "/books"+query, nil)); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /books%s: got status %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestRandom(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	if w := serve(httptest.NewRequest("GET", "/books/random", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("GET /books/random with no books: got status %d, want 404", w.Code)
+	}
+
+	addBooks(t, "Dune")
+	w := serve(httptest.NewRequest("GET", "/books/random", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /books/random: got status %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("GET /books/random: got Cache-Control %q, want no-store", got)
+	}
+	var b bookshelf.Book
+	if err := json.NewDecoder(w.Body).Decode(&b); err != nil || b.Title != "Dune" {
+		t.Errorf("GET /books/random = %+v, %v; want Dune", b, err)
+	}
+}
+
+func TestTags(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	id, err := DB.AddBook(context.Background(), &bookshelf.Book{Title: "Dune", Tags: []string{"scifi"}})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	path := fmt.Sprintf("/books/%d/tags", id)
+
+	tags := func(w *httptest.ResponseRecorder) []string {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want 200: %s", w.Code, w.Body)
+		}
+		var b bookshelf.Book
+		if err := json.NewDecoder(w.Body).Decode(&b); err != nil {
+			t.Fatalf("decoding book: %v", err)
+		}
+		return b.Tags
+	}
+	w := serve(httptest.NewRequest("PUT", path, strings.NewReader(`{"tags":["classics","desert","classics"]}`)))
+	if got, want := tags(w), []string{"classics", "desert"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PUT %s = %q, want %q", path, got, want)
+	}
+	w = serve(httptest.NewRequest("DELETE", path+"/classics", nil))
+	if got, want := tags(w), []string{"desert"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DELETE %s/classics = %q, want %q", path, got, want)
+	}
+	w = serve(httptest.NewRequest("DELETE", path+"/poetry", nil))
+	if got, want := tags(w), []string{"desert"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DELETE %s/poetry = %q, want %q", path, got, want)
+	}
+
+	if w := serve(httptest.NewRequest("PUT", path, strings.NewReader(`{"tags":"desert"}`))); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT %s with a string for tags: got status %d, want 400", path, w.Code)
+	}
+	if w := serve(httptest.NewRequest("PUT", "/books/999/tags", strings.NewReader(`{"tags":[]}`))); w.Code != http.StatusNotFound {
+		t.Errorf("PUT /books/999/tags: got status %d, want 404", w.Code)
+	}
+}
+
+func TestProblemErrors(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	r := httptest.NewRequest("POST", "/books", strings.NewReader(`{"isbn":"12345"}`))
+	r.Header.Set("Accept", "application/problem+json")
+	r.Header.Set("X-Request-ID", "req-1")
+	w := serve(r)
+
+	if got := w.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("got Content-Type %q, want application/problem+json", got)
+	}
+	var body problemBody
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding problem: %v", err)
+	}
+	if body.Type != "about:blank" || body.Title != "Bad Request" || body.Status != http.StatusBadRequest ||
+		body.RequestID != "req-1" || body.Errors["title"] != "required" {
+		t.Errorf("POST /books with an invalid book = %+v, want a 400 problem with the invalid fields", body)
+	}
+
+	w = serve(httptest.NewRequest("GET", "/books/7", nil))
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("GET /books/7 without asking for problems: got Content-Type %q, want application/json", got)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	defer func(token string) { AdminToken = token }(AdminToken)
+	AdminToken = "s3cret"
+
+	r := httptest.NewRequest("POST", "/admin/reindex", nil)
+	if w := serve(r); w.Code != http.StatusUnauthorized {
+		t.Errorf("POST /admin/reindex without a token: got status %d, want 401", w.Code)
+	}
+	r.Header.Set("Authorization", "Bearer s3cret")
+	if w := serve(r); w.Code != http.StatusNoContent {
+		t.Errorf("POST /admin/reindex: got status %d, want 204", w.Code)
+	}
+
+	DB = bookshelf.NewReadOnlyDB(bookshelf.NewMemoryDB())
+	if w := serve(r); w.Code != http.StatusForbidden {
+		t.Errorf("POST /admin/reindex in read-only mode: got status %d, want 403", w.Code)
+	}
+}
+
+func TestReset(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	defer func(token string, allow bool) { AdminToken, AllowReset = token, allow }(AdminToken, AllowReset)
+	AdminToken = "s3cret"
+	for _, title := range []string{"Dune", "Emma"} {
+		if _, err := DB.AddBook(context.Background(), &bookshelf.Book{Title: title}); err != nil {
+			t.Fatalf("AddBook: %v", err)
+		}
+	}
+
+	AllowReset = false
+	r := httptest.NewRequest("POST", "/admin/reset", nil)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	if w := serve(r); w.Code != http.StatusNotFound && w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /admin/reset without ALLOW_RESET: got status %d, want it not routed", w.Code)
+	}
+
+	AllowReset = true
+	w := serve(r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /admin/reset: got status %d, want 200", w.Code)
+	}
+	var got struct {
+		Deleted int `json:"deleted"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if got.Deleted != 2 {
+		t.Errorf("POST /admin/reset deleted %d books, want 2", got.Deleted)
+	}
+	books, err := DB.ListBooks(context.Background())
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	if len(books) != 0 {
+		t.Errorf("ListBooks after reset = %d books, want none", len(books))
+	}
+}
+
+func TestWriteAuth(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	defer func(user, pass string) { WriteUsername, WritePassword = user, pass }(WriteUsername, WritePassword)
+	WriteUsername, WritePassword = "editor", "s3cret"
+
+	r := httptest.NewRequest("POST", "/books", strings.NewReader(`{"title":"Dune"}`))
+	if w := serve(r); w.Code != http.StatusUnauthorized {
+		t.Errorf("POST /books without credentials: got status %d, want 401", w.Code)
+	}
+	r = httptest.NewRequest("POST", "/books", strings.NewReader(`{"title":"Dune"}`))
+	r.SetBasicAuth("editor", "s3cret")
+	if w := serve(r); w.Code != http.StatusCreated {
+		t.Errorf("POST /books with credentials: got status %d, want 201", w.Code)
+	}
+	if w := serve(httptest.NewRequest("GET", "/books", nil)); w.Code != http.StatusOK {
+		t.Errorf("GET /books without credentials: got status %d, want 200", w.Code)
+	}
+}
+
+func TestRenameAuthor(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	for _, b := range []*bookshelf.Book{
+		{Title: "Dune", Author: "Frank Herbert"},
+		{Title: "Emma", Author: "Jane Austen"},
+	} {
+		if _, err := DB.AddBook(context.Background(), b); err != nil {
+			t.Fatalf("AddBook: %v", err)
+		}
+	}
+
+	w := serve(httptest.NewRequest("POST", "/authors:rename", strings.NewReader(`{"from":"Frank Herbert","to":"F. Herbert"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /authors:rename: got status %d, want 200", w.Code)
+	}
+	var got struct {
+		Updated int `json:"updated"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if got.Updated != 1 {
+		t.Errorf("POST /authors:rename updated %d books, want 1", got.Updated)
+	}
+	if got := decodeTitles(t, serve(httptest.NewRequest("GET", "/books?author=F.+Herbert", nil))); !reflect.DeepEqual(got, []string{"Dune"}) {
+		t.Errorf("GET /books?author=F.+Herbert = %q, want [Dune]", got)
+	}
+
+	if w := serve(httptest.NewRequest("POST", "/authors:rename", strings.NewReader(`{"to":"F. Herbert"}`))); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /authors:rename without from: got status %d, want 400", w.Code)
+	}
+}
+
+func TestJSONAPI(t *testing.T) {
+	DB = bookshelf.NewMemoryDB()
+	id, err := DB.AddBook(context.Background(), &bookshelf.Book{Title: "Dune", Author: "Frank Herbert"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	if _, err := DB.AddBook(context.Background(), &bookshelf.Book{Title: "Emma"}); err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", fmt.Sprintf("/books/%d?fields=title", id), nil)
+	r.Header.Set("Accept", jsonAPI)
+	w := serve(r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /books/%d: got status %d, want 200", id, w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != jsonAPI {
+		t.Errorf("Content-Type = %q, want %q", got, jsonAPI)
+	}
+	var doc struct {
+		Data jsonAPIResource `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+		t.Fatalf("decoding document: %v", err)
+	}
+	want := jsonAPIResource{Type: "books", ID: strconv.FormatInt(id, 10), Attributes: map[string]interface{}{"title": "Dune"}}
+	if !reflect.DeepEqual(doc.Data, want) {
+		t.Errorf("GET /books/%d?fields=title as JSON:API = %+v, want %+v", id, doc.Data, want)
+	}
+
+	r = httptest.NewRequest("GET", "/books", nil)
+	r.Header.Set("Accept", jsonAPI)
+	w = serve(r)
+	var list struct {
+		Data []jsonAPIResource      `json:"data"`
+		Meta map[string]interface{} `json:"meta"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("decoding document: %v", err)
+	}
+	if len(list.Data) != 2 || list.Data[0].Attributes["title"] != "Dune" || list.Data[0].Attributes["author"] != "Frank Herbert" {
+		t.Errorf("GET /books as JSON:API = %+v, want Dune by Frank Herbert and Emma", list.Data)
+	}
+	if _, ok := list.Data[0].Attributes["id"]; ok {
+		t.Errorf("GET /books as JSON:API: attributes hold the id")
+	}
+	if list.Meta["total"] != 2.0 {
+		t.Errorf("GET /books as JSON:API: meta = %v, want a total of 2", list.Meta)
+	}
+
+	// Wildcards keep the plain JSON.
+	r = httptest.NewRequest("GET", "/books", nil)
+	r.Header.Set("Accept", "*/*")
+	if got := decodeTitles(t, serve(r)); !reflect.DeepEqual(got, []string{"Dune", "Emma"}) {
+		t.Errorf("GET /books with Accept */* = %q, want [Dune Emma]", got)
+	}
+}
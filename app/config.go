@@ -0,0 +1,120 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings main can read from the YAML file named by
+// CONFIG_FILE, such as
+//
+//	mongo_url: mongodb://db:27017
+//	port: "8080"
+//	pool_size: 100
+//	default_page_size: 20
+//	max_page_size: 100
+//	read_only: false
+//	reject_duplicate_isbn: true
+//
+// The environment variables of the same settings override the file.
+type Config struct {
+	MongoURL        string `yaml:"mongo_url"`
+	Port            string `yaml:"port"`
+	PoolSize        int    `yaml:"pool_size"` // 0 keeps the driver's default.
+	DefaultPageSize int    `yaml:"default_page_size"`
+	MaxPageSize     int    `yaml:"max_page_size"`
+	ReadOnly        bool   `yaml:"read_only"`
+
+	// RejectDuplicateISBN is passed on as MongoOptions.RejectDuplicateISBN.
+	RejectDuplicateISBN bool `yaml:"reject_duplicate_isbn"`
+}
+
+// defaultConfig returns the settings used when neither the file nor the
+// environment sets them.
+func defaultConfig() *Config {
+	return &Config{
+		MongoURL:        "localhost",
+		Port:            "8080",
+		DefaultPageSize: defaultPageSize,
+		MaxPageSize:     maxPageSize,
+	}
+}
+
+// LoadConfig reads the YAML file at path. Settings it leaves out keep their
+// defaults, and unknown settings are rejected so that typos do not go
+// unnoticed.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config: %v", err)
+	}
+
+	c := defaultConfig()
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("could not parse config %s: %v", path, err)
+	}
+	if c.PoolSize < 0 {
+		return nil, fmt.Errorf("bad pool_size %d: must not be negative", c.PoolSize)
+	}
+	if c.DefaultPageSize < 1 || c.MaxPageSize < 1 {
+		return nil, fmt.Errorf("bad page sizes %d and %d: must be positive", c.DefaultPageSize, c.MaxPageSize)
+	}
+	return c, nil
+}
+
+// pageSizes returns the page sizes c sets, lowering the default to the
+// maximum if it is larger.
+func (c *Config) pageSizes() pageSizes {
+	pages := pageSizes{Default: c.DefaultPageSize, Max: c.MaxPageSize}
+	if pages.Default > pages.Max {
+		pages.Default = pages.Max
+	}
+	return pages
+}
+
+// overrideFromEnv replaces the settings of c whose environment variables are
+// set: MONGO_URL, PORT, MONGO_POOL_SIZE, DEFAULT_PAGE_SIZE, MAX_PAGE_SIZE,
+// READ_ONLY and REJECT_DUPLICATE_ISBN.
+func (c *Config) overrideFromEnv() error {
+	if s := os.Getenv("MONGO_URL"); s != "" {
+		c.MongoURL = s
+	}
+	if s := os.Getenv("PORT"); s != "" {
+		c.Port = s
+	}
+	if s := os.Getenv("MONGO_POOL_SIZE"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad MONGO_POOL_SIZE %q: must be a non-negative number", s)
+		}
+		c.PoolSize = n
+	}
+	c.MaxPageSize = pageSizeFromEnv("MAX_PAGE_SIZE", c.MaxPageSize)
+	c.DefaultPageSize = pageSizeFromEnv("DEFAULT_PAGE_SIZE", c.DefaultPageSize)
+	if s := os.Getenv("READ_ONLY"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("bad READ_ONLY %q: must be true or false", s)
+		}
+		c.ReadOnly = b
+	}
+	if s := os.Getenv("REJECT_DUPLICATE_ISBN"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("bad REJECT_DUPLICATE_ISBN %q: must be true or false", s)
+		}
+		c.RejectDuplicateISBN = b
+	}
+	return nil
+}
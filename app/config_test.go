@@ -0,0 +1,120 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeConfig writes a config file holding data to a temporary directory and
+// returns its path.
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `
+mongo_url: mongodb://db:27017
+port: "9000"
+pool_size: 50
+default_page_size: 10
+max_page_size: 40
+read_only: true
+reject_duplicate_isbn: true
+`)
+	got, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	want := Config{
+		MongoURL:            "mongodb://db:27017",
+		Port:                "9000",
+		PoolSize:            50,
+		DefaultPageSize:     10,
+		MaxPageSize:         40,
+		ReadOnly:            true,
+		RejectDuplicateISBN: true,
+	}
+	if *got != want {
+		t.Errorf("LoadConfig = %+v, want %+v", *got, want)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	got, err := LoadConfig(writeConfig(t, "port: \"9000\"\n"))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	want := *defaultConfig()
+	want.Port = "9000"
+	if *got != want {
+		t.Errorf("LoadConfig = %+v, want %+v", *got, want)
+	}
+
+	if _, err := LoadConfig(writeConfig(t, "")); err != nil {
+		t.Errorf("LoadConfig of an empty file: %v", err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown setting": "mongo_ulr: mongodb://db\n",
+		"bad pool size":   "pool_size: -1\n",
+		"bad page size":   "max_page_size: 0\n",
+		"bad yaml":        "port: [\n",
+	}
+	for name, data := range tests {
+		if _, err := LoadConfig(writeConfig(t, data)); err == nil {
+			t.Errorf("LoadConfig with %s: got no error", name)
+		}
+	}
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("LoadConfig of a missing file: got no error")
+	}
+}
+
+func TestOverrideFromEnv(t *testing.T) {
+	t.Setenv("MONGO_URL", "")
+	t.Setenv("DEFAULT_PAGE_SIZE", "")
+	t.Setenv("PORT", "9001")
+	t.Setenv("MONGO_POOL_SIZE", "5")
+	t.Setenv("MAX_PAGE_SIZE", "60")
+	t.Setenv("READ_ONLY", "false")
+	t.Setenv("REJECT_DUPLICATE_ISBN", "true")
+
+	c := &Config{MongoURL: "mongodb://db", Port: "9000", ReadOnly: true, DefaultPageSize: 10, MaxPageSize: 40}
+	if err := c.overrideFromEnv(); err != nil {
+		t.Fatalf("overrideFromEnv: %v", err)
+	}
+	want := Config{
+		MongoURL:            "mongodb://db",
+		Port:                "9001",
+		PoolSize:            5,
+		DefaultPageSize:     10,
+		MaxPageSize:         60,
+		RejectDuplicateISBN: true,
+	}
+	if *c != want {
+		t.Errorf("overrideFromEnv = %+v, want %+v", *c, want)
+	}
+}
+
+func TestOverrideFromEnvErrors(t *testing.T) {
+	for _, key := range []string{"MONGO_POOL_SIZE", "READ_ONLY", "REJECT_DUPLICATE_ISBN"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, "bogus")
+			if err := defaultConfig().overrideFromEnv(); err == nil {
+				t.Errorf("overrideFromEnv with a bad %s: got no error", key)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sashayakovtseva/bookshelf"
+)
+
+// csvColumns are the columns of a books CSV file, in order.
+var csvColumns = []string{"title", "author", "published_date", "description"}
+
+// importResult is the response of importHandler.
+type importResult struct {
+	Imported int        `json:"imported"`
+	Errors   []rowError `json:"errors"`
+}
+
+// rowError describes why a row of an imported CSV file was skipped.
+type rowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// importHandler adds the books listed in the CSV file uploaded in the "file"
+// form field, credited as by creditUser. Malformed rows are skipped and
+// reported rather than failing the whole upload.
+func importHandler(w http.ResponseWriter, r *http.Request) *appError {
+	f, _, err := r.FormFile("file")
+	if err != nil {
+		return badRequestf(err, "could not read uploaded file: %v", err)
+	}
+	defer f.Close()
+
+	books, rowErrs, err := parseBooksCSV(f)
+	if err != nil {
+		return badRequestf(err, "could not parse csv: %v", err)
+	}
+	creditUser(r.Context(), books...)
+	if len(books) > 0 {
+		if _, err := DB.AddBooks(r.Context(), books); err != nil {
+			return appErrorf(err, "could not save books: %v", err)
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(importResult{
+		Imported: len(books),
+		Errors:   rowErrs,
+	})
+	if err != nil {
+		return appErrorf(err, "could not encode result: %v", err)
+	}
+	return nil
+}
+
+// parseBooksCSV reads books from CSV data laid out as csvColumns, with an
+// optional header row. Rows that cannot be turned into a book are reported
+// by their 1-based position in the file.
+func parseBooksCSV(r io.Reader) ([]*bookshelf.Book, []rowError, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	books := []*bookshelf.Book{}
+	rowErrs := []rowError{}
+	for row := 1; ; row++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*csv.ParseError); ok {
+			rowErrs = append(rowErrs, rowError{Row: row, Error: err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if row == 1 && strings.EqualFold(strings.TrimSpace(rec[0]), csvColumns[0]) {
+			continue
+		}
+		if len(rec) != len(csvColumns) {
+			rowErrs = append(rowErrs, rowError{
+				Row:   row,
+				Error: fmt.Sprintf("expected %d fields, got %d", len(csvColumns), len(rec)),
+			})
+			continue
+		}
+		b := &bookshelf.Book{
+			Title:         rec[0],
+			Author:        rec[1],
+			PublishedDate: rec[2],
+			Description:   rec[3],
+		}
+		if err := b.Validate(); err != nil {
+			rowErrs = append(rowErrs, rowError{Row: row, Error: err.Error()})
+			continue
+		}
+		books = append(books, b)
+	}
+	return books, rowErrs, nil
+}
+
+// exportHandler streams every book in the database as a CSV file laid out as
+// csvColumns, with a header row.
+func exportHandler(w http.ResponseWriter, r *http.Request) *appError {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=books.csv")
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvColumns); err != nil {
+		return appErrorf(err, "could not write csv: %v", err)
+	}
+	err := DB.ForEachBook(r.Context(), func(b *bookshelf.Book) error {
+		return cw.Write([]string{b.Title, b.Author, b.PublishedDate, b.Description})
+	})
+	if err != nil {
+		return appErrorf(err, "could not export books: %v", err)
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return appErrorf(err, "could not write csv: %v", err)
+	}
+	return nil
+}
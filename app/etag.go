@@ -0,0 +1,48 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+)
+
+// bookETag returns a strong entity tag for a book with the given full JSON
+// encoding. It identifies the stored book rather than the bytes of a given
+// response, so that it is the same whatever the naming and fields parameters
+// and can be sent back in the If-Match of an update.
+func bookETag(body []byte) string {
+	sum := sha1.Sum(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// etagMatches reports whether the If-None-Match header value header lists
+// etag, using the weak comparison function of RFC 7232.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatchesStrong reports whether the If-Match header value header lists
+// etag, using the strong comparison function of RFC 7232, under which weak
+// tags never match.
+func etagMatchesStrong(header, etag string) bool {
+	if strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
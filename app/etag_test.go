@@ -0,0 +1,47 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestETagMatches(t *testing.T) {
+	const etag = `W/"abc"`
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{`W/"abc"`, true},
+		{`"abc"`, true},
+		{`*`, true},
+		{`"xyz", W/"abc"`, true},
+		{`"xyz"`, false},
+		{`"abcd"`, false},
+		{`W/"ab"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, etag); got != tt.want {
+			t.Errorf("etagMatches(%q, %q) = %v, want %v", tt.header, etag, got, tt.want)
+		}
+	}
+}
+
+func TestETagMatchesStrong(t *testing.T) {
+	tests := []struct {
+		header, etag string
+		want         bool
+	}{
+		{`"abc"`, `"abc"`, true},
+		{`*`, `"abc"`, true},
+		{`"xyz", "abc"`, `"abc"`, true},
+		{`W/"abc"`, `"abc"`, false},
+		{`"abc"`, `W/"abc"`, false},
+		{`"abcd"`, `"abc"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatchesStrong(tt.header, tt.etag); got != tt.want {
+			t.Errorf("etagMatchesStrong(%q, %q) = %v, want %v", tt.header, tt.etag, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// fieldsFromRequest returns the JSON keys listed by the fields query
+// parameter, e.g. fields=title,author, or nil if it is missing, in which
+// case every field is wanted.
+func fieldsFromRequest(r *http.Request) map[string]bool {
+	param := r.URL.Query().Get("fields")
+	if param == "" {
+		return nil
+	}
+	fields := make(map[string]bool)
+	for _, f := range strings.Split(param, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields[f] = true
+		}
+	}
+	return fields
+}
+
+// selectFields returns v, a book or a list of books, with only the given
+// fields kept in the JSON object of each book. Fields may be named in
+// snake_case or camelCase; unknown names are ignored.
+func selectFields(v interface{}, fields map[string]bool) (interface{}, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// Decoding numbers as json.Number keeps them exactly as they were.
+	var tree interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+
+	keep := func(obj interface{}) {
+		if obj, ok := obj.(map[string]interface{}); ok {
+			for k := range obj {
+				if !fields[k] && !fields[snakeToCamel(k)] {
+					delete(obj, k)
+				}
+			}
+		}
+	}
+	if list, ok := tree.([]interface{}); ok {
+		for _, e := range list {
+			keep(e)
+		}
+	} else {
+		keep(tree)
+	}
+	return tree, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/sashayakovtseva/bookshelf"
+)
+
+func TestFieldsFromRequest(t *testing.T) {
+	tests := []struct {
+		query string
+		want  map[string]bool
+	}{
+		{"", nil},
+		{"?fields=title", map[string]bool{"title": true}},
+		{"?fields=title,%20author,,pageCount", map[string]bool{"title": true, "author": true, "pageCount": true}},
+	}
+	for _, tt := range tests {
+		got := fieldsFromRequest(httptest.NewRequest("GET", "/books"+tt.query, nil))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("fieldsFromRequest(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSelectFields(t *testing.T) {
+	book := &bookshelf.Book{ID: 1, Title: "Dune", Author: "Frank Herbert", PageCount: 412, Rating: 4.5}
+	tests := []struct {
+		v      interface{}
+		fields map[string]bool
+		want   string
+	}{
+		{book, map[string]bool{"title": true}, `{"title":"Dune"}`},
+		{book, map[string]bool{"id": true, "pageCount": true, "bogus": true}, `{"id":1,"page_count":412}`},
+		{book, map[string]bool{"rating": true}, `{"rating":4.5}`},
+		{[]*bookshelf.Book{book, {ID: 2, Title: "Emma"}}, map[string]bool{"title": true}, `[{"title":"Dune"},{"title":"Emma"}]`},
+	}
+	for _, tt := range tests {
+		v, err := selectFields(tt.v, tt.fields)
+		if err != nil {
+			t.Fatalf("selectFields: %v", err)
+		}
+		got, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("encoding selected fields: %v", err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("selectFields(%v) = %s, want %s", tt.fields, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/sashayakovtseva/bookshelf"
+)
+
+// bookType exposes bookshelf.Book to GraphQL under the same field names as
+// its JSON form. Fields resolve through the Book's json tags.
+var bookType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Book",
+	Fields: graphql.Fields{
+		"id":             &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"title":          &graphql.Field{Type: graphql.String},
+		"author":         &graphql.Field{Type: graphql.String},
+		"published_date": &graphql.Field{Type: graphql.String},
+		"description":    &graphql.Field{Type: graphql.String},
+		"isbn":           &graphql.Field{Type: graphql.String},
+		"language":       &graphql.Field{Type: graphql.String},
+		"genre":          &graphql.Field{Type: graphql.String},
+		"status":         &graphql.Field{Type: graphql.String},
+		"page_count":     &graphql.Field{Type: graphql.Int},
+		"rating":         &graphql.Field{Type: graphql.Float},
+		"tags":           &graphql.Field{Type: graphql.NewList(graphql.String)},
+		"cover_url":      &graphql.Field{Type: graphql.String},
+		"created_by_id":  &graphql.Field{Type: graphql.String},
+		"created_by":     &graphql.Field{Type: graphql.String},
+		"version":        &graphql.Field{Type: graphql.Int},
+	},
+})
+
+// newQueryType returns the root of the read-only GraphQL API, whose books
+// query pages as pages says.
+func newQueryType(pages pageSizes) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"books": &graphql.Field{
+				Type: graphql.NewList(bookType),
+				Args: graphql.FieldConfigArgument{
+					"limit":  &graphql.ArgumentConfig{Type: graphql.Int},
+					"offset": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					limit, ok := p.Args["limit"].(int)
+					if !ok {
+						limit = pages.Default
+					}
+					offset, _ := p.Args["offset"].(int)
+					books, _, err := DB.ListBooksPaged(p.Context, pages.clamp(limit), offset)
+					return books, err
+				},
+			},
+			"book": &graphql.Field{
+				Type: bookType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					s, _ := p.Args["id"].(string)
+					id, err := strconv.ParseInt(s, 10, 64)
+					if err != nil {
+						return nil, errors.New("bad book id")
+					}
+					book, err := DB.GetBook(p.Context, id)
+					if errors.Is(err, bookshelf.ErrBookNotFound) {
+						return nil, nil
+					}
+					return book, err
+				},
+			},
+		},
+	})
+}
+
+// newGraphQLSchema returns the schema served by graphqlHandler.
+func newGraphQLSchema(pages pageSizes) graphql.Schema {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: newQueryType(pages)})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// graphqlRequest is the body of a GraphQL request.
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphqlHandler runs GraphQL queries against the books with the given
+// schema. Errors in a query itself are reported in the response body, as
+// GraphQL clients expect.
+func graphqlHandler(schema graphql.Schema) appHandler {
+	return func(w http.ResponseWriter, r *http.Request) *appError {
+		var req graphqlRequest
+		if aerr := decodeJSON(w, r, &req, "graphql request"); aerr != nil {
+			return aerr
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			OperationName:  req.OperationName,
+			VariableValues: req.Variables,
+			Context:        r.Context(),
+		})
+
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			return appErrorf(err, "could not encode graphql result: %v", err)
+		}
+		return nil
+	}
+}
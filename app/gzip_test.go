@@ -0,0 +1,78 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGzipMiddleware(t *testing.T) {
+	const body = "Dune, Emma, Middlemarch, Persuasion, Ulysses"
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		code           int
+		wantGzip       bool
+	}{
+		{"no Accept-Encoding", "", "application/json", http.StatusOK, false},
+		{"gzip accepted", "gzip, deflate", "application/json", http.StatusOK, true},
+		{"gzip refused", "gzip;q=0, deflate", "application/json", http.StatusOK, false},
+		{"sniffed type", "gzip", "", http.StatusOK, true},
+		{"image", "gzip", "image/png", http.StatusOK, false},
+		{"svg image", "gzip", "image/svg+xml", http.StatusOK, true},
+		{"not modified", "gzip", "application/json", http.StatusNotModified, false},
+		{"partial content", "gzip", "application/json", http.StatusPartialContent, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.WriteHeader(tt.code)
+				if tt.code != http.StatusNotModified {
+					w.Write([]byte(body))
+				}
+			})
+			r := httptest.NewRequest("GET", "/books", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			GzipMiddleware(next).ServeHTTP(w, r)
+
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			gzipped := w.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tt.wantGzip {
+				t.Fatalf("gzipped = %v, want %v", gzipped, tt.wantGzip)
+			}
+			if tt.code == http.StatusNotModified {
+				return
+			}
+			got := w.Body.String()
+			if gzipped {
+				zr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatalf("gzip.NewReader: %v", err)
+				}
+				b, err := io.ReadAll(zr)
+				if err != nil {
+					t.Fatalf("reading gzipped body: %v", err)
+				}
+				got = string(b)
+			}
+			if got != body {
+				t.Errorf("body = %q, want %q", got, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/sashayakovtseva/bookshelf"
+)
+
+// jsonAPI is the media type of JSON:API documents, which books are sent as
+// to clients that ask for it.
+const jsonAPI = "application/vnd.api+json"
+
+// jsonAPIResource is a book as a JSON:API resource object. Its attributes
+// are the fields of the book's JSON object other than the ID.
+type jsonAPIResource struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// jsonAPIDocument is a top-level JSON:API document holding a resource or a
+// list of them in Data.
+type jsonAPIDocument struct {
+	Data interface{}            `json:"data"`
+	Meta map[string]interface{} `json:"meta,omitempty"`
+}
+
+// newJSONAPIResource returns b as a resource object, with only the given
+// fields among its attributes unless fields is nil.
+func newJSONAPIResource(b *bookshelf.Book, fields map[string]bool) (jsonAPIResource, error) {
+	var v interface{} = b
+	if fields != nil {
+		var err error
+		if v, err = selectFields(b, fields); err != nil {
+			return jsonAPIResource{}, err
+		}
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return jsonAPIResource{}, err
+	}
+	// Decoding numbers as json.Number keeps them exactly as they were.
+	var attrs map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&attrs); err != nil {
+		return jsonAPIResource{}, err
+	}
+	delete(attrs, "id")
+	return jsonAPIResource{Type: "books", ID: strconv.FormatInt(b.ID, 10), Attributes: attrs}, nil
+}
+
+// writeJSONAPIBook sends book as a JSON:API document.
+func writeJSONAPIBook(w http.ResponseWriter, r *http.Request, book *bookshelf.Book) *appError {
+	res, err := newJSONAPIResource(book, fieldsFromRequest(r))
+	if err != nil {
+		return appErrorf(err, "could not encode book: %v", err)
+	}
+
+	w.Header().Set("Content-Type", jsonAPI)
+	if err := encodeNamed(w, r, jsonAPIDocument{Data: res}); err != nil {
+		return appErrorf(err, "could not encode book: %v", err)
+	}
+	return nil
+}
+
+// writeJSONAPIBooks sends books, a page of total books, as a JSON:API
+// document, with the total in its meta.
+func writeJSONAPIBooks(w http.ResponseWriter, r *http.Request, books []*bookshelf.Book, total int) *appError {
+	fields := fieldsFromRequest(r)
+	data := make([]jsonAPIResource, len(books))
+	for i, b := range books {
+		var err error
+		if data[i], err = newJSONAPIResource(b, fields); err != nil {
+			return appErrorf(err, "could not encode books: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", jsonAPI)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	doc := jsonAPIDocument{Data: data, Meta: map[string]interface{}{"total": total}}
+	if err := encodeNamed(w, r, doc); err != nil {
+		return appErrorf(err, "could not encode books: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sashayakovtseva/bookshelf"
+)
+
+// jsonLinesFlushEvery is how many books are written between flushes of a
+// JSON Lines export.
+const jsonLinesFlushEvery = 100
+
+// exportJSONLinesHandler streams every book in the database as newline
+// delimited JSON, one book per line, so clients can process the export
+// without buffering all of it.
+func exportJSONLinesHandler(w http.ResponseWriter, r *http.Request) *appError {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	n := 0
+	err := DB.ForEachBook(r.Context(), func(b *bookshelf.Book) error {
+		if err := enc.Encode(b); err != nil {
+			return err
+		}
+		n++
+		if flusher != nil && n%jsonLinesFlushEvery == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return appErrorf(err, "could not export books: %v", err)
+	}
+	return nil
+}
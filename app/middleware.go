@@ -0,0 +1,328 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashayakovtseva/bookshelf"
+	"golang.org/x/time/rate"
+)
+
+// responseWriter wraps an http.ResponseWriter, remembering the status code
+// written through it.
+type responseWriter struct {
+	http.ResponseWriter
+	status  int
+	started bool // set once the header has been sent.
+}
+
+// newResponseWriter wraps w. The status defaults to 200, which is what gets
+// sent if the handler writes a body without calling WriteHeader.
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.status = code
+	w.started = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush passes flushes through to the wrapped writer, so streaming handlers
+// keep working behind the middleware.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// LoggingMiddleware logs the method, path, response status and duration of
+// every request handled by next to logger, at info level, followed by the
+// request's ID if RequestIDMiddleware gave it one.
+func LoggingMiddleware(logger bookshelf.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := newResponseWriter(w)
+			next.ServeHTTP(rw, r)
+			logger.Infof("%s %s %d %v %s", r.Method, r.URL.Path, rw.status, time.Since(start),
+				RequestIDFromContext(r.Context()))
+		})
+	}
+}
+
+// requestIDKey is the context key under which RequestIDMiddleware stores the
+// request's ID.
+type requestIDKey struct{}
+
+// maxRequestIDLen bounds the length of the X-Request-ID headers reused as
+// request IDs.
+const maxRequestIDLen = 128
+
+// RequestIDMiddleware gives every request handled by next an ID, which it
+// stores in the request's context and echoes in the X-Request-ID response
+// header. The ID is taken from the request's own X-Request-ID header, so that
+// it can be traced across services, unless that is missing or holds anything
+// but letters, digits, dashes, underscores and dots, in which case a random
+// UUID is generated.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newUUID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestIDFromContext returns the ID RequestIDMiddleware gave the request
+// with context ctx, or "" if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID reports whether id is safe to reuse as a request ID, and in
+// particular to write to logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand does not fail on supported platforms.
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// TimeoutMiddleware gives every request handled by next a deadline d from
+// now. Database calls made with the request's context fail once it passes,
+// and appHandler then responds with 503 Service Unavailable.
+func TimeoutMiddleware(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// BasicAuthMiddleware only lets requests through to next if they carry the
+// given username and password with HTTP basic authentication, answering 401
+// Unauthorized otherwise, and records the username for UserFromContext. It is
+// meant for the routes that write: reads are left public by not wrapping
+// them. An empty username lets every request through.
+func BasicAuthMiddleware(username, password string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if username == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, pass, ok := r.BasicAuth()
+			// Both are compared even if the first differs, so that timing
+			// does not tell which one was wrong.
+			userOK := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+			passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+			if !ok || !userOK || !passOK {
+				w.Header().Set("WWW-Authenticate", `Basic realm="bookshelf", charset="UTF-8"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+		})
+	}
+}
+
+// userKey is the context key under which BasicAuthMiddleware stores the name
+// of the authenticated user.
+type userKey struct{}
+
+// UserFromContext returns the name of the user BasicAuthMiddleware
+// authenticated the request with context ctx as, or "" if there is none.
+func UserFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// AdminMiddleware only lets requests through to next if they carry an
+// "Authorization: Bearer <token>" header. Without a token the admin routes are
+// disabled and answer 404 Not Found; with the wrong one they answer 401
+// Unauthorized.
+func AdminMiddleware(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				http.NotFound(w, r)
+				return
+			}
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// corsMethods and corsHeaders are what cross-origin requests are allowed to
+// use, as announced in answers to preflight requests.
+const (
+	corsMethods = "GET, POST, PUT, PATCH, DELETE"
+	corsHeaders = "Authorization, Content-Type, If-None-Match, If-Match, Idempotency-Key, X-Request-ID"
+)
+
+// CORSMiddleware lets browsers on the given origins call next. An origin of
+// "*" allows any origin. Preflight requests are answered directly: with 204
+// No Content and the allowed methods and headers if the origin is allowed,
+// or with 403 Forbidden otherwise. Other requests from a disallowed origin
+// are served without CORS headers, which browsers then refuse to expose.
+func CORSMiddleware(allowedOrigins []string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, o := range allowedOrigins {
+		allowed[o] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Origin")
+			ok := allowed[origin] || allowed["*"]
+			if ok {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Request-ID")
+			}
+
+			if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !ok {
+				http.Error(w, "origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", corsMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// limiterIdleTimeout is how long a client's rate limiter is kept after its
+// last request. Forgetting it resets the client to a full bucket, which by
+// then it would have refilled anyway.
+const limiterIdleTimeout = 3 * time.Minute
+
+// clientLimiter is the token bucket of one client.
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimitMiddleware limits each client IP to rps requests per second on
+// average, with bursts of up to burst requests. Requests over the limit are
+// rejected with 429 Too Many Requests and a Retry-After header telling when
+// the next one would be allowed.
+func RateLimitMiddleware(rps float64, burst int) func(http.Handler) http.Handler {
+	var (
+		mu        sync.Mutex
+		clients   = make(map[string]*clientLimiter)
+		lastSweep = time.Now()
+	)
+	// reserve takes a token from the bucket of the client with the given IP,
+	// returning how long the client must wait before it is available.
+	reserve := func(ip string, now time.Time) time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if now.Sub(lastSweep) > limiterIdleTimeout {
+			for ip, c := range clients {
+				if now.Sub(c.lastSeen) > limiterIdleTimeout {
+					delete(clients, ip)
+				}
+			}
+			lastSweep = now
+		}
+
+		c, ok := clients[ip]
+		if !ok {
+			c = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+			clients[ip] = c
+		}
+		c.lastSeen = now
+
+		res := c.limiter.ReserveN(now, 1)
+		if !res.OK() {
+			// The burst is too small to ever allow a request.
+			return limiterIdleTimeout
+		}
+		delay := res.DelayFrom(now)
+		if delay > 0 {
+			// The request is rejected rather than delayed, so give the token
+			// back.
+			res.CancelAt(now)
+		}
+		return delay
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if delay := reserve(clientIP(r), time.Now()); delay > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIP returns the IP address the request came from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,201 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sashayakovtseva/bookshelf"
+)
+
+func TestLoggingMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		level   bookshelf.Level
+		handler http.HandlerFunc
+		want    string
+	}{
+		{
+			"implicit 200",
+			bookshelf.LevelInfo,
+			func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) },
+			`INFO GET /books/1 200 \S+ req-1\n$`,
+		},
+		{
+			"explicit status",
+			bookshelf.LevelInfo,
+			func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) },
+			`INFO GET /books/1 404 \S+ req-1\n$`,
+		},
+		{
+			"errors only",
+			bookshelf.LevelError,
+			func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) },
+			`^$`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/books/1?x=y", nil)
+			r.Header.Set("X-Request-ID", "req-1")
+			RequestIDMiddleware(LoggingMiddleware(bookshelf.NewLogger(&buf, tt.level))(tt.handler)).ServeHTTP(w, r)
+			if !regexp.MustCompile(tt.want).MatchString(buf.String()) {
+				t.Errorf("logged %q, want a match for %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	tests := []struct {
+		header string
+		reused bool
+	}{
+		{"", false},
+		{"abc-123_x.y", true},
+		{"has space", false},
+		{"new\nline", false},
+		{strings.Repeat("a", maxRequestIDLen), true},
+		{strings.Repeat("a", maxRequestIDLen+1), false},
+	}
+	for _, tt := range tests {
+		var inContext string
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			inContext = RequestIDFromContext(r.Context())
+		})
+		r := httptest.NewRequest("GET", "/books", nil)
+		if tt.header != "" {
+			r.Header.Set("X-Request-ID", tt.header)
+		}
+		w := httptest.NewRecorder()
+		RequestIDMiddleware(next).ServeHTTP(w, r)
+
+		got := w.Header().Get("X-Request-ID")
+		if got != inContext {
+			t.Errorf("X-Request-ID %.20q: responded with %q, but the context holds %q", tt.header, got, inContext)
+		}
+		if tt.reused && got != tt.header {
+			t.Errorf("X-Request-ID %.20q: got ID %q, want it reused", tt.header, got)
+		}
+		if !tt.reused && !uuid.MatchString(got) {
+			t.Errorf("X-Request-ID %.20q: got ID %q, want a new UUID", tt.header, got)
+		}
+	}
+	if id := RequestIDFromContext(httptest.NewRequest("GET", "/books", nil).Context()); id != "" {
+		t.Errorf("RequestIDFromContext without the middleware = %q, want empty", id)
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+	tests := []struct {
+		name          string
+		allowed       []string
+		method        string
+		origin        string
+		preflight     bool
+		wantCode      int
+		wantOrigin    string
+		wantAllowMeth bool
+	}{
+		{"same origin", []string{"https://example.com"}, "GET", "", false, http.StatusOK, "", false},
+		{"allowed origin", []string{"https://example.com"}, "GET", "https://example.com", false, http.StatusOK, "https://example.com", false},
+		{"disallowed origin", []string{"https://example.com"}, "GET", "https://evil.example", false, http.StatusOK, "", false},
+		{"wildcard", []string{"*"}, "GET", "https://evil.example", false, http.StatusOK, "https://evil.example", false},
+		{"allowed preflight", []string{"https://example.com"}, "OPTIONS", "https://example.com", true, http.StatusNoContent, "https://example.com", true},
+		{"disallowed preflight", []string{"https://example.com"}, "OPTIONS", "https://evil.example", true, http.StatusForbidden, "", false},
+		{"plain OPTIONS", []string{"https://example.com"}, "OPTIONS", "https://example.com", false, http.StatusOK, "https://example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/books", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				r.Header.Set("Access-Control-Request-Method", "PUT")
+			}
+			w := httptest.NewRecorder()
+			CORSMiddleware(tt.allowed)(next).ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Methods") != ""; got != tt.wantAllowMeth {
+				t.Errorf("Access-Control-Allow-Methods set = %v, want %v", got, tt.wantAllowMeth)
+			}
+			if tt.origin != "" && w.Header().Get("Vary") != "Origin" {
+				t.Errorf("Vary = %q, want Origin", w.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+	tests := []struct {
+		token         string
+		authorization string
+		want          int
+	}{
+		{"", "", http.StatusNotFound},
+		{"", "Bearer ", http.StatusNotFound},
+		{"s3cret", "", http.StatusUnauthorized},
+		{"s3cret", "Bearer guess", http.StatusUnauthorized},
+		{"s3cret", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/admin/books:updateWhere", nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		AdminMiddleware(tt.token)(next).ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("token %q, Authorization %q: got status %d, want %d", tt.token, tt.authorization, w.Code, tt.want)
+		}
+	}
+}
+
+func TestBasicAuthMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+	tests := []struct {
+		username, password string
+		user, pass         string
+		send               bool
+		want               int
+	}{
+		{"", "", "", "", false, http.StatusOK},
+		{"editor", "s3cret", "", "", false, http.StatusUnauthorized},
+		{"editor", "s3cret", "editor", "guess", true, http.StatusUnauthorized},
+		{"editor", "s3cret", "admin", "s3cret", true, http.StatusUnauthorized},
+		{"editor", "s3cret", "editor", "s3cret", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/books", nil)
+		if tt.send {
+			r.SetBasicAuth(tt.user, tt.pass)
+		}
+		w := httptest.NewRecorder()
+		BasicAuthMiddleware(tt.username, tt.password)(next).ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("credentials %q/%q, sent %q/%q: got status %d, want %d", tt.username, tt.password, tt.user, tt.pass, w.Code, tt.want)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("credentials %q/%q, sent %q/%q: no WWW-Authenticate challenge", tt.username, tt.password, tt.user, tt.pass)
+		}
+	}
+}
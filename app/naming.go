@@ -0,0 +1,74 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// encodeNamed writes v as JSON to w, like json.Encoder does. If the request
+// asks for naming=camel, the keys of every JSON object in v are turned from
+// snake_case into camelCase, e.g. published_date into publishedDate; keys
+// are otherwise left as the json tags name them.
+func encodeNamed(w io.Writer, r *http.Request, v interface{}) error {
+	body, err := marshalNamed(r, v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(body, '\n'))
+	return err
+}
+
+// marshalNamed returns the JSON encoding of v with keys named as described
+// by encodeNamed.
+func marshalNamed(r *http.Request, v interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil || r.URL.Query().Get("naming") != "camel" {
+		return body, err
+	}
+
+	// Decoding numbers as json.Number keeps them exactly as they were.
+	var tree interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return json.Marshal(camelKeys(tree))
+}
+
+// camelKeys renames the keys of the objects in a decoded JSON value to
+// camelCase, recursively.
+func camelKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			renamed[snakeToCamel(k)] = camelKeys(e)
+		}
+		return renamed
+	case []interface{}:
+		for i, e := range v {
+			v[i] = camelKeys(e)
+		}
+		return v
+	}
+	return v
+}
+
+// snakeToCamel turns a snake_case name into camelCase.
+func snakeToCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
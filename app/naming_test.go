@@ -0,0 +1,23 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestSnakeToCamel(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"title", "title"},
+		{"published_date", "publishedDate"},
+		{"created_by_id", "createdById"},
+		{"trailing_", "trailing"},
+	}
+	for _, tt := range tests {
+		if got := snakeToCamel(tt.in); got != tt.want {
+			t.Errorf("snakeToCamel(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// problemJSON is the media type of RFC 7807 problem details.
+const problemJSON = "application/problem+json"
+
+// problemBody is the RFC 7807 shape of an appError, sent to clients that ask
+// for it. Besides the standard members, it carries the request ID and the
+// invalid fields like errorBody does.
+type problemBody struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail"`
+	RequestID string            `json:"request_id,omitempty"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
+// newProblem describes e as problem details. Errors are told apart by their
+// status alone, so the type is "about:blank" and the title the status text,
+// as RFC 7807 suggests.
+func newProblem(e *appError) problemBody {
+	return problemBody{
+		Type:      "about:blank",
+		Title:     http.StatusText(e.Code),
+		Status:    e.Code,
+		Detail:    e.Message,
+		RequestID: e.RequestID,
+		Errors:    e.Fields,
+	}
+}
+
+// acceptsByName reports whether the request's Accept header names
+// mediaType, rather than only matching it with a wildcard, and does not
+// refuse it with a zero quality.
+func acceptsByName(r *http.Request, mediaType string) bool {
+	accept := r.Header.Get("Accept")
+	for _, part := range strings.Split(accept, ",") {
+		rng := strings.TrimSpace(strings.Split(part, ";")[0])
+		if strings.EqualFold(rng, mediaType) {
+			return acceptQuality(accept, mediaType) > 0
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsByName(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/*", false},
+		{"application/json", false},
+		{"application/problem+json", true},
+		{"application/json, Application/Problem+JSON;q=0.5", true},
+		{"application/problem+json;q=0", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/books/7", nil)
+		r.Header.Set("Accept", tt.accept)
+		if got := acceptsByName(r, problemJSON); got != tt.want {
+			t.Errorf("acceptsByName(%q) with Accept %q = %v, want %v", problemJSON, tt.accept, got, tt.want)
+		}
+	}
+}
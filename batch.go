@@ -0,0 +1,38 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"errors"
+)
+
+// BookError reports why one book of a batch could not be saved.
+type BookError struct {
+	Index int    `json:"index"` // Position of the book in the batch.
+	Err   string `json:"error"`
+}
+
+// addEach saves books one at a time with add, for AddBooksBestEffort. Books
+// that are invalid or whose ISBN is taken are reported as failures and
+// skipped; any other error stops the batch and is returned along with what
+// was saved so far.
+func addEach(ctx context.Context, books []*Book, add func(context.Context, *Book) (int64, error)) ([]int64, []BookError, error) {
+	ids := make([]int64, len(books))
+	failures := []BookError{}
+	for i, b := range books {
+		id, err := add(ctx, b)
+		var invalid ValidationError
+		switch {
+		case err == nil:
+			ids[i] = id
+		case errors.As(err, &invalid), errors.Is(err, ErrDuplicateISBN):
+			failures = append(failures, BookError{Index: i, Err: err.Error()})
+		default:
+			return ids, failures, err
+		}
+	}
+	return ids, failures, nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestAddEach(t *testing.T) {
+	errDown := errors.New("connection refused")
+	add := func(_ context.Context, b *Book) (int64, error) {
+		switch b.Title {
+		case "invalid":
+			return 0, ValidationError{{Field: "isbn", Err: ErrInvalidISBN}}
+		case "duplicate":
+			return 7, fmt.Errorf("could not add book: %w", ErrDuplicateISBN)
+		case "down":
+			return 0, errDown
+		}
+		return int64(len(b.Title)), nil
+	}
+	books := []*Book{{Title: "Dune"}, {Title: "invalid"}, {Title: "duplicate"}, {Title: "Emma!"}, {Title: "down"}, {Title: "Persuasion"}}
+
+	ids, failures, err := addEach(context.Background(), books, add)
+	if err != errDown {
+		t.Errorf("addEach: got error %v, want the one stopping the batch", err)
+	}
+	if want := []int64{4, 0, 0, 5, 0, 0}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("addEach gave IDs %v, want %v", ids, want)
+	}
+	if len(failures) != 2 || failures[0].Index != 1 || failures[1].Index != 2 {
+		t.Errorf("addEach reported %+v, want failures of books 1 and 2", failures)
+	}
+}
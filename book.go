@@ -4,36 +4,422 @@
 
 package bookshelf
 
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrBookNotFound is returned when no book matches the requested ID.
+var ErrBookNotFound = errors.New("bookshelf: book not found")
+
+// ErrInvalidSortField is returned when books are asked to be sorted by a
+// field that is not one of the sortable ones.
+var ErrInvalidSortField = errors.New("bookshelf: invalid sort field")
+
+// ErrInvalidRating is returned when a book's rating is outside [0, 5].
+var ErrInvalidRating = errors.New("bookshelf: rating must be between 0 and 5")
+
+// ErrDatabaseUnavailable is returned when the database server cannot be
+// reached. It is usually wrapped along with the underlying error.
+var ErrDatabaseUnavailable = errors.New("bookshelf: database unavailable")
+
+// ErrVersionConflict is returned when a book is updated based on a version
+// that is no longer the stored one, meaning someone else changed it since.
+var ErrVersionConflict = errors.New("bookshelf: book was changed by someone else")
+
+// ErrReadOnly is returned by the writes made to a read-only database.
+var ErrReadOnly = errors.New("bookshelf: database is read-only")
+
+// ErrTransactionsUnsupported is returned by WithTransaction on backends that
+// cannot roll changes back.
+var ErrTransactionsUnsupported = errors.New("bookshelf: transactions are not supported")
+
+// sortFields maps the names of the fields books can be sorted by, which are
+// also their storage keys, to accessors for their values.
+var sortFields = map[string]func(*Book) string{
+	"title":          func(b *Book) string { return b.Title },
+	"author":         func(b *Book) string { return b.Author },
+	"published_date": func(b *Book) string { return b.PublishedDate },
+}
+
 // Book holds metadata about a book.
 type Book struct {
-	ID            int64  `json:"-",bson:"-"`
-	Title         string `json:"title",bson:"title"`
-	Author        string `json:"author",bson:"author"`
-	PublishedDate string `json:"published_date",bson:"published_date"`
-	Description   string `json:"description",bson:"description"`
+	// ID is stored under "id" since that is the key lookups query by.
+	ID            int64  `json:"id" bson:"id"`
+	Title         string `json:"title" bson:"title"`
+	Author        string `json:"author" bson:"author"`
+	PublishedDate string `json:"published_date" bson:"published_date"`
+	Description   string `json:"description" bson:"description"`
+	ISBN          string `json:"isbn" bson:"isbn"`
+
+	// Language is the ISO 639-1 code of the language the book is written in,
+	// e.g. "en" or "fr".
+	Language string `json:"language" bson:"language"`
+
+	// Genre is one of the Genre constants, if set.
+	Genre string `json:"genre" bson:"genre"`
+
+	// Status is one of the Status constants. Books added without one are
+	// published.
+	Status string `json:"status" bson:"status"`
+
+	// PageCount is the number of pages of the book, or 0 if unknown.
+	PageCount int `json:"page_count" bson:"page_count"`
+
+	// Rating is the book's score, from 0 to 5.
+	Rating float64 `json:"rating" bson:"rating"`
+
+	// Tags categorize the book, e.g. "scifi" or "classics".
+	Tags []string `json:"tags" bson:"tags"`
+
+	// CoverURL locates the book's cover image, if it has one.
+	CoverURL string `json:"cover_url" bson:"cover_url"`
+
+	// Metadata holds whatever extra details a deployment tracks, such as
+	// the shelf a book is on. Keys must not be empty, contain dots or start
+	// with a dollar sign.
+	Metadata map[string]string `json:"metadata,omitempty" bson:"metadata,omitempty"`
+
+	// CreatedByID and CreatedBy identify the user who added the book. They
+	// are set when the book is added and are left untouched by updates.
+	CreatedByID string `json:"created_by_id" bson:"createdby_id"`
+	CreatedBy   string `json:"created_by" bson:"createdby"`
+
+	// Version is incremented by every update, starting from 1 when the book
+	// is added. UpdateBook only succeeds if it matches the stored version.
+	Version int64 `json:"version" bson:"version"`
+
+	// CreatedAt is set when the book is added. UpdatedAt is set along with it
+	// and refreshed by every update.
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+
+	// DeletedAt is set when the book is deleted. Deleted books are hidden
+	// from every lookup until they are restored or purged.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+}
+
+// ValidateRating checks that the book's rating is between 0 and 5.
+func (b *Book) ValidateRating() error {
+	if b.Rating < 0 || b.Rating > 5 {
+		return ErrInvalidRating
+	}
+	return nil
+}
+
+// AuthorCount is the number of books written by an author.
+type AuthorCount struct {
+	Author string `json:"author" bson:"_id"`
+	Count  int    `json:"count" bson:"count"`
+}
+
+// conflictOrNotFound explains why an update of the book with the given ID
+// matched nothing: either the book is gone, or its version has moved on.
+func conflictOrNotFound(ctx context.Context, db BookDatabase, id int64) error {
+	if _, err := db.GetBook(ctx, id); err != nil {
+		return err
+	}
+	return ErrVersionConflict
+}
+
+// sortTitles sorts distinct titles alphabetically, ignoring case and accents,
+// and keeps the first limit of them, or all of them if limit is not positive.
+func sortTitles(titles []string, limit int) []string {
+	sort.Slice(titles, func(i, j int) bool {
+		if c := compareTitles(titles[i], titles[j]); c != 0 {
+			return c < 0
+		}
+		return titles[i] < titles[j]
+	})
+	if limit > 0 && limit < len(titles) {
+		titles = titles[:limit]
+	}
+	return titles
+}
+
+// indexKey returns the key under which ListTitleIndex counts a title that
+// starts with prefix.
+func indexKey(prefix string) string {
+	r, _ := utf8.DecodeRuneInString(prefix)
+	if !unicode.IsLetter(r) {
+		return "#"
+	}
+	return string(unicode.ToUpper(r))
+}
+
+// inIDOrder returns books, which are looked up by ID, in the order of ids.
+// Books not listed in ids are dropped.
+func inIDOrder(books []*Book, ids []int64) []*Book {
+	byID := make(map[int64]*Book, len(books))
+	for _, b := range books {
+		byID[b.ID] = b
+	}
+	ordered := make([]*Book, 0, len(ids))
+	for _, id := range ids {
+		if b, ok := byID[id]; ok {
+			ordered = append(ordered, b)
+		}
+	}
+	return ordered
 }
 
 // BookDatabase provides thread-safe access to a database of books.
+//
+// Books ordered by title are sorted ignoring case and accents, so that
+// "apple" and "Éclair" come before "Zebra".
+//
+// Drafts are left out of every listing, search, count and statistic. They
+// are only found by ID, by ListBooksByStatus and by ListBooksCreatedBy, so
+// that their authors can get back to them.
 type BookDatabase interface {
-	// ListBooks returns a list of books, ordered by title.
-	ListBooks() ([]*Book, error)
+	// ListBooks returns a list of the published books, ordered by title.
+	ListBooks(ctx context.Context) ([]*Book, error)
+
+	// ListBooksPaged returns at most limit published books, ordered by
+	// title, skipping the first offset of them, along with the total number
+	// of published books. A non-positive limit returns every book past
+	// offset.
+	ListBooksPaged(ctx context.Context, limit, offset int) ([]*Book, int, error)
+
+	// ListBooksAfter returns at most limit books with an ID greater than
+	// afterID, ordered by ID. Passing the ID of the last book returned as the
+	// next afterID walks through every book without gaps or overlaps.
+	ListBooksAfter(ctx context.Context, afterID int64, limit int) ([]*Book, error)
+
+	// ListBooksSorted returns a list of books ordered by the given field,
+	// which must be one of "title", "author" or "published_date", or else
+	// ErrInvalidSortField is returned.
+	ListBooksSorted(ctx context.Context, field string, descending bool) ([]*Book, error)
+
+	// ListBooksByAuthor returns a list of books, ordered by title, written by
+	// the given author.
+	ListBooksByAuthor(ctx context.Context, author string) ([]*Book, error)
+
+	// ListBooksByStatus returns a list of books, ordered by title, with the
+	// given status, which must be one of the Status constants, or else
+	// ErrInvalidStatus is returned.
+	ListBooksByStatus(ctx context.Context, status string) ([]*Book, error)
+
+	// ListBooksByTag returns a list of books, ordered by title, that carry
+	// the given tag.
+	ListBooksByTag(ctx context.Context, tag string) ([]*Book, error)
+
+	// ListBooksByTags returns a list of books, ordered by title, that carry
+	// every one of the given tags if matchAll is set, or any of them
+	// otherwise. No book matches an empty list of tags.
+	ListBooksByTags(ctx context.Context, tags []string, matchAll bool) ([]*Book, error)
+
+	// ListBooksByYear returns a list of books, ordered by title, whose
+	// published date parses (see Book.ParsedPublishedDate) to the given year.
+	ListBooksByYear(ctx context.Context, year int) ([]*Book, error)
+
+	// ListBooksBetweenDates returns a list of books, ordered by published
+	// date, whose published date parses (see Book.ParsedPublishedDate) to a
+	// day between start and end, inclusive. A zero start or end leaves that
+	// side of the range open.
+	ListBooksBetweenDates(ctx context.Context, start, end time.Time) ([]*Book, error)
+
+	// ListBooksByLanguage returns a list of books, ordered by title, written
+	// in the language with the given ISO 639-1 code.
+	ListBooksByLanguage(ctx context.Context, lang string) ([]*Book, error)
+
+	// ListBooksByGenre returns a list of books, ordered by title, of the
+	// given genre.
+	ListBooksByGenre(ctx context.Context, genre string) ([]*Book, error)
+
+	// ListBooksByPageRange returns a list of books, ordered by title, with
+	// between min and max pages inclusive. A non-positive max sets no upper
+	// bound.
+	ListBooksByPageRange(ctx context.Context, min, max int) ([]*Book, error)
+
+	// ListBooksByMetadata returns a list of books, ordered by title, whose
+	// metadata maps key to value. A key Validate would reject in a book's
+	// metadata gets a ValidationError.
+	ListBooksByMetadata(ctx context.Context, key, value string) ([]*Book, error)
 
 	// ListBooksCreatedBy returns a list of books, ordered by title, filtered by
-	// the user who created the book entry.
-	ListBooksCreatedBy(userID string) ([]*Book, error)
+	// the user who created the book entry, drafts included.
+	ListBooksCreatedBy(ctx context.Context, userID string) ([]*Book, error)
+
+	// ListBooksModifiedSince returns the books added or updated at or after
+	// since, ordered by UpdatedAt, so that clients can sync their copies.
+	ListBooksModifiedSince(ctx context.Context, since time.Time) ([]*Book, error)
+
+	// ListRecentBooks returns the limit most recently added books, newest
+	// first. A non-positive limit returns every book.
+	ListRecentBooks(ctx context.Context, limit int) ([]*Book, error)
+
+	// SearchBooks returns the books whose title, author or description match
+	// the given free-text query, most relevant first.
+	SearchBooks(ctx context.Context, query string) ([]*Book, error)
+
+	// SearchBooksFiltered is like SearchBooks, but only returns the books
+	// carrying at least one of the given tags, unless there are none.
+	SearchBooksFiltered(ctx context.Context, query string, tags []string) ([]*Book, error)
+
+	// SuggestTitles returns at most limit distinct titles, in alphabetical
+	// order, that start with prefix, ignoring case. A non-positive limit
+	// returns every such title.
+	SuggestTitles(ctx context.Context, prefix string, limit int) ([]string, error)
+
+	// ForEachBook calls fn for every published book, ordered by title,
+	// without loading them all into memory at once. Iteration stops at the
+	// first error returned by fn, which is then returned.
+	ForEachBook(ctx context.Context, fn func(*Book) error) error
+
+	// ListAuthors returns the distinct authors of the books, in alphabetical
+	// order. Books without an author are left out.
+	ListAuthors(ctx context.Context) ([]string, error)
+
+	// AverageRatingByAuthor returns the mean rating of the books of each
+	// author.
+	AverageRatingByAuthor(ctx context.Context) (map[string]float64, error)
+
+	// TopAuthors returns the limit authors with the most books, most
+	// prolific first, ties broken alphabetically. A non-positive limit
+	// returns every author. Books without an author are left out.
+	TopAuthors(ctx context.Context, limit int) ([]AuthorCount, error)
+
+	// ListTitleIndex returns the number of books whose title starts with
+	// each letter, keyed by the letter in upper case. Titles starting with
+	// anything but a letter are counted under "#".
+	ListTitleIndex(ctx context.Context) (map[string]int, error)
+
+	// CountBooks returns the number of published books, which is the total
+	// ListBooksPaged reports.
+	CountBooks(ctx context.Context) (int64, error)
+
+	// CountBooksCreatedBy returns the number of books created by the given
+	// user, drafts included.
+	CountBooksCreatedBy(ctx context.Context, userID string) (int64, error)
 
 	// GetBook retrieves a book by its ID.
-	GetBook(id int64) (*Book, error)
+	GetBook(ctx context.Context, id int64) (*Book, error)
+
+	// BookExists reports whether there is a book with the given ID, without
+	// retrieving it. Deleted books do not exist.
+	BookExists(ctx context.Context, id int64) (bool, error)
+
+	// RandomBook retrieves a published book picked at random, or
+	// ErrBookNotFound if there is none.
+	RandomBook(ctx context.Context) (*Book, error)
+
+	// GetBooks retrieves the books with the given IDs, in the same order.
+	// IDs of missing or deleted books are omitted.
+	GetBooks(ctx context.Context, ids []int64) ([]*Book, error)
 
 	// AddBook saves a given book, assigning it a new ID.
-	AddBook(b *Book) (id int64, err error)
+	AddBook(ctx context.Context, b *Book) (id int64, err error)
+
+	// AddBooks saves the given books, assigning each a new ID, and returns
+	// the IDs in the same order. If any book is invalid none are saved.
+	AddBooks(ctx context.Context, books []*Book) ([]int64, error)
+
+	// AddBooksBestEffort saves as many of the given books as it can. It
+	// returns their IDs in the same order, with 0 for the books that could
+	// not be saved, which are listed in failures. err is set if the batch
+	// had to stop part way.
+	AddBooksBestEffort(ctx context.Context, books []*Book) (ids []int64, failures []BookError, err error)
+
+	// DeleteBook marks a given book as deleted by its ID. The book can be
+	// brought back with RestoreBook until it is purged.
+	DeleteBook(ctx context.Context, id int64) error
+
+	// DeleteBookDryRun returns the book DeleteBook would delete given its ID,
+	// or ErrBookNotFound, without deleting anything.
+	DeleteBookDryRun(ctx context.Context, id int64) (*Book, error)
+
+	// DeleteBooks marks the books with the given IDs as deleted, returning
+	// how many were. IDs of missing or already deleted books are skipped.
+	DeleteBooks(ctx context.Context, ids []int64) (deleted int, err error)
+
+	// RestoreBook brings back a deleted book by its ID.
+	RestoreBook(ctx context.Context, id int64) error
+
+	// PurgeDeleted permanently removes the books deleted more than olderThan
+	// ago, returning how many were removed.
+	PurgeDeleted(ctx context.Context, olderThan time.Duration) (int, error)
+
+	// DeleteAllBooks permanently removes every book, deleted or not, along
+	// with its reviews, returning how many books were removed.
+	DeleteAllBooks(ctx context.Context) (int, error)
+
+	// UpdateBook updates the entry for a given book. It returns
+	// ErrVersionConflict unless b.Version is the stored version, and
+	// increments b.Version on success.
+	UpdateBook(ctx context.Context, b *Book) error
+
+	// PublishBook sets the status of the book with the given ID to
+	// StatusPublished, incrementing its version.
+	PublishBook(ctx context.Context, id int64) error
+
+	// SetBookTags replaces the tags of the book with the given ID, trimmed
+	// and without repeats, incrementing its version.
+	SetBookTags(ctx context.Context, id int64, tags []string) error
+
+	// UpdateBookFields changes only the given fields of a book, keyed by
+	// their JSON names, leaving the others untouched. Only title, author,
+	// published_date, description, isbn, language, genre, status,
+	// page_count, rating, tags, cover_url and metadata may be updated.
+	UpdateBookFields(ctx context.Context, id int64, fields map[string]interface{}) error
+
+	// UpdateBooksWhere sets the fields of set on every book whose fields have
+	// the values of filter, returning how many books matched. Both are keyed
+	// by JSON name: filter may use title, author, published_date, isbn,
+	// language and genre, and set the fields UpdateBookFields may update.
+	UpdateBooksWhere(ctx context.Context, filter, set map[string]interface{}) (matched int, err error)
+
+	// RenameAuthor changes the author of every book written by from to to,
+	// returning how many books were changed.
+	RenameAuthor(ctx context.Context, from, to string) (updated int, err error)
+
+	// AddReview adds a review to the book with the given ID, setting its
+	// CreatedAt.
+	AddReview(ctx context.Context, bookID int64, r *Review) error
+
+	// ListReviews returns the reviews of the book with the given ID, in the
+	// order they were added.
+	ListReviews(ctx context.Context, bookID int64) ([]*Review, error)
+
+	// ListReviewsPaged returns at most limit reviews of the book with the
+	// given ID, in the order they were added, skipping the first offset of
+	// them, along with the total number of its reviews. A non-positive limit
+	// returns every review past offset.
+	ListReviewsPaged(ctx context.Context, bookID int64, limit, offset int) ([]*Review, int, error)
+
+	// WithTransaction runs fn in a transaction, committing the changes made
+	// through the context passed to fn if it returns nil and rolling them
+	// back otherwise, in which case its error is returned. Backends without
+	// transactions emulate them, or return ErrTransactionsUnsupported without
+	// running fn; see their documentation.
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+
+	// Migrate makes sure the tables and indexes the database relies on
+	// exist, creating the missing ones. It is safe to call repeatedly.
+	Migrate(ctx context.Context) error
+
+	// Reindex rebuilds the indexes Migrate creates, for instance after a
+	// bulk import. Backends without indexes do nothing.
+	Reindex(ctx context.Context) error
+
+	// Subscribe returns a channel receiving every book added from now on,
+	// and a function to unsubscribe. The channel is closed on unsubscribing
+	// or closing the database. Books may be dropped for a subscriber that
+	// does not keep up.
+	Subscribe() (<-chan *Book, func())
 
-	// DeleteBook removes a given book by its ID.
-	DeleteBook(id int64) error
+	// Ping checks that the database can be reached.
+	Ping(ctx context.Context) error
 
-	// UpdateBook updates the entry for a given book.
-	UpdateBook(b *Book) error
+	// Name returns the name of the database backend, such as "mongodb" or
+	// "postgres".
+	Name() string
 
-	// Close closes the database, freeing up any available resources.
-	Close()
+	// Close closes the database, freeing up any available resources. Closing
+	// an already closed database does nothing.
+	Close() error
 }
@@ -0,0 +1,78 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// tagKey matches the snake_case keys books are stored and served under.
+var tagKey = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// TestBookTags checks that every Book field has a well-formed json and bson
+// key, and that no two fields share one. A json key of "-" leaves the field
+// out of JSON.
+func TestBookTags(t *testing.T) {
+	typ := reflect.TypeOf(Book{})
+	for _, name := range []string{"json", "bson"} {
+		seen := make(map[string]string)
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			tag, ok := f.Tag.Lookup(name)
+			if !ok {
+				t.Errorf("Book.%s has no %s tag", f.Name, name)
+				continue
+			}
+			key := strings.Split(tag, ",")[0]
+			if name == "json" && key == "-" {
+				continue
+			}
+			if !tagKey.MatchString(key) {
+				t.Errorf("Book.%s: %s key %q is not snake_case", f.Name, name, key)
+			}
+			if other, ok := seen[key]; ok {
+				t.Errorf("Book.%s and Book.%s share the %s key %q", other, f.Name, name, key)
+			}
+			seen[key] = f.Name
+		}
+	}
+}
+
+func TestValidateRating(t *testing.T) {
+	for _, tt := range []struct {
+		rating float64
+		want   error
+	}{
+		{0, nil},
+		{2.5, nil},
+		{5, nil},
+		{-0.1, ErrInvalidRating},
+		{5.1, ErrInvalidRating},
+	} {
+		b := &Book{Rating: tt.rating}
+		if err := b.ValidateRating(); err != tt.want {
+			t.Errorf("ValidateRating(%v) = %v, want %v", tt.rating, err, tt.want)
+		}
+	}
+}
+
+func TestIndexKey(t *testing.T) {
+	for _, tt := range []struct {
+		prefix, want string
+	}{
+		{"Dune", "D"},
+		{"emma", "E"},
+		{"Émile", "É"},
+		{"1984", "#"},
+		{"", "#"},
+	} {
+		if got := indexKey(tt.prefix); got != tt.want {
+			t.Errorf("indexKey(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package bookshelfpb holds the protocol buffer messages and gRPC stubs of
+// BookService, generated from bookshelf.proto.
+package bookshelfpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative bookshelf.proto
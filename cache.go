@@ -0,0 +1,395 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// cachingDB keeps the most recently retrieved books of another database in
+// memory, so that repeated lookups by ID skip the database.
+type cachingDB struct {
+	inner BookDatabase
+	size  int
+
+	mu      sync.Mutex
+	order   *list.List              // most recently used first; values are *Book.
+	entries map[int64]*list.Element // maps from Book's ID to its element in order.
+	gen     uint64                  // incremented by every eviction; see add.
+}
+
+// Ensure cachingDB conforms to the BookDatabase interface.
+var _ BookDatabase = &cachingDB{}
+
+// NewCachingDB wraps inner so that GetBook results are cached, keeping the
+// size most recently used books. Every write through the wrapper evicts the
+// books it may change. Writes made to inner by other means are not seen.
+func NewCachingDB(inner BookDatabase, size int) BookDatabase {
+	return &cachingDB{
+		inner:   inner,
+		size:    size,
+		order:   list.New(),
+		entries: make(map[int64]*list.Element),
+	}
+}
+
+// cached returns a copy of the cached book with the given ID, if any.
+func (db *cachingDB) cached(id int64) (*Book, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	e, ok := db.entries[id]
+	if !ok {
+		return nil, false
+	}
+	db.order.MoveToFront(e)
+	return copyBook(e.Value.(*Book)), true
+}
+
+// generation returns the number of evictions so far, to be passed to add.
+func (db *cachingDB) generation() uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.gen
+}
+
+// add caches a copy of b, evicting the least recently used book if the cache
+// is full. gen is the generation from before b was read: if anything was
+// evicted since, b may predate a write and is not cached.
+func (db *cachingDB) add(gen uint64, b *Book) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if gen != db.gen {
+		return
+	}
+	if e, ok := db.entries[b.ID]; ok {
+		e.Value = copyBook(b)
+		db.order.MoveToFront(e)
+		return
+	}
+	if db.size <= 0 {
+		return
+	}
+	for db.order.Len() >= db.size {
+		oldest := db.order.Back()
+		db.order.Remove(oldest)
+		delete(db.entries, oldest.Value.(*Book).ID)
+	}
+	db.entries[b.ID] = db.order.PushFront(copyBook(b))
+}
+
+// evict drops the books with the given IDs from the cache.
+func (db *cachingDB) evict(ids ...int64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.gen++
+	for _, id := range ids {
+		if e, ok := db.entries[id]; ok {
+			db.order.Remove(e)
+			delete(db.entries, id)
+		}
+	}
+}
+
+// evictAll empties the cache.
+func (db *cachingDB) evictAll() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.gen++
+	db.order.Init()
+	db.entries = make(map[int64]*list.Element)
+}
+
+// GetBook retrieves a book by its ID, from the cache if possible. Missing
+// books are not cached.
+func (db *cachingDB) GetBook(ctx context.Context, id int64) (*Book, error) {
+	if b, ok := db.cached(id); ok {
+		return b, nil
+	}
+	gen := db.generation()
+	b, err := db.inner.GetBook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	db.add(gen, b)
+	return b, nil
+}
+
+// BookExists answers from the cache when it holds the book.
+func (db *cachingDB) BookExists(ctx context.Context, id int64) (bool, error) {
+	if _, ok := db.cached(id); ok {
+		return true, nil
+	}
+	return db.inner.BookExists(ctx, id)
+}
+
+func (db *cachingDB) RandomBook(ctx context.Context) (*Book, error) {
+	return db.inner.RandomBook(ctx)
+}
+
+// GetBooks retrieves the books with the given IDs, in the same order, taking
+// those it can from the cache and the others from the database in one call.
+func (db *cachingDB) GetBooks(ctx context.Context, ids []int64) ([]*Book, error) {
+	var (
+		found   []*Book
+		missing []int64
+	)
+	for _, id := range ids {
+		if b, ok := db.cached(id); ok {
+			found = append(found, b)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		gen := db.generation()
+		books, err := db.inner.GetBooks(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range books {
+			db.add(gen, b)
+		}
+		found = append(found, books...)
+	}
+	return inIDOrder(found, ids), nil
+}
+
+func (db *cachingDB) ListBooks(ctx context.Context) ([]*Book, error) {
+	return db.inner.ListBooks(ctx)
+}
+
+func (db *cachingDB) ListBooksPaged(ctx context.Context, limit, offset int) ([]*Book, int, error) {
+	return db.inner.ListBooksPaged(ctx, limit, offset)
+}
+
+func (db *cachingDB) ListBooksAfter(ctx context.Context, afterID int64, limit int) ([]*Book, error) {
+	return db.inner.ListBooksAfter(ctx, afterID, limit)
+}
+
+func (db *cachingDB) ListBooksSorted(ctx context.Context, field string, descending bool) ([]*Book, error) {
+	return db.inner.ListBooksSorted(ctx, field, descending)
+}
+
+func (db *cachingDB) ListBooksByAuthor(ctx context.Context, author string) ([]*Book, error) {
+	return db.inner.ListBooksByAuthor(ctx, author)
+}
+
+func (db *cachingDB) ListBooksByStatus(ctx context.Context, status string) ([]*Book, error) {
+	return db.inner.ListBooksByStatus(ctx, status)
+}
+
+func (db *cachingDB) ListBooksByTag(ctx context.Context, tag string) ([]*Book, error) {
+	return db.inner.ListBooksByTag(ctx, tag)
+}
+
+func (db *cachingDB) ListBooksByTags(ctx context.Context, tags []string, matchAll bool) ([]*Book, error) {
+	return db.inner.ListBooksByTags(ctx, tags, matchAll)
+}
+
+func (db *cachingDB) ListBooksByYear(ctx context.Context, year int) ([]*Book, error) {
+	return db.inner.ListBooksByYear(ctx, year)
+}
+
+func (db *cachingDB) ListBooksBetweenDates(ctx context.Context, start, end time.Time) ([]*Book, error) {
+	return db.inner.ListBooksBetweenDates(ctx, start, end)
+}
+
+func (db *cachingDB) ListBooksByLanguage(ctx context.Context, lang string) ([]*Book, error) {
+	return db.inner.ListBooksByLanguage(ctx, lang)
+}
+
+func (db *cachingDB) ListBooksByGenre(ctx context.Context, genre string) ([]*Book, error) {
+	return db.inner.ListBooksByGenre(ctx, genre)
+}
+
+func (db *cachingDB) ListBooksByMetadata(ctx context.Context, key, value string) ([]*Book, error) {
+	return db.inner.ListBooksByMetadata(ctx, key, value)
+}
+
+func (db *cachingDB) ListBooksByPageRange(ctx context.Context, min, max int) ([]*Book, error) {
+	return db.inner.ListBooksByPageRange(ctx, min, max)
+}
+
+func (db *cachingDB) ListBooksCreatedBy(ctx context.Context, userID string) ([]*Book, error) {
+	return db.inner.ListBooksCreatedBy(ctx, userID)
+}
+
+func (db *cachingDB) ListBooksModifiedSince(ctx context.Context, since time.Time) ([]*Book, error) {
+	return db.inner.ListBooksModifiedSince(ctx, since)
+}
+
+func (db *cachingDB) ListRecentBooks(ctx context.Context, limit int) ([]*Book, error) {
+	return db.inner.ListRecentBooks(ctx, limit)
+}
+
+func (db *cachingDB) SearchBooks(ctx context.Context, query string) ([]*Book, error) {
+	return db.inner.SearchBooks(ctx, query)
+}
+
+func (db *cachingDB) SearchBooksFiltered(ctx context.Context, query string, tags []string) ([]*Book, error) {
+	return db.inner.SearchBooksFiltered(ctx, query, tags)
+}
+
+func (db *cachingDB) SuggestTitles(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return db.inner.SuggestTitles(ctx, prefix, limit)
+}
+
+func (db *cachingDB) ForEachBook(ctx context.Context, fn func(*Book) error) error {
+	return db.inner.ForEachBook(ctx, fn)
+}
+
+func (db *cachingDB) ListAuthors(ctx context.Context) ([]string, error) {
+	return db.inner.ListAuthors(ctx)
+}
+
+func (db *cachingDB) TopAuthors(ctx context.Context, limit int) ([]AuthorCount, error) {
+	return db.inner.TopAuthors(ctx, limit)
+}
+
+func (db *cachingDB) AverageRatingByAuthor(ctx context.Context) (map[string]float64, error) {
+	return db.inner.AverageRatingByAuthor(ctx)
+}
+
+func (db *cachingDB) ListTitleIndex(ctx context.Context) (map[string]int, error) {
+	return db.inner.ListTitleIndex(ctx)
+}
+
+func (db *cachingDB) CountBooks(ctx context.Context) (int64, error) {
+	return db.inner.CountBooks(ctx)
+}
+
+func (db *cachingDB) CountBooksCreatedBy(ctx context.Context, userID string) (int64, error) {
+	return db.inner.CountBooksCreatedBy(ctx, userID)
+}
+
+func (db *cachingDB) AddBook(ctx context.Context, b *Book) (int64, error) {
+	return db.inner.AddBook(ctx, b)
+}
+
+func (db *cachingDB) AddBooks(ctx context.Context, books []*Book) ([]int64, error) {
+	return db.inner.AddBooks(ctx, books)
+}
+
+func (db *cachingDB) AddBooksBestEffort(ctx context.Context, books []*Book) ([]int64, []BookError, error) {
+	return db.inner.AddBooksBestEffort(ctx, books)
+}
+
+func (db *cachingDB) DeleteBook(ctx context.Context, id int64) error {
+	defer db.evict(id)
+	return db.inner.DeleteBook(ctx, id)
+}
+
+func (db *cachingDB) DeleteBookDryRun(ctx context.Context, id int64) (*Book, error) {
+	return db.inner.DeleteBookDryRun(ctx, id)
+}
+
+func (db *cachingDB) DeleteBooks(ctx context.Context, ids []int64) (int, error) {
+	defer db.evict(ids...)
+	return db.inner.DeleteBooks(ctx, ids)
+}
+
+func (db *cachingDB) RestoreBook(ctx context.Context, id int64) error {
+	defer db.evict(id)
+	return db.inner.RestoreBook(ctx, id)
+}
+
+// PurgeDeleted needs no eviction, since deleted books are never cached.
+func (db *cachingDB) PurgeDeleted(ctx context.Context, olderThan time.Duration) (int, error) {
+	return db.inner.PurgeDeleted(ctx, olderThan)
+}
+
+func (db *cachingDB) DeleteAllBooks(ctx context.Context) (int, error) {
+	defer db.evictAll()
+	return db.inner.DeleteAllBooks(ctx)
+}
+
+func (db *cachingDB) UpdateBook(ctx context.Context, b *Book) error {
+	defer db.evict(b.ID)
+	return db.inner.UpdateBook(ctx, b)
+}
+
+func (db *cachingDB) PublishBook(ctx context.Context, id int64) error {
+	defer db.evict(id)
+	return db.inner.PublishBook(ctx, id)
+}
+
+func (db *cachingDB) SetBookTags(ctx context.Context, id int64, tags []string) error {
+	defer db.evict(id)
+	return db.inner.SetBookTags(ctx, id, tags)
+}
+
+func (db *cachingDB) UpdateBookFields(ctx context.Context, id int64, fields map[string]interface{}) error {
+	defer db.evict(id)
+	return db.inner.UpdateBookFields(ctx, id, fields)
+}
+
+// UpdateBooksWhere empties the cache, since it cannot tell which books the
+// filter matches.
+func (db *cachingDB) UpdateBooksWhere(ctx context.Context, filter, set map[string]interface{}) (int, error) {
+	defer db.evictAll()
+	return db.inner.UpdateBooksWhere(ctx, filter, set)
+}
+
+// RenameAuthor empties the cache, since it cannot tell which books are
+// written by from.
+func (db *cachingDB) RenameAuthor(ctx context.Context, from, to string) (int, error) {
+	defer db.evictAll()
+	return db.inner.RenameAuthor(ctx, from, to)
+}
+
+// AddReview needs no eviction, since reviews are not part of cached books.
+func (db *cachingDB) AddReview(ctx context.Context, bookID int64, r *Review) error {
+	return db.inner.AddReview(ctx, bookID, r)
+}
+
+func (db *cachingDB) ListReviews(ctx context.Context, bookID int64) ([]*Review, error) {
+	return db.inner.ListReviews(ctx, bookID)
+}
+
+func (db *cachingDB) ListReviewsPaged(ctx context.Context, bookID int64, limit, offset int) ([]*Review, int, error) {
+	return db.inner.ListReviewsPaged(ctx, bookID, limit, offset)
+}
+
+func (db *cachingDB) Migrate(ctx context.Context) error {
+	return db.inner.Migrate(ctx)
+}
+
+func (db *cachingDB) Reindex(ctx context.Context) error {
+	return db.inner.Reindex(ctx)
+}
+
+// WithTransaction empties the cache if fn fails, since it may hold books
+// changed by the rolled back transaction.
+func (db *cachingDB) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	err := db.inner.WithTransaction(ctx, fn)
+	if err != nil {
+		db.evictAll()
+	}
+	return err
+}
+
+func (db *cachingDB) Subscribe() (<-chan *Book, func()) {
+	return db.inner.Subscribe()
+}
+
+func (db *cachingDB) Ping(ctx context.Context) error {
+	return db.inner.Ping(ctx)
+}
+
+func (db *cachingDB) Name() string {
+	return db.inner.Name()
+}
+
+func (db *cachingDB) Close() error {
+	return db.inner.Close()
+}
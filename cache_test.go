@@ -0,0 +1,185 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+// countingDB counts the calls made to GetBook, and runs afterGet, if set,
+// after each of them has read the book.
+type countingDB struct {
+	BookDatabase
+	gets     int32
+	afterGet func()
+}
+
+func (db *countingDB) GetBook(ctx context.Context, id int64) (*Book, error) {
+	atomic.AddInt32(&db.gets, 1)
+	b, err := db.BookDatabase.GetBook(ctx, id)
+	if db.afterGet != nil {
+		db.afterGet()
+	}
+	return b, err
+}
+
+func TestCachingDB(t *testing.T) {
+	testDatabase(t, NewCachingDB(NewMemoryDB(), 10))
+}
+
+func TestCachingDBGetBook(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingDB{BookDatabase: NewMemoryDB()}
+	db := NewCachingDB(inner, 10)
+
+	id, err := db.AddBook(ctx, &Book{Title: "Dune"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		b, err := db.GetBook(ctx, id)
+		if err != nil {
+			t.Fatalf("GetBook: %v", err)
+		}
+		if b.Title != "Dune" {
+			t.Errorf("GetBook: got title %q, want %q", b.Title, "Dune")
+		}
+	}
+	if inner.gets != 1 {
+		t.Errorf("inner GetBook called %d times, want 1", inner.gets)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := db.GetBook(ctx, id+1); !errors.Is(err, ErrBookNotFound) {
+			t.Fatalf("GetBook of a missing book: got %v, want ErrBookNotFound", err)
+		}
+	}
+	if inner.gets != 3 {
+		t.Errorf("inner GetBook called %d times, want 3 since missing books are not cached", inner.gets)
+	}
+}
+
+func TestCachingDBUpdateEvicts(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingDB{BookDatabase: NewMemoryDB()}
+	db := NewCachingDB(inner, 10)
+
+	id, err := db.AddBook(ctx, &Book{Title: "Dune"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	b, err := db.GetBook(ctx, id)
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	b.Title = "Dune Messiah"
+	if err := db.UpdateBook(ctx, b); err != nil {
+		t.Fatalf("UpdateBook: %v", err)
+	}
+	if b, err = db.GetBook(ctx, id); err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if b.Title != "Dune Messiah" {
+		t.Errorf("GetBook after UpdateBook: got title %q, want %q", b.Title, "Dune Messiah")
+	}
+
+	if err := db.DeleteBook(ctx, id); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+	if _, err := db.GetBook(ctx, id); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("GetBook after DeleteBook: got %v, want ErrBookNotFound", err)
+	}
+}
+
+// TestCachingDBStaleRead checks that a GetBook that read a book before it
+// was updated does not cache the old copy after the update evicted it.
+func TestCachingDBStaleRead(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingDB{BookDatabase: NewMemoryDB()}
+	db := NewCachingDB(inner, 10)
+
+	id, err := db.AddBook(ctx, &Book{Title: "Dune"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+
+	read, updated := make(chan struct{}), make(chan struct{})
+	inner.afterGet = func() {
+		close(read)
+		<-updated
+	}
+	done := make(chan error)
+	go func() {
+		_, err := db.GetBook(ctx, id)
+		done <- err
+	}()
+
+	<-read
+	inner.afterGet = nil
+	err = db.UpdateBookFields(ctx, id, map[string]interface{}{"title": "Dune Messiah"})
+	close(updated)
+	if err != nil {
+		t.Fatalf("UpdateBookFields: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+
+	b, err := db.GetBook(ctx, id)
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if b.Title != "Dune Messiah" {
+		t.Errorf("GetBook after the update: got title %q, want %q", b.Title, "Dune Messiah")
+	}
+}
+
+func TestCachingDBDeleteBooksEvicts(t *testing.T) {
+	ctx := context.Background()
+	db := NewCachingDB(NewMemoryDB(), 10)
+
+	id, err := db.AddBook(ctx, &Book{Title: "Dune"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	if _, err := db.GetBook(ctx, id); err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if n, err := db.DeleteBooks(ctx, []int64{id}); err != nil || n != 1 {
+		t.Fatalf("DeleteBooks = %d, %v; want 1, nil", n, err)
+	}
+	if _, err := db.GetBook(ctx, id); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("GetBook after DeleteBooks: got %v, want ErrBookNotFound", err)
+	}
+}
+
+func TestCachingDBSize(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingDB{BookDatabase: NewMemoryDB()}
+	db := NewCachingDB(inner, 2)
+
+	var ids []int64
+	for _, title := range []string{"a", "b", "c"} {
+		id, err := db.AddBook(ctx, &Book{Title: title})
+		if err != nil {
+			t.Fatalf("AddBook: %v", err)
+		}
+		ids = append(ids, id)
+		if _, err := db.GetBook(ctx, id); err != nil {
+			t.Fatalf("GetBook: %v", err)
+		}
+	}
+	// The first book is the least recently used, so it was evicted to make
+	// room for the third.
+	if _, err := db.GetBook(ctx, ids[0]); err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if inner.gets != 4 {
+		t.Errorf("inner GetBook called %d times, want 4", inner.gets)
+	}
+}
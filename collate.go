@@ -0,0 +1,47 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"sync"
+
+	"github.com/globalsign/mgo"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// titleCollator orders titles the way readers expect, ignoring case and
+// accents, rather than by their bytes, which puts "Zebra" before "apple".
+// Collators are not safe for concurrent use, hence the mutex.
+var titleCollator = struct {
+	sync.Mutex
+	c   *collate.Collator
+	buf collate.Buffer
+}{c: collate.New(language.English, collate.Loose)}
+
+// titleCollation is the MongoDB equivalent of titleCollator.
+var titleCollation = &mgo.Collation{Locale: "en", Strength: 1}
+
+// compareTitles returns -1, 0 or 1 as title a sorts before, with or after
+// title b.
+func compareTitles(a, b string) int {
+	titleCollator.Lock()
+	defer titleCollator.Unlock()
+
+	return titleCollator.c.CompareString(a, b)
+}
+
+// titleKey returns the sort key of title: comparing the keys of two titles
+// byte by byte orders them like compareTitles does. SQL backends store it
+// next to the title to sort on.
+func titleKey(title string) []byte {
+	titleCollator.Lock()
+	defer titleCollator.Unlock()
+
+	key := titleCollator.c.KeyFromString(&titleCollator.buf, title)
+	key = append([]byte{}, key...)
+	titleCollator.buf.Reset()
+	return key
+}
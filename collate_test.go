@@ -0,0 +1,31 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompareTitles(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"apple", "Zebra", -1},
+		{"Éclair", "Zebra", -1},
+		{"eclair", "Éclair", 0},
+		{"DUNE", "dune", 0},
+		{"Emma", "Dune", 1},
+	}
+	for _, tt := range tests {
+		if got := compareTitles(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareTitles(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := bytes.Compare(titleKey(tt.a), titleKey(tt.b)); got != tt.want {
+			t.Errorf("comparing the keys of %q and %q = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CoverStore stores book cover images.
+type CoverStore interface {
+	// Save stores the cover image of the book with the given ID, read from r,
+	// replacing any previous one. It returns the URL the image can be
+	// fetched from.
+	Save(ctx context.Context, id int64, r io.Reader, contentType string) (url string, err error)
+}
+
+// fileCoverStore keeps cover images as files in a local directory.
+type fileCoverStore struct {
+	dir     string
+	baseURL string
+}
+
+// Ensure fileCoverStore conforms to the CoverStore interface.
+var _ CoverStore = &fileCoverStore{}
+
+// NewFileCoverStore creates a CoverStore that writes images to dir, creating
+// it if needed. The returned URLs are the image file names appended to
+// baseURL, under which dir is expected to be served.
+func NewFileCoverStore(dir, baseURL string) (CoverStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("covers: could not create directory: %v", err)
+	}
+	return &fileCoverStore{
+		dir:     dir,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}, nil
+}
+
+// Save stores the cover image of the book with the given ID.
+func (s *fileCoverStore) Save(ctx context.Context, id int64, r io.Reader, contentType string) (string, error) {
+	name := fmt.Sprintf("%d%s", id, imageExtension(contentType))
+
+	// Write to a temporary file first so a failed upload never clobbers the
+	// current cover.
+	f, err := ioutil.TempFile(s.dir, ".upload-")
+	if err != nil {
+		return "", fmt.Errorf("covers: could not create file: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return "", fmt.Errorf("covers: could not write file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("covers: could not write file: %v", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.Name(), filepath.Join(s.dir, name)); err != nil {
+		return "", fmt.Errorf("covers: could not save file: %v", err)
+	}
+	return s.baseURL + "/" + name, nil
+}
+
+// imageExtensions are the file extensions used for common image types, which
+// mime.ExtensionsByType does not always rank first.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// imageExtension returns the file extension for an image of contentType, or
+// an empty string if it is unknown.
+func imageExtension(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if ext, ok := imageExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
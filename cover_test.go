@@ -0,0 +1,65 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileCoverStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "covers")
+	s, err := NewFileCoverStore(dir, "/covers/")
+	if err != nil {
+		t.Fatalf("NewFileCoverStore: %v", err)
+	}
+
+	for _, data := range []string{"first", "second"} {
+		url, err := s.Save(context.Background(), 42, strings.NewReader(data), "image/png")
+		if err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		if url != "/covers/42.png" {
+			t.Errorf("Save returned URL %q, want /covers/42.png", url)
+		}
+		got, err := ioutil.ReadFile(filepath.Join(dir, "42.png"))
+		if err != nil || string(got) != data {
+			t.Errorf("cover file holds %q, %v; want %q", got, err, data)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.Save(ctx, 42, strings.NewReader("third"), "image/png"); err != context.Canceled {
+		t.Errorf("Save with a canceled context: got %v, want %v", err, context.Canceled)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("cover directory holds %d files, want only 42.png", len(files))
+	}
+	if got, _ := ioutil.ReadFile(filepath.Join(dir, "42.png")); string(got) != "second" {
+		t.Errorf("failed upload replaced the cover with %q", got)
+	}
+}
+
+func TestImageExtension(t *testing.T) {
+	for contentType, want := range map[string]string{
+		"image/jpeg":               ".jpg",
+		"image/png; charset=utf-8": ".png",
+		"image/webp":               ".webp",
+		"image/x-unknown-format":   "",
+		"not a media type;;":       "",
+	} {
+		if got := imageExtension(contentType); got != want {
+			t.Errorf("imageExtension(%q) = %q, want %q", contentType, got, want)
+		}
+	}
+}
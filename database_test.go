@@ -0,0 +1,1741 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// databaseTests are run by testDatabase. Each removes the books it adds, so
+// they all start from the books the database held beforehand.
+var databaseTests = []struct {
+	name string
+	test func(t *testing.T, db BookDatabase)
+}{
+	{"AddAndGet", testAddAndGet},
+	{"GetMissing", testGetMissing},
+	{"Update", testUpdate},
+	{"DeleteAndRestore", testDeleteAndRestore},
+	{"Purge", testPurge},
+	{"AddBooks", testAddBooks},
+	{"ForEachBook", testForEachBook},
+	{"ListOrder", testListOrder},
+	{"Search", testSearch},
+	{"Sorted", testListSorted},
+	{"CreatedBy", testCreatedBy},
+	{"Ratings", testRatings},
+	{"Tags", testTags},
+	{"Year", testListByYear},
+	{"UpdateFields", testUpdateFields},
+	{"ListAfter", testListAfter},
+	{"Count", testCount},
+	{"DeleteBooks", testDeleteBooks},
+	{"ModifiedSince", testModifiedSince},
+	{"Language", testLanguage},
+	{"SuggestTitles", testSuggestTitles},
+	{"Authors", testAuthors},
+	{"Reviews", testReviews},
+	{"GetBooks", testGetBooks},
+	{"Genre", testGenre},
+	{"ReviewsPaged", testReviewsPaged},
+	{"PageRange", testPageRange},
+	{"UpdateBooksWhere", testUpdateBooksWhere},
+	{"BookExists", testBookExists},
+	{"Metadata", testMetadata},
+	{"Subscribe", testSubscribe},
+	{"TitleIndex", testTitleIndex},
+	{"Transaction", testTransaction},
+	{"ConcurrentAdd", testConcurrentAdd},
+	{"TopAuthors", testTopAuthors},
+	{"CollatedTitles", testCollatedTitles},
+	{"MultipleTags", testMultipleTags},
+	{"Status", testStatus},
+	{"AddBooksBestEffort", testAddBooksBestEffort},
+	{"RandomBook", testRandomBook},
+	{"SetBookTags", testSetBookTags},
+	{"Reindex", testReindex},
+	{"SearchFiltered", testSearchFiltered},
+	{"DeleteAllBooks", testDeleteAllBooks},
+	{"BetweenDates", testListBetweenDates},
+	{"RenameAuthor", testRenameAuthor},
+	{"DraftsHidden", testDraftsHidden},
+}
+
+// testDatabase runs the tests every BookDatabase implementation must pass
+// against db, which should hold no books.
+func testDatabase(t *testing.T, db BookDatabase) {
+	for _, tt := range databaseTests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, db)
+		})
+	}
+}
+
+// mustAdd adds b to db, failing the test if it cannot, and returns its ID.
+// The book is deleted and purged when the test ends.
+func mustAdd(t *testing.T, db BookDatabase, b *Book) int64 {
+	t.Helper()
+	id, err := db.AddBook(context.Background(), b)
+	if err != nil {
+		t.Fatalf("AddBook(%q): %v", b.Title, err)
+	}
+	t.Cleanup(func() {
+		db.DeleteBook(context.Background(), id)
+		db.PurgeDeleted(context.Background(), 0)
+	})
+	return id
+}
+
+// titles returns the titles of books, in order.
+func titles(books []*Book) []string {
+	ts := []string{}
+	for _, b := range books {
+		ts = append(ts, b.Title)
+	}
+	return ts
+}
+
+func testAddAndGet(t *testing.T, db BookDatabase) {
+	id := mustAdd(t, db, &Book{Title: "Dune", Author: "Frank Herbert", ISBN: "978-0-441-17271-9", Tags: []string{"scifi"}})
+
+	b, err := db.GetBook(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if b.CreatedAt.IsZero() || !b.UpdatedAt.Equal(b.CreatedAt) {
+		t.Errorf("GetBook: CreatedAt = %v, UpdatedAt = %v; want both set to the same time", b.CreatedAt, b.UpdatedAt)
+	}
+	want := &Book{ID: id, Title: "Dune", Author: "Frank Herbert", ISBN: "978-0-441-17271-9", Tags: []string{"scifi"}, Status: StatusPublished,
+		Version: 1, CreatedAt: b.CreatedAt, UpdatedAt: b.UpdatedAt}
+	if !reflect.DeepEqual(b, want) {
+		t.Errorf("GetBook = %+v, want %+v", b, want)
+	}
+
+	if _, err := db.AddBook(context.Background(), &Book{Title: "Dune", ISBN: "12345"}); !errors.Is(err, ErrInvalidISBN) {
+		t.Errorf("AddBook with a bad ISBN: got %v, want ErrInvalidISBN", err)
+	}
+}
+
+func testGetMissing(t *testing.T, db BookDatabase) {
+	id := mustAdd(t, db, &Book{Title: "Dune"})
+
+	if _, err := db.GetBook(context.Background(), id+1000); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("GetBook of a missing book: got %v, want ErrBookNotFound", err)
+	}
+}
+
+func testUpdate(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	id := mustAdd(t, db, &Book{Title: "Dune"})
+
+	update := &Book{ID: id, Title: "Dune Messiah", Version: 1}
+	if err := db.UpdateBook(ctx, update); err != nil {
+		t.Fatalf("UpdateBook: %v", err)
+	}
+	if update.Version != 2 {
+		t.Errorf("UpdateBook left the version at %d, want 2", update.Version)
+	}
+	b, err := db.GetBook(ctx, id)
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if b.Title != "Dune Messiah" || b.Version != 2 {
+		t.Errorf("GetBook after UpdateBook = %q version %d, want %q version 2", b.Title, b.Version, "Dune Messiah")
+	}
+
+	// An update based on the version read before the first one must not
+	// overwrite it.
+	if err := db.UpdateBook(ctx, &Book{ID: id, Title: "Children of Dune", Version: 1}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("UpdateBook of a stale version: got %v, want ErrVersionConflict", err)
+	}
+	if b, err := db.GetBook(ctx, id); err != nil || b.Title != "Dune Messiah" || b.Version != 2 {
+		t.Errorf("after a conflicting update, GetBook = %+v, %v; want it unchanged", b, err)
+	}
+
+	if err := db.UpdateBook(ctx, &Book{ID: id + 1000, Title: "Missing"}); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("UpdateBook of a missing book: got %v, want ErrBookNotFound", err)
+	}
+}
+
+func testAddBooks(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	books := []*Book{{Title: "Dune"}, {Title: "Emma"}, {Title: "Neuromancer"}}
+	ids, err := db.AddBooks(ctx, books)
+	if err != nil {
+		t.Fatalf("AddBooks: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, id := range ids {
+			db.DeleteBook(ctx, id)
+		}
+		db.PurgeDeleted(ctx, 0)
+	})
+	if len(ids) != len(books) {
+		t.Fatalf("AddBooks returned %d IDs, want %d", len(ids), len(books))
+	}
+	for i, id := range ids {
+		if books[i].ID != id {
+			t.Errorf("AddBooks: book %d has ID %d, want the returned %d", i, books[i].ID, id)
+		}
+		b, err := db.GetBook(ctx, id)
+		if err != nil {
+			t.Fatalf("GetBook: %v", err)
+		}
+		if b.Title != books[i].Title {
+			t.Errorf("GetBook(%d): got title %q, want %q", id, b.Title, books[i].Title)
+		}
+	}
+
+	bad := []*Book{{Title: "Middlemarch"}, {Title: "Persuasion", ISBN: "12345"}}
+	if _, err := db.AddBooks(ctx, bad); !errors.Is(err, ErrInvalidISBN) {
+		t.Errorf("AddBooks with a bad ISBN: got %v, want ErrInvalidISBN", err)
+	}
+	if all, err := db.ListBooks(ctx); err != nil || len(all) != len(books) {
+		t.Errorf("ListBooks after a rejected AddBooks = %q, %v; want the %d books added before", titles(all), err, len(books))
+	}
+}
+
+func testDeleteAndRestore(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	id := mustAdd(t, db, &Book{Title: "Dune"})
+
+	if err := db.DeleteBook(ctx, id); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+	if _, err := db.GetBook(ctx, id); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("GetBook of a deleted book: got %v, want ErrBookNotFound", err)
+	}
+	if err := db.DeleteBook(ctx, id); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("DeleteBook of a deleted book: got %v, want ErrBookNotFound", err)
+	}
+	if err := db.UpdateBook(ctx, &Book{ID: id, Title: "Dune Messiah", Version: 1}); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("UpdateBook of a deleted book: got %v, want ErrBookNotFound", err)
+	}
+	if books, err := db.ListBooks(ctx); err != nil || len(books) != 0 {
+		t.Errorf("ListBooks after DeleteBook = %q, %v; want no books", titles(books), err)
+	}
+
+	if err := db.RestoreBook(ctx, id); err != nil {
+		t.Fatalf("RestoreBook: %v", err)
+	}
+	if b, err := db.GetBook(ctx, id); err != nil || b.Title != "Dune" {
+		t.Errorf("GetBook of a restored book = %v, %v; want Dune", b, err)
+	}
+	if err := db.RestoreBook(ctx, id); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("RestoreBook of a book that is not deleted: got %v, want ErrBookNotFound", err)
+	}
+}
+
+func testPurge(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	deleted := mustAdd(t, db, &Book{Title: "Dune"})
+	kept := mustAdd(t, db, &Book{Title: "Emma"})
+	if err := db.DeleteBook(ctx, deleted); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+
+	if n, err := db.PurgeDeleted(ctx, time.Hour); err != nil || n != 0 {
+		t.Errorf("PurgeDeleted of books deleted over an hour ago = %d, %v; want 0, nil", n, err)
+	}
+	if n, err := db.PurgeDeleted(ctx, 0); err != nil || n != 1 {
+		t.Errorf("PurgeDeleted = %d, %v; want 1, nil", n, err)
+	}
+	if err := db.RestoreBook(ctx, deleted); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("RestoreBook of a purged book: got %v, want ErrBookNotFound", err)
+	}
+	if _, err := db.GetBook(ctx, kept); err != nil {
+		t.Errorf("GetBook of a book that was not deleted: %v", err)
+	}
+}
+
+func testListOrder(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	for _, title := range []string{"Persuasion", "Dune", "Emma", "Middlemarch"} {
+		mustAdd(t, db, &Book{Title: title})
+	}
+	want := []string{"Dune", "Emma", "Middlemarch", "Persuasion"}
+
+	books, err := db.ListBooks(ctx)
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	if got := titles(books); !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooks = %q, want %q", got, want)
+	}
+
+	page, total, err := db.ListBooksPaged(ctx, 2, 1)
+	if err != nil {
+		t.Fatalf("ListBooksPaged: %v", err)
+	}
+	if got := titles(page); total != 4 || !reflect.DeepEqual(got, want[1:3]) {
+		t.Errorf("ListBooksPaged(2, 1) = %q, %d; want %q, 4", got, total, want[1:3])
+	}
+	if page, _, err = db.ListBooksPaged(ctx, 2, 10); err != nil || len(page) != 0 {
+		t.Errorf("ListBooksPaged past the end = %q, %v; want no books", titles(page), err)
+	}
+
+	if books, err = db.ListBooksCreatedBy(ctx, "nobody"); err != nil || len(books) != 0 {
+		t.Errorf("ListBooksCreatedBy(nobody) = %q, %v; want no books", titles(books), err)
+	}
+}
+
+func testSearch(t *testing.T, db BookDatabase) {
+	mustAdd(t, db, &Book{Title: "Dune", Author: "Frank Herbert", Description: "A desert planet."})
+	mustAdd(t, db, &Book{Title: "Emma", Author: "Jane Austen", Description: "A novel of manners."})
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"dune", []string{"Dune"}},
+		{"Austen", []string{"Emma"}},
+		{"desert", []string{"Dune"}},
+		{"tolstoy", []string{}},
+	}
+	for _, tt := range tests {
+		books, err := db.SearchBooks(context.Background(), tt.query)
+		if err != nil {
+			t.Fatalf("SearchBooks: %v", err)
+		}
+		if got := titles(books); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SearchBooks(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func testListSorted(t *testing.T, db BookDatabase) {
+	mustAdd(t, db, &Book{Title: "Dune", Author: "Frank Herbert", PublishedDate: "1965"})
+	mustAdd(t, db, &Book{Title: "Emma", Author: "Jane Austen", PublishedDate: "1815"})
+	mustAdd(t, db, &Book{Title: "Neuromancer", Author: "William Gibson", PublishedDate: "1984"})
+
+	tests := []struct {
+		field      string
+		descending bool
+		want       []string
+	}{
+		{"title", false, []string{"Dune", "Emma", "Neuromancer"}},
+		{"author", false, []string{"Dune", "Emma", "Neuromancer"}},
+		{"author", true, []string{"Neuromancer", "Emma", "Dune"}},
+		{"published_date", false, []string{"Emma", "Dune", "Neuromancer"}},
+		{"published_date", true, []string{"Neuromancer", "Dune", "Emma"}},
+	}
+	for _, tt := range tests {
+		books, err := db.ListBooksSorted(context.Background(), tt.field, tt.descending)
+		if err != nil {
+			t.Fatalf("ListBooksSorted: %v", err)
+		}
+		if got := titles(books); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ListBooksSorted(%q, %v) = %q, want %q", tt.field, tt.descending, got, tt.want)
+		}
+	}
+
+	if _, err := db.ListBooksSorted(context.Background(), "isbn; DROP TABLE books", false); !errors.Is(err, ErrInvalidSortField) {
+		t.Errorf("ListBooksSorted by an unknown field: got %v, want ErrInvalidSortField", err)
+	}
+}
+
+func testCreatedBy(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	id := mustAdd(t, db, &Book{Title: "Emma", CreatedByID: "alice", CreatedBy: "Alice"})
+	mustAdd(t, db, &Book{Title: "Dune", CreatedByID: "alice", CreatedBy: "Alice"})
+	mustAdd(t, db, &Book{Title: "Neuromancer", CreatedByID: "bob", CreatedBy: "Bob"})
+
+	books, err := db.ListBooksCreatedBy(ctx, "alice")
+	if err != nil {
+		t.Fatalf("ListBooksCreatedBy: %v", err)
+	}
+	if got, want := titles(books), []string{"Dune", "Emma"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooksCreatedBy(alice) = %q, want %q", got, want)
+	}
+
+	// Updates leave the creator alone, whatever the book says.
+	if err := db.UpdateBook(ctx, &Book{ID: id, Title: "Emma", CreatedByID: "bob", CreatedBy: "Bob", Version: 1}); err != nil {
+		t.Fatalf("UpdateBook: %v", err)
+	}
+	b, err := db.GetBook(ctx, id)
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if b.CreatedByID != "alice" || b.CreatedBy != "Alice" {
+		t.Errorf("GetBook after UpdateBook: got creator %q (%q), want alice (Alice)", b.CreatedByID, b.CreatedBy)
+	}
+}
+
+func testForEachBook(t *testing.T, db BookDatabase) {
+	for _, title := range []string{"Persuasion", "Dune", "Emma"} {
+		mustAdd(t, db, &Book{Title: title})
+	}
+
+	var got []string
+	err := db.ForEachBook(context.Background(), func(b *Book) error {
+		got = append(got, b.Title)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachBook: %v", err)
+	}
+	if want := []string{"Dune", "Emma", "Persuasion"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ForEachBook visited %q, want %q", got, want)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = db.ForEachBook(context.Background(), func(*Book) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("ForEachBook with fn failing = %v after %d calls, want %v after 1", err, n, stop)
+	}
+}
+
+func TestMemoryDB(t *testing.T) {
+	testDatabase(t, NewMemoryDB())
+}
+
+func TestMemoryDBClose(t *testing.T) {
+	db := NewMemoryDB()
+	for i := 0; i < 2; i++ {
+		if err := db.Close(); err != nil {
+			t.Errorf("Close #%d: %v", i+1, err)
+		}
+	}
+	if err := db.Ping(context.Background()); err == nil {
+		t.Errorf("Ping after Close: got nil error")
+	}
+	if _, err := db.AddBook(context.Background(), &Book{Title: "Dune"}); err == nil {
+		t.Errorf("AddBook after Close: got nil error")
+	}
+	if _, err := db.AddBooks(context.Background(), []*Book{{Title: "Dune"}}); err == nil {
+		t.Errorf("AddBooks after Close: got nil error")
+	}
+	if _, err := db.DeleteAllBooks(context.Background()); err == nil {
+		t.Errorf("DeleteAllBooks after Close: got nil error")
+	}
+	if _, err := db.GetBook(context.Background(), 1); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("GetBook after Close: got %v, want ErrBookNotFound", err)
+	}
+}
+
+// TestMongoDB runs the shared tests against the Mongo server at MONGO_URL,
+// whose books collection should be empty.
+func TestMongoDB(t *testing.T) {
+	addr := os.Getenv("MONGO_URL")
+	if addr == "" {
+		t.Skip("MONGO_URL is not set")
+	}
+	// A collection of its own keeps the run away from any real books.
+	db, err := NewMongoDBNamed(addr, "bookshelf_test", fmt.Sprintf("books_%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatalf("NewMongoDBNamed: %v", err)
+	}
+	t.Cleanup(func() {
+		db.(*mongoDB).c.DropCollection()
+		db.Close()
+	})
+	if err := db.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	testDatabase(t, db)
+}
+
+func testRatings(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	mustAdd(t, db, &Book{Title: "Emma", Author: "Jane Austen", Rating: 4})
+	mustAdd(t, db, &Book{Title: "Persuasion", Author: "Jane Austen", Rating: 5})
+	mustAdd(t, db, &Book{Title: "Dune", Author: "Frank Herbert", Rating: 3.5})
+	gone := mustAdd(t, db, &Book{Title: "Children of Dune", Author: "Frank Herbert", Rating: 0.5})
+	if err := db.DeleteBook(ctx, gone); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+
+	got, err := db.AverageRatingByAuthor(ctx)
+	if err != nil {
+		t.Fatalf("AverageRatingByAuthor: %v", err)
+	}
+	want := map[string]float64{"Jane Austen": 4.5, "Frank Herbert": 3.5}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AverageRatingByAuthor = %v, want %v", got, want)
+	}
+
+	if _, err := db.AddBook(ctx, &Book{Title: "Dune", Rating: 5.5}); !errors.Is(err, ErrInvalidRating) {
+		t.Errorf("AddBook with rating 5.5: got %v, want %v", err, ErrInvalidRating)
+	}
+}
+
+func testTags(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	mustAdd(t, db, &Book{Title: "Emma", Tags: []string{"classics"}})
+	mustAdd(t, db, &Book{Title: "Dune", Tags: []string{"scifi", "classics"}})
+	mustAdd(t, db, &Book{Title: "Neuromancer", Tags: []string{"scifi"}})
+	mustAdd(t, db, &Book{Title: "Untagged"})
+
+	for _, tt := range []struct {
+		tag  string
+		want []string
+	}{
+		{"classics", []string{"Dune", "Emma"}},
+		{"scifi", []string{"Dune", "Neuromancer"}},
+		{"sci", []string{}},
+	} {
+		books, err := db.ListBooksByTag(ctx, tt.tag)
+		if err != nil {
+			t.Fatalf("ListBooksByTag(%q): %v", tt.tag, err)
+		}
+		if got := titles(books); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ListBooksByTag(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func testListByYear(t *testing.T, db BookDatabase) {
+	mustAdd(t, db, &Book{Title: "Dune", PublishedDate: "1965-08-01"})
+	mustAdd(t, db, &Book{Title: "Dune Messiah", PublishedDate: "1969"})
+	mustAdd(t, db, &Book{Title: "The Moon Is a Harsh Mistress", PublishedDate: "1965"})
+	mustAdd(t, db, &Book{Title: "Stand on Zanzibar", PublishedDate: "1965-13"})
+	mustAdd(t, db, &Book{Title: "Undated"})
+
+	books, err := db.ListBooksByYear(context.Background(), 1965)
+	if err != nil {
+		t.Fatalf("ListBooksByYear: %v", err)
+	}
+	if got, want := titles(books), []string{"Dune", "The Moon Is a Harsh Mistress"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooksByYear(1965) = %q, want %q", got, want)
+	}
+}
+
+func testUpdateFields(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	orig := &Book{
+		Title:         "Dune",
+		Author:        "Frank Herbert",
+		PublishedDate: "1965",
+		Description:   "Spice.",
+		ISBN:          "978-0-441-17271-9",
+		Rating:        4.5,
+		Tags:          []string{"scifi"},
+	}
+	id := mustAdd(t, db, orig)
+
+	err := db.UpdateBookFields(ctx, id, map[string]interface{}{"title": "Dune (Deluxe Edition)"})
+	if err != nil {
+		t.Fatalf("UpdateBookFields: %v", err)
+	}
+	got, err := db.GetBook(ctx, id)
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	want := copyBook(orig)
+	want.ID, want.Title, want.Version = id, "Dune (Deluxe Edition)", 2
+	want.CreatedAt, want.UpdatedAt = got.CreatedAt, got.UpdatedAt
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after patching the title, GetBook = %+v, want %+v", got, want)
+	}
+
+	for _, fields := range []map[string]interface{}{
+		{"id": 7},
+		{"created_by": "mallory"},
+		{"rating": "high"},
+		{"title": ""},
+		{"title": "Dune", "isbn": "12345"},
+	} {
+		var verr ValidationError
+		if err := db.UpdateBookFields(ctx, id, fields); !errors.As(err, &verr) {
+			t.Errorf("UpdateBookFields(%v): got %v, want a ValidationError", fields, err)
+		}
+	}
+	if got, err := db.GetBook(ctx, id); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("after rejected patches, GetBook = %+v, %v; want %+v", got, err, want)
+	}
+
+	if err := db.UpdateBookFields(ctx, id+1000, map[string]interface{}{"title": "Emma"}); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("UpdateBookFields of a missing book: got %v, want ErrBookNotFound", err)
+	}
+}
+
+func testListAfter(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	want := make(map[int64]bool)
+	for _, title := range []string{"Dune", "Emma", "Middlemarch", "Persuasion", "Ulysses"} {
+		want[mustAdd(t, db, &Book{Title: title})] = true
+	}
+	gone := mustAdd(t, db, &Book{Title: "Deleted"})
+	if err := db.DeleteBook(ctx, gone); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+
+	seen := make(map[int64]bool)
+	var after int64
+	for pages := 0; ; pages++ {
+		if pages > len(want) {
+			t.Fatalf("ListBooksAfter did not run out of books")
+		}
+		books, err := db.ListBooksAfter(ctx, after, 2)
+		if err != nil {
+			t.Fatalf("ListBooksAfter(%d, 2): %v", after, err)
+		}
+		if len(books) > 2 {
+			t.Fatalf("ListBooksAfter(%d, 2) returned %d books", after, len(books))
+		}
+		if len(books) == 0 {
+			break
+		}
+		for _, b := range books {
+			if b.ID <= after || seen[b.ID] {
+				t.Errorf("ListBooksAfter(%d, 2) returned book %d out of order", after, b.ID)
+			}
+			seen[b.ID] = true
+			after = b.ID
+		}
+	}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("walking ListBooksAfter saw IDs %v, want %v", seen, want)
+	}
+}
+
+func testCount(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	mustAdd(t, db, &Book{Title: "Dune", CreatedByID: "alice"})
+	mustAdd(t, db, &Book{Title: "Emma", CreatedByID: "alice"})
+	mustAdd(t, db, &Book{Title: "Persuasion", CreatedByID: "bob"})
+	gone := mustAdd(t, db, &Book{Title: "Middlemarch", CreatedByID: "alice"})
+	if err := db.DeleteBook(ctx, gone); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+
+	if n, err := db.CountBooks(ctx); err != nil || n != 3 {
+		t.Errorf("CountBooks = %d, %v; want 3, nil", n, err)
+	}
+	for user, want := range map[string]int64{"alice": 2, "bob": 1, "nobody": 0} {
+		if n, err := db.CountBooksCreatedBy(ctx, user); err != nil || n != want {
+			t.Errorf("CountBooksCreatedBy(%q) = %d, %v; want %d, nil", user, n, err, want)
+		}
+	}
+}
+
+func testDeleteBooks(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	dune := mustAdd(t, db, &Book{Title: "Dune"})
+	emma := mustAdd(t, db, &Book{Title: "Emma"})
+	mustAdd(t, db, &Book{Title: "Persuasion"})
+	gone := mustAdd(t, db, &Book{Title: "Middlemarch"})
+	if err := db.DeleteBook(ctx, gone); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+
+	// Missing and already deleted books are not counted.
+	n, err := db.DeleteBooks(ctx, []int64{dune, emma, gone, emma + 1000})
+	if err != nil || n != 2 {
+		t.Errorf("DeleteBooks = %d, %v; want 2, nil", n, err)
+	}
+	if n, err := db.DeleteBooks(ctx, nil); err != nil || n != 0 {
+		t.Errorf("DeleteBooks of no IDs = %d, %v; want 0, nil", n, err)
+	}
+
+	books, err := db.ListBooks(ctx)
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	if got, want := titles(books), []string{"Persuasion"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooks after DeleteBooks = %q, want %q", got, want)
+	}
+	if err := db.RestoreBook(ctx, dune); err != nil {
+		t.Errorf("RestoreBook of a book deleted by DeleteBooks: %v", err)
+	}
+}
+
+func testModifiedSince(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	mustAdd(t, db, &Book{Title: "Dune"})
+	emmaID := mustAdd(t, db, &Book{Title: "Emma"})
+	middlemarchID := mustAdd(t, db, &Book{Title: "Middlemarch"})
+
+	// Leave a gap so that since separates the books added above from the
+	// changes below, whatever the precision of the stored timestamps.
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	emma, err := db.GetBook(ctx, emmaID)
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	emma.Author = "Jane Austen"
+	if err := db.UpdateBook(ctx, emma); err != nil {
+		t.Fatalf("UpdateBook: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	mustAdd(t, db, &Book{Title: "Persuasion"})
+	time.Sleep(10 * time.Millisecond)
+	if err := db.UpdateBookFields(ctx, middlemarchID, map[string]interface{}{"author": "George Eliot"}); err != nil {
+		t.Fatalf("UpdateBookFields: %v", err)
+	}
+	ulyssesID := mustAdd(t, db, &Book{Title: "Ulysses"})
+	if err := db.DeleteBook(ctx, ulyssesID); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+
+	books, err := db.ListBooksModifiedSince(ctx, since)
+	if err != nil {
+		t.Fatalf("ListBooksModifiedSince: %v", err)
+	}
+	if got, want := titles(books), []string{"Emma", "Persuasion", "Middlemarch"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooksModifiedSince = %q, want %q", got, want)
+	}
+	for _, b := range books {
+		if b.CreatedAt.IsZero() || b.UpdatedAt.Before(since) {
+			t.Errorf("%q: CreatedAt = %v, UpdatedAt = %v; want UpdatedAt at or after %v", b.Title, b.CreatedAt, b.UpdatedAt, since)
+		}
+	}
+
+	got, err := db.GetBook(ctx, emmaID)
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if !got.CreatedAt.Equal(emma.CreatedAt) || !got.CreatedAt.Before(since) {
+		t.Errorf("after UpdateBook, CreatedAt = %v, want it unchanged at %v", got.CreatedAt, emma.CreatedAt)
+	}
+
+	if books, err := db.ListBooksModifiedSince(ctx, time.Now().Add(time.Hour)); err != nil || len(books) != 0 {
+		t.Errorf("ListBooksModifiedSince(an hour from now) = %q, %v; want no books", titles(books), err)
+	}
+}
+
+func testLanguage(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	mustAdd(t, db, &Book{Title: "Persuasion", Language: "en"})
+	mustAdd(t, db, &Book{Title: "Madame Bovary", Language: "fr"})
+	mustAdd(t, db, &Book{Title: "Emma", Language: "en"})
+	mustAdd(t, db, &Book{Title: "Unknown"})
+	id := mustAdd(t, db, &Book{Title: "Candide"})
+	if err := db.UpdateBookFields(ctx, id, map[string]interface{}{"language": "fr"}); err != nil {
+		t.Fatalf("UpdateBookFields: %v", err)
+	}
+	if err := db.UpdateBookFields(ctx, id, map[string]interface{}{"language": "French"}); err == nil {
+		t.Errorf("UpdateBookFields with language French: got nil, want a ValidationError")
+	}
+
+	for _, tt := range []struct {
+		lang string
+		want []string
+	}{
+		{"en", []string{"Emma", "Persuasion"}},
+		{"fr", []string{"Candide", "Madame Bovary"}},
+		{"de", []string{}},
+	} {
+		books, err := db.ListBooksByLanguage(ctx, tt.lang)
+		if err != nil {
+			t.Fatalf("ListBooksByLanguage(%q): %v", tt.lang, err)
+		}
+		if got := titles(books); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ListBooksByLanguage(%q) = %q, want %q", tt.lang, got, tt.want)
+		}
+	}
+}
+
+func testSuggestTitles(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	for _, title := range []string{"Dune", "Dune Messiah", "Dune", "Children of Dune", "Dubliners", "D% Off"} {
+		mustAdd(t, db, &Book{Title: title})
+	}
+	gone := mustAdd(t, db, &Book{Title: "Dune Encyclopedia"})
+	if err := db.DeleteBook(ctx, gone); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+
+	for _, tt := range []struct {
+		prefix string
+		limit  int
+		want   []string
+	}{
+		{"dun", 0, []string{"Dune", "Dune Messiah"}},
+		{"Du", 0, []string{"Dubliners", "Dune", "Dune Messiah"}},
+		{"Du", 2, []string{"Dubliners", "Dune"}},
+		{"D%", 0, []string{"D% Off"}},
+		{"Messiah", 0, []string{}},
+	} {
+		got, err := db.SuggestTitles(ctx, tt.prefix, tt.limit)
+		if err != nil {
+			t.Fatalf("SuggestTitles(%q, %d): %v", tt.prefix, tt.limit, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SuggestTitles(%q, %d) = %q, want %q", tt.prefix, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func testAuthors(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	mustAdd(t, db, &Book{Title: "Persuasion", Author: "Jane Austen"})
+	mustAdd(t, db, &Book{Title: "Dune", Author: "Frank Herbert"})
+	mustAdd(t, db, &Book{Title: "Emma", Author: "Jane Austen"})
+	mustAdd(t, db, &Book{Title: "Beowulf"})
+	gone := mustAdd(t, db, &Book{Title: "Middlemarch", Author: "George Eliot"})
+	if err := db.DeleteBook(ctx, gone); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+
+	authors, err := db.ListAuthors(ctx)
+	if err != nil {
+		t.Fatalf("ListAuthors: %v", err)
+	}
+	if want := []string{"Frank Herbert", "Jane Austen"}; !reflect.DeepEqual(authors, want) {
+		t.Errorf("ListAuthors = %q, want %q", authors, want)
+	}
+
+	for _, tt := range []struct {
+		author string
+		want   []string
+	}{
+		{"Jane Austen", []string{"Emma", "Persuasion"}},
+		{"jane austen", []string{}},
+		{"George Eliot", []string{}},
+	} {
+		books, err := db.ListBooksByAuthor(ctx, tt.author)
+		if err != nil {
+			t.Fatalf("ListBooksByAuthor(%q): %v", tt.author, err)
+		}
+		if got := titles(books); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ListBooksByAuthor(%q) = %q, want %q", tt.author, got, tt.want)
+		}
+	}
+}
+
+func testReviews(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	id := mustAdd(t, db, &Book{Title: "Dune"})
+
+	reviews, err := db.ListReviews(ctx, id)
+	if err != nil || len(reviews) != 0 {
+		t.Fatalf("ListReviews of a new book = %d reviews, %v; want none", len(reviews), err)
+	}
+	for _, r := range []*Review{
+		{Author: "Alice", Body: "Spice.", Rating: 5},
+		{Author: "Bob", Body: "Too much sand.", Rating: 2},
+	} {
+		if err := db.AddReview(ctx, id, r); err != nil {
+			t.Fatalf("AddReview: %v", err)
+		}
+		if r.CreatedAt.IsZero() {
+			t.Errorf("AddReview did not set CreatedAt")
+		}
+	}
+	var verr ValidationError
+	if err := db.AddReview(ctx, id, &Review{Body: " ", Rating: 6}); !errors.As(err, &verr) || len(verr) != 2 {
+		t.Errorf("AddReview of an empty review rated 6: got %v, want a ValidationError for body and rating", err)
+	}
+	if err := db.AddReview(ctx, id+1000, &Review{Body: "Lost."}); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("AddReview to a missing book: got %v, want ErrBookNotFound", err)
+	}
+
+	reviews, err = db.ListReviews(ctx, id)
+	if err != nil {
+		t.Fatalf("ListReviews: %v", err)
+	}
+	var got []string
+	for _, r := range reviews {
+		got = append(got, fmt.Sprintf("%s:%d", r.Author, r.Rating))
+	}
+	if want := []string{"Alice:5", "Bob:2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListReviews = %q, want %q", got, want)
+	}
+	if _, err := db.ListReviews(ctx, id+1000); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("ListReviews of a missing book: got %v, want ErrBookNotFound", err)
+	}
+}
+
+func testGetBooks(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	dune := mustAdd(t, db, &Book{Title: "Dune"})
+	emma := mustAdd(t, db, &Book{Title: "Emma"})
+	gone := mustAdd(t, db, &Book{Title: "Middlemarch"})
+	if err := db.DeleteBook(ctx, gone); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+	// Getting one book first puts it in the cache of a caching database, so
+	// that GetBooks mixes cached and fetched books.
+	if _, err := db.GetBook(ctx, emma); err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+
+	for _, tt := range []struct {
+		ids  []int64
+		want []string
+	}{
+		{[]int64{emma, dune}, []string{"Emma", "Dune"}},
+		{[]int64{dune, gone, dune + 1000, emma}, []string{"Dune", "Emma"}},
+		{nil, []string{}},
+	} {
+		books, err := db.GetBooks(ctx, tt.ids)
+		if err != nil {
+			t.Fatalf("GetBooks(%v): %v", tt.ids, err)
+		}
+		if got := titles(books); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetBooks(%v) = %q, want %q", tt.ids, got, tt.want)
+		}
+	}
+}
+
+func testGenre(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	mustAdd(t, db, &Book{Title: "Dune", Genre: GenreFiction})
+	mustAdd(t, db, &Book{Title: "A Brief History of Time", Genre: GenreScience})
+	mustAdd(t, db, &Book{Title: "Emma", Genre: GenreFiction})
+	mustAdd(t, db, &Book{Title: "Unclassified"})
+	if _, err := db.AddBook(ctx, &Book{Title: "Cookbook", Genre: "cooking"}); !errors.Is(err, ErrInvalidGenre) {
+		t.Errorf("AddBook of genre cooking: got %v, want ErrInvalidGenre", err)
+	}
+
+	for _, tt := range []struct {
+		genre string
+		want  []string
+	}{
+		{GenreFiction, []string{"Dune", "Emma"}},
+		{GenreScience, []string{"A Brief History of Time"}},
+		{GenrePoetry, []string{}},
+	} {
+		books, err := db.ListBooksByGenre(ctx, tt.genre)
+		if err != nil {
+			t.Fatalf("ListBooksByGenre(%q): %v", tt.genre, err)
+		}
+		if got := titles(books); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ListBooksByGenre(%q) = %q, want %q", tt.genre, got, tt.want)
+		}
+	}
+}
+
+func testReviewsPaged(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	id := mustAdd(t, db, &Book{Title: "Dune"})
+	for _, author := range []string{"Alice", "Bob", "Carol", "Dave"} {
+		if err := db.AddReview(ctx, id, &Review{Author: author, Body: "Spice.", Rating: 4}); err != nil {
+			t.Fatalf("AddReview: %v", err)
+		}
+	}
+
+	for _, tt := range []struct {
+		limit, offset int
+		want          []string
+	}{
+		{2, 0, []string{"Alice", "Bob"}},
+		{2, 3, []string{"Dave"}},
+		{0, 1, []string{"Bob", "Carol", "Dave"}},
+		{2, 10, []string{}},
+	} {
+		reviews, total, err := db.ListReviewsPaged(ctx, id, tt.limit, tt.offset)
+		if err != nil {
+			t.Fatalf("ListReviewsPaged(%d, %d): %v", tt.limit, tt.offset, err)
+		}
+		got := []string{}
+		for _, r := range reviews {
+			got = append(got, r.Author)
+		}
+		if !reflect.DeepEqual(got, tt.want) || total != 4 {
+			t.Errorf("ListReviewsPaged(%d, %d) = %q, %d; want %q, 4", tt.limit, tt.offset, got, total, tt.want)
+		}
+	}
+	if _, _, err := db.ListReviewsPaged(ctx, id+1000, 2, 0); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("ListReviewsPaged of a missing book: got %v, want ErrBookNotFound", err)
+	}
+}
+
+func testPageRange(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	mustAdd(t, db, &Book{Title: "Middlemarch", PageCount: 880})
+	mustAdd(t, db, &Book{Title: "Dune", PageCount: 412})
+	mustAdd(t, db, &Book{Title: "Candide", PageCount: 129})
+	mustAdd(t, db, &Book{Title: "Unknown"})
+	id := mustAdd(t, db, &Book{Title: "Emma"})
+	if err := db.UpdateBookFields(ctx, id, map[string]interface{}{"page_count": 474}); err != nil {
+		t.Fatalf("UpdateBookFields: %v", err)
+	}
+
+	for _, tt := range []struct {
+		min, max int
+		want     []string
+	}{
+		{129, 474, []string{"Candide", "Dune", "Emma"}},
+		{400, 0, []string{"Dune", "Emma", "Middlemarch"}},
+		{0, 200, []string{"Candide", "Unknown"}},
+		{500, 600, []string{}},
+	} {
+		books, err := db.ListBooksByPageRange(ctx, tt.min, tt.max)
+		if err != nil {
+			t.Fatalf("ListBooksByPageRange(%d, %d): %v", tt.min, tt.max, err)
+		}
+		if got := titles(books); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ListBooksByPageRange(%d, %d) = %q, want %q", tt.min, tt.max, got, tt.want)
+		}
+	}
+}
+
+func testUpdateBooksWhere(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	emma := mustAdd(t, db, &Book{Title: "Emma", Author: "Jane Austen"})
+	mustAdd(t, db, &Book{Title: "Persuasion", Author: "Jane Austen"})
+	dune := mustAdd(t, db, &Book{Title: "Dune", Author: "Frank Herbert"})
+	gone := mustAdd(t, db, &Book{Title: "Sanditon", Author: "Jane Austen"})
+	if err := db.DeleteBook(ctx, gone); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+	// Reading the books first puts them in the cache of a caching database,
+	// which must not serve them stale afterwards.
+	for _, id := range []int64{emma, dune} {
+		if _, err := db.GetBook(ctx, id); err != nil {
+			t.Fatalf("GetBook: %v", err)
+		}
+	}
+
+	matched, err := db.UpdateBooksWhere(ctx,
+		map[string]interface{}{"author": "Jane Austen"},
+		map[string]interface{}{"genre": GenreFiction, "language": "en"})
+	if err != nil || matched != 2 {
+		t.Fatalf("UpdateBooksWhere = %d, %v; want 2, nil", matched, err)
+	}
+	b, err := db.GetBook(ctx, emma)
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if b.Genre != GenreFiction || b.Language != "en" || b.Version != 2 {
+		t.Errorf("after UpdateBooksWhere, Emma has genre %q, language %q and version %d; want fiction, en and 2",
+			b.Genre, b.Language, b.Version)
+	}
+	if b, err := db.GetBook(ctx, dune); err != nil || b.Genre != "" || b.Version != 1 {
+		t.Errorf("after UpdateBooksWhere, Dune = %+v, %v; want it unchanged", b, err)
+	}
+	books, err := db.ListBooksByGenre(ctx, GenreFiction)
+	if err != nil {
+		t.Fatalf("ListBooksByGenre: %v", err)
+	}
+	if got, want := titles(books), []string{"Emma", "Persuasion"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fiction after UpdateBooksWhere = %q, want %q", got, want)
+	}
+
+	for _, tt := range []struct {
+		filter, set map[string]interface{}
+	}{
+		{nil, map[string]interface{}{"genre": GenrePoetry}},
+		{map[string]interface{}{"rating": 3}, map[string]interface{}{"genre": GenrePoetry}},
+		{map[string]interface{}{"author": 3}, map[string]interface{}{"genre": GenrePoetry}},
+		{map[string]interface{}{"author": "Jane Austen"}, nil},
+		{map[string]interface{}{"author": "Jane Austen"}, map[string]interface{}{"id": 7}},
+		{map[string]interface{}{"author": "Jane Austen"}, map[string]interface{}{"genre": "cooking"}},
+	} {
+		var verr ValidationError
+		if n, err := db.UpdateBooksWhere(ctx, tt.filter, tt.set); !errors.As(err, &verr) {
+			t.Errorf("UpdateBooksWhere(%v, %v) = %d, %v; want a ValidationError", tt.filter, tt.set, n, err)
+		}
+	}
+	if books, err := db.ListBooksByGenre(ctx, GenreFiction); err != nil || len(books) != 2 {
+		t.Errorf("fiction after the rejected updates = %q, %v; want it unchanged", titles(books), err)
+	}
+}
+
+func testBookExists(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	id := mustAdd(t, db, &Book{Title: "Dune"})
+
+	if exists, err := db.BookExists(ctx, id); err != nil || !exists {
+		t.Errorf("BookExists of a book = %v, %v; want true", exists, err)
+	}
+	if exists, err := db.BookExists(ctx, id+1000); err != nil || exists {
+		t.Errorf("BookExists of a missing book = %v, %v; want false", exists, err)
+	}
+	// Getting the book first puts it in the cache of a caching database.
+	if _, err := db.GetBook(ctx, id); err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if err := db.DeleteBook(ctx, id); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+	if exists, err := db.BookExists(ctx, id); err != nil || exists {
+		t.Errorf("BookExists of a deleted book = %v, %v; want false", exists, err)
+	}
+}
+
+func testMetadata(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	id := mustAdd(t, db, &Book{Title: "Dune", Metadata: map[string]string{"shelf": "B2", "condition": "worn"}})
+	mustAdd(t, db, &Book{Title: "Emma", Metadata: map[string]string{"shelf": "A1"}})
+	mustAdd(t, db, &Book{Title: "Anathem", Metadata: map[string]string{"shelf": "B2"}})
+	mustAdd(t, db, &Book{Title: "Persuasion"})
+
+	got, err := db.GetBook(ctx, id)
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if want := map[string]string{"shelf": "B2", "condition": "worn"}; !reflect.DeepEqual(got.Metadata, want) {
+		t.Errorf("GetBook: got metadata %v, want %v", got.Metadata, want)
+	}
+
+	books, err := db.ListBooksByMetadata(ctx, "shelf", "B2")
+	if err != nil {
+		t.Fatalf("ListBooksByMetadata: %v", err)
+	}
+	if got, want := titles(books), []string{"Anathem", "Dune"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooksByMetadata(shelf, B2) = %q, want %q", got, want)
+	}
+	if books, err := db.ListBooksByMetadata(ctx, "shelf", "Z9"); err != nil || len(books) != 0 {
+		t.Errorf("ListBooksByMetadata(shelf, Z9) = %d books, %v; want none", len(books), err)
+	}
+	// Keys need not be identifiers.
+	odd := mustAdd(t, db, &Book{Title: "Ulysses", Metadata: map[string]string{"loaned to": "Ann", "étagère-2": "haut"}})
+	for key, value := range map[string]string{"loaned to": "Ann", "étagère-2": "haut"} {
+		books, err := db.ListBooksByMetadata(ctx, key, value)
+		if err != nil {
+			t.Fatalf("ListBooksByMetadata(%q, %q): %v", key, value, err)
+		}
+		if len(books) != 1 || books[0].ID != odd || !reflect.DeepEqual(books[0].Metadata, map[string]string{"loaned to": "Ann", "étagère-2": "haut"}) {
+			t.Errorf("ListBooksByMetadata(%q, %q) = %+v, want Ulysses with its metadata", key, value, books)
+		}
+	}
+	for _, key := range []string{"", "shelf.row", "$where"} {
+		var verr ValidationError
+		if _, err := db.ListBooksByMetadata(ctx, key, "B2"); !errors.As(err, &verr) {
+			t.Errorf("ListBooksByMetadata(%q, B2): got %v, want a ValidationError", key, err)
+		}
+	}
+
+	if err := db.UpdateBookFields(ctx, id, map[string]interface{}{"metadata": map[string]interface{}{"shelf": "A1"}}); err != nil {
+		t.Fatalf("UpdateBookFields: %v", err)
+	}
+	books, err = db.ListBooksByMetadata(ctx, "shelf", "A1")
+	if err != nil {
+		t.Fatalf("ListBooksByMetadata: %v", err)
+	}
+	if got, want := titles(books), []string{"Dune", "Emma"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooksByMetadata(shelf, A1) after update = %q, want %q", got, want)
+	}
+
+	// Updating the whole book without metadata clears it.
+	b, err := db.GetBook(ctx, id)
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	b.Metadata = nil
+	if err := db.UpdateBook(ctx, b); err != nil {
+		t.Fatalf("UpdateBook: %v", err)
+	}
+	if b, err = db.GetBook(ctx, id); err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if len(b.Metadata) != 0 {
+		t.Errorf("GetBook after UpdateBook without metadata: got metadata %v, want none", b.Metadata)
+	}
+}
+
+func testSubscribe(t *testing.T, db BookDatabase) {
+	books, unsubscribe := db.Subscribe()
+	defer unsubscribe()
+
+	mustAdd(t, db, &Book{Title: "Dune"})
+	ids, err := db.AddBooks(context.Background(), []*Book{{Title: "Emma"}})
+	if err != nil {
+		t.Fatalf("AddBooks: %v", err)
+	}
+	t.Cleanup(func() {
+		db.DeleteBooks(context.Background(), ids)
+		db.PurgeDeleted(context.Background(), 0)
+	})
+	var got []string
+	for len(got) < 2 {
+		select {
+		case b := <-books:
+			got = append(got, b.Title)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %q from the subscription, then nothing", got)
+		}
+	}
+	if want := []string{"Dune", "Emma"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("subscription got %q, want %q", got, want)
+	}
+}
+
+func testTitleIndex(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	for _, title := range []string{"Dune", "dune messiah", "Emma", "1984"} {
+		mustAdd(t, db, &Book{Title: title})
+	}
+	if err := db.DeleteBook(ctx, mustAdd(t, db, &Book{Title: "Zazie"})); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+
+	got, err := db.ListTitleIndex(ctx)
+	if err != nil {
+		t.Fatalf("ListTitleIndex: %v", err)
+	}
+	if want := map[string]int{"D": 2, "E": 1, "#": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListTitleIndex = %v, want %v", got, want)
+	}
+}
+
+func testTransaction(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	if db.Name() == "mongodb" {
+		ran := false
+		err := db.WithTransaction(ctx, func(ctx context.Context) error {
+			ran = true
+			return nil
+		})
+		if !errors.Is(err, ErrTransactionsUnsupported) || ran {
+			t.Errorf("WithTransaction = %v, ran fn %v; want ErrTransactionsUnsupported without running fn", err, ran)
+		}
+		return
+	}
+	id := mustAdd(t, db, &Book{Title: "Dune"})
+
+	var added int64
+	err := db.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		added, err = db.AddBook(ctx, &Book{Title: "Emma"})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithTransaction: %v", err)
+	}
+	t.Cleanup(func() {
+		db.DeleteBook(ctx, added)
+		db.PurgeDeleted(ctx, 0)
+	})
+	if _, err := db.GetBook(ctx, added); err != nil {
+		t.Errorf("GetBook of a book added in a committed transaction: %v", err)
+	}
+
+	// Getting the book first puts it in the cache of a caching database.
+	if _, err := db.GetBook(ctx, id); err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	errAbort := errors.New("abort")
+	err = db.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := db.UpdateBookFields(ctx, id, map[string]interface{}{"title": "Dune Messiah"}); err != nil {
+			return err
+		}
+		if _, err := db.AddBooks(ctx, []*Book{{Title: "Persuasion"}}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("WithTransaction = %v, want the error from fn", err)
+	}
+	if b, err := db.GetBook(ctx, id); err != nil || b.Title != "Dune" {
+		t.Errorf("GetBook after rolling back = %+v, %v; want Dune", b, err)
+	}
+	books, err := db.ListBooks(ctx)
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	if got, want := titles(books), []string{"Dune", "Emma"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooks after rolling back = %q, want %q", got, want)
+	}
+}
+
+// testConcurrentAdd checks that books added at the same time all get
+// distinct IDs.
+func testConcurrentAdd(t *testing.T, db BookDatabase) {
+	const n = 50
+	ids := make([]int64, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i], errs[i] = db.AddBook(context.Background(), &Book{Title: fmt.Sprintf("Book %02d", i)})
+		}(i)
+	}
+	wg.Wait()
+	t.Cleanup(func() {
+		db.DeleteBooks(context.Background(), ids)
+		db.PurgeDeleted(context.Background(), 0)
+	})
+
+	seen := make(map[int64]bool)
+	for i, id := range ids {
+		if errs[i] != nil {
+			t.Fatalf("AddBook: %v", errs[i])
+		}
+		if id <= 0 || seen[id] {
+			t.Errorf("AddBook gave ID %d, want a positive ID not given before", id)
+		}
+		seen[id] = true
+	}
+}
+
+func testTopAuthors(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	for _, b := range []*Book{
+		{Title: "Emma", Author: "Jane Austen"},
+		{Title: "Persuasion", Author: "Jane Austen"},
+		{Title: "Dune", Author: "Frank Herbert"},
+		{Title: "Dune Messiah", Author: "Frank Herbert"},
+		{Title: "Middlemarch", Author: "George Eliot"},
+		{Title: "Beowulf"},
+	} {
+		mustAdd(t, db, b)
+	}
+	if err := db.DeleteBook(ctx, mustAdd(t, db, &Book{Title: "Sanditon", Author: "Jane Austen"})); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+
+	tests := []struct {
+		limit int
+		want  []AuthorCount
+	}{
+		{0, []AuthorCount{{"Frank Herbert", 2}, {"Jane Austen", 2}, {"George Eliot", 1}}},
+		{2, []AuthorCount{{"Frank Herbert", 2}, {"Jane Austen", 2}}},
+		{10, []AuthorCount{{"Frank Herbert", 2}, {"Jane Austen", 2}, {"George Eliot", 1}}},
+	}
+	for _, tt := range tests {
+		got, err := db.TopAuthors(ctx, tt.limit)
+		if err != nil {
+			t.Fatalf("TopAuthors(%d): %v", tt.limit, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TopAuthors(%d) = %v, want %v", tt.limit, got, tt.want)
+		}
+	}
+}
+
+// testCollatedTitles checks that books are ordered by title ignoring case
+// and accents.
+func testCollatedTitles(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	for _, title := range []string{"Zebra", "apple", "Éclair", "Dune"} {
+		mustAdd(t, db, &Book{Title: title, Author: "Anon"})
+	}
+	want := []string{"apple", "Dune", "Éclair", "Zebra"}
+
+	books, err := db.ListBooks(ctx)
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	if got := titles(books); !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooks = %q, want %q", got, want)
+	}
+	if books, err = db.ListBooksByAuthor(ctx, "Anon"); err != nil {
+		t.Fatalf("ListBooksByAuthor: %v", err)
+	}
+	if got := titles(books); !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooksByAuthor = %q, want %q", got, want)
+	}
+	if books, err = db.ListBooksSorted(ctx, "title", true); err != nil {
+		t.Fatalf("ListBooksSorted: %v", err)
+	}
+	if got, want := titles(books), []string{"Zebra", "Éclair", "Dune", "apple"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooksSorted(title, descending) = %q, want %q", got, want)
+	}
+}
+
+func testMultipleTags(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	for _, b := range []*Book{
+		{Title: "Neuromancer", Tags: []string{"scifi", "cyberpunk"}},
+		{Title: "Emma", Tags: []string{"classics"}},
+		{Title: "Dune", Tags: []string{"scifi", "classics"}},
+		{Title: "Middlemarch"},
+	} {
+		mustAdd(t, db, b)
+	}
+
+	tests := []struct {
+		tags     []string
+		matchAll bool
+		want     []string
+	}{
+		{[]string{"scifi", "classics"}, false, []string{"Dune", "Emma", "Neuromancer"}},
+		{[]string{"scifi", "classics"}, true, []string{"Dune"}},
+		{[]string{"scifi", "scifi"}, true, []string{"Dune", "Neuromancer"}},
+		{[]string{"scifi", "poetry"}, true, []string{}},
+		{[]string{"poetry"}, false, []string{}},
+		{nil, false, []string{}},
+		{nil, true, []string{}},
+	}
+	for _, tt := range tests {
+		books, err := db.ListBooksByTags(ctx, tt.tags, tt.matchAll)
+		if err != nil {
+			t.Fatalf("ListBooksByTags(%q, %v): %v", tt.tags, tt.matchAll, err)
+		}
+		if got := titles(books); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ListBooksByTags(%q, %v) = %q, want %q", tt.tags, tt.matchAll, got, tt.want)
+		}
+	}
+}
+
+func testStatus(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	draft := mustAdd(t, db, &Book{Title: "Emma", Status: StatusDraft})
+	mustAdd(t, db, &Book{Title: "Dune"})
+
+	list := func(status string) []string {
+		t.Helper()
+		books, err := db.ListBooksByStatus(ctx, status)
+		if err != nil {
+			t.Fatalf("ListBooksByStatus(%s): %v", status, err)
+		}
+		return titles(books)
+	}
+	if got, want := list(StatusDraft), []string{"Emma"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooksByStatus(draft) = %q, want %q", got, want)
+	}
+	if got, want := list(StatusPublished), []string{"Dune"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooksByStatus(published) = %q, want %q", got, want)
+	}
+	books, err := db.ListBooks(ctx)
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	if got, want := titles(books), []string{"Dune"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooks with a draft = %q, want %q", got, want)
+	}
+	if _, err := db.ListBooksByStatus(ctx, "archived"); !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("ListBooksByStatus(archived): got %v, want ErrInvalidStatus", err)
+	}
+
+	// Getting the book first puts it in the cache of a caching database.
+	if b, err := db.GetBook(ctx, draft); err != nil || b.Status != StatusDraft {
+		t.Fatalf("GetBook of a draft = %+v, %v; want a draft", b, err)
+	}
+	if err := db.PublishBook(ctx, draft); err != nil {
+		t.Fatalf("PublishBook: %v", err)
+	}
+	b, err := db.GetBook(ctx, draft)
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if b.Status != StatusPublished || b.Version != 2 {
+		t.Errorf("after PublishBook, got status %q and version %d, want published and 2", b.Status, b.Version)
+	}
+	if got, want := list(StatusPublished), []string{"Dune", "Emma"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooksByStatus(published) after publishing = %q, want %q", got, want)
+	}
+	if err := db.PublishBook(ctx, draft+1000); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("PublishBook of a missing book: got %v, want ErrBookNotFound", err)
+	}
+}
+
+func testAddBooksBestEffort(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	ids, failures, err := db.AddBooksBestEffort(ctx, []*Book{
+		{Title: "Dune"},
+		{Title: ""},
+		{Title: "Emma", ISBN: "12345"},
+		{Title: "Persuasion"},
+	})
+	if err != nil {
+		t.Fatalf("AddBooksBestEffort: %v", err)
+	}
+	t.Cleanup(func() {
+		db.DeleteBooks(ctx, ids)
+		db.PurgeDeleted(ctx, 0)
+	})
+
+	if len(ids) != 4 || ids[0] == 0 || ids[1] != 0 || ids[2] != 0 || ids[3] == 0 {
+		t.Errorf("AddBooksBestEffort gave IDs %v, want IDs for books 0 and 3 only", ids)
+	}
+	var failed []int
+	for _, f := range failures {
+		failed = append(failed, f.Index)
+		if f.Err == "" {
+			t.Errorf("failure of book %d has no error", f.Index)
+		}
+	}
+	if want := []int{1, 2}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("AddBooksBestEffort reported failures of books %v, want %v", failed, want)
+	}
+	books, err := db.ListBooks(ctx)
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	if got, want := titles(books), []string{"Dune", "Persuasion"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooks after AddBooksBestEffort = %q, want %q", got, want)
+	}
+}
+
+func testRandomBook(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	if b, err := db.RandomBook(ctx); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("RandomBook of an empty database = %+v, %v; want ErrBookNotFound", b, err)
+	}
+
+	mustAdd(t, db, &Book{Title: "Emma", Status: StatusDraft})
+	if b, err := db.RandomBook(ctx); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("RandomBook with only a draft = %+v, %v; want ErrBookNotFound", b, err)
+	}
+
+	mustAdd(t, db, &Book{Title: "Dune"})
+	mustAdd(t, db, &Book{Title: "Persuasion"})
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		b, err := db.RandomBook(ctx)
+		if err != nil {
+			t.Fatalf("RandomBook: %v", err)
+		}
+		seen[b.Title] = true
+	}
+	if want := map[string]bool{"Dune": true, "Persuasion": true}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("50 calls of RandomBook picked %v, want both published books and no draft", seen)
+	}
+}
+
+func testSetBookTags(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	id := mustAdd(t, db, &Book{Title: "Dune", Tags: []string{"scifi"}})
+
+	// Getting the book first puts it in the cache of a caching database.
+	if _, err := db.GetBook(ctx, id); err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if err := db.SetBookTags(ctx, id, []string{" classics", "desert", "classics", ""}); err != nil {
+		t.Fatalf("SetBookTags: %v", err)
+	}
+	b, err := db.GetBook(ctx, id)
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if want := []string{"classics", "desert"}; !reflect.DeepEqual(b.Tags, want) || b.Version != 2 {
+		t.Errorf("after SetBookTags, got tags %q and version %d, want %q and 2", b.Tags, b.Version, want)
+	}
+
+	if err := db.SetBookTags(ctx, id, nil); err != nil {
+		t.Fatalf("SetBookTags(nil): %v", err)
+	}
+	if b, err = db.GetBook(ctx, id); err != nil || len(b.Tags) != 0 {
+		t.Errorf("after SetBookTags(nil), GetBook = %+v, %v; want no tags", b, err)
+	}
+	if err := db.SetBookTags(ctx, id+1000, []string{"scifi"}); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("SetBookTags of a missing book: got %v, want ErrBookNotFound", err)
+	}
+}
+
+func testReindex(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	mustAdd(t, db, &Book{Title: "Emma"})
+	mustAdd(t, db, &Book{Title: "Dune"})
+
+	for i := 0; i < 2; i++ {
+		if err := db.Reindex(ctx); err != nil {
+			t.Fatalf("Reindex #%d: %v", i+1, err)
+		}
+	}
+	books, err := db.ListBooks(ctx)
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	if got, want := titles(books), []string{"Dune", "Emma"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooks after Reindex = %q, want %q", got, want)
+	}
+}
+
+func testSearchFiltered(t *testing.T, db BookDatabase) {
+	mustAdd(t, db, &Book{Title: "Dune", Description: "A desert planet.", Tags: []string{"scifi"}})
+	mustAdd(t, db, &Book{Title: "The Desert Fathers", Tags: []string{"history"}})
+	mustAdd(t, db, &Book{Title: "Desert Solitaire", Tags: []string{"nature", "memoir"}})
+
+	tests := []struct {
+		tags []string
+		want []string
+	}{
+		{nil, []string{"Desert Solitaire", "Dune", "The Desert Fathers"}},
+		{[]string{"scifi"}, []string{"Dune"}},
+		{[]string{"scifi", "memoir"}, []string{"Desert Solitaire", "Dune"}},
+		{[]string{"poetry"}, []string{}},
+	}
+	for _, tt := range tests {
+		books, err := db.SearchBooksFiltered(context.Background(), "desert", tt.tags)
+		if err != nil {
+			t.Fatalf("SearchBooksFiltered(desert, %q): %v", tt.tags, err)
+		}
+		got := titles(books)
+		if db.Name() != "memory" && db.Name() != "sqlite" {
+			// Full-text search ranks results rather than sorting them.
+			sort.Strings(got)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SearchBooksFiltered(desert, %q) = %q, want %q", tt.tags, got, tt.want)
+		}
+	}
+}
+
+func testDeleteAllBooks(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	id, err := db.AddBook(ctx, &Book{Title: "Dune"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	if err := db.AddReview(ctx, id, &Review{Body: "Spice.", Rating: 5}); err != nil {
+		t.Fatalf("AddReview: %v", err)
+	}
+	deleted, err := db.AddBook(ctx, &Book{Title: "Emma"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	if err := db.DeleteBook(ctx, deleted); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+
+	if n, err := db.DeleteAllBooks(ctx); err != nil || n != 2 {
+		t.Fatalf("DeleteAllBooks = %d, %v; want 2, nil", n, err)
+	}
+	if _, err := db.GetBook(ctx, id); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("GetBook after DeleteAllBooks: got %v, want ErrBookNotFound", err)
+	}
+	if err := db.RestoreBook(ctx, deleted); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("RestoreBook after DeleteAllBooks: got %v, want ErrBookNotFound", err)
+	}
+	if n, err := db.DeleteAllBooks(ctx); err != nil || n != 0 {
+		t.Errorf("DeleteAllBooks of an empty database = %d, %v; want 0, nil", n, err)
+	}
+
+	newID := mustAdd(t, db, &Book{Title: "Persuasion"})
+	if newID == id || newID == deleted {
+		t.Errorf("AddBook after DeleteAllBooks reused ID %d", newID)
+	}
+	reviews, err := db.ListReviews(ctx, newID)
+	if err != nil {
+		t.Fatalf("ListReviews: %v", err)
+	}
+	if len(reviews) != 0 {
+		t.Errorf("ListReviews of a new book = %d reviews, want none", len(reviews))
+	}
+}
+
+func testListBetweenDates(t *testing.T, db BookDatabase) {
+	mustAdd(t, db, &Book{Title: "Dune", PublishedDate: "1965-08-01"})
+	mustAdd(t, db, &Book{Title: "Dune Messiah", PublishedDate: "1969"})
+	mustAdd(t, db, &Book{Title: "The Moon Is a Harsh Mistress", PublishedDate: "1966-06"})
+	mustAdd(t, db, &Book{Title: "Stranger in a Strange Land", PublishedDate: "1961-06-01"})
+	mustAdd(t, db, &Book{Title: "Stand on Zanzibar", PublishedDate: "1968-13"})
+	mustAdd(t, db, &Book{Title: "Undated"})
+
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		start, end time.Time
+		want       []string
+	}{
+		{day(1965, 1, 1), day(1969, 12, 31), []string{"Dune", "The Moon Is a Harsh Mistress", "Dune Messiah"}},
+		{day(1965, 8, 2), day(1968, 12, 31), []string{"The Moon Is a Harsh Mistress"}},
+		{day(1966, 1, 1), time.Time{}, []string{"The Moon Is a Harsh Mistress", "Dune Messiah"}},
+		{time.Time{}, day(1965, 8, 1), []string{"Stranger in a Strange Land", "Dune"}},
+		{day(1970, 1, 1), time.Time{}, []string{}},
+	}
+	for _, tt := range tests {
+		books, err := db.ListBooksBetweenDates(context.Background(), tt.start, tt.end)
+		if err != nil {
+			t.Fatalf("ListBooksBetweenDates: %v", err)
+		}
+		if got := titles(books); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ListBooksBetweenDates(%v, %v) = %q, want %q", tt.start.Format("2006-01-02"), tt.end.Format("2006-01-02"), got, tt.want)
+		}
+	}
+}
+
+func testRenameAuthor(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	dune := mustAdd(t, db, &Book{Title: "Dune", Author: "Frank Herbert"})
+	mustAdd(t, db, &Book{Title: "Dune Messiah", Author: "Frank Herbert"})
+	mustAdd(t, db, &Book{Title: "Emma", Author: "Jane Austen"})
+	// Read Dune first, so that a cached copy would show up below.
+	if _, err := db.GetBook(ctx, dune); err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+
+	if n, err := db.RenameAuthor(ctx, "Frank Herbert", "F. Herbert"); err != nil || n != 2 {
+		t.Fatalf("RenameAuthor = %d, %v; want 2, nil", n, err)
+	}
+	b, err := db.GetBook(ctx, dune)
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if b.Author != "F. Herbert" {
+		t.Errorf("author after RenameAuthor = %q, want %q", b.Author, "F. Herbert")
+	}
+	books, err := db.ListBooksByAuthor(ctx, "Jane Austen")
+	if err != nil {
+		t.Fatalf("ListBooksByAuthor: %v", err)
+	}
+	if got, want := titles(books), []string{"Emma"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooksByAuthor(Jane Austen) after RenameAuthor = %q, want %q", got, want)
+	}
+
+	for _, tt := range []struct{ from, to string }{
+		{"F. Herbert", "F. Herbert"},
+		{"Nobody", "Somebody"},
+	} {
+		if n, err := db.RenameAuthor(ctx, tt.from, tt.to); err != nil || n != 0 {
+			t.Errorf("RenameAuthor(%q, %q) = %d, %v; want 0, nil", tt.from, tt.to, n, err)
+		}
+	}
+}
+
+func testDraftsHidden(t *testing.T, db BookDatabase) {
+	ctx := context.Background()
+	draft := mustAdd(t, db, &Book{Title: "Emma", Author: "Jane Austen", Tags: []string{"classic"}, CreatedByID: "jane", Status: StatusDraft})
+	mustAdd(t, db, &Book{Title: "Dune", Author: "Frank Herbert", Tags: []string{"classic"}, CreatedByID: "jane"})
+
+	if n, err := db.CountBooks(ctx); err != nil || n != 1 {
+		t.Errorf("CountBooks with a draft = %d, %v; want 1, nil", n, err)
+	}
+	books, err := db.SearchBooks(ctx, "emma")
+	if err != nil {
+		t.Fatalf("SearchBooks: %v", err)
+	}
+	if len(books) != 0 {
+		t.Errorf("SearchBooks(emma) found the draft: %q", titles(books))
+	}
+	books, err = db.ListBooksByTag(ctx, "classic")
+	if err != nil {
+		t.Fatalf("ListBooksByTag: %v", err)
+	}
+	if got, want := titles(books), []string{"Dune"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooksByTag(classic) with a draft = %q, want %q", got, want)
+	}
+	authors, err := db.ListAuthors(ctx)
+	if err != nil {
+		t.Fatalf("ListAuthors: %v", err)
+	}
+	if want := []string{"Frank Herbert"}; !reflect.DeepEqual(authors, want) {
+		t.Errorf("ListAuthors with a draft = %q, want %q", authors, want)
+	}
+	suggested, err := db.SuggestTitles(ctx, "em", 10)
+	if err != nil {
+		t.Fatalf("SuggestTitles: %v", err)
+	}
+	if len(suggested) != 0 {
+		t.Errorf("SuggestTitles(em) suggested the draft: %q", suggested)
+	}
+	var each []string
+	if err := db.ForEachBook(ctx, func(b *Book) error { each = append(each, b.Title); return nil }); err != nil {
+		t.Fatalf("ForEachBook: %v", err)
+	}
+	if want := []string{"Dune"}; !reflect.DeepEqual(each, want) {
+		t.Errorf("ForEachBook with a draft visited %q, want %q", each, want)
+	}
+
+	// Their creator still finds them, as does their ID.
+	books, err = db.ListBooksCreatedBy(ctx, "jane")
+	if err != nil {
+		t.Fatalf("ListBooksCreatedBy: %v", err)
+	}
+	if got, want := titles(books), []string{"Dune", "Emma"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooksCreatedBy(jane) = %q, want %q", got, want)
+	}
+	if n, err := db.CountBooksCreatedBy(ctx, "jane"); err != nil || n != 2 {
+		t.Errorf("CountBooksCreatedBy(jane) = %d, %v; want 2, nil", n, err)
+	}
+	if _, err := db.GetBook(ctx, draft); err != nil {
+		t.Errorf("GetBook of a draft: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// publishedDateLayouts are the layouts PublishedDate is parsed with, from the
+// most to the least precise.
+var publishedDateLayouts = []string{"2006-01-02", "2006-01", "2006"}
+
+// ParsedPublishedDate parses the book's PublishedDate with each of the
+// layouts "2006-01-02", "2006-01" and "2006" in turn, reporting whether any
+// of them succeeded. Missing months and days default to the first one.
+func (b *Book) ParsedPublishedDate() (time.Time, bool) {
+	for _, layout := range publishedDateLayouts {
+		if t, err := time.Parse(layout, b.PublishedDate); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// publishedIn reports whether b has a parseable published date in year.
+func publishedIn(b *Book, year int) bool {
+	t, ok := b.ParsedPublishedDate()
+	return ok && t.Year() == year
+}
+
+// keepPublishedIn filters books in place down to those published in year.
+func keepPublishedIn(books []*Book, year int) []*Book {
+	kept := books[:0]
+	for _, b := range books {
+		if publishedIn(b, year) {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// publishedBetween reports whether b has a parseable published date between
+// start and end, inclusive. A zero start or end leaves that side open.
+func publishedBetween(b *Book, start, end time.Time) bool {
+	t, ok := b.ParsedPublishedDate()
+	return ok && (start.IsZero() || !t.Before(start)) && (end.IsZero() || !t.After(end))
+}
+
+// keepPublishedBetween filters books in place down to those published between
+// start and end, and sorts them by published date. Books published the same
+// day keep their order.
+func keepPublishedBetween(books []*Book, start, end time.Time) []*Book {
+	kept := books[:0]
+	for _, b := range books {
+		if publishedBetween(b, start, end) {
+			kept = append(kept, b)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		ti, _ := kept[i].ParsedPublishedDate()
+		tj, _ := kept[j].ParsedPublishedDate()
+		return ti.Before(tj)
+	})
+	return kept
+}
+
+// publishedYearBounds returns the years of start and end as four digits, as
+// published dates start with, or "" for an open side. Backends use them to
+// narrow down candidates before checking them with publishedBetween.
+func publishedYearBounds(start, end time.Time) (from, to string) {
+	if !start.IsZero() {
+		from = fmt.Sprintf("%04d", start.Year())
+	}
+	if !end.IsZero() {
+		to = fmt.Sprintf("%04d", end.Year())
+	}
+	return from, to
+}
+
+// yearPattern returns a regular expression matching the published dates in
+// year that have one of the parseable layouts. Backends use it to narrow
+// down candidates before checking them with publishedIn.
+func yearPattern(year int) string {
+	return fmt.Sprintf(`^%04d(-[0-9]{2}(-[0-9]{2})?)?$`, year)
+}
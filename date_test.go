@@ -0,0 +1,76 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestParsedPublishedDate(t *testing.T) {
+	tests := []struct {
+		date   string
+		want   time.Time
+		wantOK bool
+	}{
+		{"1965-08-01", time.Date(1965, 8, 1, 0, 0, 0, 0, time.UTC), true},
+		{"1965-08", time.Date(1965, 8, 1, 0, 0, 0, 0, time.UTC), true},
+		{"1965", time.Date(1965, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"", time.Time{}, false},
+		{"August 1965", time.Time{}, false},
+		{"1965-13", time.Time{}, false},
+		{"1965-02-30", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		b := &Book{PublishedDate: tt.date}
+		got, ok := b.ParsedPublishedDate()
+		if !got.Equal(tt.want) || ok != tt.wantOK {
+			t.Errorf("ParsedPublishedDate(%q) = %v, %v; want %v, %v", tt.date, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestPublishedBetween(t *testing.T) {
+	start := time.Date(1965, 8, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		date       string
+		start, end time.Time
+		want       bool
+	}{
+		{"1965-08-01", start, end, true},
+		{"1965-07-31", start, end, false},
+		{"1965", start, end, false},
+		{"1969-12", start, end, true},
+		{"1970", start, end, false},
+		{"1970", start, time.Time{}, true},
+		{"1901", time.Time{}, end, true},
+		{"", time.Time{}, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		b := &Book{PublishedDate: tt.date}
+		if got := publishedBetween(b, tt.start, tt.end); got != tt.want {
+			t.Errorf("publishedBetween(%q, %v, %v) = %v, want %v", tt.date, tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestYearPattern(t *testing.T) {
+	re := regexp.MustCompile(yearPattern(1965))
+	for date, want := range map[string]bool{
+		"1965":       true,
+		"1965-08":    true,
+		"1965-08-01": true,
+		"1966":       false,
+		"21965":      false,
+		"1965-8":     false,
+		"1965 or so": false,
+	} {
+		if got := re.MatchString(date); got != want {
+			t.Errorf("yearPattern(1965) matches %q = %v, want %v", date, got, want)
+		}
+	}
+}
@@ -5,9 +5,16 @@
 package bookshelf
 
 import (
-	"crypto/rand"
+	"context"
+	"errors"
 	"fmt"
-	"math/big"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
@@ -16,91 +23,1415 @@ import (
 type mongoDB struct {
 	conn *mgo.Session
 	c    *mgo.Collection
+
+	rejectDuplicateISBN bool
+	log                 Logger
+
+	feed bookFeed // publishes the books added through this mongoDB.
 }
 
 // Ensure mongoDB conforms to the BookDatabase interface.
 var _ BookDatabase = &mongoDB{}
 
+// MongoOptions tunes the session used to talk to a Mongo server. Zero fields
+// are replaced by the defaults listed next to them.
+type MongoOptions struct {
+	// PoolLimit caps the number of sockets open per server (4096).
+	PoolLimit int
+
+	// DialTimeout bounds establishing the initial connection (10s).
+	DialTimeout time.Duration
+
+	// SocketTimeout bounds every individual socket operation (1m).
+	SocketTimeout time.Duration
+
+	// ReadPreference chooses which members of a replica set serve reads:
+	// "primary", "primaryPreferred", "secondary", "secondaryPreferred" or
+	// "nearest". It overrides a readPreference given in the address.
+	ReadPreference string
+
+	// WriteConcern is how many members of a replica set must acknowledge a
+	// write before it returns: "majority", or a number such as "2". The
+	// driver's default, acknowledgement by the primary alone, applies if it
+	// is empty.
+	WriteConcern string
+
+	// Database and Collection name where books are stored ("bookshelf" and
+	// "books").
+	Database   string
+	Collection string
+
+	// RejectDuplicateISBN makes AddBook refuse a book whose non-empty ISBN
+	// is already stored, returning the existing book's ID along with
+	// ErrDuplicateISBN. A unique index on isbn, created by Migrate,
+	// backs the check.
+	RejectDuplicateISBN bool
+
+	// Logger receives the database's log messages, including the duration
+	// of every operation at debug level (info and above to standard error).
+	Logger Logger
+}
+
+// withDefaults returns a copy of opts with zero fields set to their defaults.
+func (opts MongoOptions) withDefaults() MongoOptions {
+	if opts.PoolLimit <= 0 {
+		opts.PoolLimit = 4096
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 10 * time.Second
+	}
+	if opts.SocketTimeout <= 0 {
+		opts.SocketTimeout = time.Minute
+	}
+	if opts.Database == "" {
+		opts.Database = "bookshelf"
+	}
+	if opts.Collection == "" {
+		opts.Collection = "books"
+	}
+	if opts.Logger == nil {
+		opts.Logger = defaultLogger
+	}
+	return opts
+}
+
+// readPreferences maps the read preferences of MongoOptions to mgo modes.
+var readPreferences = map[string]mgo.Mode{
+	"primary":            mgo.Primary,
+	"primaryPreferred":   mgo.PrimaryPreferred,
+	"secondary":          mgo.Secondary,
+	"secondaryPreferred": mgo.SecondaryPreferred,
+	"nearest":            mgo.Nearest,
+}
+
 // NewMongoDB creates a new BookDatabase backed by a given Mongo server,
 // authenticated with given credentials.
+//
+// addr is either a host name or a connection string, such as
+// "mongodb://user:pass@a,b,c/?replicaSet=rs0", which may list every member
+// of a replica set and carry credentials and options.
 func NewMongoDB(addr string) (BookDatabase, error) {
-	conn, err := mgo.Dial(addr)
+	return NewMongoDBNamed(addr, "bookshelf", "books")
+}
+
+// NewMongoDBNamed is like NewMongoDB, but stores books in the given database
+// and collection, so that several deployments or test runs can share a
+// cluster.
+func NewMongoDBNamed(addr, dbName, collName string) (BookDatabase, error) {
+	return NewMongoDBWithOptions(addr, MongoOptions{Database: dbName, Collection: collName})
+}
+
+// NewMongoDBWithOptions is like NewMongoDB, but configures the session's
+// connection pool, timeouts, read preference, write concern and naming with
+// opts.
+func NewMongoDBWithOptions(addr string, opts MongoOptions) (BookDatabase, error) {
+	info, err := mongoDialInfo(addr, opts)
+	if err != nil {
+		return nil, fmt.Errorf("mongo: %v", err)
+	}
+	safe, err := mongoSafe(opts.WriteConcern)
 	if err != nil {
-		return nil, fmt.Errorf("mongo: could not dial: %v", err)
+		return nil, fmt.Errorf("mongo: %v", err)
 	}
+	// A maxPoolSize in the address applies unless opts sets a limit.
+	if opts.PoolLimit <= 0 {
+		opts.PoolLimit = info.PoolLimit
+	}
+	opts = opts.withDefaults()
+	info.Timeout = opts.DialTimeout
 
+	conn, err := mgo.DialWithInfo(info)
+	if err != nil {
+		return nil, fmt.Errorf("mongo: could not dial: %w", unavailable(err))
+	}
+	conn.SetPoolLimit(opts.PoolLimit)
+	conn.SetSocketTimeout(opts.SocketTimeout)
+	if safe != nil {
+		conn.SetSafe(safe)
+	}
+
+	c := conn.DB(opts.Database).C(opts.Collection)
+	ensureListIndexes(c, opts.Logger)
 	return &mongoDB{
-		conn: conn,
-		c:    conn.DB("bookshelf").C("books"),
+		conn:                conn,
+		c:                   c,
+		rejectDuplicateISBN: opts.RejectDuplicateISBN,
+		log:                 opts.Logger,
 	}, nil
 }
 
-// Close closes the database.
-func (db *mongoDB) Close() {
+// listIndexes back the title ordering of the book lists and their filtering
+// by creator.
+var listIndexes = []mgo.Index{
+	titleIndex,
+	{Key: []string{"createdby_id"}},
+}
+
+// ensureListIndexes creates listIndexes on c so that lists are not sorted in
+// memory even before Migrate runs. Failures are only logged, since the
+// indexes speed up queries without being needed for them to work.
+func ensureListIndexes(c *mgo.Collection, logger Logger) {
+	for _, idx := range listIndexes {
+		if err := c.EnsureIndex(idx); err != nil {
+			logger.Errorf("mongo: could not create %v index: %v", idx.Key, err)
+		}
+	}
+}
+
+// mongoDialInfo parses addr as accepted by NewMongoDB and applies the read
+// preference of opts to it.
+func mongoDialInfo(addr string, opts MongoOptions) (*mgo.DialInfo, error) {
+	info, err := mgo.ParseURL(addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse address: %v", err)
+	}
+	if opts.ReadPreference != "" {
+		mode, ok := readPreferences[opts.ReadPreference]
+		if !ok {
+			return nil, fmt.Errorf("unknown read preference %q", opts.ReadPreference)
+		}
+		info.ReadPreference = &mgo.ReadPreference{Mode: mode}
+	}
+	return info, nil
+}
+
+// mongoSafe returns the safe mode requiring the write concern of
+// MongoOptions, or nil to keep the driver's default.
+func mongoSafe(writeConcern string) (*mgo.Safe, error) {
+	if writeConcern == "" {
+		return nil, nil
+	}
+	if writeConcern == "majority" {
+		return &mgo.Safe{WMode: "majority"}, nil
+	}
+	w, err := strconv.Atoi(writeConcern)
+	if err != nil || w < 1 {
+		return nil, fmt.Errorf("bad write concern %q: must be majority or a positive number", writeConcern)
+	}
+	return &mgo.Safe{W: w}, nil
+}
+
+// titleIndex backs sorting by title. Queries only use an index for sorting if
+// it has the same collation as they do, so it is named after it to tell it
+// apart from an index on title without one.
+var titleIndex = mgo.Index{
+	Key:       []string{"title"},
+	Name:      "title_en_1",
+	Collation: titleCollation,
+}
+
+// searchIndex is the text index backing SearchBooks.
+var searchIndex = mgo.Index{
+	Key: []string{"$text:title", "$text:author", "$text:description"},
+}
+
+// isbnIndex keeps ISBNs unique when duplicates are rejected. Books without
+// an ISBN store an empty one, which a sparse index would still cover, so a
+// partial index leaves them out instead.
+var isbnIndex = mgo.Index{
+	Key:           []string{"isbn"},
+	Unique:        true,
+	PartialFilter: bson.M{"isbn": bson.M{"$gt": ""}},
+}
+
+// mongoIndexes are the indexes Migrate creates, keyed by a description for
+// error messages. Indexing the tags array makes it a multikey index, with one
+// entry per tag.
+var mongoIndexes = []struct {
+	name  string
+	index mgo.Index
+}{
+	{"text", searchIndex},
+	{"tags", mgo.Index{Key: []string{"tags"}}},
+	{"id", mgo.Index{Key: []string{"id"}}},
+	{"author", mgo.Index{Key: []string{"author"}}},
+	{"title", titleIndex},
+	{"createdby_id", mgo.Index{Key: []string{"createdby_id"}}},
+	{"language", mgo.Index{Key: []string{"language"}}},
+	{"genre", mgo.Index{Key: []string{"genre"}}},
+	{"status", mgo.Index{Key: []string{"status"}}},
+	{"page_count", mgo.Index{Key: []string{"page_count"}}},
+	{"created_at", mgo.Index{Key: []string{"created_at"}}},
+	{"updated_at", mgo.Index{Key: []string{"updated_at"}}},
+}
+
+// Migrate creates the indexes that do not exist yet, including the unique
+// ISBN index if duplicate ISBNs are rejected.
+func (db *mongoDB) Migrate(ctx context.Context) error {
+	return db.run(ctx, func(c *mgo.Collection) error {
+		for _, idx := range mongoIndexes {
+			if err := c.EnsureIndex(idx.index); err != nil {
+				return fmt.Errorf("mongodb: could not create %s index: %w", idx.name, err)
+			}
+		}
+		if db.rejectDuplicateISBN {
+			if err := c.EnsureIndex(isbnIndex); err != nil {
+				return fmt.Errorf("mongodb: could not create isbn index: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// Reindex drops the indexes Migrate creates and creates them again. Books
+// are neither sorted by an index nor kept to unique ISBNs by it in between.
+func (db *mongoDB) Reindex(ctx context.Context) error {
+	indexes := make([]mgo.Index, 0, len(mongoIndexes)+1)
+	for _, idx := range mongoIndexes {
+		indexes = append(indexes, idx.index)
+	}
+	if db.rejectDuplicateISBN {
+		indexes = append(indexes, isbnIndex)
+	}
+
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		for _, idx := range indexes {
+			var err error
+			if idx.Name != "" {
+				err = c.DropIndexName(idx.Name)
+			} else {
+				err = c.DropIndex(idx.Key...)
+			}
+			if err != nil && !isIndexNotFound(err) {
+				return fmt.Errorf("mongodb: could not drop %v index: %w", idx.Key, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return db.Migrate(ctx)
+}
+
+// isIndexNotFound reports whether err is the server's answer to dropping an
+// index that does not exist.
+func isIndexNotFound(err error) bool {
+	var qerr *mgo.QueryError
+	if errors.As(err, &qerr) && qerr.Code == 27 {
+		return true
+	}
+	return strings.Contains(err.Error(), "index not found")
+}
+
+// Close closes the database. mgo tears the session down without reporting
+// failures, so there is never an error to return.
+func (db *mongoDB) Close() error {
+	db.feed.close()
 	db.conn.Close()
+	return nil
+}
+
+// Subscribe returns a channel receiving every book added from now on. Books
+// are read from a change stream, so that those added by other processes are
+// seen too. Standalone servers have no change streams, so it falls back to
+// the books added through this mongoDB.
+func (db *mongoDB) Subscribe() (<-chan *Book, func()) {
+	s := db.conn.Copy()
+	stream, err := db.c.With(s).Watch([]bson.M{{"$match": bson.M{"operationType": "insert"}}},
+		mgo.ChangeStreamOptions{MaxAwaitTimeMS: time.Second})
+	if err != nil {
+		s.Close()
+		return db.feed.Subscribe()
+	}
+
+	ch := make(chan *Book, feedBuffer)
+	done := make(chan struct{})
+	go func() {
+		defer close(ch)
+		defer s.Close()
+		defer stream.Close()
+
+		for {
+			var event struct {
+				Book *Book `bson:"fullDocument"`
+			}
+			// Next gives up after MaxAwaitTimeMS without error, giving a
+			// chance to notice unsubscribing.
+			if stream.Next(&event) {
+				select {
+				case ch <- event.Book:
+				case <-done:
+					return
+				}
+				continue
+			}
+			if err := stream.Err(); err != nil {
+				db.log.Errorf("mongodb: change stream failed: %v", err)
+				return
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return ch, func() { once.Do(func() { close(done) }) }
+}
+
+// WithTransaction returns ErrTransactionsUnsupported without running fn.
+// Multi-document transactions need sessions, which the mgo driver does not
+// support, and running fn as is would keep the changes it makes before
+// failing.
+func (db *mongoDB) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return ErrTransactionsUnsupported
+}
+
+// Name returns "mongodb".
+func (db *mongoDB) Name() string {
+	return "mongodb"
+}
+
+// Ping checks that the Mongo server can be reached.
+func (db *mongoDB) Ping(ctx context.Context) error {
+	return db.run(ctx, func(c *mgo.Collection) error {
+		return c.Database.Session.Ping()
+	})
+}
+
+// run calls fn with the books collection bound to a copy of the session.
+// mgo has no notion of a context, so if ctx is done before fn returns the
+// session copy is closed, aborting whatever fn has in flight.
+func (db *mongoDB) run(ctx context.Context, fn func(c *mgo.Collection) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s := db.conn.Copy()
+	defer s.Close()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(db.c.With(s))
+	}()
+
+	select {
+	case err := <-done:
+		db.log.Debugf("mongodb: operation on %s took %v, err: %v", db.c.FullName, time.Since(start), err)
+		return unavailable(err)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// unavailable wraps err with ErrDatabaseUnavailable if it comes from failing
+// to reach the server.
+func unavailable(err error) error {
+	if isTransient(err) && !errors.Is(err, ErrDatabaseUnavailable) {
+		return fmt.Errorf("%w: %v", ErrDatabaseUnavailable, err)
+	}
+	return err
 }
 
 // GetBook retrieves a book by its ID.
-func (db *mongoDB) GetBook(id int64) (*Book, error) {
+func (db *mongoDB) GetBook(ctx context.Context, id int64) (*Book, error) {
 	b := &Book{}
-	if err := db.c.Find(bson.D{{Name: "id", Value: id}}).One(b); err != nil {
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(live(bson.M{"id": id})).One(b)
+	})
+	if err == mgo.ErrNotFound {
+		return nil, ErrBookNotFound
+	}
+	if err != nil {
 		return nil, err
 	}
 	return b, nil
 }
 
-var maxRand = big.NewInt(1<<63 - 1)
+// RandomBook retrieves a published book picked at random with $sample.
+func (db *mongoDB) RandomBook(ctx context.Context) (*Book, error) {
+	b := &Book{}
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Pipe([]bson.M{
+			{"$match": published(nil)},
+			{"$sample": bson.M{"size": 1}},
+		}).One(b)
+	})
+	if err == mgo.ErrNotFound {
+		return nil, ErrBookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mongodb: could not pick a book: %w", err)
+	}
+	return b, nil
+}
+
+// BookExists reports whether there is a book with the given ID. The count is
+// answered from the id index, without reading the book's document.
+func (db *mongoDB) BookExists(ctx context.Context, id int64) (bool, error) {
+	var n int
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		var err error
+		n, err = c.Find(live(bson.M{"id": id})).Select(bson.M{"_id": 1}).Limit(1).Count()
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("mongodb: could not look up book: %w", err)
+	}
+	return n > 0, nil
+}
 
-// randomID returns a positive number that fits within an int64.
-func randomID() (int64, error) {
-	// Get a random number within the range [0, 1<<63-1)
-	n, err := rand.Int(rand.Reader, maxRand)
+// GetBooks retrieves the books with the given IDs in a single query, in the
+// same order.
+func (db *mongoDB) GetBooks(ctx context.Context, ids []int64) ([]*Book, error) {
+	if len(ids) == 0 {
+		return []*Book{}, nil
+	}
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(live(bson.M{"id": bson.M{"$in": ids}})).All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inIDOrder(result, ids), nil
+}
+
+// countersCollection holds, for each collection of books in the database, a
+// document whose seq is the last ID assigned to a book in it.
+const countersCollection = "counters"
+
+// allocateIDs reserves n consecutive IDs and returns the first of them. The
+// counter is incremented with a single findAndModify, so concurrent callers,
+// even in other processes, never get the same IDs.
+func (db *mongoDB) allocateIDs(ctx context.Context, n int) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		_, err := c.Database.C(countersCollection).FindId(c.Name).Apply(mgo.Change{
+			Update:    bson.M{"$inc": bson.M{"seq": int64(n)}},
+			Upsert:    true,
+			ReturnNew: true,
+		}, &counter)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
-	// Don't assign 0.
-	return n.Int64() + 1, nil
+	return counter.Seq - int64(n) + 1, nil
 }
 
-// AddBook saves a given book, assigning it a new ID.
-func (db *mongoDB) AddBook(b *Book) (id int64, err error) {
-	id, err = randomID()
+// AddBook saves a given book, assigning it the next ID of the collection's
+// counter, and returns that ID.
+func (db *mongoDB) AddBook(ctx context.Context, b *Book) (id int64, err error) {
+	if err := b.Validate(); err != nil {
+		return 0, err
+	}
+
+	if db.rejectDuplicateISBN && b.ISBN != "" {
+		if existing, err := db.checkISBN(ctx, b.ISBN); err != nil {
+			return existing, err
+		}
+	}
+
+	id, err = db.allocateIDs(ctx, 1)
 	if err != nil {
-		return 0, fmt.Errorf("mongodb: could not assign a new ID: %v", err)
+		return 0, fmt.Errorf("mongodb: could not assign a new ID: %w", err)
 	}
 
+	now := time.Now()
 	b.ID = id
-	if err := db.c.Insert(b); err != nil {
-		return 0, fmt.Errorf("mongodb: could not add book: %v", err)
+	b.Version = 1
+	b.defaultStatus()
+	b.CreatedAt, b.UpdatedAt = now, now
+	b.DeletedAt = nil
+	err = db.run(ctx, func(c *mgo.Collection) error {
+		return c.Insert(b)
+	})
+	if mgo.IsDup(err) {
+		// The unique isbn index is the only one a book can break: another
+		// book with the same ISBN was added since checkISBN, or the index
+		// is left from when duplicates were rejected.
+		if existing, err := db.checkISBN(ctx, b.ISBN); err != nil {
+			return existing, err
+		}
+		return 0, fmt.Errorf("mongodb: could not add book: %w", ErrDuplicateISBN)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("mongodb: could not add book: %w", err)
 	}
+	db.feed.publish(b)
 	return id, nil
 }
 
-// DeleteBook removes a given book by its ID.
-func (db *mongoDB) DeleteBook(id int64) error {
-	return db.c.Remove(bson.D{{Name: "id", Value: id}})
+// checkISBN returns the ID of the book stored with the given ISBN along with
+// ErrDuplicateISBN, or no error if there is no such book. Deleted books keep
+// their ISBN until they are purged.
+func (db *mongoDB) checkISBN(ctx context.Context, isbn string) (int64, error) {
+	var b Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(bson.M{"isbn": isbn}).Select(bson.M{"id": 1}).One(&b)
+	})
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("mongodb: could not look up isbn: %w", err)
+	}
+	return b.ID, ErrDuplicateISBN
+}
+
+// AddBooks saves the given books in a single bulk insert, assigning them
+// consecutive IDs reserved at once from the collection's counter. Every
+// book is validated before anything is written, but MongoDB cannot roll back
+// a bulk insert that fails part way: books before the failing one remain
+// saved.
+func (db *mongoDB) AddBooks(ctx context.Context, books []*Book) ([]int64, error) {
+	for _, b := range books {
+		if err := b.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(books) == 0 {
+		return []int64{}, nil
+	}
+	first, err := db.allocateIDs(ctx, len(books))
+	if err != nil {
+		return nil, fmt.Errorf("mongodb: could not assign new IDs: %w", err)
+	}
+
+	now := time.Now()
+	ids := make([]int64, len(books))
+	docs := make([]interface{}, len(books))
+	for i, b := range books {
+		id := first + int64(i)
+		b.ID = id
+		b.Version = 1
+		b.defaultStatus()
+		b.CreatedAt, b.UpdatedAt = now, now
+		b.DeletedAt = nil
+		ids[i] = id
+		docs[i] = b
+	}
+
+	err = db.run(ctx, func(c *mgo.Collection) error {
+		bulk := c.Bulk()
+		bulk.Insert(docs...)
+		_, err := bulk.Run()
+		return err
+	})
+	if mgo.IsDup(err) {
+		return nil, fmt.Errorf("mongodb: could not add books: %w", ErrDuplicateISBN)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mongodb: could not add books: %w", err)
+	}
+	db.feed.publish(books...)
+	return ids, nil
+}
+
+// AddBooksBestEffort saves the given books one at a time, skipping the
+// invalid ones and those whose ISBN is taken.
+func (db *mongoDB) AddBooksBestEffort(ctx context.Context, books []*Book) ([]int64, []BookError, error) {
+	return addEach(ctx, books, db.AddBook)
+}
+
+// DeleteBook marks a given book as deleted by its ID.
+func (db *mongoDB) DeleteBook(ctx context.Context, id int64) error {
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Update(live(bson.M{"id": id}), bson.M{"$set": bson.M{"deleted_at": time.Now()}})
+	})
+	if err == mgo.ErrNotFound {
+		return ErrBookNotFound
+	}
+	return err
+}
+
+// DeleteBookDryRun returns the book DeleteBook would delete given its ID.
+func (db *mongoDB) DeleteBookDryRun(ctx context.Context, id int64) (*Book, error) {
+	return db.GetBook(ctx, id)
+}
+
+// DeleteBooks marks the books with the given IDs as deleted in a single
+// update, returning how many were.
+func (db *mongoDB) DeleteBooks(ctx context.Context, ids []int64) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	var info *mgo.ChangeInfo
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		var err error
+		info, err = c.UpdateAll(live(bson.M{"id": bson.M{"$in": ids}}),
+			bson.M{"$set": bson.M{"deleted_at": time.Now()}})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("mongodb: could not delete books: %w", err)
+	}
+	return info.Updated, nil
+}
+
+// RestoreBook brings back a deleted book by its ID.
+func (db *mongoDB) RestoreBook(ctx context.Context, id int64) error {
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Update(bson.M{"id": id, "deleted_at": bson.M{"$ne": nil}},
+			bson.M{"$unset": bson.M{"deleted_at": ""}})
+	})
+	if err == mgo.ErrNotFound {
+		return ErrBookNotFound
+	}
+	return err
+}
+
+// PurgeDeleted permanently removes the books deleted more than olderThan ago.
+func (db *mongoDB) PurgeDeleted(ctx context.Context, olderThan time.Duration) (int, error) {
+	var info *mgo.ChangeInfo
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		var err error
+		info, err = c.RemoveAll(bson.M{"deleted_at": bson.M{"$lte": time.Now().Add(-olderThan)}})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return info.Removed, nil
+}
+
+// DeleteAllBooks permanently removes every book. Their reviews are stored
+// with them, and so go too. The ID counter is kept, so that IDs are never
+// reused.
+func (db *mongoDB) DeleteAllBooks(ctx context.Context) (int, error) {
+	var info *mgo.ChangeInfo
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		var err error
+		info, err = c.RemoveAll(bson.M{})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("mongodb: could not delete books: %w", err)
+	}
+	return info.Removed, nil
+}
+
+// live narrows the selector sel down to books that have not been deleted.
+func live(sel bson.M) bson.M {
+	if sel == nil {
+		sel = bson.M{}
+	}
+	sel["deleted_at"] = nil
+	return sel
+}
+
+// published narrows the selector sel down to the published books that have
+// not been deleted, which are the only ones listings, searches and counts
+// see.
+func published(sel bson.M) bson.M {
+	sel = live(sel)
+	sel["status"] = withStatus(StatusPublished)["status"]
+	return sel
 }
 
 // UpdateBook updates the entry for a given book.
-func (db *mongoDB) UpdateBook(b *Book) error {
-	return db.c.Update(bson.D{{Name: "id", Value: b.ID}}, b)
+func (db *mongoDB) UpdateBook(ctx context.Context, b *Book) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	b.defaultStatus()
+	update, err := setExcept(b, "createdby_id", "createdby", "created_at", "deleted_at", "version")
+	if err != nil {
+		return fmt.Errorf("mongodb: could not encode book: %v", err)
+	}
+	now := time.Now()
+	update["$set"].(bson.M)["updated_at"] = now
+	update["$inc"] = bson.M{"version": 1}
+	if len(b.Metadata) == 0 {
+		// Empty metadata is left out of $set, so it has to be removed for
+		// the update to clear it, as the other backends do.
+		update["$unset"] = bson.M{"metadata": ""}
+	}
+	err = db.run(ctx, func(c *mgo.Collection) error {
+		return c.Update(live(bson.M{"id": b.ID, "version": versionMatch(b.Version)}), update)
+	})
+	if err == mgo.ErrNotFound {
+		return conflictOrNotFound(ctx, db, b.ID)
+	}
+	if mgo.IsDup(err) {
+		return fmt.Errorf("mongodb: could not update book: %w", ErrDuplicateISBN)
+	}
+	if err != nil {
+		return err
+	}
+	b.Version++
+	b.UpdatedAt = now
+	return nil
+}
+
+// versionMatch returns a selector value matching the stored version v. Books
+// saved before versions were introduced have none, which counts as 0.
+func versionMatch(v int64) interface{} {
+	if v == 0 {
+		return bson.M{"$in": []interface{}{0, nil}}
+	}
+	return v
 }
 
-// ListBooks returns a list of books, ordered by title.
-func (db *mongoDB) ListBooks() ([]*Book, error) {
+// PublishBook sets the status of a given book to published.
+func (db *mongoDB) PublishBook(ctx context.Context, id int64) error {
+	return db.UpdateBookFields(ctx, id, map[string]interface{}{"status": StatusPublished})
+}
+
+// SetBookTags replaces the tags of a given book.
+func (db *mongoDB) SetBookTags(ctx context.Context, id int64, tags []string) error {
+	return db.UpdateBookFields(ctx, id, map[string]interface{}{"tags": normalizeTags(tags)})
+}
+
+// UpdateBookFields changes only the given fields of a book.
+func (db *mongoDB) UpdateBookFields(ctx context.Context, id int64, fields map[string]interface{}) error {
+	b, err := db.GetBook(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := applyFields(b, fields); err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	b.UpdatedAt = time.Now()
+	update, err := setOnly(b, append(fieldNames(fields), "updated_at")...)
+	if err != nil {
+		return fmt.Errorf("mongodb: could not encode book: %v", err)
+	}
+	update["$inc"] = bson.M{"version": 1}
+	err = db.run(ctx, func(c *mgo.Collection) error {
+		return c.Update(live(bson.M{"id": id}), update)
+	})
+	if err == mgo.ErrNotFound {
+		return ErrBookNotFound
+	}
+	if mgo.IsDup(err) {
+		return fmt.Errorf("mongodb: could not update book: %w", ErrDuplicateISBN)
+	}
+	return err
+}
+
+// UpdateBooksWhere sets the fields of set on every book whose fields have the
+// values of filter, returning how many books matched.
+func (db *mongoDB) UpdateBooksWhere(ctx context.Context, filter, set map[string]interface{}) (int, error) {
+	b, err := bulkUpdate(filter, set)
+	if err != nil {
+		return 0, err
+	}
+
+	b.UpdatedAt = time.Now()
+	update, err := setOnly(b, append(fieldNames(set), "updated_at")...)
+	if err != nil {
+		return 0, fmt.Errorf("mongodb: could not encode book: %v", err)
+	}
+	update["$inc"] = bson.M{"version": 1}
+	sel := bson.M{}
+	for k, v := range filter {
+		sel[k] = v
+	}
+	var info *mgo.ChangeInfo
+	err = db.run(ctx, func(c *mgo.Collection) error {
+		var err error
+		info, err = c.UpdateAll(live(sel), update)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("mongodb: could not update books: %w", err)
+	}
+	return info.Matched, nil
+}
+
+// RenameAuthor changes the author of every book written by from to to.
+func (db *mongoDB) RenameAuthor(ctx context.Context, from, to string) (int, error) {
+	if from == to {
+		return 0, nil
+	}
+	return db.UpdateBooksWhere(ctx, map[string]interface{}{"author": from}, map[string]interface{}{"author": to})
+}
+
+// setOnly returns a $set update document assigning the fields of b with the
+// given keys.
+func setOnly(b *Book, keys ...string) (bson.M, error) {
+	update, err := setExcept(b)
+	if err != nil {
+		return nil, err
+	}
+	fields := update["$set"].(bson.M)
+	only := make(bson.M, len(keys))
+	for _, k := range keys {
+		only[k] = fields[k]
+	}
+	return bson.M{"$set": only}, nil
+}
+
+// setExcept returns a $set update document assigning every field of b other
+// than the given keys.
+func setExcept(b *Book, keys ...string) (bson.M, error) {
+	raw, err := bson.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	fields := bson.M{}
+	if err := bson.Unmarshal(raw, fields); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		delete(fields, k)
+	}
+	return bson.M{"$set": fields}, nil
+}
+
+// AddReview adds a review to the book with the given ID. Reviews are
+// embedded in the book's document, which Book does not decode.
+func (db *mongoDB) AddReview(ctx context.Context, bookID int64, r *Review) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	r.CreatedAt = time.Now()
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Update(live(bson.M{"id": bookID}), bson.M{"$push": bson.M{"reviews": r}})
+	})
+	if err == mgo.ErrNotFound {
+		return ErrBookNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("mongodb: could not add review: %w", err)
+	}
+	return nil
+}
+
+// ListReviews returns the reviews of the book with the given ID, in the order
+// they were added.
+func (db *mongoDB) ListReviews(ctx context.Context, bookID int64) ([]*Review, error) {
+	var doc struct {
+		Reviews []*Review `bson:"reviews"`
+	}
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(live(bson.M{"id": bookID})).Select(bson.M{"reviews": 1}).One(&doc)
+	})
+	if err == mgo.ErrNotFound {
+		return nil, ErrBookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if doc.Reviews == nil {
+		doc.Reviews = []*Review{}
+	}
+	return doc.Reviews, nil
+}
+
+// ListReviewsPaged returns at most limit reviews of the book with the given
+// ID, skipping the first offset of them, along with the total number of its
+// reviews. Only the requested reviews are read, using a $slice projection.
+func (db *mongoDB) ListReviewsPaged(ctx context.Context, bookID int64, limit, offset int) ([]*Review, int, error) {
+	if limit <= 0 {
+		limit = math.MaxInt32
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var (
+		doc struct {
+			Reviews []*Review `bson:"reviews"`
+		}
+		count struct {
+			Total int `bson:"total"`
+		}
+	)
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		q := c.Find(live(bson.M{"id": bookID})).
+			Select(bson.M{"reviews": bson.M{"$slice": []int{offset, limit}}})
+		if err := q.One(&doc); err != nil {
+			return err
+		}
+		return c.Pipe([]bson.M{
+			{"$match": live(bson.M{"id": bookID})},
+			{"$project": bson.M{"total": bson.M{"$size": bson.M{"$ifNull": []interface{}{"$reviews", []interface{}{}}}}}},
+		}).One(&count)
+	})
+	if err == mgo.ErrNotFound {
+		return nil, 0, ErrBookNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if doc.Reviews == nil {
+		doc.Reviews = []*Review{}
+	}
+	return doc.Reviews, count.Total, nil
+}
+
+// withStatus returns a selector matching books with the given status. Books
+// saved before statuses were introduced have none and count as published.
+func withStatus(status string) bson.M {
+	if status == StatusDraft {
+		return bson.M{"status": StatusDraft}
+	}
+	return bson.M{"status": bson.M{"$ne": StatusDraft}}
+}
+
+// ListBooks returns a list of the published books, ordered by title.
+func (db *mongoDB) ListBooks(ctx context.Context) ([]*Book, error) {
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(published(nil)).Sort("title").Collation(titleCollation).All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListBooksPaged returns at most limit published books, ordered by title,
+// skipping the first offset of them, along with the total number of
+// published books.
+func (db *mongoDB) ListBooksPaged(ctx context.Context, limit, offset int) ([]*Book, int, error) {
+	if limit < 0 {
+		limit = 0
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var (
+		result []*Book
+		total  int
+	)
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		var err error
+		if total, err = c.Find(published(nil)).Count(); err != nil {
+			return err
+		}
+		return c.Find(published(nil)).Sort("title").Collation(titleCollation).Skip(offset).Limit(limit).All(&result)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return result, total, nil
+}
+
+// ListBooksAfter returns at most limit books with an ID greater than afterID,
+// ordered by ID.
+func (db *mongoDB) ListBooksAfter(ctx context.Context, afterID int64, limit int) ([]*Book, error) {
+	if limit < 0 {
+		limit = 0
+	}
+
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(published(bson.M{"id": bson.M{"$gt": afterID}})).Sort("id").Limit(limit).All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListBooksSorted returns a list of books ordered by the given field.
+func (db *mongoDB) ListBooksSorted(ctx context.Context, field string, descending bool) ([]*Book, error) {
+	if _, ok := sortFields[field]; !ok {
+		return nil, ErrInvalidSortField
+	}
+	var collation *mgo.Collation
+	if field == "title" {
+		collation = titleCollation
+	}
+	if descending {
+		field = "-" + field
+	}
+
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(published(nil)).Sort(field, "id").Collation(collation).All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListBooksByAuthor returns a list of books, ordered by title, written by the
+// given author.
+func (db *mongoDB) ListBooksByAuthor(ctx context.Context, author string) ([]*Book, error) {
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(published(bson.M{"author": author})).Sort("title").Collation(titleCollation).All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListBooksByStatus returns a list of books, ordered by title, with the given
+// status.
+func (db *mongoDB) ListBooksByStatus(ctx context.Context, status string) ([]*Book, error) {
+	if err := checkStatus(status); err != nil {
+		return nil, err
+	}
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(live(withStatus(status))).Sort("title").Collation(titleCollation).All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListBooksByTag returns a list of books, ordered by title, that carry the
+// given tag.
+func (db *mongoDB) ListBooksByTag(ctx context.Context, tag string) ([]*Book, error) {
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(published(bson.M{"tags": tag})).Sort("title").Collation(titleCollation).All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListBooksByTags returns a list of books, ordered by title, that carry all
+// or any of the given tags.
+func (db *mongoDB) ListBooksByTags(ctx context.Context, tags []string, matchAll bool) ([]*Book, error) {
+	if len(tags) == 0 {
+		return []*Book{}, nil
+	}
+	op := "$in"
+	if matchAll {
+		op = "$all"
+	}
+
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(published(bson.M{"tags": bson.M{op: tags}})).Sort("title").Collation(titleCollation).All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListBooksByYear returns a list of books, ordered by title, published in the
+// given year.
+func (db *mongoDB) ListBooksByYear(ctx context.Context, year int) ([]*Book, error) {
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		sel := published(bson.M{"published_date": bson.RegEx{Pattern: yearPattern(year)}})
+		return c.Find(sel).Sort("title").Collation(titleCollation).All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keepPublishedIn(result, year), nil
+}
+
+// ListBooksBetweenDates returns a list of books, ordered by published date,
+// published between start and end.
+func (db *mongoDB) ListBooksBetweenDates(ctx context.Context, start, end time.Time) ([]*Book, error) {
+	// Strings compare bytewise, so a date in any of the layouts sorts after
+	// its year and no later than the last day of it.
+	from, to := publishedYearBounds(start, end)
+	bounds := bson.M{"$type": "string"}
+	if from != "" {
+		bounds["$gte"] = from
+	}
+	if to != "" {
+		bounds["$lte"] = to + "-12-31"
+	}
+
 	var result []*Book
-	if err := db.c.Find(nil).Sort("title").All(&result); err != nil {
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(published(bson.M{"published_date": bounds})).Sort("title").Collation(titleCollation).All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keepPublishedBetween(result, start, end), nil
+}
+
+// ListBooksByLanguage returns a list of books, ordered by title, written in the
+// given language.
+func (db *mongoDB) ListBooksByLanguage(ctx context.Context, lang string) ([]*Book, error) {
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(published(bson.M{"language": lang})).Sort("title").Collation(titleCollation).All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListBooksByGenre returns a list of books, ordered by title, of the given
+// genre.
+func (db *mongoDB) ListBooksByGenre(ctx context.Context, genre string) ([]*Book, error) {
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(published(bson.M{"genre": genre})).Sort("title").Collation(titleCollation).All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListBooksByMetadata returns a list of books, ordered by title, whose
+// metadata maps key to value. The key is checked as by Validate before it
+// becomes part of a field path.
+func (db *mongoDB) ListBooksByMetadata(ctx context.Context, key, value string) ([]*Book, error) {
+	if err := validateMetadataKey(key); err != nil {
+		return nil, ValidationError{{Field: "metadata", Err: err}}
+	}
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(published(bson.M{"metadata." + key: value})).Sort("title").Collation(titleCollation).All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListBooksByPageRange returns a list of books, ordered by title, with between
+// min and max pages inclusive.
+func (db *mongoDB) ListBooksByPageRange(ctx context.Context, min, max int) ([]*Book, error) {
+	pages := bson.M{"$gte": min}
+	if max > 0 {
+		pages["$lte"] = max
+	}
+
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(published(bson.M{"page_count": pages})).Sort("title").Collation(titleCollation).All(&result)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
 // ListBooksCreatedBy returns a list of books, ordered by title, filtered by
-// the user who created the book entry.
-func (db *mongoDB) ListBooksCreatedBy(userID string) ([]*Book, error) {
+// the user who created the book entry, drafts included.
+func (db *mongoDB) ListBooksCreatedBy(ctx context.Context, userID string) ([]*Book, error) {
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(live(bson.M{"createdby_id": userID})).Sort("title").Collation(titleCollation).All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListBooksModifiedSince returns the books added or updated at or after since,
+// ordered by UpdatedAt.
+func (db *mongoDB) ListBooksModifiedSince(ctx context.Context, since time.Time) ([]*Book, error) {
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(published(bson.M{"updated_at": bson.M{"$gte": since}})).Sort("updated_at", "id").All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListRecentBooks returns the limit most recently added books, newest first.
+func (db *mongoDB) ListRecentBooks(ctx context.Context, limit int) ([]*Book, error) {
+	if limit < 0 {
+		limit = 0
+	}
+
+	var result []*Book
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(published(nil)).Sort("-created_at", "-id").Limit(limit).All(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SearchBooks returns the books whose title, author or description match the
+// given free-text query, most relevant first.
+func (db *mongoDB) SearchBooks(ctx context.Context, query string) ([]*Book, error) {
+	return db.SearchBooksFiltered(ctx, query, nil)
+}
+
+// SearchBooksFiltered returns the books found by SearchBooks that carry any
+// of the given tags, or all of them if there are none, in a single query.
+func (db *mongoDB) SearchBooksFiltered(ctx context.Context, query string, tags []string) ([]*Book, error) {
+	sel := bson.M{"$text": bson.M{"$search": query}}
+	if len(tags) > 0 {
+		sel["tags"] = bson.M{"$in": tags}
+	}
+
 	var result []*Book
-	if err := db.c.Find(bson.D{{Name: "createdbyid", Value: userID}}).Sort("title").All(&result); err != nil {
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(published(sel)).
+			Select(bson.M{"score": bson.M{"$meta": "textScore"}}).
+			Sort("$textScore:score").
+			All(&result)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
+
+// SuggestTitles returns at most limit distinct titles, in alphabetical order,
+// that start with prefix, ignoring case. The anchored regular expression lets
+// MongoDB scan the title index rather than every book.
+func (db *mongoDB) SuggestTitles(ctx context.Context, prefix string, limit int) ([]string, error) {
+	titles := []string{}
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		sel := published(bson.M{"title": bson.RegEx{Pattern: "^" + regexp.QuoteMeta(prefix), Options: "i"}})
+		return c.Find(sel).Distinct("title", &titles)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortTitles(titles, limit), nil
+}
+
+// ForEachBook calls fn for every book, ordered by title, reading them from a
+// cursor one at a time.
+func (db *mongoDB) ForEachBook(ctx context.Context, fn func(*Book) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s := db.conn.Copy()
+	defer s.Close()
+
+	iter := db.c.With(s).Find(published(nil)).Sort("title").Collation(titleCollation).Iter()
+	for {
+		b := &Book{}
+		if !iter.Next(b) {
+			return unavailable(iter.Close())
+		}
+		err := ctx.Err()
+		if err == nil {
+			err = fn(b)
+		}
+		if err != nil {
+			iter.Close()
+			return err
+		}
+	}
+}
+
+// ListAuthors returns the distinct authors of the books, in alphabetical
+// order.
+func (db *mongoDB) ListAuthors(ctx context.Context) ([]string, error) {
+	authors := []string{}
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Find(published(bson.M{"author": bson.M{"$ne": ""}})).Distinct("author", &authors)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(authors)
+	return authors, nil
+}
+
+// AverageRatingByAuthor returns the mean rating of the books of each author.
+func (db *mongoDB) AverageRatingByAuthor(ctx context.Context) (map[string]float64, error) {
+	var groups []struct {
+		Author string  `bson:"_id"`
+		Rating float64 `bson:"rating"`
+	}
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Pipe([]bson.M{
+			{"$match": published(nil)},
+			{"$group": bson.M{"_id": "$author", "rating": bson.M{"$avg": "$rating"}}},
+		}).All(&groups)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]float64, len(groups))
+	for _, g := range groups {
+		result[g.Author] = g.Rating
+	}
+	return result, nil
+}
+
+// TopAuthors returns the limit authors with the most books, most prolific
+// first, grouping and sorting them on the server.
+func (db *mongoDB) TopAuthors(ctx context.Context, limit int) ([]AuthorCount, error) {
+	pipeline := []bson.M{
+		{"$match": published(bson.M{"author": bson.M{"$ne": ""}})},
+		{"$group": bson.M{"_id": "$author", "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.D{{Name: "count", Value: -1}, {Name: "_id", Value: 1}}},
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": limit})
+	}
+	top := []AuthorCount{}
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Pipe(pipeline).All(&top)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("mongodb: could not count books by author: %w", err)
+	}
+	return top, nil
+}
+
+// ListTitleIndex returns the number of books whose title starts with each
+// letter. The server groups titles by their first character, which are then
+// folded into letters here, since $toUpper only knows about ASCII.
+func (db *mongoDB) ListTitleIndex(ctx context.Context) (map[string]int, error) {
+	var groups []struct {
+		First string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		return c.Pipe([]bson.M{
+			{"$match": published(nil)},
+			{"$group": bson.M{
+				"_id":   bson.M{"$substrCP": []interface{}{"$title", 0, 1}},
+				"count": bson.M{"$sum": 1},
+			}},
+		}).All(&groups)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int)
+	for _, g := range groups {
+		index[indexKey(g.First)] += g.Count
+	}
+	return index, nil
+}
+
+// CountBooks returns the number of published books.
+func (db *mongoDB) CountBooks(ctx context.Context) (int64, error) {
+	return db.count(ctx, published(nil))
+}
+
+// CountBooksCreatedBy returns the number of books created by the given user.
+func (db *mongoDB) CountBooksCreatedBy(ctx context.Context, userID string) (int64, error) {
+	return db.count(ctx, live(bson.M{"createdby_id": userID}))
+}
+
+// count returns the number of books matching sel.
+func (db *mongoDB) count(ctx context.Context, sel bson.M) (int64, error) {
+	var n int
+	err := db.run(ctx, func(c *mgo.Collection) error {
+		var err error
+		n, err = c.Find(sel).Count()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("mongodb: could not count books: %w", err)
+	}
+	return int64(n), nil
+}
@@ -0,0 +1,254 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// TestMongoCanceledContext checks that calls made with a context that is
+// already done fail with its error without reaching the server.
+func TestMongoCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	db := &mongoDB{}
+	called := false
+	err := db.run(ctx, func(*mgo.Collection) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || called {
+		t.Errorf("run with a canceled context = %v, called %v; want context.Canceled, not called", err, called)
+	}
+	if _, err := db.GetBook(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetBook with a canceled context: got %v, want context.Canceled", err)
+	}
+	if _, err := db.ListBooks(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("ListBooks with a canceled context: got %v, want context.Canceled", err)
+	}
+}
+
+func TestMongoOptionsDefaults(t *testing.T) {
+	tests := []struct {
+		in, want MongoOptions
+	}{
+		{
+			MongoOptions{},
+			MongoOptions{PoolLimit: 4096, DialTimeout: 10 * time.Second, SocketTimeout: time.Minute, Database: "bookshelf", Collection: "books", Logger: defaultLogger},
+		},
+		{
+			MongoOptions{PoolLimit: -1, RejectDuplicateISBN: true},
+			MongoOptions{PoolLimit: 4096, DialTimeout: 10 * time.Second, SocketTimeout: time.Minute, Database: "bookshelf", Collection: "books", RejectDuplicateISBN: true, Logger: defaultLogger},
+		},
+		{
+			MongoOptions{PoolLimit: 8, DialTimeout: time.Second, SocketTimeout: 5 * time.Second, Database: "library", Collection: "staging_books"},
+			MongoOptions{PoolLimit: 8, DialTimeout: time.Second, SocketTimeout: 5 * time.Second, Database: "library", Collection: "staging_books", Logger: defaultLogger},
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.in.withDefaults(); got != tt.want {
+			t.Errorf("%+v.withDefaults() = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMongoSafe(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    *mgo.Safe
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"majority", &mgo.Safe{WMode: "majority"}, false},
+		{"2", &mgo.Safe{W: 2}, false},
+		{"0", nil, true},
+		{"all", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := mongoSafe(tt.in)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mongoSafe(%q) = %+v, %v; want %+v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if _, err := NewMongoDBWithOptions("127.0.0.1:1", MongoOptions{WriteConcern: "all"}); err == nil || errors.Is(err, ErrDatabaseUnavailable) {
+		t.Errorf("NewMongoDBWithOptions with a bad write concern: got %v, want it rejected before dialing", err)
+	}
+}
+
+func TestNewMongoDBWithOptionsDialTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := NewMongoDBWithOptions("127.0.0.1:1", MongoOptions{DialTimeout: 200 * time.Millisecond})
+	if !errors.Is(err, ErrDatabaseUnavailable) {
+		t.Fatalf("NewMongoDBWithOptions with nothing listening: got %v, want ErrDatabaseUnavailable", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("NewMongoDBWithOptions took %v, want it bounded by the dial timeout", d)
+	}
+}
+
+func TestVersionMatch(t *testing.T) {
+	if got, want := versionMatch(3), interface{}(int64(3)); got != want {
+		t.Errorf("versionMatch(3) = %v, want %v", got, want)
+	}
+	// Books saved before versions existed have no version field at all.
+	want := bson.M{"$in": []interface{}{0, nil}}
+	if got := versionMatch(0); !reflect.DeepEqual(got, want) {
+		t.Errorf("versionMatch(0) = %v, want %v", got, want)
+	}
+}
+
+// TestMongoRejectDuplicateISBN checks both settings of
+// MongoOptions.RejectDuplicateISBN against the Mongo server at MONGO_URL,
+// whose books collection should be empty.
+func TestMongoRejectDuplicateISBN(t *testing.T) {
+	addr := os.Getenv("MONGO_URL")
+	if addr == "" {
+		t.Skip("MONGO_URL is not set")
+	}
+	const isbn = "978-0-441-17271-9"
+
+	for _, reject := range []bool{false, true} {
+		db, err := NewMongoDBWithOptions(addr, MongoOptions{RejectDuplicateISBN: reject})
+		if err != nil {
+			t.Fatalf("NewMongoDBWithOptions: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if reject {
+			// The unique index outlives the connection; leave the
+			// collection as it was found.
+			t.Cleanup(func() { db.(*mongoDB).c.DropIndex("isbn") })
+		}
+		if err := db.Migrate(context.Background()); err != nil {
+			t.Fatalf("Migrate: %v", err)
+		}
+
+		first := mustAdd(t, db, &Book{Title: "Dune", ISBN: isbn})
+		id, err := db.AddBook(context.Background(), &Book{Title: "Dune (Reprint)", ISBN: isbn})
+		switch {
+		case !reject && err != nil:
+			t.Errorf("AddBook of a duplicate ISBN, not rejecting: %v", err)
+		case reject && (!errors.Is(err, ErrDuplicateISBN) || id != first):
+			t.Errorf("AddBook of a duplicate ISBN, rejecting = %d, %v; want %d, ErrDuplicateISBN", id, err, first)
+		}
+		if !reject && err == nil {
+			db.DeleteBook(context.Background(), id)
+			db.PurgeDeleted(context.Background(), 0)
+		}
+
+		if reject {
+			// An update breaking the unique index is refused too.
+			other := mustAdd(t, db, &Book{Title: "Dune Messiah"})
+			err := db.UpdateBookFields(context.Background(), other, map[string]interface{}{"isbn": isbn})
+			if !errors.Is(err, ErrDuplicateISBN) {
+				t.Errorf("UpdateBookFields to a duplicate ISBN: got %v, want ErrDuplicateISBN", err)
+			}
+		}
+
+		// Books without an ISBN never clash.
+		mustAdd(t, db, &Book{Title: "Emma"})
+		mustAdd(t, db, &Book{Title: "Persuasion"})
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	wrapped := unavailable(errors.New("no reachable servers"))
+	if !errors.Is(wrapped, ErrDatabaseUnavailable) {
+		t.Errorf("unavailable(no reachable servers) = %v, want it to wrap ErrDatabaseUnavailable", wrapped)
+	}
+	if again := unavailable(wrapped); again != wrapped {
+		t.Errorf("unavailable wrapped %v a second time", wrapped)
+	}
+	for _, err := range []error{nil, ErrBookNotFound, mgo.ErrNotFound, context.Canceled} {
+		if got := unavailable(err); got != err {
+			t.Errorf("unavailable(%v) = %v, want it unchanged", err, got)
+		}
+	}
+}
+
+func TestMongoDialInfo(t *testing.T) {
+	info, err := mongoDialInfo("localhost", MongoOptions{})
+	if err != nil {
+		t.Fatalf("mongoDialInfo(localhost): %v", err)
+	}
+	if want := []string{"localhost"}; !reflect.DeepEqual(info.Addrs, want) {
+		t.Errorf("mongoDialInfo(localhost): got addresses %q, want %q", info.Addrs, want)
+	}
+	if info.ReadPreference != nil && info.ReadPreference.Mode != mgo.Primary {
+		t.Errorf("mongoDialInfo(localhost): got read preference %+v, want primary", info.ReadPreference)
+	}
+
+	const url = "mongodb://reader:secret@a:27017,b:27018/library?replicaSet=rs0&maxPoolSize=7&readPreference=primary"
+	info, err = mongoDialInfo(url, MongoOptions{ReadPreference: "secondaryPreferred"})
+	if err != nil {
+		t.Fatalf("mongoDialInfo(%q): %v", url, err)
+	}
+	if want := []string{"a:27017", "b:27018"}; !reflect.DeepEqual(info.Addrs, want) {
+		t.Errorf("got addresses %q, want %q", info.Addrs, want)
+	}
+	if info.Username != "reader" || info.Password != "secret" || info.Database != "library" {
+		t.Errorf("got credentials %q:%q on %q, want reader:secret on library", info.Username, info.Password, info.Database)
+	}
+	if info.ReplicaSetName != "rs0" || info.PoolLimit != 7 {
+		t.Errorf("got replica set %q and pool limit %d, want rs0 and 7", info.ReplicaSetName, info.PoolLimit)
+	}
+	if info.ReadPreference == nil || info.ReadPreference.Mode != mgo.SecondaryPreferred {
+		t.Errorf("got read preference %+v, want the one from the options to win", info.ReadPreference)
+	}
+
+	for _, tt := range []struct {
+		addr string
+		opts MongoOptions
+	}{
+		{"localhost", MongoOptions{ReadPreference: "fastest"}},
+		{"mongodb://a/?bogusOption=1", MongoOptions{}},
+	} {
+		if _, err := mongoDialInfo(tt.addr, tt.opts); err == nil {
+			t.Errorf("mongoDialInfo(%q, %+v): got nil error", tt.addr, tt.opts)
+		}
+	}
+}
+
+// TestMongoListIndexes checks that connecting, without migrating, creates the
+// indexes book lists rely on.
+func TestMongoListIndexes(t *testing.T) {
+	addr := os.Getenv("MONGO_URL")
+	if addr == "" {
+		t.Skip("MONGO_URL is not set")
+	}
+	db, err := NewMongoDBNamed(addr, "bookshelf_test", fmt.Sprintf("books_%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatalf("NewMongoDBNamed: %v", err)
+	}
+	c := db.(*mongoDB).c
+	t.Cleanup(func() {
+		c.DropCollection()
+		db.Close()
+	})
+
+	indexes, err := c.Indexes()
+	if err != nil {
+		t.Fatalf("Indexes: %v", err)
+	}
+	found := make(map[string]bool)
+	for _, idx := range indexes {
+		found[strings.Join(idx.Key, ",")] = true
+	}
+	for _, key := range []string{"title", "createdby_id"} {
+		if !found[key] {
+			t.Errorf("no index on %s after connecting; indexes: %v", key, indexes)
+		}
+	}
+}
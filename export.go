@@ -0,0 +1,116 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// exportVersion is the version of the format written by ExportAll.
+const exportVersion = 1
+
+// ErrUnsupportedExport is returned by ImportAll when the dump is not in a
+// format it understands.
+var ErrUnsupportedExport = errors.New("bookshelf: unsupported export version")
+
+// exportedBook is a book as written by ExportAll, along with its reviews.
+type exportedBook struct {
+	*Book
+	Reviews []*Review `json:"reviews"`
+}
+
+// export is the document written by ExportAll.
+type export struct {
+	Version int             `json:"version"`
+	Books   []*exportedBook `json:"books"`
+}
+
+// ExportAll writes every book of db, with its reviews, to w as a single JSON
+// document of the form {"version":1,"books":[...]}, which ImportAll reads
+// back. Published books are written one at a time, ordered by title, so that
+// they are never all held in memory, and drafts after them.
+func ExportAll(ctx context.Context, db BookDatabase, w io.Writer) error {
+	if _, err := fmt.Fprintf(w, `{"version":%d,"books":[`, exportVersion); err != nil {
+		return err
+	}
+	first := true
+	write := func(b *Book) error {
+		reviews, err := db.ListReviews(ctx, b.ID)
+		if err != nil {
+			return err
+		}
+		if reviews == nil {
+			reviews = []*Review{}
+		}
+		body, err := json.Marshal(exportedBook{b, reviews})
+		if err != nil {
+			return err
+		}
+		if !first {
+			body = append([]byte{','}, body...)
+		}
+		first = false
+		_, err = w.Write(body)
+		return err
+	}
+	err := db.ForEachBook(ctx, write)
+	if err == nil {
+		// ForEachBook skips drafts, which are rarely many.
+		var drafts []*Book
+		drafts, err = db.ListBooksByStatus(ctx, StatusDraft)
+		for i := 0; err == nil && i < len(drafts); i++ {
+			err = write(drafts[i])
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("bookshelf: could not export books: %w", err)
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// ImportAll adds the books and reviews of a document written by ExportAll to
+// db, in a single transaction where db supports them. Books are assigned new
+// IDs, versions and timestamps, as by AddBooks, and reviews new timestamps.
+func ImportAll(ctx context.Context, db BookDatabase, r io.Reader) error {
+	var doc export
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return fmt.Errorf("bookshelf: could not read export: %v", err)
+	}
+	if doc.Version != exportVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedExport, doc.Version)
+	}
+
+	books := make([]*Book, len(doc.Books))
+	for i, e := range doc.Books {
+		if e.Book == nil {
+			e.Book = &Book{}
+		}
+		books[i] = e.Book
+	}
+	add := func(ctx context.Context) error {
+		if _, err := db.AddBooks(ctx, books); err != nil {
+			return err
+		}
+		for _, e := range doc.Books {
+			for _, r := range e.Reviews {
+				if err := db.AddReview(ctx, e.ID, r); err != nil {
+					return fmt.Errorf("bookshelf: could not import reviews of %q: %w", e.Title, err)
+				}
+			}
+		}
+		return nil
+	}
+	err := db.WithTransaction(ctx, add)
+	if errors.Is(err, ErrTransactionsUnsupported) {
+		// Without transactions, whatever was added before a failure stays.
+		err = add(ctx)
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportImportAll(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemoryDB()
+	id, err := src.AddBook(ctx, &Book{Title: "Dune", Author: "Frank Herbert", Tags: []string{"scifi"}})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	if err := src.AddReview(ctx, id, &Review{Body: "Spice.", Rating: 5}); err != nil {
+		t.Fatalf("AddReview: %v", err)
+	}
+	if _, err := src.AddBook(ctx, &Book{Title: "Emma"}); err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	if _, err := src.AddBook(ctx, &Book{Title: "Persuasion", Status: StatusDraft}); err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportAll(ctx, src, &buf); err != nil {
+		t.Fatalf("ExportAll: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), `{"version":1,"books":[{`) {
+		t.Errorf("ExportAll wrote %.40q..., want a version 1 document", buf.String())
+	}
+
+	dst := newTestSQLiteDB(t)
+	if err := ImportAll(ctx, dst, &buf); err != nil {
+		t.Fatalf("ImportAll: %v", err)
+	}
+	books, err := dst.ListBooks(ctx)
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	if got, want := titles(books), []string{"Dune", "Emma"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("imported %q, want %q", got, want)
+	}
+	if books[0].Author != "Frank Herbert" || !reflect.DeepEqual(books[0].Tags, []string{"scifi"}) {
+		t.Errorf("imported %+v, want the author and tags of Dune", books[0])
+	}
+	reviews, err := dst.ListReviews(ctx, books[0].ID)
+	if err != nil || len(reviews) != 1 || reviews[0].Body != "Spice." {
+		t.Errorf("imported reviews of Dune = %+v, %v; want Spice.", reviews, err)
+	}
+	drafts, err := dst.ListBooksByStatus(ctx, StatusDraft)
+	if err != nil {
+		t.Fatalf("ListBooksByStatus: %v", err)
+	}
+	if got, want := titles(drafts), []string{"Persuasion"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("imported drafts %q, want %q", got, want)
+	}
+}
+
+func TestImportAllErrors(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	if err := ImportAll(ctx, db, strings.NewReader(`{"version":2,"books":[]}`)); !errors.Is(err, ErrUnsupportedExport) {
+		t.Errorf("ImportAll of version 2: got %v, want ErrUnsupportedExport", err)
+	}
+	if err := ImportAll(ctx, db, strings.NewReader(`{"version":`)); err == nil {
+		t.Errorf("ImportAll of a truncated document: got nil error")
+	}
+
+	// An invalid book rolls back the whole import.
+	const doc = `{"version":1,"books":[{"title":"Dune","reviews":[]},{"title":"","reviews":[]}]}`
+	if err := ImportAll(ctx, db, strings.NewReader(doc)); err == nil {
+		t.Errorf("ImportAll of a book without a title: got nil error")
+	}
+	if n, err := db.CountBooks(ctx); err != nil || n != 0 {
+		t.Errorf("after a failed import, CountBooks = %d, %v; want 0", n, err)
+	}
+}
+
+// noTxDB is a database without transactions, like Mongo.
+type noTxDB struct {
+	BookDatabase
+}
+
+func (noTxDB) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return ErrTransactionsUnsupported
+}
+
+func TestImportAllWithoutTransactions(t *testing.T) {
+	ctx := context.Background()
+	src := NewMemoryDB()
+	if _, err := src.AddBook(ctx, &Book{Title: "Dune"}); err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := ExportAll(ctx, src, &buf); err != nil {
+		t.Fatalf("ExportAll: %v", err)
+	}
+
+	dst := noTxDB{NewMemoryDB()}
+	if err := ImportAll(ctx, dst, &buf); err != nil {
+		t.Fatalf("ImportAll: %v", err)
+	}
+	books, err := dst.ListBooks(ctx)
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	if got, want := titles(books), []string{"Dune"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("imported %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import "sync"
+
+// feedBuffer is how many books a subscriber may fall behind by before books
+// are dropped for it.
+const feedBuffer = 64
+
+// bookFeed fans the books added to a database out to its subscribers, within
+// the process. The zero value is ready to use.
+type bookFeed struct {
+	mu     sync.Mutex
+	subs   map[chan *Book]struct{}
+	closed bool
+}
+
+// Subscribe returns a channel receiving a copy of every book published from
+// now on, and a function to unsubscribe, which closes the channel. Books are
+// dropped for subscribers that fall too far behind, rather than holding up
+// the writes.
+func (f *bookFeed) Subscribe() (<-chan *Book, func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan *Book, feedBuffer)
+	if f.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if f.subs == nil {
+		f.subs = make(map[chan *Book]struct{})
+	}
+	f.subs[ch] = struct{}{}
+	return ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if _, ok := f.subs[ch]; ok {
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish sends a copy of each of books to every subscriber.
+func (f *bookFeed) publish(books ...*Book) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for ch := range f.subs {
+		for _, b := range books {
+			select {
+			case ch <- copyBook(b):
+			default:
+			}
+		}
+	}
+}
+
+// close unsubscribes everyone, and makes later subscriptions closed from the
+// start.
+func (f *bookFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for ch := range f.subs {
+		close(ch)
+	}
+	f.subs = nil
+	f.closed = true
+}
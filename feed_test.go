@@ -0,0 +1,46 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import "testing"
+
+func TestBookFeed(t *testing.T) {
+	var f bookFeed
+	a, unsubscribeA := f.Subscribe()
+	b, unsubscribeB := f.Subscribe()
+
+	book := &Book{ID: 1, Title: "Dune"}
+	f.publish(book)
+	book.Title = "Changed"
+	for _, ch := range []<-chan *Book{a, b} {
+		if got := <-ch; got.Title != "Dune" {
+			t.Errorf("subscriber got %q, want a copy titled Dune", got.Title)
+		}
+	}
+
+	unsubscribeA()
+	unsubscribeA()
+	if _, ok := <-a; ok {
+		t.Errorf("channel still open after unsubscribing")
+	}
+
+	// A subscriber that does not keep up loses books rather than blocking
+	// publishing.
+	for i := 0; i < feedBuffer+10; i++ {
+		f.publish(&Book{Title: "Emma"})
+	}
+	if len(b) != feedBuffer {
+		t.Errorf("slow subscriber has %d books waiting, want %d", len(b), feedBuffer)
+	}
+
+	f.close()
+	for range b {
+	}
+	unsubscribeB()
+	c, _ := f.Subscribe()
+	if _, ok := <-c; ok {
+		t.Errorf("Subscribe after close: channel is open")
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import "errors"
+
+// The genres a book may belong to.
+const (
+	GenreFiction    = "fiction"
+	GenreNonfiction = "nonfiction"
+	GenreBiography  = "biography"
+	GenreScience    = "science"
+	GenreHistory    = "history"
+	GenrePoetry     = "poetry"
+	GenreChildren   = "children"
+)
+
+// genres is the set of known genres.
+var genres = map[string]bool{
+	GenreFiction:    true,
+	GenreNonfiction: true,
+	GenreBiography:  true,
+	GenreScience:    true,
+	GenreHistory:    true,
+	GenrePoetry:     true,
+	GenreChildren:   true,
+}
+
+// ErrInvalidGenre is returned when a book's genre is not one of the known
+// ones.
+var ErrInvalidGenre = errors.New("bookshelf: unknown genre")
+
+// ValidateGenre checks that the book's genre is one of the Genre constants.
+// An empty genre is valid.
+func (b *Book) ValidateGenre() error {
+	if b.Genre != "" && !genres[b.Genre] {
+		return ErrInvalidGenre
+	}
+	return nil
+}
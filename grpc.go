@@ -0,0 +1,143 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sashayakovtseva/bookshelf/bookshelfpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer implements BookService by delegating to a BookDatabase.
+type grpcServer struct {
+	bookshelfpb.UnimplementedBookServiceServer
+	db BookDatabase
+}
+
+// NewGRPCServer creates a gRPC server exposing db as the BookService defined
+// in bookshelfpb/bookshelf.proto.
+func NewGRPCServer(db BookDatabase) *grpc.Server {
+	s := grpc.NewServer()
+	bookshelfpb.RegisterBookServiceServer(s, &grpcServer{db: db})
+	return s
+}
+
+// List returns a page of books, ordered by title.
+func (s *grpcServer) List(ctx context.Context, req *bookshelfpb.ListRequest) (*bookshelfpb.ListResponse, error) {
+	books, total, err := s.db.ListBooksPaged(ctx, int(req.GetLimit()), int(req.GetOffset()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &bookshelfpb.ListResponse{Total: int32(total)}
+	for _, b := range books {
+		resp.Books = append(resp.Books, bookToProto(b))
+	}
+	return resp, nil
+}
+
+// Get retrieves a book by its ID.
+func (s *grpcServer) Get(ctx context.Context, req *bookshelfpb.GetRequest) (*bookshelfpb.Book, error) {
+	b, err := s.db.GetBook(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return bookToProto(b), nil
+}
+
+// Add saves a new book and returns it with its assigned ID.
+func (s *grpcServer) Add(ctx context.Context, req *bookshelfpb.AddRequest) (*bookshelfpb.Book, error) {
+	b := bookFromProto(req.GetBook())
+	if _, err := s.db.AddBook(ctx, b); err != nil {
+		return nil, grpcError(err)
+	}
+	return bookToProto(b), nil
+}
+
+// Update replaces the details of a book and returns it as stored.
+func (s *grpcServer) Update(ctx context.Context, req *bookshelfpb.UpdateRequest) (*bookshelfpb.Book, error) {
+	b := bookFromProto(req.GetBook())
+	if err := s.db.UpdateBook(ctx, b); err != nil {
+		return nil, grpcError(err)
+	}
+	b, err := s.db.GetBook(ctx, b.ID)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return bookToProto(b), nil
+}
+
+// Delete marks a book as deleted.
+func (s *grpcServer) Delete(ctx context.Context, req *bookshelfpb.DeleteRequest) (*bookshelfpb.DeleteResponse, error) {
+	if err := s.db.DeleteBook(ctx, req.GetId()); err != nil {
+		return nil, grpcError(err)
+	}
+	return &bookshelfpb.DeleteResponse{}, nil
+}
+
+// grpcError maps well-known database errors to the gRPC status that best
+// describes them, defaulting to Internal.
+func grpcError(err error) error {
+	var verr ValidationError
+	code := codes.Internal
+	switch {
+	case errors.As(err, &verr):
+		code = codes.InvalidArgument
+	case errors.Is(err, ErrBookNotFound):
+		code = codes.NotFound
+	case errors.Is(err, ErrVersionConflict):
+		code = codes.Aborted
+	case errors.Is(err, ErrDuplicateISBN):
+		code = codes.AlreadyExists
+	case errors.Is(err, ErrDatabaseUnavailable):
+		code = codes.Unavailable
+	case errors.Is(err, ErrReadOnly):
+		code = codes.FailedPrecondition
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, err.Error())
+}
+
+// bookToProto converts b to its protocol buffer form.
+func bookToProto(b *Book) *bookshelfpb.Book {
+	return &bookshelfpb.Book{
+		Id:            b.ID,
+		Title:         b.Title,
+		Author:        b.Author,
+		PublishedDate: b.PublishedDate,
+		Description:   b.Description,
+		Isbn:          b.ISBN,
+		Rating:        b.Rating,
+		Tags:          b.Tags,
+		CoverUrl:      b.CoverURL,
+		CreatedById:   b.CreatedByID,
+		CreatedBy:     b.CreatedBy,
+		Version:       b.Version,
+	}
+}
+
+// bookFromProto converts p, which may be nil, to a Book.
+func bookFromProto(p *bookshelfpb.Book) *Book {
+	return &Book{
+		ID:            p.GetId(),
+		Title:         p.GetTitle(),
+		Author:        p.GetAuthor(),
+		PublishedDate: p.GetPublishedDate(),
+		Description:   p.GetDescription(),
+		ISBN:          p.GetIsbn(),
+		Rating:        p.GetRating(),
+		Tags:          p.GetTags(),
+		CoverURL:      p.GetCoverUrl(),
+		CreatedByID:   p.GetCreatedById(),
+		CreatedBy:     p.GetCreatedBy(),
+		Version:       p.GetVersion(),
+	}
+}
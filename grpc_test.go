@@ -0,0 +1,122 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/sashayakovtseva/bookshelf/bookshelfpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestGRPCClient serves db with NewGRPCServer over an in-process
+// connection and returns a client of it. Both are shut down when the test
+// ends.
+func newTestGRPCClient(t *testing.T, db BookDatabase) bookshelfpb.BookServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := NewGRPCServer(db)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.NewClient: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return bookshelfpb.NewBookServiceClient(conn)
+}
+
+func TestGRPCAddAndGet(t *testing.T) {
+	ctx := context.Background()
+	client := newTestGRPCClient(t, NewMemoryDB())
+
+	added, err := client.Add(ctx, &bookshelfpb.AddRequest{Book: &bookshelfpb.Book{
+		Title:  "Dune",
+		Author: "Frank Herbert",
+		Tags:   []string{"sf"},
+	}})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if added.GetId() == 0 || added.GetVersion() != 1 {
+		t.Errorf("Add: got ID %d and version %d, want an ID and version 1", added.GetId(), added.GetVersion())
+	}
+
+	got, err := client.Get(ctx, &bookshelfpb.GetRequest{Id: added.GetId()})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.GetTitle() != "Dune" || got.GetAuthor() != "Frank Herbert" || len(got.GetTags()) != 1 {
+		t.Errorf("Get = %v, want the book added", got)
+	}
+
+	list, err := client.List(ctx, &bookshelfpb.ListRequest{Limit: 10})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if list.GetTotal() != 1 || len(list.GetBooks()) != 1 {
+		t.Errorf("List: got %d books of %d, want 1 of 1", len(list.GetBooks()), list.GetTotal())
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	ctx := context.Background()
+	client := newTestGRPCClient(t, NewMemoryDB())
+
+	_, err := client.Get(ctx, &bookshelfpb.GetRequest{Id: 42})
+	if got := status.Code(err); got != codes.NotFound {
+		t.Errorf("Get of a missing book: got code %v, want NotFound", got)
+	}
+	_, err = client.Add(ctx, &bookshelfpb.AddRequest{Book: &bookshelfpb.Book{Title: "Dune", Rating: 6}})
+	if got := status.Code(err); got != codes.InvalidArgument {
+		t.Errorf("Add of an invalid book: got code %v, want InvalidArgument", got)
+	}
+
+	added, err := client.Add(ctx, &bookshelfpb.AddRequest{Book: &bookshelfpb.Book{Title: "Dune"}})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	stale := &bookshelfpb.Book{Id: added.GetId(), Title: "Dune Messiah", Version: added.GetVersion() + 1}
+	_, err = client.Update(ctx, &bookshelfpb.UpdateRequest{Book: stale})
+	if got := status.Code(err); got != codes.Aborted {
+		t.Errorf("Update of a stale version: got code %v, want Aborted", got)
+	}
+	if _, err := client.Delete(ctx, &bookshelfpb.DeleteRequest{Id: added.GetId()}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	_, err = client.Get(ctx, &bookshelfpb.GetRequest{Id: added.GetId()})
+	if got := status.Code(err); got != codes.NotFound {
+		t.Errorf("Get of a deleted book: got code %v, want NotFound", got)
+	}
+}
+
+func TestGRPCErrorUnavailable(t *testing.T) {
+	err := grpcError(fmt.Errorf("%w: no reachable servers", ErrDatabaseUnavailable))
+	if got := status.Code(err); got != codes.Unavailable {
+		t.Errorf("grpcError of an unreachable database: got code %v, want Unavailable", got)
+	}
+}
+
+func TestGRPCErrorReadOnly(t *testing.T) {
+	ctx := context.Background()
+	client := newTestGRPCClient(t, NewReadOnlyDB(NewMemoryDB()))
+
+	_, err := client.Add(ctx, &bookshelfpb.AddRequest{Book: &bookshelfpb.Book{Title: "Dune"}})
+	if got := status.Code(err); got != codes.FailedPrecondition {
+		t.Errorf("Add to a read-only database: got code %v, want FailedPrecondition", got)
+	}
+}
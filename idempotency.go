@@ -0,0 +1,92 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// IdempotentRequest is what an IdempotencyStore remembers of the request
+// that first used an idempotency key.
+type IdempotentRequest struct {
+	// BookID is the ID of the book the request created.
+	BookID int64
+
+	// Digest identifies the book the request asked for, so that a retry can
+	// be told from a reuse of the key for another book.
+	Digest string
+}
+
+// IdempotencyStore remembers which book was created for each idempotency
+// key, so that a retried request does not create the book again.
+type IdempotencyStore interface {
+	// Get returns the request that first used key, and whether there is
+	// one. Keys are forgotten once they expire.
+	Get(ctx context.Context, key string) (req IdempotentRequest, ok bool, err error)
+
+	// Put records req as the request that used key.
+	Put(ctx context.Context, key string, req IdempotentRequest) error
+}
+
+// memoryIdempotencyStore keeps idempotency keys in memory.
+type memoryIdempotencyStore struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]idempotencyEntry
+	lastSweep time.Time
+}
+
+// idempotencyEntry is the request that used a key, and when the key expires.
+type idempotencyEntry struct {
+	req     IdempotentRequest
+	expires time.Time
+}
+
+// Ensure memoryIdempotencyStore conforms to the IdempotencyStore interface.
+var _ IdempotencyStore = &memoryIdempotencyStore{}
+
+// NewMemoryIdempotencyStore creates an IdempotencyStore that keeps keys in
+// memory for ttl after they are put. Keys are not shared between processes.
+func NewMemoryIdempotencyStore(ttl time.Duration) IdempotencyStore {
+	return &memoryIdempotencyStore{
+		ttl:       ttl,
+		entries:   make(map[string]idempotencyEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Get returns the request that first used key, if it has not expired.
+func (s *memoryIdempotencyStore) Get(_ context.Context, key string) (IdempotentRequest, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || !time.Now().Before(e.expires) {
+		return IdempotentRequest{}, false, nil
+	}
+	return e.req, true, nil
+}
+
+// Put records req as the request that used key. Expired keys are dropped
+// along the way, at most once per ttl.
+func (s *memoryIdempotencyStore) Put(_ context.Context, key string, req IdempotentRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > s.ttl {
+		for k, e := range s.entries {
+			if !now.Before(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	s.entries[key] = idempotencyEntry{req: req, expires: now.Add(s.ttl)}
+	return nil
+}
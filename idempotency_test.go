@@ -0,0 +1,35 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryIdempotencyStore(50 * time.Millisecond)
+
+	if _, ok, err := s.Get(ctx, "a"); ok || err != nil {
+		t.Fatalf("Get of an unknown key = _, %v, %v; want false, nil", ok, err)
+	}
+	want := IdempotentRequest{BookID: 7, Digest: "d1"}
+	if err := s.Put(ctx, "a", want); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if req, ok, err := s.Get(ctx, "a"); req != want || !ok || err != nil {
+		t.Errorf("Get after Put = %+v, %v, %v; want %+v, true, nil", req, ok, err, want)
+	}
+	if _, ok, _ := s.Get(ctx, "b"); ok {
+		t.Errorf("Get of another key found a book")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok, _ := s.Get(ctx, "a"); ok {
+		t.Errorf("Get after the key expired found a book")
+	}
+}
@@ -0,0 +1,360 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// metricsRegistry holds the collectors exposed by MetricsHandler.
+	metricsRegistry = prometheus.NewRegistry()
+
+	dbCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "bookshelf",
+		Subsystem: "db",
+		Name:      "calls_total",
+		Help:      "Number of BookDatabase method calls.",
+	}, []string{"method"})
+
+	dbErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "bookshelf",
+		Subsystem: "db",
+		Name:      "errors_total",
+		Help:      "Number of BookDatabase method calls that returned an error.",
+	}, []string{"method"})
+
+	dbDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "bookshelf",
+		Subsystem: "db",
+		Name:      "call_duration_seconds",
+		Help:      "Duration of BookDatabase method calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		dbCalls,
+		dbErrors,
+		dbDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// MetricsHandler serves the metrics recorded by instrumented databases in the
+// Prometheus exposition format.
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// instrumentedDB records metrics about the calls made to another database.
+type instrumentedDB struct {
+	inner BookDatabase
+}
+
+// Ensure instrumentedDB conforms to the BookDatabase interface.
+var _ BookDatabase = &instrumentedDB{}
+
+// NewInstrumentedDB wraps inner so that the number, duration and errors of
+// calls to each of its methods are exposed by MetricsHandler. The behavior of
+// inner is left unchanged.
+func NewInstrumentedDB(inner BookDatabase) BookDatabase {
+	return &instrumentedDB{inner: inner}
+}
+
+// observe records a call to method that started at start and failed with
+// *err, if not nil. It is meant to be deferred.
+func observe(method string, start time.Time, err *error) {
+	dbCalls.WithLabelValues(method).Inc()
+	dbDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		dbErrors.WithLabelValues(method).Inc()
+	}
+}
+
+func (db *instrumentedDB) ListBooks(ctx context.Context) (_ []*Book, err error) {
+	defer observe("ListBooks", time.Now(), &err)
+	return db.inner.ListBooks(ctx)
+}
+
+func (db *instrumentedDB) ListBooksPaged(ctx context.Context, limit, offset int) (_ []*Book, _ int, err error) {
+	defer observe("ListBooksPaged", time.Now(), &err)
+	return db.inner.ListBooksPaged(ctx, limit, offset)
+}
+
+func (db *instrumentedDB) ListBooksAfter(ctx context.Context, afterID int64, limit int) (_ []*Book, err error) {
+	defer observe("ListBooksAfter", time.Now(), &err)
+	return db.inner.ListBooksAfter(ctx, afterID, limit)
+}
+
+func (db *instrumentedDB) ListBooksSorted(ctx context.Context, field string, descending bool) (_ []*Book, err error) {
+	defer observe("ListBooksSorted", time.Now(), &err)
+	return db.inner.ListBooksSorted(ctx, field, descending)
+}
+
+func (db *instrumentedDB) ListBooksByAuthor(ctx context.Context, author string) (_ []*Book, err error) {
+	defer observe("ListBooksByAuthor", time.Now(), &err)
+	return db.inner.ListBooksByAuthor(ctx, author)
+}
+
+func (db *instrumentedDB) ListBooksByStatus(ctx context.Context, status string) (_ []*Book, err error) {
+	defer observe("ListBooksByStatus", time.Now(), &err)
+	return db.inner.ListBooksByStatus(ctx, status)
+}
+
+func (db *instrumentedDB) ListBooksByTag(ctx context.Context, tag string) (_ []*Book, err error) {
+	defer observe("ListBooksByTag", time.Now(), &err)
+	return db.inner.ListBooksByTag(ctx, tag)
+}
+
+func (db *instrumentedDB) ListBooksByTags(ctx context.Context, tags []string, matchAll bool) (_ []*Book, err error) {
+	defer observe("ListBooksByTags", time.Now(), &err)
+	return db.inner.ListBooksByTags(ctx, tags, matchAll)
+}
+
+func (db *instrumentedDB) ListBooksByYear(ctx context.Context, year int) (_ []*Book, err error) {
+	defer observe("ListBooksByYear", time.Now(), &err)
+	return db.inner.ListBooksByYear(ctx, year)
+}
+
+func (db *instrumentedDB) ListBooksBetweenDates(ctx context.Context, start, end time.Time) (_ []*Book, err error) {
+	defer observe("ListBooksBetweenDates", time.Now(), &err)
+	return db.inner.ListBooksBetweenDates(ctx, start, end)
+}
+
+func (db *instrumentedDB) ListBooksByLanguage(ctx context.Context, lang string) (_ []*Book, err error) {
+	defer observe("ListBooksByLanguage", time.Now(), &err)
+	return db.inner.ListBooksByLanguage(ctx, lang)
+}
+
+func (db *instrumentedDB) ListBooksByGenre(ctx context.Context, genre string) (_ []*Book, err error) {
+	defer observe("ListBooksByGenre", time.Now(), &err)
+	return db.inner.ListBooksByGenre(ctx, genre)
+}
+
+func (db *instrumentedDB) ListBooksByMetadata(ctx context.Context, key, value string) (_ []*Book, err error) {
+	defer observe("ListBooksByMetadata", time.Now(), &err)
+	return db.inner.ListBooksByMetadata(ctx, key, value)
+}
+
+func (db *instrumentedDB) ListBooksByPageRange(ctx context.Context, min, max int) (_ []*Book, err error) {
+	defer observe("ListBooksByPageRange", time.Now(), &err)
+	return db.inner.ListBooksByPageRange(ctx, min, max)
+}
+
+func (db *instrumentedDB) ListBooksCreatedBy(ctx context.Context, userID string) (_ []*Book, err error) {
+	defer observe("ListBooksCreatedBy", time.Now(), &err)
+	return db.inner.ListBooksCreatedBy(ctx, userID)
+}
+
+func (db *instrumentedDB) ListBooksModifiedSince(ctx context.Context, since time.Time) (_ []*Book, err error) {
+	defer observe("ListBooksModifiedSince", time.Now(), &err)
+	return db.inner.ListBooksModifiedSince(ctx, since)
+}
+
+func (db *instrumentedDB) ListRecentBooks(ctx context.Context, limit int) (_ []*Book, err error) {
+	defer observe("ListRecentBooks", time.Now(), &err)
+	return db.inner.ListRecentBooks(ctx, limit)
+}
+
+func (db *instrumentedDB) SearchBooks(ctx context.Context, query string) (_ []*Book, err error) {
+	defer observe("SearchBooks", time.Now(), &err)
+	return db.inner.SearchBooks(ctx, query)
+}
+
+func (db *instrumentedDB) SearchBooksFiltered(ctx context.Context, query string, tags []string) (_ []*Book, err error) {
+	defer observe("SearchBooksFiltered", time.Now(), &err)
+	return db.inner.SearchBooksFiltered(ctx, query, tags)
+}
+
+func (db *instrumentedDB) SuggestTitles(ctx context.Context, prefix string, limit int) (_ []string, err error) {
+	defer observe("SuggestTitles", time.Now(), &err)
+	return db.inner.SuggestTitles(ctx, prefix, limit)
+}
+
+func (db *instrumentedDB) ForEachBook(ctx context.Context, fn func(*Book) error) (err error) {
+	defer observe("ForEachBook", time.Now(), &err)
+	return db.inner.ForEachBook(ctx, fn)
+}
+
+func (db *instrumentedDB) ListAuthors(ctx context.Context) (_ []string, err error) {
+	defer observe("ListAuthors", time.Now(), &err)
+	return db.inner.ListAuthors(ctx)
+}
+
+func (db *instrumentedDB) TopAuthors(ctx context.Context, limit int) (_ []AuthorCount, err error) {
+	defer observe("TopAuthors", time.Now(), &err)
+	return db.inner.TopAuthors(ctx, limit)
+}
+
+func (db *instrumentedDB) AverageRatingByAuthor(ctx context.Context) (_ map[string]float64, err error) {
+	defer observe("AverageRatingByAuthor", time.Now(), &err)
+	return db.inner.AverageRatingByAuthor(ctx)
+}
+
+func (db *instrumentedDB) ListTitleIndex(ctx context.Context) (_ map[string]int, err error) {
+	defer observe("ListTitleIndex", time.Now(), &err)
+	return db.inner.ListTitleIndex(ctx)
+}
+
+func (db *instrumentedDB) CountBooks(ctx context.Context) (_ int64, err error) {
+	defer observe("CountBooks", time.Now(), &err)
+	return db.inner.CountBooks(ctx)
+}
+
+func (db *instrumentedDB) CountBooksCreatedBy(ctx context.Context, userID string) (_ int64, err error) {
+	defer observe("CountBooksCreatedBy", time.Now(), &err)
+	return db.inner.CountBooksCreatedBy(ctx, userID)
+}
+
+func (db *instrumentedDB) GetBook(ctx context.Context, id int64) (_ *Book, err error) {
+	defer observe("GetBook", time.Now(), &err)
+	return db.inner.GetBook(ctx, id)
+}
+
+func (db *instrumentedDB) BookExists(ctx context.Context, id int64) (_ bool, err error) {
+	defer observe("BookExists", time.Now(), &err)
+	return db.inner.BookExists(ctx, id)
+}
+
+func (db *instrumentedDB) RandomBook(ctx context.Context) (_ *Book, err error) {
+	defer observe("RandomBook", time.Now(), &err)
+	return db.inner.RandomBook(ctx)
+}
+
+func (db *instrumentedDB) GetBooks(ctx context.Context, ids []int64) (_ []*Book, err error) {
+	defer observe("GetBooks", time.Now(), &err)
+	return db.inner.GetBooks(ctx, ids)
+}
+
+func (db *instrumentedDB) AddBook(ctx context.Context, b *Book) (_ int64, err error) {
+	defer observe("AddBook", time.Now(), &err)
+	return db.inner.AddBook(ctx, b)
+}
+
+func (db *instrumentedDB) AddBooks(ctx context.Context, books []*Book) (_ []int64, err error) {
+	defer observe("AddBooks", time.Now(), &err)
+	return db.inner.AddBooks(ctx, books)
+}
+
+func (db *instrumentedDB) AddBooksBestEffort(ctx context.Context, books []*Book) (_ []int64, _ []BookError, err error) {
+	defer observe("AddBooksBestEffort", time.Now(), &err)
+	return db.inner.AddBooksBestEffort(ctx, books)
+}
+
+func (db *instrumentedDB) DeleteBook(ctx context.Context, id int64) (err error) {
+	defer observe("DeleteBook", time.Now(), &err)
+	return db.inner.DeleteBook(ctx, id)
+}
+
+func (db *instrumentedDB) DeleteBookDryRun(ctx context.Context, id int64) (_ *Book, err error) {
+	defer observe("DeleteBookDryRun", time.Now(), &err)
+	return db.inner.DeleteBookDryRun(ctx, id)
+}
+
+func (db *instrumentedDB) DeleteBooks(ctx context.Context, ids []int64) (_ int, err error) {
+	defer observe("DeleteBooks", time.Now(), &err)
+	return db.inner.DeleteBooks(ctx, ids)
+}
+
+func (db *instrumentedDB) RestoreBook(ctx context.Context, id int64) (err error) {
+	defer observe("RestoreBook", time.Now(), &err)
+	return db.inner.RestoreBook(ctx, id)
+}
+
+func (db *instrumentedDB) PurgeDeleted(ctx context.Context, olderThan time.Duration) (_ int, err error) {
+	defer observe("PurgeDeleted", time.Now(), &err)
+	return db.inner.PurgeDeleted(ctx, olderThan)
+}
+
+func (db *instrumentedDB) DeleteAllBooks(ctx context.Context) (_ int, err error) {
+	defer observe("DeleteAllBooks", time.Now(), &err)
+	return db.inner.DeleteAllBooks(ctx)
+}
+
+func (db *instrumentedDB) UpdateBook(ctx context.Context, b *Book) (err error) {
+	defer observe("UpdateBook", time.Now(), &err)
+	return db.inner.UpdateBook(ctx, b)
+}
+
+func (db *instrumentedDB) PublishBook(ctx context.Context, id int64) (err error) {
+	defer observe("PublishBook", time.Now(), &err)
+	return db.inner.PublishBook(ctx, id)
+}
+
+func (db *instrumentedDB) SetBookTags(ctx context.Context, id int64, tags []string) (err error) {
+	defer observe("SetBookTags", time.Now(), &err)
+	return db.inner.SetBookTags(ctx, id, tags)
+}
+
+func (db *instrumentedDB) UpdateBookFields(ctx context.Context, id int64, fields map[string]interface{}) (err error) {
+	defer observe("UpdateBookFields", time.Now(), &err)
+	return db.inner.UpdateBookFields(ctx, id, fields)
+}
+
+func (db *instrumentedDB) UpdateBooksWhere(ctx context.Context, filter, set map[string]interface{}) (_ int, err error) {
+	defer observe("UpdateBooksWhere", time.Now(), &err)
+	return db.inner.UpdateBooksWhere(ctx, filter, set)
+}
+
+func (db *instrumentedDB) RenameAuthor(ctx context.Context, from, to string) (_ int, err error) {
+	defer observe("RenameAuthor", time.Now(), &err)
+	return db.inner.RenameAuthor(ctx, from, to)
+}
+
+func (db *instrumentedDB) AddReview(ctx context.Context, bookID int64, r *Review) (err error) {
+	defer observe("AddReview", time.Now(), &err)
+	return db.inner.AddReview(ctx, bookID, r)
+}
+
+func (db *instrumentedDB) ListReviews(ctx context.Context, bookID int64) (_ []*Review, err error) {
+	defer observe("ListReviews", time.Now(), &err)
+	return db.inner.ListReviews(ctx, bookID)
+}
+
+func (db *instrumentedDB) ListReviewsPaged(ctx context.Context, bookID int64, limit, offset int) (_ []*Review, _ int, err error) {
+	defer observe("ListReviewsPaged", time.Now(), &err)
+	return db.inner.ListReviewsPaged(ctx, bookID, limit, offset)
+}
+
+func (db *instrumentedDB) Migrate(ctx context.Context) (err error) {
+	defer observe("Migrate", time.Now(), &err)
+	return db.inner.Migrate(ctx)
+}
+
+func (db *instrumentedDB) Reindex(ctx context.Context) (err error) {
+	defer observe("Reindex", time.Now(), &err)
+	return db.inner.Reindex(ctx)
+}
+
+func (db *instrumentedDB) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer observe("WithTransaction", time.Now(), &err)
+	return db.inner.WithTransaction(ctx, fn)
+}
+
+func (db *instrumentedDB) Subscribe() (<-chan *Book, func()) {
+	return db.inner.Subscribe()
+}
+
+func (db *instrumentedDB) Ping(ctx context.Context) (err error) {
+	defer observe("Ping", time.Now(), &err)
+	return db.inner.Ping(ctx)
+}
+
+func (db *instrumentedDB) Name() string {
+	return db.inner.Name()
+}
+
+func (db *instrumentedDB) Close() error {
+	return db.inner.Close()
+}
@@ -0,0 +1,55 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentedDB(t *testing.T) {
+	testDatabase(t, NewInstrumentedDB(NewMemoryDB()))
+}
+
+func TestInstrumentedDBMetrics(t *testing.T) {
+	ctx := context.Background()
+	db := NewInstrumentedDB(NewMemoryDB())
+	calls := testutil.ToFloat64(dbCalls.WithLabelValues("GetBook"))
+	errs := testutil.ToFloat64(dbErrors.WithLabelValues("GetBook"))
+
+	id, err := db.AddBook(ctx, &Book{Title: "Dune"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	if _, err := db.GetBook(ctx, id); err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if _, err := db.GetBook(ctx, id+1); err != ErrBookNotFound {
+		t.Fatalf("GetBook of a missing book: got %v, want %v", err, ErrBookNotFound)
+	}
+
+	if got := testutil.ToFloat64(dbCalls.WithLabelValues("GetBook")) - calls; got != 2 {
+		t.Errorf("GetBook calls went up by %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(dbErrors.WithLabelValues("GetBook")) - errs; got != 1 {
+		t.Errorf("GetBook errors went up by %v, want 1", got)
+	}
+
+	w := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`bookshelf_db_calls_total{method="GetBook"}`,
+		`bookshelf_db_errors_total{method="GetBook"}`,
+		`bookshelf_db_call_duration_seconds_bucket{method="GetBook"`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("/metrics output lacks %s", want)
+		}
+	}
+}
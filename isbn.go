@@ -0,0 +1,75 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalidISBN is returned when a book carries an ISBN that is neither a
+// well-formed ISBN-10 nor ISBN-13, or whose check digit does not match.
+var ErrInvalidISBN = errors.New("bookshelf: invalid ISBN")
+
+// ErrDuplicateISBN is returned when adding a book whose ISBN is already
+// taken by another book, by databases configured to reject duplicates.
+var ErrDuplicateISBN = errors.New("bookshelf: a book with this ISBN already exists")
+
+// ValidateISBN checks the book's ISBN, accepting both ISBN-10 and ISBN-13
+// with optional hyphens or spaces. An empty ISBN is valid.
+func (b *Book) ValidateISBN() error {
+	if b.ISBN == "" {
+		return nil
+	}
+	isbn := strings.NewReplacer("-", "", " ", "").Replace(b.ISBN)
+	switch len(isbn) {
+	case 10:
+		if validISBN10(isbn) {
+			return nil
+		}
+	case 13:
+		if validISBN13(isbn) {
+			return nil
+		}
+	}
+	return ErrInvalidISBN
+}
+
+// validISBN10 reports whether s is ten characters of digits (the last may be
+// an X standing for 10) whose weighted sum is divisible by 11.
+func validISBN10(s string) bool {
+	sum := 0
+	for i := 0; i < 10; i++ {
+		var d int
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			d = int(c - '0')
+		case (c == 'X' || c == 'x') && i == 9:
+			d = 10
+		default:
+			return false
+		}
+		sum += (10 - i) * d
+	}
+	return sum%11 == 0
+}
+
+// validISBN13 reports whether s is thirteen digits whose alternating 1/3
+// weighted sum is divisible by 10.
+func validISBN13(s string) bool {
+	sum := 0
+	for i := 0; i < 13; i++ {
+		c := s[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
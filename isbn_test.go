@@ -0,0 +1,36 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import "testing"
+
+func TestValidateISBN(t *testing.T) {
+	tests := []struct {
+		isbn string
+		ok   bool
+	}{
+		{"", true},
+		{"0306406152", true},
+		{"0-306-40615-2", true},
+		{"080442957X", true},
+		{"080442957x", true},
+		{"9780306406157", true},
+		{"978-0-306-40615-7", true},
+		{"978 0 306 40615 7", true},
+		{"0306406153", false},
+		{"9780306406158", false},
+		{"X804429570", false},
+		{"97803064061X7", false},
+		{"030640615", false},
+		{"97803064061570", false},
+		{"abcdefghij", false},
+	}
+	for _, tt := range tests {
+		b := &Book{ISBN: tt.isbn}
+		if err := b.ValidateISBN(); (err == nil) != tt.ok {
+			t.Errorf("ValidateISBN(%q) = %v, want valid %v", tt.isbn, err, tt.ok)
+		}
+	}
+}
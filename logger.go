@@ -0,0 +1,80 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// Logger writes leveled log messages, formatted as with fmt.Sprintf.
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// Level is the least severe level of the messages a Logger writes.
+type Level int
+
+// The levels of log messages, from least to most severe.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelError
+)
+
+// ParseLevel parses "debug", "info" or "error", ignoring case. The empty
+// string is LevelInfo.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("bookshelf: unknown log level %q", s)
+}
+
+// stdLogger is a Logger on top of the log package.
+type stdLogger struct {
+	l     *log.Logger
+	level Level
+}
+
+// NewLogger creates a Logger writing the messages at level or above to w,
+// prefixed like those of the log package and by their level.
+func NewLogger(w io.Writer, level Level) Logger {
+	return &stdLogger{l: log.New(w, "", log.LstdFlags), level: level}
+}
+
+// defaultLogger is used by the databases given no Logger: it writes info
+// messages and above to standard error, like the log package.
+var defaultLogger = NewLogger(os.Stderr, LevelInfo)
+
+func (l *stdLogger) Debugf(format string, v ...interface{}) {
+	l.logf(LevelDebug, "DEBUG ", format, v...)
+}
+
+func (l *stdLogger) Infof(format string, v ...interface{}) {
+	l.logf(LevelInfo, "INFO ", format, v...)
+}
+
+func (l *stdLogger) Errorf(format string, v ...interface{}) {
+	l.logf(LevelError, "ERROR ", format, v...)
+}
+
+// logf writes the message unless level is below the logger's.
+func (l *stdLogger) logf(level Level, prefix, format string, v ...interface{}) {
+	if level < l.level {
+		return
+	}
+	l.l.Output(3, prefix+fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,79 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Level
+	}{
+		{"", LevelInfo},
+		{"debug", LevelDebug},
+		{"INFO", LevelInfo},
+		{"Error", LevelError},
+	}
+	for _, tt := range tests {
+		if got, err := ParseLevel(tt.in); err != nil || got != tt.want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("ParseLevel(verbose): got nil error")
+	}
+}
+
+func TestLoggerLevels(t *testing.T) {
+	tests := []struct {
+		level Level
+		want  []string
+	}{
+		{LevelDebug, []string{"DEBUG d", "INFO i", "ERROR e"}},
+		{LevelInfo, []string{"INFO i", "ERROR e"}},
+		{LevelError, []string{"ERROR e"}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		l := NewLogger(&buf, tt.level)
+		l.Debugf("d")
+		l.Infof("i")
+		l.Errorf("e")
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if len(lines) != len(tt.want) {
+			t.Errorf("level %v: logged %q, want %q", tt.level, lines, tt.want)
+			continue
+		}
+		for i, line := range lines {
+			if !strings.HasSuffix(line, " "+tt.want[i]) {
+				t.Errorf("level %v: line %d is %q, want it to end in %q", tt.level, i, line, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestSQLiteDebugLogging(t *testing.T) {
+	var buf bytes.Buffer
+	db, err := NewSQLiteDBWithLogger(filepath.Join(t.TempDir(), "books.db"), NewLogger(&buf, LevelDebug))
+	if err != nil {
+		t.Fatalf("NewSQLiteDBWithLogger: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	mustAdd(t, db, &Book{Title: "Dune"})
+
+	if !strings.Contains(buf.String(), `DEBUG sqlite: query INSERT INTO books`) {
+		t.Errorf("AddBook logged %q, want the insert at debug level", buf.String())
+	}
+}
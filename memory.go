@@ -0,0 +1,949 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryDB is a simple in-memory persistence layer for books.
+type memoryDB struct {
+	mu      sync.RWMutex
+	nextID  int64               // next ID to assign to a book.
+	books   map[int64]*Book     // maps from Book's ID to book.
+	reviews map[int64][]*Review // maps from Book's ID to its reviews.
+	closed  bool                // set by Close, after which writes fail.
+
+	feed bookFeed // publishes added books.
+}
+
+// errMemoryClosed is returned by the writes made to a closed memoryDB.
+var errMemoryClosed = errors.New("memory: database is closed")
+
+// Ensure memoryDB conforms to the BookDatabase interface.
+var _ BookDatabase = &memoryDB{}
+
+// NewMemoryDB creates a new BookDatabase that keeps books in memory. It is
+// mostly useful for tests and local development.
+func NewMemoryDB() BookDatabase {
+	return &memoryDB{
+		books:   make(map[int64]*Book),
+		reviews: make(map[int64][]*Review),
+		nextID:  1,
+	}
+}
+
+// Close closes the database, after which reads find nothing and writes fail.
+func (db *memoryDB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.books = nil
+	db.reviews = nil
+	db.closed = true
+	db.feed.close()
+	return nil
+}
+
+// Migrate does nothing, since there is no schema to create.
+func (db *memoryDB) Migrate(_ context.Context) error {
+	return nil
+}
+
+// Reindex does nothing, since there are no indexes to rebuild.
+func (db *memoryDB) Reindex(_ context.Context) error {
+	return nil
+}
+
+// Name returns "memory".
+func (db *memoryDB) Name() string {
+	return "memory"
+}
+
+// Subscribe returns a channel receiving every book added from now on.
+func (db *memoryDB) Subscribe() (<-chan *Book, func()) {
+	return db.feed.Subscribe()
+}
+
+// memoryTx is a transaction emulated by WithTransaction.
+type memoryTx struct {
+	db      *memoryDB
+	pending []*Book // books added in the transaction, published on success.
+}
+
+// memoryTxKey is the context key under which WithTransaction stores its
+// *memoryTx.
+type memoryTxKey struct{}
+
+// publish publishes books to subscribers, or once the transaction ctx runs
+// in succeeds. The caller must hold db.mu.
+func (db *memoryDB) publish(ctx context.Context, books ...*Book) {
+	if t, ok := ctx.Value(memoryTxKey{}).(*memoryTx); ok && t.db == db {
+		t.pending = append(t.pending, books...)
+		return
+	}
+	db.feed.publish(books...)
+}
+
+// WithTransaction runs fn, restoring the books and reviews as they were
+// before if it returns an error. Transactions are not isolated: changes made
+// concurrently by others are seen by fn, and undone along with its own.
+func (db *memoryDB) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if t, ok := ctx.Value(memoryTxKey{}).(*memoryTx); ok && t.db == db {
+		return fn(ctx)
+	}
+
+	db.mu.RLock()
+	nextID := db.nextID
+	books := make(map[int64]*Book, len(db.books))
+	for id, b := range db.books {
+		books[id] = copyBook(b)
+	}
+	reviews := make(map[int64][]*Review, len(db.reviews))
+	for id, rs := range db.reviews {
+		for _, r := range rs {
+			r := *r
+			reviews[id] = append(reviews[id], &r)
+		}
+	}
+	db.mu.RUnlock()
+
+	t := &memoryTx{db: db}
+	if err := fn(context.WithValue(ctx, memoryTxKey{}, t)); err != nil {
+		db.mu.Lock()
+		db.nextID, db.books, db.reviews = nextID, books, reviews
+		db.mu.Unlock()
+		return err
+	}
+	db.feed.publish(t.pending...)
+	return nil
+}
+
+// Ping reports an error once the database has been closed.
+func (db *memoryDB) Ping(_ context.Context) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return errMemoryClosed
+	}
+	return nil
+}
+
+// GetBook retrieves a book by its ID.
+func (db *memoryDB) GetBook(_ context.Context, id int64) (*Book, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	b, ok := db.live(id)
+	if !ok {
+		return nil, ErrBookNotFound
+	}
+	return copyBook(b), nil
+}
+
+// RandomBook retrieves a published book picked uniformly at random.
+func (db *memoryDB) RandomBook(_ context.Context) (*Book, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	books := db.filter(func(*Book) bool { return true })
+	if len(books) == 0 {
+		return nil, ErrBookNotFound
+	}
+	return books[rand.Intn(len(books))], nil
+}
+
+// BookExists reports whether there is a book with the given ID.
+func (db *memoryDB) BookExists(_ context.Context, id int64) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	_, ok := db.live(id)
+	return ok, nil
+}
+
+// GetBooks retrieves the books with the given IDs, in the same order.
+func (db *memoryDB) GetBooks(_ context.Context, ids []int64) ([]*Book, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	books := make([]*Book, 0, len(ids))
+	for _, id := range ids {
+		if b, ok := db.live(id); ok {
+			books = append(books, copyBook(b))
+		}
+	}
+	return books, nil
+}
+
+// live returns the book with the given ID unless it is missing or deleted.
+// The caller must hold db.mu.
+func (db *memoryDB) live(id int64) (*Book, bool) {
+	b, ok := db.books[id]
+	if !ok || b.DeletedAt != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// AddBook saves a given book, assigning it a new ID.
+func (db *memoryDB) AddBook(ctx context.Context, b *Book) (id int64, err error) {
+	if err := b.Validate(); err != nil {
+		return 0, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return 0, errMemoryClosed
+	}
+	now := time.Now()
+	b.ID = db.nextID
+	b.Version = 1
+	b.defaultStatus()
+	b.CreatedAt, b.UpdatedAt = now, now
+	b.DeletedAt = nil
+	db.books[b.ID] = copyBook(b)
+
+	db.nextID++
+	db.publish(ctx, b)
+
+	return b.ID, nil
+}
+
+// AddBooks saves the given books, assigning each a new ID.
+func (db *memoryDB) AddBooks(ctx context.Context, books []*Book) ([]int64, error) {
+	for _, b := range books {
+		if err := b.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, errMemoryClosed
+	}
+	now := time.Now()
+	ids := make([]int64, len(books))
+	for i, b := range books {
+		b.ID = db.nextID
+		b.Version = 1
+		b.defaultStatus()
+		b.CreatedAt, b.UpdatedAt = now, now
+		b.DeletedAt = nil
+		db.books[b.ID] = copyBook(b)
+		ids[i] = b.ID
+
+		db.nextID++
+	}
+	db.publish(ctx, books...)
+	return ids, nil
+}
+
+// AddBooksBestEffort saves the given books one at a time, skipping the
+// invalid ones and those whose ISBN is taken.
+func (db *memoryDB) AddBooksBestEffort(ctx context.Context, books []*Book) ([]int64, []BookError, error) {
+	return addEach(ctx, books, db.AddBook)
+}
+
+// DeleteBook marks a given book as deleted by its ID.
+func (db *memoryDB) DeleteBook(_ context.Context, id int64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	b, ok := db.live(id)
+	if !ok {
+		return ErrBookNotFound
+	}
+	now := time.Now()
+	b.DeletedAt = &now
+	return nil
+}
+
+// DeleteBookDryRun returns the book DeleteBook would delete given its ID.
+func (db *memoryDB) DeleteBookDryRun(ctx context.Context, id int64) (*Book, error) {
+	return db.GetBook(ctx, id)
+}
+
+// DeleteBooks marks the books with the given IDs as deleted, returning how
+// many were.
+func (db *memoryDB) DeleteBooks(_ context.Context, ids []int64) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := time.Now()
+	n := 0
+	for _, id := range ids {
+		if b, ok := db.live(id); ok {
+			t := now
+			b.DeletedAt = &t
+			n++
+		}
+	}
+	return n, nil
+}
+
+// RestoreBook brings back a deleted book by its ID.
+func (db *memoryDB) RestoreBook(_ context.Context, id int64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	b, ok := db.books[id]
+	if !ok || b.DeletedAt == nil {
+		return ErrBookNotFound
+	}
+	b.DeletedAt = nil
+	return nil
+}
+
+// PurgeDeleted permanently removes the books deleted more than olderThan ago.
+func (db *memoryDB) PurgeDeleted(_ context.Context, olderThan time.Duration) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	cutoff := time.Now().Add(-olderThan)
+	n := 0
+	for id, b := range db.books {
+		if b.DeletedAt != nil && !b.DeletedAt.After(cutoff) {
+			delete(db.books, id)
+			delete(db.reviews, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// DeleteAllBooks permanently removes every book.
+func (db *memoryDB) DeleteAllBooks(_ context.Context) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return 0, errMemoryClosed
+	}
+	n := len(db.books)
+	db.books = make(map[int64]*Book)
+	db.reviews = make(map[int64][]*Review)
+	return n, nil
+}
+
+// UpdateBook updates the entry for a given book.
+func (db *memoryDB) UpdateBook(_ context.Context, b *Book) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	old, ok := db.live(b.ID)
+	if !ok {
+		return ErrBookNotFound
+	}
+	if old.Version != b.Version {
+		return ErrVersionConflict
+	}
+	b.defaultStatus()
+	b.Version++
+	b.UpdatedAt = time.Now()
+	nb := copyBook(b)
+	nb.CreatedByID, nb.CreatedBy = old.CreatedByID, old.CreatedBy
+	nb.CreatedAt = old.CreatedAt
+	nb.DeletedAt = nil
+	db.books[b.ID] = nb
+	return nil
+}
+
+// PublishBook sets the status of a given book to published.
+func (db *memoryDB) PublishBook(ctx context.Context, id int64) error {
+	return db.UpdateBookFields(ctx, id, map[string]interface{}{"status": StatusPublished})
+}
+
+// SetBookTags replaces the tags of a given book.
+func (db *memoryDB) SetBookTags(ctx context.Context, id int64, tags []string) error {
+	return db.UpdateBookFields(ctx, id, map[string]interface{}{"tags": normalizeTags(tags)})
+}
+
+// UpdateBookFields changes only the given fields of a book.
+func (db *memoryDB) UpdateBookFields(_ context.Context, id int64, fields map[string]interface{}) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	old, ok := db.live(id)
+	if !ok {
+		return ErrBookNotFound
+	}
+	b := copyBook(old)
+	if err := applyFields(b, fields); err != nil {
+		return err
+	}
+	b.Version++
+	b.UpdatedAt = time.Now()
+	db.books[id] = b
+	return nil
+}
+
+// UpdateBooksWhere sets the fields of set on every book whose fields have the
+// values of filter, returning how many books matched.
+func (db *memoryDB) UpdateBooksWhere(_ context.Context, filter, set map[string]interface{}) (int, error) {
+	if _, err := bulkUpdate(filter, set); err != nil {
+		return 0, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Apply every update before storing any, so that a book made invalid
+	// by set leaves them all untouched.
+	var updated []*Book
+	for _, old := range db.books {
+		if old.DeletedAt != nil || !matchesFilter(old, filter) {
+			continue
+		}
+		b := copyBook(old)
+		if err := applyFields(b, set); err != nil {
+			return 0, err
+		}
+		updated = append(updated, b)
+	}
+	now := time.Now()
+	for _, b := range updated {
+		b.Version++
+		b.UpdatedAt = now
+		db.books[b.ID] = b
+	}
+	return len(updated), nil
+}
+
+// RenameAuthor changes the author of every book written by from to to.
+func (db *memoryDB) RenameAuthor(ctx context.Context, from, to string) (int, error) {
+	if from == to {
+		return 0, nil
+	}
+	return db.UpdateBooksWhere(ctx, map[string]interface{}{"author": from}, map[string]interface{}{"author": to})
+}
+
+// AddReview adds a review to the book with the given ID.
+func (db *memoryDB) AddReview(_ context.Context, bookID int64, r *Review) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.live(bookID); !ok {
+		return ErrBookNotFound
+	}
+	r.CreatedAt = time.Now()
+	c := *r
+	db.reviews[bookID] = append(db.reviews[bookID], &c)
+	return nil
+}
+
+// ListReviews returns the reviews of the book with the given ID, in the order
+// they were added.
+func (db *memoryDB) ListReviews(_ context.Context, bookID int64) ([]*Review, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if _, ok := db.live(bookID); !ok {
+		return nil, ErrBookNotFound
+	}
+	reviews := make([]*Review, len(db.reviews[bookID]))
+	for i, r := range db.reviews[bookID] {
+		c := *r
+		reviews[i] = &c
+	}
+	return reviews, nil
+}
+
+// ListReviewsPaged returns at most limit reviews of the book with the given
+// ID, skipping the first offset of them, along with the total number of its
+// reviews.
+func (db *memoryDB) ListReviewsPaged(ctx context.Context, bookID int64, limit, offset int) ([]*Review, int, error) {
+	reviews, err := db.ListReviews(ctx, bookID)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := len(reviews)
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > total {
+		offset = total
+	}
+	reviews = reviews[offset:]
+	if limit > 0 && limit < len(reviews) {
+		reviews = reviews[:limit]
+	}
+	return reviews, total, nil
+}
+
+// ListBooks returns a list of the published books, ordered by title.
+func (db *memoryDB) ListBooks(_ context.Context) ([]*Book, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.filter(func(*Book) bool { return true }), nil
+}
+
+// ListBooksPaged returns at most limit published books, ordered by title,
+// skipping the first offset of them, along with the total number of
+// published books.
+func (db *memoryDB) ListBooksPaged(_ context.Context, limit, offset int) ([]*Book, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	books := db.filter(func(*Book) bool { return true })
+	return paginate(books, limit, offset), len(books), nil
+}
+
+// ListBooksAfter returns at most limit books with an ID greater than afterID,
+// ordered by ID.
+func (db *memoryDB) ListBooksAfter(_ context.Context, afterID int64, limit int) ([]*Book, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	books := db.filter(func(b *Book) bool { return b.ID > afterID })
+	sort.Slice(books, func(i, j int) bool { return books[i].ID < books[j].ID })
+	return paginate(books, limit, 0), nil
+}
+
+// ListBooksSorted returns a list of books ordered by the given field.
+func (db *memoryDB) ListBooksSorted(_ context.Context, field string, descending bool) ([]*Book, error) {
+	value, ok := sortFields[field]
+	if !ok {
+		return nil, ErrInvalidSortField
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	less := func(a, b *Book) bool { return value(a) < value(b) }
+	if field == "title" {
+		less = func(a, b *Book) bool { return compareTitles(a.Title, b.Title) < 0 }
+	}
+	books := db.filter(func(*Book) bool { return true })
+	sort.SliceStable(books, func(i, j int) bool {
+		if descending {
+			return less(books[j], books[i])
+		}
+		return less(books[i], books[j])
+	})
+	return books, nil
+}
+
+// ListBooksByAuthor returns a list of books, ordered by title, written by the
+// given author.
+func (db *memoryDB) ListBooksByAuthor(_ context.Context, author string) ([]*Book, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.filter(func(b *Book) bool { return b.Author == author }), nil
+}
+
+// ListBooksByStatus returns a list of books, ordered by title, with the given
+// status.
+func (db *memoryDB) ListBooksByStatus(_ context.Context, status string) ([]*Book, error) {
+	if err := checkStatus(status); err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	draft := status == StatusDraft
+	return db.filterAll(func(b *Book) bool { return isDraft(b) == draft }), nil
+}
+
+// ListBooksByTag returns a list of books, ordered by title, that carry the
+// given tag.
+func (db *memoryDB) ListBooksByTag(_ context.Context, tag string) ([]*Book, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.filter(func(b *Book) bool { return hasTag(b, tag) }), nil
+}
+
+// ListBooksByTags returns a list of books, ordered by title, that carry all
+// or any of the given tags.
+func (db *memoryDB) ListBooksByTags(_ context.Context, tags []string, matchAll bool) ([]*Book, error) {
+	if len(tags) == 0 {
+		return []*Book{}, nil
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.filter(func(b *Book) bool {
+		for _, tag := range tags {
+			if hasTag(b, tag) != matchAll {
+				return !matchAll
+			}
+		}
+		return matchAll
+	}), nil
+}
+
+// hasTag reports whether b carries the given tag.
+func hasTag(b *Book, tag string) bool {
+	for _, t := range b.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAnyTag reports whether b carries at least one of the given tags.
+func hasAnyTag(b *Book, tags []string) bool {
+	for _, tag := range tags {
+		if hasTag(b, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListBooksByYear returns a list of books, ordered by title, published in the
+// given year.
+func (db *memoryDB) ListBooksByYear(_ context.Context, year int) ([]*Book, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.filter(func(b *Book) bool { return publishedIn(b, year) }), nil
+}
+
+// ListBooksBetweenDates returns a list of books, ordered by published date,
+// published between start and end.
+func (db *memoryDB) ListBooksBetweenDates(_ context.Context, start, end time.Time) ([]*Book, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return keepPublishedBetween(db.filter(func(b *Book) bool { return true }), start, end), nil
+}
+
+// ListBooksByLanguage returns a list of books, ordered by title, written in the
+// given language.
+func (db *memoryDB) ListBooksByLanguage(_ context.Context, lang string) ([]*Book, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.filter(func(b *Book) bool { return b.Language == lang }), nil
+}
+
+// ListBooksByGenre returns a list of books, ordered by title, of the given
+// genre.
+func (db *memoryDB) ListBooksByGenre(_ context.Context, genre string) ([]*Book, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.filter(func(b *Book) bool { return b.Genre == genre }), nil
+}
+
+// ListBooksByMetadata returns a list of books, ordered by title, whose
+// metadata maps key to value.
+func (db *memoryDB) ListBooksByMetadata(_ context.Context, key, value string) ([]*Book, error) {
+	if err := validateMetadataKey(key); err != nil {
+		return nil, ValidationError{{Field: "metadata", Err: err}}
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.filter(func(b *Book) bool {
+		v, ok := b.Metadata[key]
+		return ok && v == value
+	}), nil
+}
+
+// ListBooksByPageRange returns a list of books, ordered by title, with between
+// min and max pages inclusive.
+func (db *memoryDB) ListBooksByPageRange(_ context.Context, min, max int) ([]*Book, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.filter(func(b *Book) bool {
+		return b.PageCount >= min && (max <= 0 || b.PageCount <= max)
+	}), nil
+}
+
+// ListBooksCreatedBy returns a list of books, ordered by title, filtered by
+// the user who created the book entry, drafts included.
+func (db *memoryDB) ListBooksCreatedBy(_ context.Context, userID string) ([]*Book, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.filterAll(func(b *Book) bool { return b.CreatedByID == userID }), nil
+}
+
+// ListBooksModifiedSince returns the books added or updated at or after since,
+// ordered by UpdatedAt.
+func (db *memoryDB) ListBooksModifiedSince(_ context.Context, since time.Time) ([]*Book, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	books := db.filter(func(b *Book) bool { return !b.UpdatedAt.Before(since) })
+	sort.Slice(books, func(i, j int) bool {
+		if !books[i].UpdatedAt.Equal(books[j].UpdatedAt) {
+			return books[i].UpdatedAt.Before(books[j].UpdatedAt)
+		}
+		return books[i].ID < books[j].ID
+	})
+	return books, nil
+}
+
+// ListRecentBooks returns the limit most recently added books, newest first.
+func (db *memoryDB) ListRecentBooks(_ context.Context, limit int) ([]*Book, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	books := db.filter(func(*Book) bool { return true })
+	sort.Slice(books, func(i, j int) bool {
+		if !books[i].CreatedAt.Equal(books[j].CreatedAt) {
+			return books[i].CreatedAt.After(books[j].CreatedAt)
+		}
+		return books[i].ID > books[j].ID
+	})
+	return paginate(books, limit, 0), nil
+}
+
+// SearchBooks returns the books whose title, author or description contain
+// any of the words of the query, ignoring case. Results are ordered by title.
+func (db *memoryDB) SearchBooks(ctx context.Context, query string) ([]*Book, error) {
+	return db.SearchBooksFiltered(ctx, query, nil)
+}
+
+// SearchBooksFiltered returns the books found by SearchBooks that carry any
+// of the given tags, or all of them if there are none.
+func (db *memoryDB) SearchBooksFiltered(_ context.Context, query string, tags []string) ([]*Book, error) {
+	terms := strings.Fields(strings.ToLower(query))
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.filter(func(b *Book) bool {
+		if len(tags) > 0 && !hasAnyTag(b, tags) {
+			return false
+		}
+		text := strings.ToLower(b.Title + " " + b.Author + " " + b.Description)
+		for _, t := range terms {
+			if strings.Contains(text, t) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// SuggestTitles returns at most limit distinct titles, in alphabetical order,
+// that start with prefix, ignoring case.
+func (db *memoryDB) SuggestTitles(_ context.Context, prefix string, limit int) ([]string, error) {
+	prefix = strings.ToLower(prefix)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	titles := []string{}
+	for _, b := range db.books {
+		if b.DeletedAt == nil && !isDraft(b) && !seen[b.Title] && strings.HasPrefix(strings.ToLower(b.Title), prefix) {
+			seen[b.Title] = true
+			titles = append(titles, b.Title)
+		}
+	}
+	return sortTitles(titles, limit), nil
+}
+
+// ForEachBook calls fn for every book, ordered by title.
+func (db *memoryDB) ForEachBook(ctx context.Context, fn func(*Book) error) error {
+	db.mu.RLock()
+	books := db.filter(func(*Book) bool { return true })
+	db.mu.RUnlock()
+
+	for _, b := range books {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListAuthors returns the distinct authors of the books, in alphabetical
+// order.
+func (db *memoryDB) ListAuthors(_ context.Context) ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	authors := []string{}
+	for _, b := range db.books {
+		if b.DeletedAt == nil && !isDraft(b) && b.Author != "" && !seen[b.Author] {
+			seen[b.Author] = true
+			authors = append(authors, b.Author)
+		}
+	}
+	sort.Strings(authors)
+	return authors, nil
+}
+
+// AverageRatingByAuthor returns the mean rating of the books of each author.
+func (db *memoryDB) AverageRatingByAuthor(_ context.Context) (map[string]float64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, b := range db.filter(func(*Book) bool { return true }) {
+		sums[b.Author] += b.Rating
+		counts[b.Author]++
+	}
+	for author, n := range counts {
+		sums[author] /= float64(n)
+	}
+	return sums, nil
+}
+
+// TopAuthors returns the limit authors with the most books, most prolific
+// first.
+func (db *memoryDB) TopAuthors(_ context.Context, limit int) ([]AuthorCount, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, b := range db.filter(func(b *Book) bool { return b.Author != "" }) {
+		counts[b.Author]++
+	}
+	top := make([]AuthorCount, 0, len(counts))
+	for author, n := range counts {
+		top = append(top, AuthorCount{Author: author, Count: n})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Author < top[j].Author
+	})
+	if limit > 0 && limit < len(top) {
+		top = top[:limit]
+	}
+	return top, nil
+}
+
+// ListTitleIndex returns the number of books whose title starts with each
+// letter.
+func (db *memoryDB) ListTitleIndex(_ context.Context) (map[string]int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	index := make(map[string]int)
+	for _, b := range db.filter(func(*Book) bool { return true }) {
+		index[indexKey(b.Title)]++
+	}
+	return index, nil
+}
+
+// CountBooks returns the number of published books.
+func (db *memoryDB) CountBooks(_ context.Context) (int64, error) {
+	return db.count(func(b *Book) bool { return !isDraft(b) }), nil
+}
+
+// CountBooksCreatedBy returns the number of books created by the given user.
+func (db *memoryDB) CountBooksCreatedBy(_ context.Context, userID string) (int64, error) {
+	return db.count(func(b *Book) bool { return b.CreatedByID == userID }), nil
+}
+
+// count returns the number of books that have not been deleted and for which
+// keep returns true.
+func (db *memoryDB) count(keep func(*Book) bool) int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var n int64
+	for _, b := range db.books {
+		if b.DeletedAt == nil && keep(b) {
+			n++
+		}
+	}
+	return n
+}
+
+// filter returns copies of the published books that have not been deleted
+// and for which keep returns true, ordered by title. The caller must hold
+// db.mu.
+func (db *memoryDB) filter(keep func(*Book) bool) []*Book {
+	return db.filterAll(func(b *Book) bool { return !isDraft(b) && keep(b) })
+}
+
+// filterAll is like filter, but keeps drafts too.
+func (db *memoryDB) filterAll(keep func(*Book) bool) []*Book {
+	books := []*Book{}
+	for _, b := range db.books {
+		if b.DeletedAt == nil && keep(b) {
+			books = append(books, copyBook(b))
+		}
+	}
+	sortByTitle(books)
+	return books
+}
+
+// sortByTitle orders books by title, ignoring case and accents, breaking
+// ties by ID so the order is stable across calls.
+func sortByTitle(books []*Book) {
+	sort.Slice(books, func(i, j int) bool {
+		if c := compareTitles(books[i].Title, books[j].Title); c != 0 {
+			return c < 0
+		}
+		return books[i].ID < books[j].ID
+	})
+}
+
+// paginate returns the window of books described by limit and offset. A
+// non-positive limit returns every book past offset.
+func paginate(books []*Book, limit, offset int) []*Book {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(books) {
+		return nil
+	}
+	books = books[offset:]
+	if limit > 0 && limit < len(books) {
+		books = books[:limit]
+	}
+	return books
+}
+
+// copyBook returns a copy of b that shares no memory with it.
+func copyBook(b *Book) *Book {
+	c := *b
+	if b.DeletedAt != nil {
+		t := *b.DeletedAt
+		c.DeletedAt = &t
+	}
+	if b.Tags != nil {
+		c.Tags = append([]string{}, b.Tags...)
+	}
+	if b.Metadata != nil {
+		c.Metadata = make(map[string]string, len(b.Metadata))
+		for k, v := range b.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+	return &c
+}
@@ -0,0 +1,208 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"errors"
+	"sort"
+)
+
+// updatableFields maps the names of the fields UpdateBookFields may change,
+// which are also their storage keys, to functions assigning them a value.
+var updatableFields = map[string]func(b *Book, v interface{}) error{
+	"title":          setString(func(b *Book) *string { return &b.Title }),
+	"author":         setString(func(b *Book) *string { return &b.Author }),
+	"published_date": setString(func(b *Book) *string { return &b.PublishedDate }),
+	"description":    setString(func(b *Book) *string { return &b.Description }),
+	"isbn":           setString(func(b *Book) *string { return &b.ISBN }),
+	"language":       setString(func(b *Book) *string { return &b.Language }),
+	"genre":          setString(func(b *Book) *string { return &b.Genre }),
+	"status":         setString(func(b *Book) *string { return &b.Status }),
+	"cover_url":      setString(func(b *Book) *string { return &b.CoverURL }),
+	"page_count": func(b *Book, v interface{}) error {
+		switch v := v.(type) {
+		case float64:
+			if v != float64(int(v)) {
+				return errors.New("must be a whole number")
+			}
+			b.PageCount = int(v)
+		case int:
+			b.PageCount = v
+		default:
+			return errors.New("must be a number")
+		}
+		return nil
+	},
+	"rating": func(b *Book, v interface{}) error {
+		switch v := v.(type) {
+		case float64:
+			b.Rating = v
+		case int:
+			b.Rating = float64(v)
+		default:
+			return errors.New("must be a number")
+		}
+		return nil
+	},
+	"metadata": func(b *Book, v interface{}) error {
+		switch v := v.(type) {
+		case map[string]string:
+			b.Metadata = make(map[string]string, len(v))
+			for k, s := range v {
+				b.Metadata[k] = s
+			}
+		case map[string]interface{}:
+			metadata := make(map[string]string, len(v))
+			for k, e := range v {
+				s, ok := e.(string)
+				if !ok {
+					return errors.New("must map strings to strings")
+				}
+				metadata[k] = s
+			}
+			b.Metadata = metadata
+		case nil:
+			b.Metadata = nil
+		default:
+			return errors.New("must map strings to strings")
+		}
+		return nil
+	},
+	"tags": func(b *Book, v interface{}) error {
+		switch v := v.(type) {
+		case []string:
+			b.Tags = append([]string{}, v...)
+		case []interface{}:
+			tags := make([]string, len(v))
+			for i, t := range v {
+				s, ok := t.(string)
+				if !ok {
+					return errors.New("must be a list of strings")
+				}
+				tags[i] = s
+			}
+			b.Tags = tags
+		case nil:
+			b.Tags = nil
+		default:
+			return errors.New("must be a list of strings")
+		}
+		return nil
+	},
+}
+
+// setString returns a setter for the string field of a book picked by field.
+func setString(field func(b *Book) *string) func(b *Book, v interface{}) error {
+	return func(b *Book, v interface{}) error {
+		s, ok := v.(string)
+		if !ok {
+			return errors.New("must be a string")
+		}
+		*field(b) = s
+		return nil
+	}
+}
+
+// applyFields assigns fields, keyed by their JSON names, to b and validates
+// the result. Fields that are unknown, not updatable or of the wrong type are
+// reported in a ValidationError.
+func applyFields(b *Book, fields map[string]interface{}) error {
+	var errs ValidationError
+	for _, name := range fieldNames(fields) {
+		set, ok := updatableFields[name]
+		if !ok {
+			errs = append(errs, &FieldError{Field: name, Err: errors.New("cannot be updated")})
+			continue
+		}
+		if err := set(b, fields[name]); err != nil {
+			errs = append(errs, &FieldError{Field: name, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return b.Validate()
+}
+
+// filterFields maps the names of the fields UpdateBooksWhere may select
+// books by, which are also their storage keys, to accessors for their values.
+var filterFields = map[string]func(*Book) string{
+	"title":          func(b *Book) string { return b.Title },
+	"author":         func(b *Book) string { return b.Author },
+	"published_date": func(b *Book) string { return b.PublishedDate },
+	"isbn":           func(b *Book) string { return b.ISBN },
+	"language":       func(b *Book) string { return b.Language },
+	"genre":          func(b *Book) string { return b.Genre },
+}
+
+// checkFilter checks that filter is not empty and only matches the fields of
+// filterFields against strings, reporting the offending ones in a
+// ValidationError.
+func checkFilter(filter map[string]interface{}) error {
+	if len(filter) == 0 {
+		return ValidationError{{Field: "filter", Err: errors.New("must not be empty")}}
+	}
+	var errs ValidationError
+	for _, name := range fieldNames(filter) {
+		if _, ok := filterFields[name]; !ok {
+			errs = append(errs, &FieldError{Field: name, Err: errors.New("cannot be filtered by")})
+		} else if _, ok := filter[name].(string); !ok {
+			errs = append(errs, &FieldError{Field: name, Err: errors.New("must be a string")})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// matchesFilter reports whether b has the values of filter, which has passed
+// checkFilter.
+func matchesFilter(b *Book, filter map[string]interface{}) bool {
+	for name, v := range filter {
+		if filterFields[name](b) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// bulkUpdate checks filter and set as given to UpdateBooksWhere, and returns
+// a book holding the values of set, so that they can be stored like those of
+// UpdateBookFields. Only the problems with the fields in set are reported.
+func bulkUpdate(filter, set map[string]interface{}) (*Book, error) {
+	if err := checkFilter(filter); err != nil {
+		return nil, err
+	}
+	if len(set) == 0 {
+		return nil, ValidationError{{Field: "set", Err: errors.New("must not be empty")}}
+	}
+	b := &Book{}
+	err := applyFields(b, set)
+	var verr ValidationError
+	if !errors.As(err, &verr) {
+		return b, err
+	}
+	var errs ValidationError
+	for _, fe := range verr {
+		if _, ok := set[fe.Field]; ok {
+			errs = append(errs, fe)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return b, nil
+}
+
+// fieldNames returns the keys of fields in sorted order.
+func fieldNames(fields map[string]interface{}) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,64 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyFields(t *testing.T) {
+	tests := []struct {
+		fields map[string]interface{}
+		want   Book
+	}{
+		{map[string]interface{}{}, Book{Title: "Dune", Rating: 3, Tags: []string{"scifi"}}},
+		{map[string]interface{}{"rating": 4}, Book{Title: "Dune", Rating: 4, Tags: []string{"scifi"}}},
+		{map[string]interface{}{"rating": 4.5}, Book{Title: "Dune", Rating: 4.5, Tags: []string{"scifi"}}},
+		{map[string]interface{}{"tags": []interface{}{"a", "b"}}, Book{Title: "Dune", Rating: 3, Tags: []string{"a", "b"}}},
+		{map[string]interface{}{"tags": nil}, Book{Title: "Dune", Rating: 3}},
+		{map[string]interface{}{"page_count": 412.0}, Book{Title: "Dune", Rating: 3, Tags: []string{"scifi"}, PageCount: 412}},
+		{
+			map[string]interface{}{"metadata": map[string]interface{}{"shelf": "B2"}},
+			Book{Title: "Dune", Rating: 3, Tags: []string{"scifi"}, Metadata: map[string]string{"shelf": "B2"}},
+		},
+		{
+			map[string]interface{}{"author": "Frank Herbert", "published_date": "1965"},
+			Book{Title: "Dune", Author: "Frank Herbert", PublishedDate: "1965", Rating: 3, Tags: []string{"scifi"}},
+		},
+	}
+	for _, tt := range tests {
+		b := &Book{Title: "Dune", Rating: 3, Tags: []string{"scifi"}}
+		if err := applyFields(b, tt.fields); err != nil {
+			t.Errorf("applyFields(%v): %v", tt.fields, err)
+			continue
+		}
+		if !reflect.DeepEqual(*b, tt.want) {
+			t.Errorf("applyFields(%v) = %+v, want %+v", tt.fields, *b, tt.want)
+		}
+	}
+}
+
+func TestApplyFieldsErrors(t *testing.T) {
+	b := &Book{Title: "Dune"}
+	err := applyFields(b, map[string]interface{}{
+		"id":         1,
+		"title":      7,
+		"tags":       []interface{}{"scifi", 3},
+		"rating":     4,
+		"page_count": 412.5,
+	})
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("applyFields: got %v, want a ValidationError", err)
+	}
+	var got []string
+	for _, fe := range verr {
+		got = append(got, fe.Field)
+	}
+	if want := []string{"id", "page_count", "tags", "title"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("applyFields reported fields %q, want %q", got, want)
+	}
+}
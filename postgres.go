@@ -0,0 +1,145 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// postgresDB is a sqlDB that searches with Postgres full-text search.
+type postgresDB struct {
+	*sqlDB
+}
+
+// Ensure postgresDB conforms to the BookDatabase interface.
+var _ BookDatabase = &postgresDB{}
+
+// createTableStatements are run by Migrate to make sure the schema exists.
+var createTableStatements = []string{
+	`CREATE TABLE IF NOT EXISTS books (
+		id BIGSERIAL PRIMARY KEY,
+		title TEXT NOT NULL DEFAULT '',
+		author TEXT NOT NULL DEFAULT '',
+		published_date TEXT NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		isbn TEXT NOT NULL DEFAULT '',
+		language TEXT NOT NULL DEFAULT '',
+		genre TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'published',
+		page_count INTEGER NOT NULL DEFAULT 0,
+		rating DOUBLE PRECISION NOT NULL DEFAULT 0,
+		tags TEXT[] NOT NULL DEFAULT '{}',
+		cover_url TEXT NOT NULL DEFAULT '',
+		metadata JSONB NOT NULL DEFAULT '{}',
+		title_key BYTEA,
+		created_by_id TEXT NOT NULL DEFAULT '',
+		created_by TEXT NOT NULL DEFAULT '',
+		version BIGINT NOT NULL DEFAULT 1,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		deleted_at TIMESTAMPTZ
+	)`,
+	`CREATE TABLE IF NOT EXISTS reviews (
+		id BIGSERIAL PRIMARY KEY,
+		book_id BIGINT NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+		author TEXT NOT NULL DEFAULT '',
+		body TEXT NOT NULL DEFAULT '',
+		rating INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS rating DOUBLE PRECISION NOT NULL DEFAULT 0`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS cover_url TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS created_by_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS created_by TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS genre TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS page_count INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS title_key BYTEA`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'published'`,
+	`CREATE INDEX IF NOT EXISTS reviews_book_id_idx ON reviews (book_id)`,
+	`CREATE INDEX IF NOT EXISTS books_author_idx ON books (author)`,
+	`CREATE INDEX IF NOT EXISTS books_title_key_idx ON books (title_key, id)`,
+	`CREATE INDEX IF NOT EXISTS books_created_by_id_idx ON books (created_by_id)`,
+	`CREATE INDEX IF NOT EXISTS books_created_at_idx ON books (created_at)`,
+	`CREATE INDEX IF NOT EXISTS books_updated_at_idx ON books (updated_at)`,
+	`CREATE INDEX IF NOT EXISTS books_language_idx ON books (language)`,
+	`CREATE INDEX IF NOT EXISTS books_genre_idx ON books (genre)`,
+	`CREATE INDEX IF NOT EXISTS books_status_idx ON books (status)`,
+	`CREATE INDEX IF NOT EXISTS books_page_count_idx ON books (page_count)`,
+	`CREATE INDEX IF NOT EXISTS books_tags_idx ON books USING GIN (tags)`,
+	`CREATE INDEX IF NOT EXISTS books_search_idx ON books
+		USING GIN (to_tsvector('english', ` + searchDocument + `))`,
+}
+
+// NewPostgresDB creates a new BookDatabase backed by the Postgres server
+// identified by connString. Call Migrate to create the schema.
+func NewPostgresDB(connString string) (BookDatabase, error) {
+	return NewPostgresDBWithLogger(connString, defaultLogger)
+}
+
+// NewPostgresDBWithLogger is like NewPostgresDB, but writes log messages,
+// including every query at debug level, to logger.
+func NewPostgresDBWithLogger(connString string, logger Logger) (BookDatabase, error) {
+	conn, err := sql.Open("postgres", connString)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: could not open: %v", err)
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("postgres: could not connect: %v", err)
+	}
+
+	return &postgresDB{&sqlDB{
+		name:        "postgres",
+		conn:        conn,
+		log:         logger,
+		tags:        func(tags *[]string) interface{} { return pq.Array(tags) },
+		hasTag:      "tags @> ARRAY[$1]",
+		hasAllTags:  "tags @> $1",
+		hasAnyTag:   "tags && $1",
+		hasMetadata: "metadata ->> $1 = $2",
+		schema:      createTableStatements,
+	}}, nil
+}
+
+// Reindex rebuilds the indexes of the books table. Postgres wants to be told
+// that books is a table.
+func (db *postgresDB) Reindex(ctx context.Context) error {
+	if _, err := db.conn.ExecContext(ctx, "REINDEX TABLE books"); err != nil {
+		return fmt.Errorf("%s: could not reindex: %v", db.name, err)
+	}
+	return nil
+}
+
+// SearchBooks returns the books whose title, author or description match the
+// given free-text query, most relevant first.
+func (db *postgresDB) SearchBooks(ctx context.Context, query string) ([]*Book, error) {
+	return db.SearchBooksFiltered(ctx, query, nil)
+}
+
+// SearchBooksFiltered returns the books found by SearchBooks that carry any
+// of the given tags, or all of them if there are none.
+func (db *postgresDB) SearchBooksFiltered(ctx context.Context, query string, tags []string) ([]*Book, error) {
+	where := "status <> 'draft' AND deleted_at IS NULL AND to_tsvector('english', " + searchDocument + ") @@ plainto_tsquery('english', $1)"
+	args := []interface{}{query}
+	if len(tags) > 0 {
+		where += " AND tags && $2"
+		args = append(args, db.tagsArg(tags))
+	}
+	return db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE "+where+
+			" ORDER BY ts_rank(to_tsvector('english', "+searchDocument+"), plainto_tsquery('english', $1)) DESC, title_key, id",
+		args...)
+}
@@ -0,0 +1,79 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// newTestPostgresDB connects to the Postgres server at POSTGRES_URL, skipping
+// the test if it is not set. It should point to a database of the tests' own
+// whose books table is empty.
+func newTestPostgresDB(t *testing.T) BookDatabase {
+	t.Helper()
+	connString := os.Getenv("POSTGRES_URL")
+	if connString == "" {
+		t.Skip("POSTGRES_URL is not set")
+	}
+	db, err := NewPostgresDB(connString)
+	if err != nil {
+		t.Fatalf("NewPostgresDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return db
+}
+
+func TestPostgresDB(t *testing.T) {
+	testDatabase(t, newTestPostgresDB(t))
+}
+
+// TestPostgresMigrateTwice checks that migrating a database whose schema
+// already exists keeps the books stored in it.
+func TestPostgresMigrateTwice(t *testing.T) {
+	ctx := context.Background()
+	db := newTestPostgresDB(t)
+	id := mustAdd(t, db, &Book{Title: "Dune"})
+
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatalf("second Migrate: %v", err)
+	}
+	if _, err := db.GetBook(ctx, id); err != nil {
+		t.Errorf("GetBook after migrating again: %v", err)
+	}
+}
+
+// TestPostgresSearchRanking checks that full-text search puts the most
+// relevant books first rather than ordering them by title.
+func TestPostgresSearchRanking(t *testing.T) {
+	db := newTestPostgresDB(t)
+	mustAdd(t, db, &Book{Title: "Dune", Description: "A desert planet and the spice found there."})
+	mustAdd(t, db, &Book{Title: "The Spice Trade", Description: "Spices, spice routes and the spice merchants."})
+	mustAdd(t, db, &Book{Title: "Emma", Description: "A novel of manners."})
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"spice", []string{"The Spice Trade", "Dune"}},
+		{"spices", []string{"The Spice Trade", "Dune"}},
+		{"spice planet", []string{"Dune"}},
+		{"manners", []string{"Emma"}},
+	}
+	for _, tt := range tests {
+		books, err := db.SearchBooks(context.Background(), tt.query)
+		if err != nil {
+			t.Fatalf("SearchBooks: %v", err)
+		}
+		if got := titles(books); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SearchBooks(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"time"
+)
+
+// readOnlyDB serves the reads of another database and refuses every write.
+type readOnlyDB struct {
+	inner BookDatabase
+}
+
+// Ensure readOnlyDB conforms to the BookDatabase interface.
+var _ BookDatabase = &readOnlyDB{}
+
+// NewReadOnlyDB wraps inner so that reads pass through while every write
+// returns ErrReadOnly without reaching inner, for serving a frozen catalog.
+func NewReadOnlyDB(inner BookDatabase) BookDatabase {
+	return &readOnlyDB{inner: inner}
+}
+
+func (db *readOnlyDB) GetBook(ctx context.Context, id int64) (*Book, error) {
+	return db.inner.GetBook(ctx, id)
+}
+
+func (db *readOnlyDB) BookExists(ctx context.Context, id int64) (bool, error) {
+	return db.inner.BookExists(ctx, id)
+}
+
+func (db *readOnlyDB) RandomBook(ctx context.Context) (*Book, error) {
+	return db.inner.RandomBook(ctx)
+}
+
+func (db *readOnlyDB) GetBooks(ctx context.Context, ids []int64) ([]*Book, error) {
+	return db.inner.GetBooks(ctx, ids)
+}
+
+func (db *readOnlyDB) ListBooks(ctx context.Context) ([]*Book, error) {
+	return db.inner.ListBooks(ctx)
+}
+
+func (db *readOnlyDB) ListBooksPaged(ctx context.Context, limit, offset int) ([]*Book, int, error) {
+	return db.inner.ListBooksPaged(ctx, limit, offset)
+}
+
+func (db *readOnlyDB) ListBooksAfter(ctx context.Context, afterID int64, limit int) ([]*Book, error) {
+	return db.inner.ListBooksAfter(ctx, afterID, limit)
+}
+
+func (db *readOnlyDB) ListBooksSorted(ctx context.Context, field string, descending bool) ([]*Book, error) {
+	return db.inner.ListBooksSorted(ctx, field, descending)
+}
+
+func (db *readOnlyDB) ListBooksByAuthor(ctx context.Context, author string) ([]*Book, error) {
+	return db.inner.ListBooksByAuthor(ctx, author)
+}
+
+func (db *readOnlyDB) ListBooksByStatus(ctx context.Context, status string) ([]*Book, error) {
+	return db.inner.ListBooksByStatus(ctx, status)
+}
+
+func (db *readOnlyDB) ListBooksByTag(ctx context.Context, tag string) ([]*Book, error) {
+	return db.inner.ListBooksByTag(ctx, tag)
+}
+
+func (db *readOnlyDB) ListBooksByTags(ctx context.Context, tags []string, matchAll bool) ([]*Book, error) {
+	return db.inner.ListBooksByTags(ctx, tags, matchAll)
+}
+
+func (db *readOnlyDB) ListBooksByYear(ctx context.Context, year int) ([]*Book, error) {
+	return db.inner.ListBooksByYear(ctx, year)
+}
+
+func (db *readOnlyDB) ListBooksBetweenDates(ctx context.Context, start, end time.Time) ([]*Book, error) {
+	return db.inner.ListBooksBetweenDates(ctx, start, end)
+}
+
+func (db *readOnlyDB) ListBooksByLanguage(ctx context.Context, lang string) ([]*Book, error) {
+	return db.inner.ListBooksByLanguage(ctx, lang)
+}
+
+func (db *readOnlyDB) ListBooksByGenre(ctx context.Context, genre string) ([]*Book, error) {
+	return db.inner.ListBooksByGenre(ctx, genre)
+}
+
+func (db *readOnlyDB) ListBooksByMetadata(ctx context.Context, key, value string) ([]*Book, error) {
+	return db.inner.ListBooksByMetadata(ctx, key, value)
+}
+
+func (db *readOnlyDB) ListBooksByPageRange(ctx context.Context, min, max int) ([]*Book, error) {
+	return db.inner.ListBooksByPageRange(ctx, min, max)
+}
+
+func (db *readOnlyDB) ListBooksCreatedBy(ctx context.Context, userID string) ([]*Book, error) {
+	return db.inner.ListBooksCreatedBy(ctx, userID)
+}
+
+func (db *readOnlyDB) ListBooksModifiedSince(ctx context.Context, since time.Time) ([]*Book, error) {
+	return db.inner.ListBooksModifiedSince(ctx, since)
+}
+
+func (db *readOnlyDB) ListRecentBooks(ctx context.Context, limit int) ([]*Book, error) {
+	return db.inner.ListRecentBooks(ctx, limit)
+}
+
+func (db *readOnlyDB) SearchBooks(ctx context.Context, query string) ([]*Book, error) {
+	return db.inner.SearchBooks(ctx, query)
+}
+
+func (db *readOnlyDB) SearchBooksFiltered(ctx context.Context, query string, tags []string) ([]*Book, error) {
+	return db.inner.SearchBooksFiltered(ctx, query, tags)
+}
+
+func (db *readOnlyDB) SuggestTitles(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return db.inner.SuggestTitles(ctx, prefix, limit)
+}
+
+func (db *readOnlyDB) ForEachBook(ctx context.Context, fn func(*Book) error) error {
+	return db.inner.ForEachBook(ctx, fn)
+}
+
+func (db *readOnlyDB) ListAuthors(ctx context.Context) ([]string, error) {
+	return db.inner.ListAuthors(ctx)
+}
+
+func (db *readOnlyDB) TopAuthors(ctx context.Context, limit int) ([]AuthorCount, error) {
+	return db.inner.TopAuthors(ctx, limit)
+}
+
+func (db *readOnlyDB) AverageRatingByAuthor(ctx context.Context) (map[string]float64, error) {
+	return db.inner.AverageRatingByAuthor(ctx)
+}
+
+func (db *readOnlyDB) ListTitleIndex(ctx context.Context) (map[string]int, error) {
+	return db.inner.ListTitleIndex(ctx)
+}
+
+func (db *readOnlyDB) CountBooks(ctx context.Context) (int64, error) {
+	return db.inner.CountBooks(ctx)
+}
+
+func (db *readOnlyDB) CountBooksCreatedBy(ctx context.Context, userID string) (int64, error) {
+	return db.inner.CountBooksCreatedBy(ctx, userID)
+}
+
+func (db *readOnlyDB) AddBook(ctx context.Context, b *Book) (int64, error) {
+	return 0, ErrReadOnly
+}
+
+func (db *readOnlyDB) AddBooks(ctx context.Context, books []*Book) ([]int64, error) {
+	return nil, ErrReadOnly
+}
+
+func (db *readOnlyDB) AddBooksBestEffort(ctx context.Context, books []*Book) ([]int64, []BookError, error) {
+	return nil, nil, ErrReadOnly
+}
+
+func (db *readOnlyDB) DeleteBook(ctx context.Context, id int64) error {
+	return ErrReadOnly
+}
+
+// DeleteBookDryRun fails like DeleteBook would.
+func (db *readOnlyDB) DeleteBookDryRun(ctx context.Context, id int64) (*Book, error) {
+	return nil, ErrReadOnly
+}
+
+func (db *readOnlyDB) DeleteBooks(ctx context.Context, ids []int64) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (db *readOnlyDB) RestoreBook(ctx context.Context, id int64) error {
+	return ErrReadOnly
+}
+
+func (db *readOnlyDB) PurgeDeleted(ctx context.Context, olderThan time.Duration) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (db *readOnlyDB) DeleteAllBooks(ctx context.Context) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (db *readOnlyDB) UpdateBook(ctx context.Context, b *Book) error {
+	return ErrReadOnly
+}
+
+func (db *readOnlyDB) PublishBook(ctx context.Context, id int64) error {
+	return ErrReadOnly
+}
+
+func (db *readOnlyDB) SetBookTags(ctx context.Context, id int64, tags []string) error {
+	return ErrReadOnly
+}
+
+func (db *readOnlyDB) UpdateBookFields(ctx context.Context, id int64, fields map[string]interface{}) error {
+	return ErrReadOnly
+}
+
+func (db *readOnlyDB) UpdateBooksWhere(ctx context.Context, filter, set map[string]interface{}) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (db *readOnlyDB) RenameAuthor(ctx context.Context, from, to string) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (db *readOnlyDB) AddReview(ctx context.Context, bookID int64, r *Review) error {
+	return ErrReadOnly
+}
+
+func (db *readOnlyDB) ListReviews(ctx context.Context, bookID int64) ([]*Review, error) {
+	return db.inner.ListReviews(ctx, bookID)
+}
+
+func (db *readOnlyDB) ListReviewsPaged(ctx context.Context, bookID int64, limit, offset int) ([]*Review, int, error) {
+	return db.inner.ListReviewsPaged(ctx, bookID, limit, offset)
+}
+
+// Migrate passes through, since indexes serve reads too.
+func (db *readOnlyDB) Migrate(ctx context.Context) error {
+	return db.inner.Migrate(ctx)
+}
+
+func (db *readOnlyDB) Reindex(ctx context.Context) error {
+	return ErrReadOnly
+}
+
+// WithTransaction runs fn, in which writes fail with ErrReadOnly like
+// anywhere else.
+func (db *readOnlyDB) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return db.inner.WithTransaction(ctx, fn)
+}
+
+func (db *readOnlyDB) Subscribe() (<-chan *Book, func()) {
+	return db.inner.Subscribe()
+}
+
+func (db *readOnlyDB) Ping(ctx context.Context) error {
+	return db.inner.Ping(ctx)
+}
+
+func (db *readOnlyDB) Name() string {
+	return db.inner.Name()
+}
+
+func (db *readOnlyDB) Close() error {
+	return db.inner.Close()
+}
@@ -0,0 +1,189 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// writeCountingDB counts the writes that reach it.
+type writeCountingDB struct {
+	BookDatabase
+	writes int
+}
+
+func (db *writeCountingDB) AddBook(ctx context.Context, b *Book) (int64, error) {
+	db.writes++
+	return db.BookDatabase.AddBook(ctx, b)
+}
+
+func (db *writeCountingDB) AddBooks(ctx context.Context, books []*Book) ([]int64, error) {
+	db.writes++
+	return db.BookDatabase.AddBooks(ctx, books)
+}
+
+func (db *writeCountingDB) AddBooksBestEffort(ctx context.Context, books []*Book) ([]int64, []BookError, error) {
+	db.writes++
+	return db.BookDatabase.AddBooksBestEffort(ctx, books)
+}
+
+func (db *writeCountingDB) PublishBook(ctx context.Context, id int64) error {
+	db.writes++
+	return db.BookDatabase.PublishBook(ctx, id)
+}
+
+func (db *writeCountingDB) SetBookTags(ctx context.Context, id int64, tags []string) error {
+	db.writes++
+	return db.BookDatabase.SetBookTags(ctx, id, tags)
+}
+
+func (db *writeCountingDB) Reindex(ctx context.Context) error {
+	db.writes++
+	return db.BookDatabase.Reindex(ctx)
+}
+
+func (db *writeCountingDB) DeleteBook(ctx context.Context, id int64) error {
+	db.writes++
+	return db.BookDatabase.DeleteBook(ctx, id)
+}
+
+func (db *writeCountingDB) DeleteBooks(ctx context.Context, ids []int64) (int, error) {
+	db.writes++
+	return db.BookDatabase.DeleteBooks(ctx, ids)
+}
+
+func (db *writeCountingDB) RestoreBook(ctx context.Context, id int64) error {
+	db.writes++
+	return db.BookDatabase.RestoreBook(ctx, id)
+}
+
+func (db *writeCountingDB) PurgeDeleted(ctx context.Context, olderThan time.Duration) (int, error) {
+	db.writes++
+	return db.BookDatabase.PurgeDeleted(ctx, olderThan)
+}
+
+func (db *writeCountingDB) DeleteAllBooks(ctx context.Context) (int, error) {
+	db.writes++
+	return db.BookDatabase.DeleteAllBooks(ctx)
+}
+
+func (db *writeCountingDB) RenameAuthor(ctx context.Context, from, to string) (int, error) {
+	db.writes++
+	return db.BookDatabase.RenameAuthor(ctx, from, to)
+}
+
+func (db *writeCountingDB) UpdateBook(ctx context.Context, b *Book) error {
+	db.writes++
+	return db.BookDatabase.UpdateBook(ctx, b)
+}
+
+func (db *writeCountingDB) UpdateBookFields(ctx context.Context, id int64, fields map[string]interface{}) error {
+	db.writes++
+	return db.BookDatabase.UpdateBookFields(ctx, id, fields)
+}
+
+func (db *writeCountingDB) UpdateBooksWhere(ctx context.Context, filter, set map[string]interface{}) (int, error) {
+	db.writes++
+	return db.BookDatabase.UpdateBooksWhere(ctx, filter, set)
+}
+
+func (db *writeCountingDB) AddReview(ctx context.Context, bookID int64, r *Review) error {
+	db.writes++
+	return db.BookDatabase.AddReview(ctx, bookID, r)
+}
+
+func TestReadOnlyDB(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemoryDB()
+	id, err := mem.AddBook(ctx, &Book{Title: "Dune", Author: "Frank Herbert"})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	inner := &writeCountingDB{BookDatabase: mem}
+	db := NewReadOnlyDB(inner)
+
+	writes := map[string]func() error{
+		"AddBook": func() error {
+			_, err := db.AddBook(ctx, &Book{Title: "Emma"})
+			return err
+		},
+		"AddBooks": func() error {
+			_, err := db.AddBooks(ctx, []*Book{{Title: "Emma"}})
+			return err
+		},
+		"AddBooksBestEffort": func() error {
+			_, _, err := db.AddBooksBestEffort(ctx, []*Book{{Title: "Emma"}})
+			return err
+		},
+		"DeleteBook": func() error { return db.DeleteBook(ctx, id) },
+		"DeleteBooks": func() error {
+			_, err := db.DeleteBooks(ctx, []int64{id})
+			return err
+		},
+		"DeleteBookDryRun": func() error {
+			_, err := db.DeleteBookDryRun(ctx, id)
+			return err
+		},
+		"RestoreBook": func() error { return db.RestoreBook(ctx, id) },
+		"PurgeDeleted": func() error {
+			_, err := db.PurgeDeleted(ctx, 0)
+			return err
+		},
+		"DeleteAllBooks": func() error {
+			_, err := db.DeleteAllBooks(ctx)
+			return err
+		},
+		"UpdateBook":       func() error { return db.UpdateBook(ctx, &Book{ID: id, Title: "Dune Messiah", Version: 1}) },
+		"UpdateBookFields": func() error { return db.UpdateBookFields(ctx, id, map[string]interface{}{"title": "Dune Messiah"}) },
+		"UpdateBooksWhere": func() error {
+			_, err := db.UpdateBooksWhere(ctx, map[string]interface{}{"title": "Dune"}, map[string]interface{}{"genre": GenreFiction})
+			return err
+		},
+		"RenameAuthor": func() error {
+			_, err := db.RenameAuthor(ctx, "Frank Herbert", "F. Herbert")
+			return err
+		},
+		"AddReview":   func() error { return db.AddReview(ctx, id, &Review{Body: "Spice.", Rating: 5}) },
+		"PublishBook": func() error { return db.PublishBook(ctx, id) },
+		"SetBookTags": func() error { return db.SetBookTags(ctx, id, []string{"scifi"}) },
+		"Reindex":     func() error { return db.Reindex(ctx) },
+		"WithTransaction": func() error {
+			return db.WithTransaction(ctx, func(ctx context.Context) error {
+				return db.DeleteBook(ctx, id)
+			})
+		},
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s: got %v, want ErrReadOnly", name, err)
+		}
+	}
+	if inner.writes != 0 {
+		t.Errorf("%d writes reached the inner database, want none", inner.writes)
+	}
+
+	b, err := db.GetBook(ctx, id)
+	if err != nil || b.Title != "Dune" {
+		t.Errorf("GetBook = %+v, %v; want Dune", b, err)
+	}
+	if books, err := db.ListBooks(ctx); err != nil || len(books) != 1 {
+		t.Errorf("ListBooks = %d books, %v; want 1", len(books), err)
+	}
+	if n, err := db.CountBooks(ctx); err != nil || n != 1 {
+		t.Errorf("CountBooks = %d, %v; want 1", n, err)
+	}
+	if authors, err := db.ListAuthors(ctx); err != nil || len(authors) != 1 {
+		t.Errorf("ListAuthors = %q, %v; want Frank Herbert", authors, err)
+	}
+	if reviews, err := db.ListReviews(ctx, id); err != nil || len(reviews) != 0 {
+		t.Errorf("ListReviews = %d reviews, %v; want none", len(reviews), err)
+	}
+	if err := db.Ping(ctx); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+}
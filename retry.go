@@ -0,0 +1,449 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+)
+
+// retryingDB retries the reads made to another database when they fail with
+// a transient error.
+type retryingDB struct {
+	inner       BookDatabase
+	maxAttempts int
+	base        time.Duration
+}
+
+// Ensure retryingDB conforms to the BookDatabase interface.
+var _ BookDatabase = &retryingDB{}
+
+// NewRetryingDB wraps inner so that reads failing with a transient error, as
+// classified by isTransient, are tried again up to maxAttempts times in all.
+// Attempts are spaced by an exponential backoff starting at base, with
+// jitter. Writes are never retried, since a write that timed out may still
+// have been applied.
+func NewRetryingDB(inner BookDatabase, maxAttempts int, base time.Duration) BookDatabase {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &retryingDB{
+		inner:       inner,
+		maxAttempts: maxAttempts,
+		base:        base,
+	}
+}
+
+// isTransient reports whether err is likely to go away if the call is
+// retried, such as a timeout or a dropped connection. Cancellation of the
+// caller's context is never transient.
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+	return errors.Is(err, ErrDatabaseUnavailable) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		// mgo reports failing over between servers this way.
+		err.Error() == "no reachable servers"
+}
+
+// retry calls fn until it succeeds, fails with an error that is not
+// transient, or has been called maxAttempts times, and returns its last
+// error.
+func (db *retryingDB) retry(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= db.maxAttempts || !isTransient(err) {
+			return err
+		}
+
+		// Wait somewhere between half and all of base * 2^(attempt-1), so
+		// that clients failing together do not retry together.
+		delay := db.base << uint(attempt-1)
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+func (db *retryingDB) ListBooks(ctx context.Context) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListBooks(ctx)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) ListBooksPaged(ctx context.Context, limit, offset int) (books []*Book, total int, err error) {
+	err = db.retry(ctx, func() error {
+		books, total, err = db.inner.ListBooksPaged(ctx, limit, offset)
+		return err
+	})
+	return books, total, err
+}
+
+func (db *retryingDB) ListBooksAfter(ctx context.Context, afterID int64, limit int) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListBooksAfter(ctx, afterID, limit)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) ListBooksSorted(ctx context.Context, field string, descending bool) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListBooksSorted(ctx, field, descending)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) ListBooksByAuthor(ctx context.Context, author string) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListBooksByAuthor(ctx, author)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) ListBooksByStatus(ctx context.Context, status string) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListBooksByStatus(ctx, status)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) ListBooksByTag(ctx context.Context, tag string) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListBooksByTag(ctx, tag)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) ListBooksByTags(ctx context.Context, tags []string, matchAll bool) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListBooksByTags(ctx, tags, matchAll)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) ListBooksByYear(ctx context.Context, year int) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListBooksByYear(ctx, year)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) ListBooksBetweenDates(ctx context.Context, start, end time.Time) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListBooksBetweenDates(ctx, start, end)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) ListBooksByLanguage(ctx context.Context, lang string) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListBooksByLanguage(ctx, lang)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) ListBooksByGenre(ctx context.Context, genre string) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListBooksByGenre(ctx, genre)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) ListBooksByMetadata(ctx context.Context, key, value string) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListBooksByMetadata(ctx, key, value)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) ListBooksByPageRange(ctx context.Context, min, max int) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListBooksByPageRange(ctx, min, max)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) ListBooksCreatedBy(ctx context.Context, userID string) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListBooksCreatedBy(ctx, userID)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) ListBooksModifiedSince(ctx context.Context, since time.Time) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListBooksModifiedSince(ctx, since)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) ListRecentBooks(ctx context.Context, limit int) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.ListRecentBooks(ctx, limit)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) SearchBooks(ctx context.Context, query string) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.SearchBooks(ctx, query)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) SearchBooksFiltered(ctx context.Context, query string, tags []string) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.SearchBooksFiltered(ctx, query, tags)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) SuggestTitles(ctx context.Context, prefix string, limit int) (titles []string, err error) {
+	err = db.retry(ctx, func() error {
+		titles, err = db.inner.SuggestTitles(ctx, prefix, limit)
+		return err
+	})
+	return titles, err
+}
+
+// ForEachBook is not retried, since fn may already have seen some of the
+// books when the error occurs.
+func (db *retryingDB) ForEachBook(ctx context.Context, fn func(*Book) error) error {
+	return db.inner.ForEachBook(ctx, fn)
+}
+
+func (db *retryingDB) ListAuthors(ctx context.Context) (authors []string, err error) {
+	err = db.retry(ctx, func() error {
+		authors, err = db.inner.ListAuthors(ctx)
+		return err
+	})
+	return authors, err
+}
+
+func (db *retryingDB) TopAuthors(ctx context.Context, limit int) (top []AuthorCount, err error) {
+	err = db.retry(ctx, func() error {
+		top, err = db.inner.TopAuthors(ctx, limit)
+		return err
+	})
+	return top, err
+}
+
+func (db *retryingDB) AverageRatingByAuthor(ctx context.Context) (ratings map[string]float64, err error) {
+	err = db.retry(ctx, func() error {
+		ratings, err = db.inner.AverageRatingByAuthor(ctx)
+		return err
+	})
+	return ratings, err
+}
+
+func (db *retryingDB) ListTitleIndex(ctx context.Context) (index map[string]int, err error) {
+	err = db.retry(ctx, func() error {
+		index, err = db.inner.ListTitleIndex(ctx)
+		return err
+	})
+	return index, err
+}
+
+func (db *retryingDB) CountBooks(ctx context.Context) (n int64, err error) {
+	err = db.retry(ctx, func() error {
+		n, err = db.inner.CountBooks(ctx)
+		return err
+	})
+	return n, err
+}
+
+func (db *retryingDB) CountBooksCreatedBy(ctx context.Context, userID string) (n int64, err error) {
+	err = db.retry(ctx, func() error {
+		n, err = db.inner.CountBooksCreatedBy(ctx, userID)
+		return err
+	})
+	return n, err
+}
+
+func (db *retryingDB) GetBook(ctx context.Context, id int64) (b *Book, err error) {
+	err = db.retry(ctx, func() error {
+		b, err = db.inner.GetBook(ctx, id)
+		return err
+	})
+	return b, err
+}
+
+func (db *retryingDB) BookExists(ctx context.Context, id int64) (exists bool, err error) {
+	err = db.retry(ctx, func() error {
+		exists, err = db.inner.BookExists(ctx, id)
+		return err
+	})
+	return exists, err
+}
+
+func (db *retryingDB) RandomBook(ctx context.Context) (b *Book, err error) {
+	err = db.retry(ctx, func() error {
+		b, err = db.inner.RandomBook(ctx)
+		return err
+	})
+	return b, err
+}
+
+func (db *retryingDB) GetBooks(ctx context.Context, ids []int64) (books []*Book, err error) {
+	err = db.retry(ctx, func() error {
+		books, err = db.inner.GetBooks(ctx, ids)
+		return err
+	})
+	return books, err
+}
+
+func (db *retryingDB) AddBook(ctx context.Context, b *Book) (int64, error) {
+	return db.inner.AddBook(ctx, b)
+}
+
+func (db *retryingDB) AddBooks(ctx context.Context, books []*Book) ([]int64, error) {
+	return db.inner.AddBooks(ctx, books)
+}
+
+func (db *retryingDB) AddBooksBestEffort(ctx context.Context, books []*Book) ([]int64, []BookError, error) {
+	return db.inner.AddBooksBestEffort(ctx, books)
+}
+
+func (db *retryingDB) DeleteBook(ctx context.Context, id int64) error {
+	return db.inner.DeleteBook(ctx, id)
+}
+
+// DeleteBookDryRun is retried, since it changes nothing.
+func (db *retryingDB) DeleteBookDryRun(ctx context.Context, id int64) (b *Book, err error) {
+	err = db.retry(ctx, func() error {
+		b, err = db.inner.DeleteBookDryRun(ctx, id)
+		return err
+	})
+	return b, err
+}
+
+func (db *retryingDB) DeleteBooks(ctx context.Context, ids []int64) (int, error) {
+	return db.inner.DeleteBooks(ctx, ids)
+}
+
+func (db *retryingDB) RestoreBook(ctx context.Context, id int64) error {
+	return db.inner.RestoreBook(ctx, id)
+}
+
+func (db *retryingDB) PurgeDeleted(ctx context.Context, olderThan time.Duration) (int, error) {
+	return db.inner.PurgeDeleted(ctx, olderThan)
+}
+
+func (db *retryingDB) DeleteAllBooks(ctx context.Context) (int, error) {
+	return db.inner.DeleteAllBooks(ctx)
+}
+
+func (db *retryingDB) UpdateBook(ctx context.Context, b *Book) error {
+	return db.inner.UpdateBook(ctx, b)
+}
+
+func (db *retryingDB) PublishBook(ctx context.Context, id int64) error {
+	return db.inner.PublishBook(ctx, id)
+}
+
+func (db *retryingDB) SetBookTags(ctx context.Context, id int64, tags []string) error {
+	return db.inner.SetBookTags(ctx, id, tags)
+}
+
+func (db *retryingDB) UpdateBookFields(ctx context.Context, id int64, fields map[string]interface{}) error {
+	return db.inner.UpdateBookFields(ctx, id, fields)
+}
+
+func (db *retryingDB) UpdateBooksWhere(ctx context.Context, filter, set map[string]interface{}) (int, error) {
+	return db.inner.UpdateBooksWhere(ctx, filter, set)
+}
+
+func (db *retryingDB) RenameAuthor(ctx context.Context, from, to string) (int, error) {
+	return db.inner.RenameAuthor(ctx, from, to)
+}
+
+func (db *retryingDB) AddReview(ctx context.Context, bookID int64, r *Review) error {
+	return db.inner.AddReview(ctx, bookID, r)
+}
+
+func (db *retryingDB) ListReviews(ctx context.Context, bookID int64) (reviews []*Review, err error) {
+	err = db.retry(ctx, func() error {
+		reviews, err = db.inner.ListReviews(ctx, bookID)
+		return err
+	})
+	return reviews, err
+}
+
+func (db *retryingDB) ListReviewsPaged(ctx context.Context, bookID int64, limit, offset int) (reviews []*Review, total int, err error) {
+	err = db.retry(ctx, func() error {
+		reviews, total, err = db.inner.ListReviewsPaged(ctx, bookID, limit, offset)
+		return err
+	})
+	return reviews, total, err
+}
+
+func (db *retryingDB) Migrate(ctx context.Context) error {
+	return db.inner.Migrate(ctx)
+}
+
+func (db *retryingDB) Reindex(ctx context.Context) error {
+	return db.inner.Reindex(ctx)
+}
+
+// WithTransaction is not retried, since fn may not be safe to run twice.
+// Reads made by fn are retried as usual.
+func (db *retryingDB) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return db.inner.WithTransaction(ctx, fn)
+}
+
+func (db *retryingDB) Subscribe() (<-chan *Book, func()) {
+	return db.inner.Subscribe()
+}
+
+// Ping is not retried, so that health checks report the database as it is.
+func (db *retryingDB) Ping(ctx context.Context) error {
+	return db.inner.Ping(ctx)
+}
+
+func (db *retryingDB) Name() string {
+	return db.inner.Name()
+}
+
+func (db *retryingDB) Close() error {
+	return db.inner.Close()
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyDB fails the first failures calls to GetBook and AddBook with err,
+// then passes them on, counting every call.
+type flakyDB struct {
+	BookDatabase
+	failures   int
+	err        error
+	gets, adds int
+}
+
+func (db *flakyDB) GetBook(ctx context.Context, id int64) (*Book, error) {
+	db.gets++
+	if db.gets <= db.failures {
+		return nil, db.err
+	}
+	return db.BookDatabase.GetBook(ctx, id)
+}
+
+func (db *flakyDB) AddBook(ctx context.Context, b *Book) (int64, error) {
+	db.adds++
+	if db.adds <= db.failures {
+		return 0, db.err
+	}
+	return db.BookDatabase.AddBook(ctx, b)
+}
+
+func TestRetryingDB(t *testing.T) {
+	testDatabase(t, NewRetryingDB(NewMemoryDB(), 3, time.Millisecond))
+}
+
+// errUnreachable is what mgo fails with while no server can be reached.
+var errUnreachable = errors.New("no reachable servers")
+
+func TestRetryingDBRetriesReads(t *testing.T) {
+	ctx := context.Background()
+	inner := &flakyDB{BookDatabase: NewMemoryDB()}
+	id := mustAdd(t, inner, &Book{Title: "Dune"})
+
+	inner.failures, inner.err = 2, errUnreachable
+	db := NewRetryingDB(inner, 3, time.Millisecond)
+	b, err := db.GetBook(ctx, id)
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	if b.Title != "Dune" {
+		t.Errorf("GetBook: got title %q, want %q", b.Title, "Dune")
+	}
+	if inner.gets != 3 {
+		t.Errorf("inner GetBook called %d times, want 3", inner.gets)
+	}
+}
+
+func TestRetryingDBGivesUp(t *testing.T) {
+	inner := &flakyDB{BookDatabase: NewMemoryDB(), failures: 5, err: errUnreachable}
+	db := NewRetryingDB(inner, 3, time.Millisecond)
+
+	if _, err := db.GetBook(context.Background(), 1); err != errUnreachable {
+		t.Errorf("GetBook: got %v, want %v", err, errUnreachable)
+	}
+	if inner.gets != 3 {
+		t.Errorf("inner GetBook called %d times, want 3", inner.gets)
+	}
+}
+
+func TestRetryingDBDoesNotRetryPermanentErrors(t *testing.T) {
+	inner := &flakyDB{BookDatabase: NewMemoryDB()}
+	db := NewRetryingDB(inner, 3, time.Millisecond)
+
+	if _, err := db.GetBook(context.Background(), 1); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("GetBook of a missing book: got %v, want ErrBookNotFound", err)
+	}
+	if inner.gets != 1 {
+		t.Errorf("inner GetBook called %d times, want 1", inner.gets)
+	}
+}
+
+func TestRetryingDBDoesNotRetryAddBook(t *testing.T) {
+	inner := &flakyDB{BookDatabase: NewMemoryDB(), failures: 1, err: errUnreachable}
+	db := NewRetryingDB(inner, 3, time.Millisecond)
+
+	if _, err := db.AddBook(context.Background(), &Book{Title: "Dune"}); err != errUnreachable {
+		t.Errorf("AddBook: got %v, want %v", err, errUnreachable)
+	}
+	if inner.adds != 1 {
+		t.Errorf("inner AddBook called %d times, want 1", inner.adds)
+	}
+}
+
+func TestRetryingDBStopsWhenCanceled(t *testing.T) {
+	inner := &flakyDB{BookDatabase: NewMemoryDB(), failures: 5, err: errUnreachable}
+	db := NewRetryingDB(inner, 5, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := db.GetBook(ctx, 1); err != errUnreachable {
+		t.Errorf("GetBook: got %v, want %v", err, errUnreachable)
+	}
+	if inner.gets != 1 {
+		t.Errorf("inner GetBook called %d times, want 1", inner.gets)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("boom"), false},
+		{ErrBookNotFound, false},
+		{ErrVersionConflict, false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+		{fmt.Errorf("mongodb: %w", context.DeadlineExceeded), false},
+		{errUnreachable, true},
+		{ErrDatabaseUnavailable, true},
+		{fmt.Errorf("%w: no reachable servers", ErrDatabaseUnavailable), true},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, true},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{syscall.EPIPE, true},
+		{io.EOF, true},
+		{fmt.Errorf("reading reply: %w", io.ErrUnexpectedEOF), true},
+	}
+	for _, tt := range tests {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Review is a reader's opinion of a book.
+type Review struct {
+	Author string `json:"author" bson:"author"`
+	Body   string `json:"body" bson:"body"`
+
+	// Rating is the reviewer's score, from 0 to 5.
+	Rating int `json:"rating" bson:"rating"`
+
+	// CreatedAt is set when the review is added.
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Validate checks that the review has a body and a rating between 0 and 5,
+// returning a ValidationError listing the problems found, if any.
+func (r *Review) Validate() error {
+	var errs ValidationError
+	if strings.TrimSpace(r.Body) == "" {
+		errs = append(errs, &FieldError{Field: "body", Err: errors.New("required")})
+	}
+	if r.Rating < 0 || r.Rating > 5 {
+		errs = append(errs, &FieldError{Field: "rating", Err: ErrInvalidRating})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
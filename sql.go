@@ -0,0 +1,1251 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sqlDB is a BookDatabase on top of database/sql, shared by the Postgres and
+// SQLite backends. Queries are written with $n placeholders, which both
+// understand, and are prepared once then reused.
+type sqlDB struct {
+	name string // prefixes error messages, e.g. "postgres".
+	conn *sql.DB
+	log  Logger // logs every query at debug level.
+
+	// tags adapts a pointer to a book's tags for use both as a query argument
+	// and as a scan destination.
+	tags func(*[]string) interface{}
+	// hasTag is the condition matching the books whose tags include $1.
+	hasTag string
+	// hasAllTags and hasAnyTag are the conditions matching the books whose
+	// tags include all or any of the tags of $1, adapted by tags.
+	hasAllTags, hasAnyTag string
+	// hasMetadata is the condition matching the books whose metadata maps
+	// $1 to $2.
+	hasMetadata string
+	// schema lists the statements Migrate runs to create the schema.
+	schema []string
+
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt // maps from query to its prepared statement.
+
+	feed bookFeed // publishes the books added through this sqlDB.
+}
+
+// Ensure sqlDB conforms to the BookDatabase interface.
+var _ BookDatabase = &sqlDB{}
+
+// bookColumns lists the columns of the books table in the order scanBook
+// expects them.
+const bookColumns = "id, title, author, published_date, description, isbn, language, genre, status, page_count, rating, tags, cover_url, metadata, created_by_id, created_by, version, created_at, updated_at, deleted_at"
+
+// Migrate creates the tables and indexes that do not exist yet, then fills in
+// the title sort keys of the books stored before they were introduced.
+func (db *sqlDB) Migrate(ctx context.Context) error {
+	for _, stmt := range db.schema {
+		db.log.Debugf("%s: exec %s", db.name, stmt)
+		if _, err := db.conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: could not create schema: %w", db.name, err)
+		}
+	}
+	if err := db.fillTitleKeys(ctx); err != nil {
+		return fmt.Errorf("%s: could not compute title sort keys: %w", db.name, err)
+	}
+	return nil
+}
+
+// Reindex rebuilds the indexes of the books table.
+func (db *sqlDB) Reindex(ctx context.Context) error {
+	if _, err := db.conn.ExecContext(ctx, "REINDEX books"); err != nil {
+		return fmt.Errorf("%s: could not reindex: %w", db.name, err)
+	}
+	return nil
+}
+
+// fillTitleKeys sets the title_key of the books that have none. The keys are
+// computed by titleKey, which the databases have no equivalent of, so books
+// are sorted by title the same way whatever the backend.
+func (db *sqlDB) fillTitleKeys(ctx context.Context) error {
+	return db.WithTransaction(ctx, func(ctx context.Context) error {
+		tx := db.txFrom(ctx).tx
+		rows, err := tx.QueryContext(ctx, "SELECT id, title FROM books WHERE title_key IS NULL")
+		if err != nil {
+			return err
+		}
+		titles := make(map[int64]string)
+		for rows.Next() {
+			var (
+				id    int64
+				title string
+			)
+			if err := rows.Scan(&id, &title); err != nil {
+				rows.Close()
+				return err
+			}
+			titles[id] = title
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for id, title := range titles {
+			db.log.Debugf("%s: setting title_key of book %d", db.name, id)
+			if _, err := tx.ExecContext(ctx, "UPDATE books SET title_key = $1 WHERE id = $2", titleKey(title), id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the database.
+func (db *sqlDB) Close() error {
+	db.mu.Lock()
+	var err error
+	for _, stmt := range db.stmts {
+		if cerr := stmt.Close(); err == nil {
+			err = cerr
+		}
+	}
+	db.stmts = nil
+	db.mu.Unlock()
+	db.feed.close()
+
+	if cerr := db.conn.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: could not close: %w", db.name, err)
+	}
+	return nil
+}
+
+// Name returns the name of the SQL database, "postgres" or "sqlite".
+func (db *sqlDB) Name() string {
+	return db.name
+}
+
+// Subscribe returns a channel receiving every book added from now on. Only
+// books added through this process are seen.
+func (db *sqlDB) Subscribe() (<-chan *Book, func()) {
+	return db.feed.Subscribe()
+}
+
+// Ping checks that the database can be reached.
+func (db *sqlDB) Ping(ctx context.Context) error {
+	return db.conn.PingContext(ctx)
+}
+
+// prepare returns the prepared statement for query, preparing it on first
+// use.
+func (db *sqlDB) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if stmt, ok := db.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := db.conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if db.stmts == nil {
+		db.stmts = make(map[string]*sql.Stmt)
+	}
+	db.stmts[query] = stmt
+	return stmt, nil
+}
+
+// stmt returns the prepared statement for query, bound to the transaction
+// ctx runs in, if any.
+func (db *sqlDB) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	stmt, err := db.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if t := db.txFrom(ctx); t != nil {
+		stmt = t.tx.StmtContext(ctx, stmt)
+	}
+	return stmt, nil
+}
+
+// query runs a prepared query that returns rows.
+func (db *sqlDB) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := db.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	db.log.Debugf("%s: query %s %v", db.name, query, args)
+	return stmt.QueryContext(ctx, args...)
+}
+
+// exec runs a prepared query that returns no rows.
+func (db *sqlDB) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := db.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	db.log.Debugf("%s: exec %s %v", db.name, query, args)
+	return stmt.ExecContext(ctx, args...)
+}
+
+// queryRow runs a prepared query that returns at most one row.
+func (db *sqlDB) queryRow(ctx context.Context, query string, args ...interface{}) rowScanner {
+	stmt, err := db.stmt(ctx, query)
+	if err != nil {
+		return errRow{err}
+	}
+	db.log.Debugf("%s: query %s %v", db.name, query, args)
+	return stmt.QueryRowContext(ctx, args...)
+}
+
+// sqlTx is a transaction started by WithTransaction.
+type sqlTx struct {
+	db      *sqlDB
+	tx      *sql.Tx
+	pending []*Book // books added in the transaction, published on commit.
+}
+
+// sqlTxKey is the context key under which WithTransaction stores its
+// *sqlTx.
+type sqlTxKey struct{}
+
+// txFrom returns the transaction of db that ctx runs in, or nil.
+func (db *sqlDB) txFrom(ctx context.Context) *sqlTx {
+	if t, ok := ctx.Value(sqlTxKey{}).(*sqlTx); ok && t.db == db {
+		return t
+	}
+	return nil
+}
+
+// conner is implemented by both *sql.DB and *sql.Tx.
+type conner interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// connFor returns the transaction ctx runs in, if any, or else the
+// connection pool, to run the queries that are not prepared.
+func (db *sqlDB) connFor(ctx context.Context) conner {
+	if t := db.txFrom(ctx); t != nil {
+		return t.tx
+	}
+	return db.conn
+}
+
+// publish publishes books to subscribers, or once the transaction ctx runs
+// in commits.
+func (db *sqlDB) publish(ctx context.Context, books ...*Book) {
+	if t := db.txFrom(ctx); t != nil {
+		t.pending = append(t.pending, books...)
+		return
+	}
+	db.feed.publish(books...)
+}
+
+// WithTransaction runs fn in a transaction, committing it if fn returns nil
+// and rolling it back otherwise. Calls nested in fn join its transaction.
+func (db *sqlDB) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if db.txFrom(ctx) != nil {
+		return fn(ctx)
+	}
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: could not begin transaction: %w", db.name, err)
+	}
+	t := &sqlTx{db: db, tx: tx}
+	if err := fn(context.WithValue(ctx, sqlTxKey{}, t)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: could not commit transaction: %w", db.name, err)
+	}
+	db.feed.publish(t.pending...)
+	return nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// errRow is a rowScanner that fails with err.
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(...interface{}) error {
+	return r.err
+}
+
+// scanBook reads a book from a row selected with bookColumns.
+func (db *sqlDB) scanBook(s rowScanner) (*Book, error) {
+	b := &Book{}
+	err := s.Scan(&b.ID, &b.Title, &b.Author, &b.PublishedDate, &b.Description, &b.ISBN, &b.Language, &b.Genre,
+		&b.Status, &b.PageCount, &b.Rating, db.tags(&b.Tags), &b.CoverURL, jsonMap{&b.Metadata}, &b.CreatedByID, &b.CreatedBy, &b.Version,
+		&b.CreatedAt, &b.UpdatedAt, &b.DeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// tagsArg adapts tags for storage in a NOT NULL column.
+func (db *sqlDB) tagsArg(tags []string) interface{} {
+	if tags == nil {
+		tags = []string{}
+	}
+	return db.tags(&tags)
+}
+
+// jsonMap stores a book's metadata as a JSON object, which is empty rather
+// than null when there is none.
+type jsonMap struct {
+	p *map[string]string
+}
+
+// Value implements driver.Valuer.
+func (m jsonMap) Value() (driver.Value, error) {
+	if len(*m.p) == 0 {
+		return "{}", nil
+	}
+	b, err := json.Marshal(*m.p)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner. An empty object scans as a nil map.
+func (m jsonMap) Scan(src interface{}) error {
+	var data []byte
+	switch src := src.(type) {
+	case string:
+		data = []byte(src)
+	case []byte:
+		data = src
+	case nil:
+		*m.p = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into metadata", src)
+	}
+	*m.p = nil
+	if err := json.Unmarshal(data, m.p); err != nil {
+		return err
+	}
+	if len(*m.p) == 0 {
+		*m.p = nil
+	}
+	return nil
+}
+
+// queryBooks runs a query selecting bookColumns and collects the results.
+func (db *sqlDB) queryBooks(ctx context.Context, query string, args ...interface{}) ([]*Book, error) {
+	rows, err := db.query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: could not list books: %w", db.name, err)
+	}
+	defer rows.Close()
+
+	books := []*Book{}
+	for rows.Next() {
+		b, err := db.scanBook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: could not read row: %w", db.name, err)
+		}
+		books = append(books, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: could not list books: %w", db.name, err)
+	}
+	return books, nil
+}
+
+// GetBook retrieves a book by its ID.
+func (db *sqlDB) GetBook(ctx context.Context, id int64) (*Book, error) {
+	row := db.queryRow(ctx, "SELECT "+bookColumns+" FROM books WHERE id = $1 AND deleted_at IS NULL", id)
+	b, err := db.scanBook(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrBookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: could not get book: %w", db.name, err)
+	}
+	return b, nil
+}
+
+// RandomBook retrieves a published book picked at random. Ordering by
+// random() reads every row, which is fine for the size of a bookshelf.
+func (db *sqlDB) RandomBook(ctx context.Context) (*Book, error) {
+	row := db.queryRow(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE status <> 'draft' AND deleted_at IS NULL ORDER BY random() LIMIT 1")
+	b, err := db.scanBook(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrBookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: could not pick a book: %w", db.name, err)
+	}
+	return b, nil
+}
+
+// BookExists reports whether there is a book with the given ID.
+func (db *sqlDB) BookExists(ctx context.Context, id int64) (bool, error) {
+	var exists bool
+	err := db.queryRow(ctx, "SELECT EXISTS (SELECT 1 FROM books WHERE id = $1 AND deleted_at IS NULL)", id).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("%s: could not look up book: %w", db.name, err)
+	}
+	return exists, nil
+}
+
+// GetBooks retrieves the books with the given IDs in a single query, in the
+// same order.
+func (db *sqlDB) GetBooks(ctx context.Context, ids []int64) ([]*Book, error) {
+	if len(ids) == 0 {
+		return []*Book{}, nil
+	}
+	args := make([]interface{}, len(ids))
+	placeholders := make([]string, len(ids))
+	for i, id := range ids {
+		args[i] = id
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	// The query changes with the number of IDs, so it is not worth
+	// preparing.
+	query := "SELECT " + bookColumns + " FROM books WHERE id IN (" + strings.Join(placeholders, ", ") + ") AND deleted_at IS NULL"
+	db.log.Debugf("%s: query %s %v", db.name, query, args)
+	rows, err := db.connFor(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: could not get books: %w", db.name, err)
+	}
+	defer rows.Close()
+
+	var books []*Book
+	for rows.Next() {
+		b, err := db.scanBook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: could not read row: %w", db.name, err)
+		}
+		books = append(books, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: could not get books: %w", db.name, err)
+	}
+	return inIDOrder(books, ids), nil
+}
+
+// AddBook saves a given book, assigning it a new ID.
+func (db *sqlDB) AddBook(ctx context.Context, b *Book) (id int64, err error) {
+	if err := b.Validate(); err != nil {
+		return 0, err
+	}
+
+	stmt, err := db.stmt(ctx, insertBookQuery)
+	if err != nil {
+		return 0, fmt.Errorf("%s: could not add book: %w", db.name, err)
+	}
+	id, err = db.insertBook(ctx, stmt, b)
+	if err != nil {
+		return 0, fmt.Errorf("%s: could not add book: %w", db.name, err)
+	}
+	db.publish(ctx, b)
+	return id, nil
+}
+
+// AddBooks saves the given books in a single transaction, assigning each a
+// new ID. Within WithTransaction, the books are added in its transaction.
+func (db *sqlDB) AddBooks(ctx context.Context, books []*Book) ([]int64, error) {
+	for _, b := range books {
+		if err := b.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	ids := make([]int64, len(books))
+	err := db.WithTransaction(ctx, func(ctx context.Context) error {
+		stmt, err := db.stmt(ctx, insertBookQuery)
+		if err != nil {
+			return err
+		}
+		for i, b := range books {
+			if ids[i], err = db.insertBook(ctx, stmt, b); err != nil {
+				return err
+			}
+		}
+		db.publish(ctx, books...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: could not add books: %w", db.name, err)
+	}
+	return ids, nil
+}
+
+// insertBookQuery inserts a book and returns the ID assigned to it.
+const insertBookQuery = `INSERT INTO books (title, author, published_date, description, isbn, language, genre,
+		page_count, rating, tags, cover_url, metadata, created_by_id, created_by, created_at, updated_at, title_key, status)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $15, $16, $17) RETURNING id`
+
+// insertBook inserts b with stmt, prepared from insertBookQuery, and sets
+// its ID to the one assigned by the database.
+func (db *sqlDB) insertBook(ctx context.Context, stmt *sql.Stmt, b *Book) (int64, error) {
+	now := time.Now().UTC()
+	b.defaultStatus()
+	db.log.Debugf("%s: query %s %q", db.name, insertBookQuery, b.Title)
+	err := stmt.QueryRowContext(ctx,
+		b.Title, b.Author, b.PublishedDate, b.Description, b.ISBN, b.Language, b.Genre, b.PageCount,
+		b.Rating, db.tagsArg(b.Tags), b.CoverURL, jsonMap{&b.Metadata}, b.CreatedByID, b.CreatedBy,
+		now, titleKey(b.Title), b.Status).Scan(&b.ID)
+	if err != nil {
+		return 0, err
+	}
+	b.Version = 1
+	b.CreatedAt, b.UpdatedAt = now, now
+	b.DeletedAt = nil
+	return b.ID, nil
+}
+
+// AddBooksBestEffort saves the given books one at a time, skipping the
+// invalid ones and those whose ISBN is taken.
+func (db *sqlDB) AddBooksBestEffort(ctx context.Context, books []*Book) ([]int64, []BookError, error) {
+	return addEach(ctx, books, db.AddBook)
+}
+
+// DeleteBook marks a given book as deleted by its ID.
+func (db *sqlDB) DeleteBook(ctx context.Context, id int64) error {
+	res, err := db.exec(ctx,
+		"UPDATE books SET deleted_at = $2 WHERE id = $1 AND deleted_at IS NULL", id, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("%s: could not delete book: %w", db.name, err)
+	}
+	return expectAffected(res)
+}
+
+// DeleteBookDryRun returns the book DeleteBook would delete given its ID.
+func (db *sqlDB) DeleteBookDryRun(ctx context.Context, id int64) (*Book, error) {
+	return db.GetBook(ctx, id)
+}
+
+// DeleteBooks marks the books with the given IDs as deleted in a single
+// statement, returning how many were.
+func (db *sqlDB) DeleteBooks(ctx context.Context, ids []int64) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	args := []interface{}{time.Now().UTC()}
+	placeholders := make([]string, len(ids))
+	for i, id := range ids {
+		args = append(args, id)
+		placeholders[i] = fmt.Sprintf("$%d", len(args))
+	}
+	// The query changes with the number of IDs, so it is not worth
+	// preparing.
+	query := "UPDATE books SET deleted_at = $1 WHERE id IN (" + strings.Join(placeholders, ", ") + ") AND deleted_at IS NULL"
+	db.log.Debugf("%s: exec %s %v", db.name, query, args)
+	res, err := db.connFor(ctx).ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("%s: could not delete books: %w", db.name, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// RestoreBook brings back a deleted book by its ID.
+func (db *sqlDB) RestoreBook(ctx context.Context, id int64) error {
+	res, err := db.exec(ctx,
+		"UPDATE books SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return fmt.Errorf("%s: could not restore book: %w", db.name, err)
+	}
+	return expectAffected(res)
+}
+
+// PurgeDeleted permanently removes the books deleted more than olderThan ago.
+func (db *sqlDB) PurgeDeleted(ctx context.Context, olderThan time.Duration) (int, error) {
+	res, err := db.exec(ctx,
+		"DELETE FROM books WHERE deleted_at <= $1", time.Now().UTC().Add(-olderThan))
+	if err != nil {
+		return 0, fmt.Errorf("%s: could not purge books: %w", db.name, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// DeleteAllBooks permanently removes every book. Their reviews go with them.
+func (db *sqlDB) DeleteAllBooks(ctx context.Context) (int, error) {
+	res, err := db.exec(ctx, "DELETE FROM books")
+	if err != nil {
+		return 0, fmt.Errorf("%s: could not delete books: %w", db.name, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// UpdateBook updates the entry for a given book.
+func (db *sqlDB) UpdateBook(ctx context.Context, b *Book) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+
+	b.defaultStatus()
+	now := time.Now().UTC()
+	res, err := db.exec(ctx,
+		`UPDATE books SET title = $2, author = $3, published_date = $4, description = $5, isbn = $6,
+			language = $7, genre = $8, page_count = $9, rating = $10, tags = $11, cover_url = $12,
+			metadata = $13, title_key = $16, status = $17, version = version + 1, updated_at = $15
+		WHERE id = $1 AND version = $14 AND deleted_at IS NULL`,
+		b.ID, b.Title, b.Author, b.PublishedDate, b.Description, b.ISBN, b.Language, b.Genre, b.PageCount,
+		b.Rating, db.tagsArg(b.Tags), b.CoverURL, jsonMap{&b.Metadata}, b.Version, now, titleKey(b.Title), b.Status)
+	if err != nil {
+		return fmt.Errorf("%s: could not update book: %w", db.name, err)
+	}
+	err = expectAffected(res)
+	if err == ErrBookNotFound {
+		return conflictOrNotFound(ctx, db, b.ID)
+	}
+	if err != nil {
+		return err
+	}
+	b.Version++
+	b.UpdatedAt = now
+	return nil
+}
+
+// PublishBook sets the status of a given book to published.
+func (db *sqlDB) PublishBook(ctx context.Context, id int64) error {
+	return db.UpdateBookFields(ctx, id, map[string]interface{}{"status": StatusPublished})
+}
+
+// SetBookTags replaces the tags of a given book.
+func (db *sqlDB) SetBookTags(ctx context.Context, id int64, tags []string) error {
+	return db.UpdateBookFields(ctx, id, map[string]interface{}{"tags": normalizeTags(tags)})
+}
+
+// UpdateBookFields changes only the given fields of a book.
+func (db *sqlDB) UpdateBookFields(ctx context.Context, id int64, fields map[string]interface{}) error {
+	b, err := db.GetBook(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := applyFields(b, fields); err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	// Column names come from the updatableFields whitelist checked by
+	// applyFields, so they are safe to splice into the query.
+	query := "UPDATE books SET "
+	args := []interface{}{id}
+	for i, name := range fieldNames(fields) {
+		if i > 0 {
+			query += ", "
+		}
+		args = append(args, db.columnValue(b, name))
+		query += fmt.Sprintf("%s = $%d", name, len(args))
+	}
+	if _, ok := fields["title"]; ok {
+		args = append(args, titleKey(b.Title))
+		query += fmt.Sprintf(", title_key = $%d", len(args))
+	}
+	args = append(args, time.Now().UTC())
+	query += fmt.Sprintf(", version = version + 1, updated_at = $%d WHERE id = $1 AND deleted_at IS NULL", len(args))
+
+	res, err := db.exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("%s: could not update book: %w", db.name, err)
+	}
+	return expectAffected(res)
+}
+
+// UpdateBooksWhere sets the fields of set on every book whose fields have the
+// values of filter, returning how many books matched.
+func (db *sqlDB) UpdateBooksWhere(ctx context.Context, filter, set map[string]interface{}) (int, error) {
+	b, err := bulkUpdate(filter, set)
+	if err != nil {
+		return 0, err
+	}
+
+	// Column names come from the filterFields and updatableFields
+	// whitelists, so they are safe to splice into the query.
+	var (
+		sets, conds []string
+		args        []interface{}
+	)
+	for _, name := range fieldNames(set) {
+		args = append(args, db.columnValue(b, name))
+		sets = append(sets, fmt.Sprintf("%s = $%d", name, len(args)))
+	}
+	if _, ok := set["title"]; ok {
+		args = append(args, titleKey(b.Title))
+		sets = append(sets, fmt.Sprintf("title_key = $%d", len(args)))
+	}
+	args = append(args, time.Now().UTC())
+	sets = append(sets, "version = version + 1", fmt.Sprintf("updated_at = $%d", len(args)))
+	for _, name := range fieldNames(filter) {
+		args = append(args, filter[name])
+		conds = append(conds, fmt.Sprintf("%s = $%d", name, len(args)))
+	}
+
+	res, err := db.exec(ctx,
+		"UPDATE books SET "+strings.Join(sets, ", ")+
+			" WHERE "+strings.Join(conds, " AND ")+" AND deleted_at IS NULL",
+		args...)
+	if err != nil {
+		return 0, fmt.Errorf("%s: could not update books: %w", db.name, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: could not update books: %w", db.name, err)
+	}
+	return int(n), nil
+}
+
+// RenameAuthor changes the author of every book written by from to to.
+func (db *sqlDB) RenameAuthor(ctx context.Context, from, to string) (int, error) {
+	if from == to {
+		return 0, nil
+	}
+	return db.UpdateBooksWhere(ctx, map[string]interface{}{"author": from}, map[string]interface{}{"author": to})
+}
+
+// columnValue returns the value to store in the column of b with the given
+// updatable field name.
+func (db *sqlDB) columnValue(b *Book, name string) interface{} {
+	switch name {
+	case "title":
+		return b.Title
+	case "author":
+		return b.Author
+	case "published_date":
+		return b.PublishedDate
+	case "description":
+		return b.Description
+	case "isbn":
+		return b.ISBN
+	case "language":
+		return b.Language
+	case "genre":
+		return b.Genre
+	case "status":
+		return b.Status
+	case "page_count":
+		return b.PageCount
+	case "rating":
+		return b.Rating
+	case "tags":
+		return db.tagsArg(b.Tags)
+	case "cover_url":
+		return b.CoverURL
+	case "metadata":
+		return jsonMap{&b.Metadata}
+	}
+	panic("bookshelf: no column for field " + name)
+}
+
+// expectAffected returns ErrBookNotFound if res reports no rows affected.
+func expectAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrBookNotFound
+	}
+	return nil
+}
+
+// sqlLimit returns the value for a LIMIT clause, where a non-positive limit
+// means no limit at all.
+func sqlLimit(limit int) int64 {
+	if limit <= 0 {
+		return math.MaxInt64
+	}
+	return int64(limit)
+}
+
+// AddReview adds a review to the book with the given ID.
+func (db *sqlDB) AddReview(ctx context.Context, bookID int64, r *Review) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	// Selecting from books inserts nothing unless the book exists.
+	res, err := db.exec(ctx,
+		`INSERT INTO reviews (book_id, author, body, rating, created_at)
+		SELECT id, $2, $3, $4, $5 FROM books WHERE id = $1 AND deleted_at IS NULL`,
+		bookID, r.Author, r.Body, r.Rating, now)
+	if err != nil {
+		return fmt.Errorf("%s: could not add review: %w", db.name, err)
+	}
+	if err := expectAffected(res); err != nil {
+		return err
+	}
+	r.CreatedAt = now
+	return nil
+}
+
+// ListReviews returns the reviews of the book with the given ID, in the order
+// they were added.
+func (db *sqlDB) ListReviews(ctx context.Context, bookID int64) ([]*Review, error) {
+	if _, err := db.GetBook(ctx, bookID); err != nil {
+		return nil, err
+	}
+	return db.queryReviews(ctx,
+		"SELECT author, body, rating, created_at FROM reviews WHERE book_id = $1 ORDER BY id", bookID)
+}
+
+// ListReviewsPaged returns at most limit reviews of the book with the given
+// ID, skipping the first offset of them, along with the total number of its
+// reviews.
+func (db *sqlDB) ListReviewsPaged(ctx context.Context, bookID int64, limit, offset int) ([]*Review, int, error) {
+	if _, err := db.GetBook(ctx, bookID); err != nil {
+		return nil, 0, err
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var total int
+	if err := db.queryRow(ctx, "SELECT count(*) FROM reviews WHERE book_id = $1", bookID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("%s: could not count reviews: %w", db.name, err)
+	}
+	reviews, err := db.queryReviews(ctx,
+		"SELECT author, body, rating, created_at FROM reviews WHERE book_id = $1 ORDER BY id LIMIT $2 OFFSET $3",
+		bookID, sqlLimit(limit), offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return reviews, total, nil
+}
+
+// queryReviews runs a query selecting author, body, rating and created_at
+// from reviews, and returns the reviews it yields.
+func (db *sqlDB) queryReviews(ctx context.Context, query string, args ...interface{}) ([]*Review, error) {
+	rows, err := db.query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: could not list reviews: %w", db.name, err)
+	}
+	defer rows.Close()
+
+	reviews := []*Review{}
+	for rows.Next() {
+		r := &Review{}
+		if err := rows.Scan(&r.Author, &r.Body, &r.Rating, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: could not read row: %w", db.name, err)
+		}
+		reviews = append(reviews, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: could not list reviews: %w", db.name, err)
+	}
+	return reviews, nil
+}
+
+// ListBooks returns a list of the published books, ordered by title.
+func (db *sqlDB) ListBooks(ctx context.Context) ([]*Book, error) {
+	return db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE status <> 'draft' AND deleted_at IS NULL ORDER BY title_key, id")
+}
+
+// ListBooksPaged returns at most limit published books, ordered by title,
+// skipping the first offset of them, along with the total number of
+// published books.
+func (db *sqlDB) ListBooksPaged(ctx context.Context, limit, offset int) ([]*Book, int, error) {
+	if offset < 0 {
+		offset = 0
+	}
+
+	var total int
+	err := db.queryRow(ctx, "SELECT count(*) FROM books WHERE status <> 'draft' AND deleted_at IS NULL").Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: could not count books: %w", db.name, err)
+	}
+	books, err := db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE status <> 'draft' AND deleted_at IS NULL ORDER BY title_key, id LIMIT $1 OFFSET $2",
+		sqlLimit(limit), offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return books, total, nil
+}
+
+// ListBooksAfter returns at most limit books with an ID greater than afterID,
+// ordered by ID.
+func (db *sqlDB) ListBooksAfter(ctx context.Context, afterID int64, limit int) ([]*Book, error) {
+	return db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE id > $1 AND status <> 'draft' AND deleted_at IS NULL ORDER BY id LIMIT $2",
+		afterID, sqlLimit(limit))
+}
+
+// ListBooksSorted returns a list of books ordered by the given field.
+func (db *sqlDB) ListBooksSorted(ctx context.Context, field string, descending bool) ([]*Book, error) {
+	// The whitelist keeps field safe to splice into the query.
+	if _, ok := sortFields[field]; !ok {
+		return nil, ErrInvalidSortField
+	}
+	column, order := field, " ASC"
+	if field == "title" {
+		column = "title_key"
+	}
+	if descending {
+		order = " DESC"
+	}
+	return db.queryBooks(ctx, "SELECT "+bookColumns+" FROM books WHERE status <> 'draft' AND deleted_at IS NULL ORDER BY "+column+order+", id")
+}
+
+// ListBooksByAuthor returns a list of books, ordered by title, written by the
+// given author.
+func (db *sqlDB) ListBooksByAuthor(ctx context.Context, author string) ([]*Book, error) {
+	return db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE author = $1 AND status <> 'draft' AND deleted_at IS NULL ORDER BY title_key, id", author)
+}
+
+// ListBooksByStatus returns a list of books, ordered by title, with the given
+// status.
+func (db *sqlDB) ListBooksByStatus(ctx context.Context, status string) ([]*Book, error) {
+	if err := checkStatus(status); err != nil {
+		return nil, err
+	}
+	cond := "status <> 'draft'"
+	if status == StatusDraft {
+		cond = "status = 'draft'"
+	}
+	return db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE "+cond+" AND deleted_at IS NULL ORDER BY title_key, id")
+}
+
+// ListBooksByTag returns a list of books, ordered by title, that carry the
+// given tag.
+func (db *sqlDB) ListBooksByTag(ctx context.Context, tag string) ([]*Book, error) {
+	return db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE "+db.hasTag+" AND status <> 'draft' AND deleted_at IS NULL ORDER BY title_key, id", tag)
+}
+
+// ListBooksByTags returns a list of books, ordered by title, that carry all
+// or any of the given tags.
+func (db *sqlDB) ListBooksByTags(ctx context.Context, tags []string, matchAll bool) ([]*Book, error) {
+	if len(tags) == 0 {
+		return []*Book{}, nil
+	}
+	cond := db.hasAnyTag
+	if matchAll {
+		cond = db.hasAllTags
+	}
+	return db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE "+cond+" AND status <> 'draft' AND deleted_at IS NULL ORDER BY title_key, id",
+		db.tagsArg(tags))
+}
+
+// ListBooksByYear returns a list of books, ordered by title, published in the
+// given year.
+func (db *sqlDB) ListBooksByYear(ctx context.Context, year int) ([]*Book, error) {
+	result, err := db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE published_date LIKE $1 AND status <> 'draft' AND deleted_at IS NULL ORDER BY title_key, id",
+		fmt.Sprintf("%04d%%", year))
+	if err != nil {
+		return nil, err
+	}
+	return keepPublishedIn(result, year), nil
+}
+
+// ListBooksBetweenDates returns a list of books, ordered by published date,
+// published between start and end.
+func (db *sqlDB) ListBooksBetweenDates(ctx context.Context, start, end time.Time) ([]*Book, error) {
+	var (
+		conds = []string{"status <> 'draft' AND deleted_at IS NULL"}
+		args  []interface{}
+	)
+	// Only the years are compared, since they are digits alone and so sort
+	// the same whatever the collation.
+	from, to := publishedYearBounds(start, end)
+	if from != "" {
+		args = append(args, from)
+		conds = append(conds, fmt.Sprintf("substr(published_date, 1, 4) >= $%d", len(args)))
+	}
+	if to != "" {
+		args = append(args, to)
+		conds = append(conds, fmt.Sprintf("substr(published_date, 1, 4) <= $%d", len(args)))
+	}
+	result, err := db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE "+strings.Join(conds, " AND ")+" ORDER BY title_key, id",
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	return keepPublishedBetween(result, start, end), nil
+}
+
+// ListBooksByLanguage returns a list of books, ordered by title, written in the
+// given language.
+func (db *sqlDB) ListBooksByLanguage(ctx context.Context, lang string) ([]*Book, error) {
+	return db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE language = $1 AND status <> 'draft' AND deleted_at IS NULL ORDER BY title_key, id", lang)
+}
+
+// ListBooksByGenre returns a list of books, ordered by title, of the given
+// genre.
+func (db *sqlDB) ListBooksByGenre(ctx context.Context, genre string) ([]*Book, error) {
+	return db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE genre = $1 AND status <> 'draft' AND deleted_at IS NULL ORDER BY title_key, id", genre)
+}
+
+// ListBooksByMetadata returns a list of books, ordered by title, whose
+// metadata maps key to value.
+func (db *sqlDB) ListBooksByMetadata(ctx context.Context, key, value string) ([]*Book, error) {
+	if err := validateMetadataKey(key); err != nil {
+		return nil, ValidationError{{Field: "metadata", Err: err}}
+	}
+	return db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE "+db.hasMetadata+" AND status <> 'draft' AND deleted_at IS NULL ORDER BY title_key, id",
+		key, value)
+}
+
+// ListBooksByPageRange returns a list of books, ordered by title, with between
+// min and max pages inclusive.
+func (db *sqlDB) ListBooksByPageRange(ctx context.Context, min, max int) ([]*Book, error) {
+	cond, args := "page_count >= $1", []interface{}{min}
+	if max > 0 {
+		cond, args = cond+" AND page_count <= $2", append(args, max)
+	}
+	return db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE "+cond+" AND status <> 'draft' AND deleted_at IS NULL ORDER BY title_key, id", args...)
+}
+
+// ListBooksCreatedBy returns a list of books, ordered by title, filtered by
+// the user who created the book entry, drafts included.
+func (db *sqlDB) ListBooksCreatedBy(ctx context.Context, userID string) ([]*Book, error) {
+	return db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE created_by_id = $1 AND deleted_at IS NULL ORDER BY title_key, id", userID)
+}
+
+// ListBooksModifiedSince returns the books added or updated at or after since,
+// ordered by UpdatedAt.
+func (db *sqlDB) ListBooksModifiedSince(ctx context.Context, since time.Time) ([]*Book, error) {
+	return db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE updated_at >= $1 AND status <> 'draft' AND deleted_at IS NULL ORDER BY updated_at, id",
+		since.UTC())
+}
+
+// ListRecentBooks returns the limit most recently added books, newest first.
+func (db *sqlDB) ListRecentBooks(ctx context.Context, limit int) ([]*Book, error) {
+	return db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE status <> 'draft' AND deleted_at IS NULL ORDER BY created_at DESC, id DESC LIMIT $1",
+		sqlLimit(limit))
+}
+
+// SearchBooks returns the books whose title, author or description contain
+// any of the words of the query, ignoring case. Results are ordered by title.
+func (db *sqlDB) SearchBooks(ctx context.Context, query string) ([]*Book, error) {
+	return db.SearchBooksFiltered(ctx, query, nil)
+}
+
+// SearchBooksFiltered returns the books found by SearchBooks that carry any
+// of the given tags, or all of them if there are none.
+func (db *sqlDB) SearchBooksFiltered(ctx context.Context, query string, tags []string) ([]*Book, error) {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return []*Book{}, nil
+	}
+
+	var (
+		conds []string
+		args  []interface{}
+		where = "status <> 'draft' AND deleted_at IS NULL"
+	)
+	if len(tags) > 0 {
+		// hasAnyTag takes the tags as $1, so the terms come after them.
+		args = append(args, db.tagsArg(tags))
+		where += " AND " + db.hasAnyTag
+	}
+	for _, t := range terms {
+		args = append(args, "%"+likeEscaper.Replace(t)+"%")
+		conds = append(conds, fmt.Sprintf(`lower(%s) LIKE $%d ESCAPE '\'`, searchDocument, len(args)))
+	}
+	return db.queryBooks(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE "+where+" AND ("+strings.Join(conds, " OR ")+") ORDER BY title_key, id",
+		args...)
+}
+
+// searchDocument is the text SearchBooks matches queries against.
+const searchDocument = "title || ' ' || author || ' ' || description"
+
+// likeEscaper escapes the wildcards of a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SuggestTitles returns at most limit distinct titles, in alphabetical order,
+// that start with prefix, ignoring case.
+func (db *sqlDB) SuggestTitles(ctx context.Context, prefix string, limit int) ([]string, error) {
+	return db.queryStrings(ctx, "suggest titles",
+		`SELECT title FROM books WHERE lower(title) LIKE $1 ESCAPE '\' AND status <> 'draft' AND deleted_at IS NULL
+		GROUP BY title, title_key ORDER BY title_key, title LIMIT $2`,
+		likeEscaper.Replace(strings.ToLower(prefix))+"%", sqlLimit(limit))
+}
+
+// queryStrings runs a query selecting a single text column and collects the
+// results. what describes the query in error messages.
+func (db *sqlDB) queryStrings(ctx context.Context, what, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: could not %s: %w", db.name, what, err)
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("%s: could not read row: %w", db.name, err)
+		}
+		values = append(values, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: could not %s: %w", db.name, what, err)
+	}
+	return values, nil
+}
+
+// ForEachBook calls fn for every book, ordered by title, reading them from the
+// result set one row at a time.
+func (db *sqlDB) ForEachBook(ctx context.Context, fn func(*Book) error) error {
+	rows, err := db.query(ctx,
+		"SELECT "+bookColumns+" FROM books WHERE status <> 'draft' AND deleted_at IS NULL ORDER BY title_key, id")
+	if err != nil {
+		return fmt.Errorf("%s: could not list books: %w", db.name, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		b, err := db.scanBook(rows)
+		if err != nil {
+			return fmt.Errorf("%s: could not read row: %w", db.name, err)
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListAuthors returns the distinct authors of the books, in alphabetical
+// order.
+func (db *sqlDB) ListAuthors(ctx context.Context) ([]string, error) {
+	return db.queryStrings(ctx, "list authors",
+		"SELECT DISTINCT author FROM books WHERE author <> '' AND status <> 'draft' AND deleted_at IS NULL ORDER BY author")
+}
+
+// AverageRatingByAuthor returns the mean rating of the books of each author.
+func (db *sqlDB) AverageRatingByAuthor(ctx context.Context) (map[string]float64, error) {
+	rows, err := db.query(ctx,
+		"SELECT author, avg(rating) FROM books WHERE status <> 'draft' AND deleted_at IS NULL GROUP BY author")
+	if err != nil {
+		return nil, fmt.Errorf("%s: could not average ratings: %w", db.name, err)
+	}
+	defer rows.Close()
+
+	result := make(map[string]float64)
+	for rows.Next() {
+		var (
+			author string
+			rating float64
+		)
+		if err := rows.Scan(&author, &rating); err != nil {
+			return nil, fmt.Errorf("%s: could not read row: %w", db.name, err)
+		}
+		result[author] = rating
+	}
+	return result, rows.Err()
+}
+
+// TopAuthors returns the limit authors with the most books, most prolific
+// first.
+func (db *sqlDB) TopAuthors(ctx context.Context, limit int) ([]AuthorCount, error) {
+	rows, err := db.query(ctx,
+		"SELECT author, count(*) FROM books WHERE status <> 'draft' AND deleted_at IS NULL AND author <> ''"+
+			" GROUP BY author ORDER BY count(*) DESC, author LIMIT $1",
+		sqlLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("%s: could not count books by author: %w", db.name, err)
+	}
+	defer rows.Close()
+
+	top := []AuthorCount{}
+	for rows.Next() {
+		var a AuthorCount
+		if err := rows.Scan(&a.Author, &a.Count); err != nil {
+			return nil, fmt.Errorf("%s: could not read row: %w", db.name, err)
+		}
+		top = append(top, a)
+	}
+	return top, rows.Err()
+}
+
+// ListTitleIndex returns the number of books whose title starts with each
+// letter.
+func (db *sqlDB) ListTitleIndex(ctx context.Context) (map[string]int, error) {
+	rows, err := db.query(ctx,
+		"SELECT substr(title, 1, 1), count(*) FROM books WHERE status <> 'draft' AND deleted_at IS NULL GROUP BY substr(title, 1, 1)")
+	if err != nil {
+		return nil, fmt.Errorf("%s: could not index titles: %w", db.name, err)
+	}
+	defer rows.Close()
+
+	index := make(map[string]int)
+	for rows.Next() {
+		var (
+			first string
+			n     int
+		)
+		if err := rows.Scan(&first, &n); err != nil {
+			return nil, fmt.Errorf("%s: could not read row: %w", db.name, err)
+		}
+		// The query groups "a" and "A" apart; indexKey merges them.
+		index[indexKey(first)] += n
+	}
+	return index, rows.Err()
+}
+
+// CountBooks returns the number of published books.
+func (db *sqlDB) CountBooks(ctx context.Context) (int64, error) {
+	return db.count(ctx, "SELECT count(*) FROM books WHERE status <> 'draft' AND deleted_at IS NULL")
+}
+
+// CountBooksCreatedBy returns the number of books created by the given user.
+func (db *sqlDB) CountBooksCreatedBy(ctx context.Context, userID string) (int64, error) {
+	return db.count(ctx, "SELECT count(*) FROM books WHERE created_by_id = $1 AND deleted_at IS NULL", userID)
+}
+
+// count runs a query selecting a single count.
+func (db *sqlDB) count(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	var n int64
+	if err := db.queryRow(ctx, query, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("%s: could not count books: %w", db.name, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchemaStatements are run by Migrate to make sure the schema exists.
+var sqliteSchemaStatements = []string{
+	`CREATE TABLE IF NOT EXISTS books (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT NOT NULL DEFAULT '',
+		author TEXT NOT NULL DEFAULT '',
+		published_date TEXT NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		isbn TEXT NOT NULL DEFAULT '',
+		language TEXT NOT NULL DEFAULT '',
+		genre TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'published',
+		page_count INTEGER NOT NULL DEFAULT 0,
+		rating REAL NOT NULL DEFAULT 0,
+		tags TEXT NOT NULL DEFAULT '[]',
+		cover_url TEXT NOT NULL DEFAULT '',
+		metadata TEXT NOT NULL DEFAULT '{}',
+		title_key BLOB,
+		created_by_id TEXT NOT NULL DEFAULT '',
+		created_by TEXT NOT NULL DEFAULT '',
+		version INTEGER NOT NULL DEFAULT 1,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		deleted_at TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS reviews (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		book_id INTEGER NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+		author TEXT NOT NULL DEFAULT '',
+		body TEXT NOT NULL DEFAULT '',
+		rating INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS reviews_book_id_idx ON reviews (book_id)`,
+	`CREATE INDEX IF NOT EXISTS books_author_idx ON books (author)`,
+	`CREATE INDEX IF NOT EXISTS books_title_key_idx ON books (title_key, id)`,
+	`CREATE INDEX IF NOT EXISTS books_created_by_id_idx ON books (created_by_id)`,
+	`CREATE INDEX IF NOT EXISTS books_created_at_idx ON books (created_at)`,
+	`CREATE INDEX IF NOT EXISTS books_updated_at_idx ON books (updated_at)`,
+	`CREATE INDEX IF NOT EXISTS books_language_idx ON books (language)`,
+	`CREATE INDEX IF NOT EXISTS books_genre_idx ON books (genre)`,
+	`CREATE INDEX IF NOT EXISTS books_status_idx ON books (status)`,
+	`CREATE INDEX IF NOT EXISTS books_page_count_idx ON books (page_count)`,
+}
+
+// sqliteHasAllTags matches the books whose tags include all the distinct tags
+// of the JSON array $1.
+const sqliteHasAllTags = `(SELECT count(DISTINCT value) FROM json_each(books.tags)
+		WHERE value IN (SELECT value FROM json_each($1))) = (SELECT count(DISTINCT value) FROM json_each($1))`
+
+// NewSQLiteDB creates a new BookDatabase stored in the SQLite file at path,
+// creating the file if it does not exist. Call Migrate to create the schema.
+//
+// The database is opened in WAL mode so readers do not block the writer, and
+// connections wait for locks rather than failing with SQLITE_BUSY, making it
+// safe to share between goroutines. Foreign keys are enforced, so purging a
+// book removes its reviews.
+func NewSQLiteDB(path string) (BookDatabase, error) {
+	return NewSQLiteDBWithLogger(path, defaultLogger)
+}
+
+// NewSQLiteDBWithLogger is like NewSQLiteDB, but writes log messages,
+// including every query at debug level, to logger.
+func NewSQLiteDBWithLogger(path string, logger Logger) (BookDatabase, error) {
+	params := url.Values{}
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_txlock", "immediate")
+
+	conn, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: could not open: %v", err)
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sqlite: could not open: %v", err)
+	}
+
+	return &sqlDB{
+		name:        "sqlite",
+		conn:        conn,
+		log:         logger,
+		tags:        func(tags *[]string) interface{} { return jsonStrings{tags} },
+		hasTag:      "EXISTS (SELECT 1 FROM json_each(books.tags) WHERE json_each.value = $1)",
+		hasAllTags:  sqliteHasAllTags,
+		hasAnyTag:   "EXISTS (SELECT 1 FROM json_each(books.tags) WHERE value IN (SELECT value FROM json_each($1)))",
+		hasMetadata: "EXISTS (SELECT 1 FROM json_each(books.metadata) WHERE json_each.key = $1 AND json_each.value = $2)",
+		schema:      sqliteSchemaStatements,
+	}, nil
+}
+
+// jsonStrings stores a list of strings as a JSON array in a TEXT column.
+type jsonStrings struct {
+	p *[]string
+}
+
+// Value implements driver.Valuer.
+func (s jsonStrings) Value() (driver.Value, error) {
+	b, err := json.Marshal(*s.p)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner.
+func (s jsonStrings) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(src), s.p)
+	case []byte:
+		return json.Unmarshal(src, s.p)
+	case nil:
+		*s.p = nil
+		return nil
+	}
+	return fmt.Errorf("sqlite: cannot scan %T into a list of strings", src)
+}
@@ -0,0 +1,149 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// newTestSQLiteDB opens and migrates a SQLite database in a temporary file
+// that is removed when the test ends.
+func newTestSQLiteDB(t *testing.T) BookDatabase {
+	t.Helper()
+	db, err := NewSQLiteDB(filepath.Join(t.TempDir(), "books.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return db
+}
+
+func TestSQLiteDB(t *testing.T) {
+	testDatabase(t, newTestSQLiteDB(t))
+}
+
+func TestSQLiteReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "books.db")
+	db, err := NewSQLiteDB(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteDB: %v", err)
+	}
+	if _, err := db.AddBook(ctx, &Book{Title: "Dune"}); err == nil {
+		t.Errorf("AddBook before Migrate: got nil, want an error")
+	}
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	id, err := db.AddBook(ctx, &Book{Title: "Dune", Tags: []string{"scifi", "classics"}})
+	if err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+
+	db, err = NewSQLiteDB(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteDB of an existing file: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate of an existing schema: %v", err)
+	}
+	b, err := db.GetBook(ctx, id)
+	if err != nil {
+		t.Fatalf("GetBook after reopening: %v", err)
+	}
+	if want := []string{"scifi", "classics"}; b.Title != "Dune" || !reflect.DeepEqual(b.Tags, want) {
+		t.Errorf("GetBook after reopening = %+v, want Dune tagged %q", b, want)
+	}
+}
+
+// TestSQLiteFillTitleKeys checks that Migrate computes the title sort keys
+// of books stored before there were any.
+// TestSQLiteWrapsErrors checks that errors keep the cause they wrap, so that
+// callers can tell a canceled request from a failing database.
+func TestSQLiteWrapsErrors(t *testing.T) {
+	db := newTestSQLiteDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := db.ListBooks(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("ListBooks with a canceled context: got %v, want it to wrap context.Canceled", err)
+	}
+	if _, err := db.AddBook(ctx, &Book{Title: "Dune"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("AddBook with a canceled context: got %v, want it to wrap context.Canceled", err)
+	}
+	if _, err := db.CountBooks(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("CountBooks with a canceled context: got %v, want it to wrap context.Canceled", err)
+	}
+}
+
+func TestSQLiteFillTitleKeys(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLiteDB(t)
+	mustAdd(t, db, &Book{Title: "Zebra"})
+	mustAdd(t, db, &Book{Title: "apple"})
+	if _, err := db.(*sqlDB).conn.ExecContext(ctx, "UPDATE books SET title_key = NULL"); err != nil {
+		t.Fatalf("clearing title keys: %v", err)
+	}
+
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	books, err := db.ListBooks(ctx)
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	if got, want := titles(books), []string{"apple", "Zebra"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListBooks after migrating = %q, want %q", got, want)
+	}
+}
+
+// TestSQLiteConcurrentWrites checks that concurrent writers wait for each
+// other rather than failing with SQLITE_BUSY.
+func TestSQLiteConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLiteDB(t)
+
+	const writers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.AddBooks(ctx, []*Book{{Title: "Dune"}, {Title: "Emma"}})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("AddBooks: %v", err)
+		}
+	}
+
+	books, err := db.ListBooks(ctx)
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	if len(books) != 2*writers {
+		t.Errorf("got %d books, want %d", len(books), 2*writers)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import "errors"
+
+// The statuses a book may have. Drafts are left out of the default book
+// listings until they are published.
+const (
+	StatusDraft     = "draft"
+	StatusPublished = "published"
+)
+
+// ErrInvalidStatus is returned when a book's status is neither StatusDraft
+// nor StatusPublished.
+var ErrInvalidStatus = errors.New("bookshelf: status must be draft or published")
+
+// ValidateStatus checks that the book's status is one of the Status
+// constants. An empty status is valid, and stands for StatusPublished, so
+// that books saved before statuses were introduced stay visible.
+func (b *Book) ValidateStatus() error {
+	switch b.Status {
+	case "", StatusDraft, StatusPublished:
+		return nil
+	}
+	return ErrInvalidStatus
+}
+
+// checkStatus returns ErrInvalidStatus unless status is one of the Status
+// constants.
+func checkStatus(status string) error {
+	if status != StatusDraft && status != StatusPublished {
+		return ErrInvalidStatus
+	}
+	return nil
+}
+
+// defaultStatus sets the book's status to StatusPublished if it is empty.
+func (b *Book) defaultStatus() {
+	if b.Status == "" {
+		b.Status = StatusPublished
+	}
+}
+
+// isDraft reports whether b is a draft.
+func isDraft(b *Book) bool {
+	return b.Status == StatusDraft
+}
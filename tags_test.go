@@ -0,0 +1,25 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		in, want []string
+	}{
+		{nil, []string{}},
+		{[]string{"scifi", "classics"}, []string{"scifi", "classics"}},
+		{[]string{" scifi ", "", "  ", "scifi", "classics"}, []string{"scifi", "classics"}},
+	}
+	for _, tt := range tests {
+		if got := normalizeTags(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("normalizeTags(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxTextLength caps the length, in runes, of a book's title and author.
+const maxTextLength = 512
+
+// FieldError reports a problem with a single field of a book.
+type FieldError struct {
+	Field string // JSON name of the offending field.
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying problem, so that errors.Is can match it.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError lists every problem found with a book.
+type ValidationError []*FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return "bookshelf: invalid book: " + strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the field errors matches target.
+func (e ValidationError) Is(target error) bool {
+	for _, fe := range e {
+		if errors.Is(fe, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks every field of the book, returning a ValidationError that
+// lists all the problems found, or nil if there are none. Books are
+// validated before they are written to any database.
+func (b *Book) Validate() error {
+	var errs ValidationError
+	add := func(field string, err error) {
+		errs = append(errs, &FieldError{Field: field, Err: err})
+	}
+
+	if strings.TrimSpace(b.Title) == "" {
+		add("title", errors.New("required"))
+	}
+	if utf8.RuneCountInString(b.Title) > maxTextLength {
+		add("title", fmt.Errorf("must be at most %d characters", maxTextLength))
+	}
+	if utf8.RuneCountInString(b.Author) > maxTextLength {
+		add("author", fmt.Errorf("must be at most %d characters", maxTextLength))
+	}
+	if err := b.ValidateISBN(); err != nil {
+		add("isbn", err)
+	}
+	if b.Language != "" && !isLanguageCode(b.Language) {
+		add("language", errors.New("must be a two-letter lowercase ISO 639-1 code"))
+	}
+	if err := b.ValidateGenre(); err != nil {
+		add("genre", err)
+	}
+	if err := b.ValidateStatus(); err != nil {
+		add("status", err)
+	}
+	if b.PageCount < 0 {
+		add("page_count", errors.New("must not be negative"))
+	}
+	if err := b.ValidateRating(); err != nil {
+		add("rating", err)
+	}
+	for k := range b.Metadata {
+		if err := validateMetadataKey(k); err != nil {
+			add("metadata", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateMetadataKey checks a key of a book's metadata. Mongo stores
+// metadata as a subdocument, so keys with dots or a leading $ would be read
+// as paths or operators.
+func validateMetadataKey(k string) error {
+	if k == "" || strings.Contains(k, ".") || strings.HasPrefix(k, "$") {
+		return fmt.Errorf("bad key %q: must not be empty, contain dots or start with $", k)
+	}
+	return nil
+}
+
+// isLanguageCode reports whether s has the form of an ISO 639-1 code: two
+// lowercase ASCII letters.
+func isLanguageCode(s string) bool {
+	return len(s) == 2 && 'a' <= s[0] && s[0] <= 'z' && 'a' <= s[1] && s[1] <= 'z'
+}
@@ -0,0 +1,69 @@
+// Copyright 2015 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bookshelf
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	long := strings.Repeat("é", maxTextLength+1)
+	tests := []struct {
+		book   Book
+		fields []string // fields reported invalid, in order
+	}{
+		{Book{Title: "Dune"}, nil},
+		{Book{Title: strings.Repeat("é", maxTextLength), Author: "Frank Herbert"}, nil},
+		{Book{}, []string{"title"}},
+		{Book{Title: "  "}, []string{"title"}},
+		{Book{Title: long, Author: long}, []string{"title", "author"}},
+		{Book{Title: "Dune", ISBN: "12345", Rating: 7}, []string{"isbn", "rating"}},
+		{Book{Title: "Dune", Language: "en"}, nil},
+		{Book{Title: "Dune", Language: "EN"}, []string{"language"}},
+		{Book{Title: "Dune", Language: "eng"}, []string{"language"}},
+		{Book{Title: "Dune", Genre: GenreFiction}, nil},
+		{Book{Title: "Dune", Genre: "Fiction"}, []string{"genre"}},
+		{Book{Title: "Dune", PageCount: -1}, []string{"page_count"}},
+		{Book{Title: "Dune", Metadata: map[string]string{"shelf": "B2"}}, nil},
+		{Book{Title: "Dune", Status: StatusDraft}, nil},
+		{Book{Title: "Dune", Status: "archived"}, []string{"status"}},
+		{Book{Title: "Dune", Metadata: map[string]string{"shelf.row": "2"}}, []string{"metadata"}},
+		{Book{Title: "Dune", Metadata: map[string]string{"$where": "1"}}, []string{"metadata"}},
+	}
+	for _, tt := range tests {
+		err := tt.book.Validate()
+		if tt.fields == nil {
+			if err != nil {
+				t.Errorf("Validate(%.20q) = %v, want nil", tt.book.Title, err)
+			}
+			continue
+		}
+		var verr ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("Validate(%.20q) = %v, want a ValidationError", tt.book.Title, err)
+			continue
+		}
+		var got []string
+		for _, fe := range verr {
+			got = append(got, fe.Field)
+		}
+		if !reflect.DeepEqual(got, tt.fields) {
+			t.Errorf("Validate(%.20q) reported fields %q, want %q", tt.book.Title, got, tt.fields)
+		}
+	}
+}
+
+func TestValidationErrorIs(t *testing.T) {
+	err := (&Book{Title: "Dune", ISBN: "12345"}).Validate()
+	if !errors.Is(err, ErrInvalidISBN) {
+		t.Errorf("errors.Is(%v, ErrInvalidISBN) = false, want true", err)
+	}
+	if errors.Is(err, ErrInvalidRating) {
+		t.Errorf("errors.Is(%v, ErrInvalidRating) = true, want false", err)
+	}
+}